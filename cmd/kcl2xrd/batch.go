@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// xrdSourceRegex matches files declaring an XRD: a schema marked with @xrd or
+// an __xrd_kind variable
+var xrdSourceRegex = regexp.MustCompile(`(?m)^\s*#.*@xrd\b|^__xrd_kind\s*=`)
+
+// batchWorkers is the number of files batch mode converts at once
+var batchWorkers = runtime.NumCPU()
+
+// batchSource is a KCL file converted in batch mode and the path of its XRD
+// relative to the output directory
+type batchSource struct {
+	file   string
+	output string
+}
+
+// batchResult is the outcome of converting one batch source
+type batchResult struct {
+	conversion
+	err error
+}
+
+// isBatch reports whether the inputs name directories, either a directory
+// itself or a directory tree as `dir/...`
+func isBatch(inputs []string) bool {
+	for _, input := range inputs {
+		if strings.HasSuffix(input, "...") {
+			return true
+		}
+		if info, err := os.Stat(input); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// runBatch converts every XRD source found in the inputs concurrently. Each
+// source is written to the output directory under its path relative to the
+// input directory, with a .yaml extension, or to stdout as a YAML stream.
+// Compositions are written the same way to the --composition directory.
+// Files that fail are reported together once all files are converted. The
+// files are converted concurrently, so run sets up cmd beforehand.
+func runBatch(cmd *cobra.Command, state *commandState) error {
+	sources, err := discoverSources(inputFiles)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no KCL files with an @xrd schema or __xrd_kind found in %s", strings.Join(inputFiles, ", "))
+	}
+	for _, dir := range []string{outputFile, compositionFile} {
+		if info, err := os.Stat(dir); dir != "" && err == nil && !info.IsDir() {
+			return fmt.Errorf("output %s must be a directory when converting several files", dir)
+		}
+	}
+
+	results := make([]batchResult, len(sources))
+	limit := make(chan struct{}, batchWorkers)
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			results[i].conversion, results[i].err = convert(cmd, state, []string{src.file})
+		}()
+	}
+	wg.Wait()
+
+	// Report and write in file order, so output does not depend on scheduling
+	var failures []string
+	var stream []string
+	for i, src := range sources {
+		result := results[i]
+		printDiagnostics(result.diagnostics)
+		if result.err != nil {
+			failures = append(failures, fmt.Sprintf("  %s: %v", src.file, result.err))
+			continue
+		}
+
+		if compositionFile != "" {
+			path := filepath.Join(compositionFile, src.output)
+			if err := writeBatchFile(path, result.composition); err != nil {
+				failures = append(failures, fmt.Sprintf("  %s: %v", src.file, err))
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Composition written to %s\n", path)
+		}
+
+		if outputFile == "" {
+			stream = append(stream, result.xrd)
+			continue
+		}
+		path := filepath.Join(outputFile, src.output)
+		if err := writeBatchFile(path, result.xrd); err != nil {
+			failures = append(failures, fmt.Sprintf("  %s: %v", src.file, err))
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "XRD written to %s\n", path)
+	}
+	if len(stream) > 0 {
+		fmt.Print(strings.Join(stream, "---\n"))
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d files failed to convert:\n%s", len(failures), len(sources), strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// writeBatchFile writes an output file of batch mode, creating its directory
+func writeBatchFile(path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
+
+// discoverSources lists the XRD sources of the inputs in a deterministic
+// order. A directory contributes its own files, `dir/...` every file below
+// it; test files (_test.k) and hidden directories are skipped. Files named
+// explicitly are always converted.
+func discoverSources(inputs []string) ([]batchSource, error) {
+	var sources []batchSource
+	for _, input := range inputs {
+		root, recursive := input, false
+		if strings.HasSuffix(input, "...") {
+			root, recursive = filepath.Clean(strings.TrimSuffix(input, "...")), true
+		}
+
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+		if !info.IsDir() {
+			sources = append(sources, batchSource{file: filepath.Clean(input), output: outputName(filepath.Base(input))})
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != root && (!recursive || strings.HasPrefix(d.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(path) != ".k" || strings.HasSuffix(path, "_test.k") {
+				return nil
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if !xrdSourceRegex.Match(content) {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, batchSource{file: path, output: outputName(rel)})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input directory: %w", err)
+		}
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].file < sources[j].file
+	})
+
+	// Files found through several inputs are converted once, but two files
+	// must not write the same output file
+	unique := sources[:0]
+	seen := make(map[string]string)
+	for _, src := range sources {
+		if other, ok := seen[src.output]; ok {
+			if other == src.file {
+				continue
+			}
+			return nil, fmt.Errorf("%s and %s would both be written to %s", other, src.file, src.output)
+		}
+		seen[src.output] = src.file
+		unique = append(unique, src)
+	}
+	return unique, nil
+}
+
+// outputName returns the XRD file name for a KCL file path
+func outputName(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".yaml"
+}
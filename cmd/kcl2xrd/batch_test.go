@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBatchConversion converts several files concurrently; run with -race
+// to check that conversions share no mutable state
+func TestBatchConversion(t *testing.T) {
+	workers := batchWorkers
+	batchWorkers = 4
+	defer func() { batchWorkers = workers }()
+
+	files := make(map[string]string)
+	for i := 0; i < 8; i++ {
+		files[fmt.Sprintf("apps/app%d.k", i)] = fmt.Sprintf(`__xrd_kind = "XApp%d"
+__xrd_group = "example.org"
+
+schema XApp%d:
+    # @minLength(1)
+    name: str
+    replicas?: int = %d
+`, i, i, i)
+	}
+	files["apps/nested/broken.k"] = `__xrd_kind = "XBroken"
+
+schema XBroken:
+    name: str
+`
+	files["apps/helpers.k"] = `schema Helper:
+    name: str
+`
+	dir := writeFiles(t, files)
+	output := filepath.Join(dir, "xrds")
+
+	err := execute(t, "-i", filepath.Join(dir, "apps")+"/...", "-o", output)
+	if err == nil || !strings.Contains(err.Error(), "1 of 9 files failed to convert") {
+		t.Fatalf("expected the file without a group to fail, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "broken.k") {
+		t.Errorf("expected the failure to name broken.k, got %v", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		data, err := os.ReadFile(filepath.Join(output, fmt.Sprintf("app%d.yaml", i)))
+		if err != nil {
+			t.Fatalf("expected an XRD for app%d.k: %v", i, err)
+		}
+		if !strings.Contains(string(data), fmt.Sprintf("kind: XApp%d", i)) {
+			t.Errorf("app%d.yaml has the wrong kind:\n%s", i, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(output, "helpers.yaml")); err == nil {
+		t.Error("expected files without an XRD to be skipped")
+	}
+}
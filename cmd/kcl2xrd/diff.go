@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/differ"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the diff command; 1 is an error
+const (
+	exitAdditive = 2
+	exitBreaking = 3
+)
+
+// exitCode is returned by commands whose outcome is their exit code
+type exitCode int
+
+func (e exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// newDiffCmd returns the diff command, which classifies the schema changes
+// between two revisions of an XRD
+func newDiffCmd(state *commandState) *cobra.Command {
+	var ref string
+	cmd := &cobra.Command{
+		Use:   "diff [OLD NEW]",
+		Short: "Classify the schema changes between two XRD revisions as breaking or additive",
+		Long: `Compare two revisions of an XRD or CRD and report breaking changes, such as a
+removed field, a new required field, a type change, a tightened enum, pattern
+or bound, or a field becoming immutable, separately from additive ones, such
+as a new optional field or a relaxed constraint.
+
+Compare two XRD files:
+
+  kcl2xrd diff old.yaml new.yaml
+
+or the XRD generated from KCL files with the XRD generated from the same
+files at a git ref:
+
+  kcl2xrd diff --ref main -i app.k
+
+The exit code is 0 without changes, 2 with additive changes only and 3 with
+breaking changes.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var oldXRD, newXRD []byte
+			if ref != "" {
+				if len(args) > 0 {
+					return fmt.Errorf("diff takes either two XRD files or --ref with KCL files")
+				}
+				if len(inputFiles) == 0 {
+					return fmt.Errorf("--ref requires KCL files to generate the XRD from (-i)")
+				}
+				cmd.SilenceUsage = true
+				var err error
+				oldXRD, newXRD, err = generateAtRef(cmd, state, ref)
+				if err != nil {
+					return err
+				}
+			} else {
+				if len(args) != 2 {
+					return fmt.Errorf("diff takes two XRD files, or --ref with KCL files")
+				}
+				cmd.SilenceUsage = true
+				var err error
+				if oldXRD, err = os.ReadFile(args[0]); err != nil {
+					return fmt.Errorf("failed to read old XRD: %w", err)
+				}
+				if newXRD, err = os.ReadFile(args[1]); err != nil {
+					return fmt.Errorf("failed to read new XRD: %w", err)
+				}
+			}
+
+			changes, err := differ.Compare(oldXRD, newXRD)
+			if err != nil {
+				return err
+			}
+			if len(changes) == 0 {
+				fmt.Fprintln(os.Stderr, "No schema changes")
+				return nil
+			}
+
+			breaking := 0
+			for _, severity := range []differ.Severity{differ.Breaking, differ.Additive} {
+				var lines []string
+				for _, c := range changes {
+					if c.Severity == severity {
+						lines = append(lines, "  "+c.String())
+					}
+				}
+				if len(lines) == 0 {
+					continue
+				}
+				if severity == differ.Breaking {
+					breaking = len(lines)
+				}
+				title := strings.ToUpper(severity.String()[:1]) + severity.String()[1:]
+				fmt.Printf("%s changes:\n%s\n", title, strings.Join(lines, "\n"))
+			}
+			fmt.Fprintf(os.Stderr, "%d breaking and %d additive changes\n", breaking, len(changes)-breaking)
+
+			// The outcome is the exit code, not an error to print
+			cmd.SilenceErrors = true
+			if differ.HasBreaking(changes) {
+				return exitCode(exitBreaking)
+			}
+			return exitCode(exitAdditive)
+		},
+	}
+
+	cmd.Flags().StringVar(&ref, "ref", "", "Git ref to compare the XRD generated from the KCL files with")
+	cmd.Flags().StringSliceVarP(&inputFiles, "input", "i", nil, "Input KCL schema file, with --ref; repeat to use one version per file")
+	addXRDFlags(cmd.Flags())
+	return cmd
+}
+
+// generateAtRef generates the XRD from the input files as they are at a git
+// ref and as they are now
+func generateAtRef(cmd *cobra.Command, state *commandState, ref string) ([]byte, []byte, error) {
+	if isBatch(inputFiles) {
+		return nil, nil, fmt.Errorf("diff takes KCL files, not directories")
+	}
+
+	current := inputFiles
+	now, err := convert(cmd, state, current)
+	printDiagnostics(now.diagnostics)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	first, err := filepath.Abs(current[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := git(filepath.Dir(first), "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, nil, err
+	}
+	top := strings.TrimSpace(string(out))
+
+	dir, err := os.MkdirTemp("", "kcl2xrd-diff-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := extractRef(top, ref, dir); err != nil {
+		return nil, nil, err
+	}
+
+	// The same files within the checkout of the ref
+	var previous []string
+	for _, file := range current {
+		rel, err := repoPath(top, file)
+		if err != nil {
+			return nil, nil, err
+		}
+		previous = append(previous, filepath.Join(dir, rel))
+	}
+	then, err := convert(cmd, state, previous)
+	if err != nil {
+		return nil, nil, fmt.Errorf("at %s: %w", ref, err)
+	}
+	return []byte(then.xrd), []byte(now.xrd), nil
+}
+
+// repoPath returns the path of a file relative to the top of its repository
+func repoPath(top, file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(top); err == nil {
+		top = resolved
+	}
+	rel, err := filepath.Rel(top, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is not in the repository at %s", file, top)
+	}
+	return rel, nil
+}
+
+// extractRef writes the tree of a git ref into a directory
+func extractRef(top, ref, dir string) error {
+	archive, err := git(top, "archive", "--format=tar", ref)
+	if err != nil {
+		return err
+	}
+
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", ref, err)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %w", ref, err)
+			}
+			if err := os.WriteFile(target, content, 0644); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// git runs a git command in a directory and returns its output
+func git(dir string, args ...string) ([]byte, error) {
+	command := exec.Command("git", args...)
+	command.Dir = dir
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	out, err := command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
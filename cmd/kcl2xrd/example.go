@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// newExampleCmd returns the example command, which renders example
+// manifests for the XRD generated from KCL files
+func newExampleCmd(state *commandState) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "example",
+		Short: "Generate an example composite resource and claim for an XRD",
+		Long: `Generate an example composite resource for the XRD converted from KCL files,
+followed by an example claim with --with-claims. Values come from defaults,
+enums, formats, patterns and bounds; required fields are filled with valid
+placeholders and optional fields are commented out.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runExample(cmd, state)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&inputFiles, "input", "i", nil, "Input KCL schema file (required); repeat to use one version per file")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file for the examples (stdout if not specified)")
+	addXRDFlags(cmd.Flags())
+	if err := cmd.MarkFlagRequired("input"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return cmd
+}
+
+func runExample(cmd *cobra.Command, state *commandState) error {
+	if isBatch(inputFiles) {
+		return fmt.Errorf("example takes KCL files, not directories")
+	}
+
+	// Failures past this point are not usage errors
+	cmd.SilenceUsage = true
+	renderExample = true
+	out, err := convert(cmd, state, inputFiles)
+	printDiagnostics(out.diagnostics)
+	if err != nil {
+		return err
+	}
+
+	if outputFile == "" {
+		fmt.Print(out.example)
+		return nil
+	}
+	if err := os.WriteFile(outputFile, []byte(out.example), 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Example written to %s\n", outputFile)
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestExamplesValidate generates the example manifests of every KCL example
+// and validates them against the example's XRD
+func TestExamplesValidate(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "examples", "kcl", "*.k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no KCL examples found")
+	}
+	dir := t.TempDir()
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".k")
+		t.Run(name, func(t *testing.T) {
+			xrd := filepath.Join(dir, name+".yaml")
+			example := filepath.Join(dir, name+"-example.yaml")
+			if err := execute(t, "-i", file, "-g", "example.org", "-o", xrd); err != nil {
+				t.Fatalf("conversion failed: %v", err)
+			}
+			if err := execute(t, "example", "-i", file, "-g", "example.org", "-o", example); err != nil {
+				t.Fatalf("example generation failed: %v", err)
+			}
+			if err := execute(t, "validate", "--xrd", xrd, example); err != nil {
+				t.Errorf("the example does not validate: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/importer"
+	"github.com/spf13/cobra"
+)
+
+// newImportCmd returns the import command, which converts an existing XRD or
+// CRD into annotated KCL
+func newImportCmd() *cobra.Command {
+	var input, output string
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Convert an existing XRD or CRD into annotated KCL",
+		Long: `Convert an existing Crossplane XRD or Kubernetes CRD into a KCL file with
+__xrd_ variables and comment annotations. Generating an XRD from the KCL file
+reproduces the definition's schemas; anything the KCL cannot express is
+reported as a warning.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				return fmt.Errorf("failed to read input: %w", err)
+			}
+			result, err := importer.Import(data)
+			if err != nil {
+				return fmt.Errorf("failed to import %s: %w", input, err)
+			}
+			printWarnings(result.Warnings)
+
+			if output == "" {
+				fmt.Print(result.KCL)
+				return nil
+			}
+			if err := os.WriteFile(output, []byte(result.KCL), 0644); err != nil {
+				return fmt.Errorf("failed to write output file: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "KCL written to %s\n", output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&input, "input", "i", "", "Input XRD or CRD YAML file (required)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output KCL file (stdout if not specified)")
+	if err := cmd.MarkFlagRequired("input"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return cmd
+}
@@ -1,96 +1,225 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/ggkhrmv/kcl2xrd/pkg/generator"
 	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
-	inputFile          string
-	outputFile         string
-	group              string
-	version            string
-	withClaims         bool
-	claimKind          string
-	claimPlural        string
-	schemaName         string
-	served             bool
-	referenceable      bool
-	categories         []string
-	printerColumns     []string
+	inputFiles        []string
+	outputFile        string
+	group             string
+	version           string
+	withClaims        bool
+	claimKind         string
+	claimPlural       string
+	schemaName        string
+	served            bool
+	referenceable     bool
+	categories        []string
+	printerColumns    []string
+	crossplaneVersion string
+	scope             string
+	specOptions       generator.XRDOptions // XRD spec settings and metadata from flags
+	compositionFile   string
+	renderExample     bool // set by the example command
+	openAPIFiles      []string
+	strict            bool // report warnings as errors
 )
 
 func main() {
+	if err := newRootCmd().Execute(); err != nil {
+		var code exitCode
+		if errors.As(err, &code) {
+			os.Exit(int(code))
+		}
+		os.Exit(1)
+	}
+}
+
+// newRootCmd returns the kcl2xrd command, which converts KCL files to XRDs,
+// with its subcommands
+func newRootCmd() *cobra.Command {
+	state := &commandState{}
 	rootCmd := &cobra.Command{
 		Use:   "kcl2xrd",
 		Short: "Convert KCL schemas to Crossplane XRDs",
 		Long:  `A tool to convert KCL (KCL Configuration Language) schemas to Crossplane Composite Resource Definitions (XRDs)`,
-		RunE:  run,
-	}
-
-	rootCmd.Flags().StringVarP(&inputFile, "input", "i", "", "Input KCL schema file (required)")
-	rootCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output XRD file (stdout if not specified)")
-	rootCmd.Flags().StringVarP(&group, "group", "g", "", "API group for the XRD (optional if specified in KCL file via __xrd_group)")
-	rootCmd.Flags().StringVarP(&version, "version", "v", "v1alpha1", "API version for the XRD")
-	rootCmd.Flags().StringVarP(&schemaName, "schema", "s", "", "Name of the schema to convert (defaults to @xrd marked schema, __xrd_kind, or last schema in file)")
-	rootCmd.Flags().BoolVar(&withClaims, "with-claims", false, "Generate XRD with claimNames")
-	rootCmd.Flags().StringVar(&claimKind, "claim-kind", "", "Kind for the claim (defaults to schema name without 'X' prefix)")
-	rootCmd.Flags().StringVar(&claimPlural, "claim-plural", "", "Plural for the claim (auto-generated if not specified)")
-	rootCmd.Flags().BoolVar(&served, "served", true, "Mark version as served")
-	rootCmd.Flags().BoolVar(&referenceable, "referenceable", true, "Mark version as referenceable")
-	rootCmd.Flags().StringSliceVar(&categories, "categories", nil, "Categories for the XRD (comma-separated)")
-	rootCmd.Flags().StringSliceVar(&printerColumns, "printer-columns", nil, "Additional printer columns (format: name:type:jsonPath:description)")
-	
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd, state)
+		},
+	}
+
+	rootCmd.Flags().StringSliceVarP(&inputFiles, "input", "i", nil, "Input KCL schema file (required); repeat to generate one version per file, or a directory (dir/... for subdirectories) to convert every XRD in it")
+	rootCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output XRD file, or directory for directory inputs (stdout if not specified)")
+	rootCmd.Flags().StringVar(&compositionFile, "composition", "", "Also write a Composition skeleton with a function-kcl step to this file, or directory for directory inputs")
+	addXRDFlags(rootCmd.Flags())
+
+	rootCmd.AddCommand(newImportCmd())
+	rootCmd.AddCommand(newExampleCmd(state))
+	rootCmd.AddCommand(newValidateCmd())
+	rootCmd.AddCommand(newDiffCmd(state))
+
 	if err := rootCmd.MarkFlagRequired("input"); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+	return rootCmd
+}
 
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
-	}
+// commandState is what the commands of one newRootCmd share while converting
+// files: the OpenAPI definitions given with --openapi, loaded once for all
+// converted files
+type commandState struct {
+	definitionsOnce sync.Once
+	definitions     *generator.Definitions
+	definitionsErr  error
+}
+
+// loadDefinitions loads the OpenAPI definitions given with --openapi, once
+// for all converted files
+func (s *commandState) loadDefinitions() (*generator.Definitions, error) {
+	s.definitionsOnce.Do(func() {
+		if len(openAPIFiles) > 0 {
+			s.definitions, s.definitionsErr = generator.LoadDefinitions(openAPIFiles)
+		}
+	})
+	return s.definitions, s.definitionsErr
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	// Parse KCL schema
-	result, err := parser.ParseKCLFileWithSchemas(inputFile)
+// addXRDFlags adds the flags that set up the XRD, shared by the commands
+// that convert KCL files
+func addXRDFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&group, "group", "g", "", "API group for the XRD (optional if specified in KCL file via __xrd_group)")
+	flags.StringVarP(&version, "version", "v", "v1alpha1", "API version for the XRD")
+	flags.StringVarP(&schemaName, "schema", "s", "", "Name of the schema to convert (defaults to @xrd marked schema, __xrd_kind, or last schema in file)")
+	flags.BoolVar(&withClaims, "with-claims", false, "Generate XRD with claimNames")
+	flags.StringVar(&claimKind, "claim-kind", "", "Kind for the claim (defaults to schema name without 'X' prefix)")
+	flags.StringVar(&claimPlural, "claim-plural", "", "Plural for the claim (auto-generated if not specified)")
+	flags.StringVar(&specOptions.ClaimSingular, "claim-singular", "", "Singular name for the claim")
+	flags.StringSliceVar(&specOptions.ClaimShortNames, "claim-short-names", nil, "Short names for the claim (comma-separated)")
+	flags.StringVar(&specOptions.ClaimListKind, "claim-list-kind", "", "List kind for the claim")
+	flags.StringVar(&specOptions.Plural, "plural", "", "Plural for the XRD (auto-generated if not specified, or via __xrd_plural)")
+	flags.StringVar(&specOptions.Singular, "singular", "", "Singular name for the XRD (optional if specified in KCL file via __xrd_singular)")
+	flags.StringSliceVar(&specOptions.ShortNames, "short-names", nil, "Short names for the XRD (comma-separated, optional if specified in KCL file via __xrd_short_names)")
+	flags.StringVar(&specOptions.ListKind, "list-kind", "", "List kind for the XRD (optional if specified in KCL file via __xrd_list_kind)")
+	flags.BoolVar(&served, "served", true, "Mark version as served")
+	flags.BoolVar(&referenceable, "referenceable", true, "Mark version as referenceable")
+	flags.StringSliceVar(&categories, "categories", nil, "Categories for the XRD (comma-separated)")
+	flags.StringSliceVar(&printerColumns, "printer-columns", nil, "Additional printer columns (format: name:type:jsonPath:description)")
+	flags.StringVar(&crossplaneVersion, "crossplane-version", "", "Crossplane XRD API to target: v1 or v2 (defaults to v1, or v2 if a scope is set)")
+	flags.StringVar(&scope, "scope", "", "Scope of a Crossplane v2 XRD: Namespaced, Cluster or LegacyCluster (optional if specified in KCL file via __xrd_scope)")
+	flags.StringVar(&specOptions.DefaultCompositionRef, "default-composition-ref", "", "Name of the default Composition")
+	flags.StringVar(&specOptions.EnforcedCompositionRef, "enforced-composition-ref", "", "Name of the Composition all composite resources must use")
+	flags.StringVar(&specOptions.DefaultCompositionUpdatePolicy, "default-composition-update-policy", "", "Default Composition update policy: Automatic or Manual")
+	flags.StringVar(&specOptions.DefaultCompositeDeletePolicy, "default-composite-delete-policy", "", "Default composite delete policy for claims: Background or Foreground")
+	flags.StringSliceVar(&specOptions.ConnectionSecretKeys, "connection-secret-keys", nil, "Connection secret keys of the composite resource (comma-separated)")
+	flags.StringToStringVar(&specOptions.Labels, "labels", nil, "Labels for the XRD metadata (key=value,...)")
+	flags.StringToStringVar(&specOptions.Annotations, "annotations", nil, "Annotations for the XRD metadata (key=value,...)")
+	flags.StringSliceVar(&openAPIFiles, "openapi", nil, "OpenAPI documents or CRDs (files or directories), e.g. Kubernetes' swagger.json, whose schemas replace the expansion of the KCL schemas generated from them")
+	flags.IntVar(&specOptions.MaxRecursionDepth, "max-recursion-depth", 0, "Expand recursive schemas this many times and cut deeper references with x-kubernetes-preserve-unknown-fields (recursion is an error if 0)")
+	flags.BoolVar(&strict, "strict", false, "Treat warnings, like unparsed statements or untranslated checks, as errors")
+}
+
+func run(cmd *cobra.Command, state *commandState) error {
+	if compositionFile != "" && filepath.Clean(compositionFile) == filepath.Clean(outputFile) {
+		return fmt.Errorf("--composition must differ from --output")
+	}
+	// Failures past this point are not usage errors. Set before batch mode
+	// converts files concurrently.
+	cmd.SilenceUsage = true
+	if isBatch(inputFiles) {
+		return runBatch(cmd, state)
+	}
+
+	out, err := convert(cmd, state, inputFiles)
+	printDiagnostics(out.diagnostics)
 	if err != nil {
-		return fmt.Errorf("failed to parse KCL file: %w", err)
+		return err
 	}
 
-	// Select schema to convert
-	var selectedSchema *parser.Schema
-	if schemaName != "" {
-		// User specified a schema name via CLI
-		if result.Schemas[schemaName] == nil {
-			return fmt.Errorf("schema '%s' not found in file. Available schemas: %v", schemaName, getSchemaNames(result.Schemas))
+	if err := writeOutput(out.xrd); err != nil {
+		return err
+	}
+	if compositionFile != "" {
+		if err := os.WriteFile(compositionFile, []byte(out.composition), 0644); err != nil {
+			return fmt.Errorf("failed to write Composition file: %w", err)
 		}
-		selectedSchema = result.Schemas[schemaName]
-	} else {
-		// Check if any schema is marked with @xrd annotation
-		var xrdSchema *parser.Schema
-		for _, schema := range result.Schemas {
-			if schema.IsXRD {
-				if xrdSchema != nil {
-					return fmt.Errorf("multiple schemas marked with @xrd annotation: '%s' and '%s'. Only one schema should be marked.", xrdSchema.Name, schema.Name)
-				}
-				xrdSchema = schema
-			}
+		fmt.Fprintf(os.Stderr, "Composition written to %s\n", compositionFile)
+	}
+	return nil
+}
+
+// conversion is the output of converting KCL files
+type conversion struct {
+	xrd         string
+	composition string // Composition skeleton, generated with --composition
+	example     string // example manifests, generated by the example command
+	diagnostics parser.Diagnostics
+}
+
+// convert generates one XRD from KCL files. Each file contributes one
+// version, or one per schema marked with @xrd(version=...). Diagnostics are
+// returned rather than printed, so that files can be converted concurrently;
+// for the same reason convert only reads cmd.
+func convert(cmd *cobra.Command, state *commandState, files []string) (conversion, error) {
+	var out conversion
+	definitions, err := state.loadDefinitions()
+	if err != nil {
+		return out, err
+	}
+	var sources []versionSource
+	for _, inputFile := range files {
+		result, err := parser.ParseKCLFileWithSchemas(inputFile)
+		var diagnostics parser.Diagnostics
+		if errors.As(err, &diagnostics) {
+			return out, fmt.Errorf("failed to parse KCL file: %w", out.report(diagnostics))
+		}
+		if err != nil {
+			return out, fmt.Errorf("failed to parse KCL file: %w", err)
+		}
+		if err := out.report(result.Diagnostics); err != nil {
+			return out, fmt.Errorf("failed to parse KCL file: %w", err)
+		}
+
+		selected, err := selectSchemas(result)
+		if err != nil {
+			return out, err
 		}
-		
-		if xrdSchema != nil {
-			// Use the schema marked with @xrd
-			selectedSchema = xrdSchema
-		} else {
-			// Use the primary (last) schema
-			selectedSchema = result.Primary
+		for _, schema := range selected {
+			sources = append(sources, versionSource{
+				file:   inputFile,
+				result: result,
+				schema: schema,
+				shared: len(selected) > 1,
+			})
 		}
 	}
+	if len(sources) > 1 {
+		return out, generateMultiVersion(cmd, sources, definitions, &out)
+	}
+	return out, generateSingleVersion(cmd, sources[0], definitions, &out)
+}
+
+// generateSingleVersion generates an XRD with one version from a schema, and
+// its Composition with --composition or its examples for the example command
+func generateSingleVersion(cmd *cobra.Command, src versionSource, definitions *generator.Definitions, out *conversion) error {
+	result, selectedSchema := src.result, src.schema
+
+	// Flags the file's metadata may fill in, copied so that concurrent
+	// conversions do not share them
+	group, version, categories, scope := group, version, categories, scope
+	served, referenceable, printerColumns := served, referenceable, printerColumns
 
 	// Apply metadata from KCL file if present, CLI flags override
 	if result.Metadata != nil {
@@ -103,6 +232,9 @@ func run(cmd *cobra.Command, args []string) error {
 		if len(categories) == 0 && len(result.Metadata.Categories) > 0 {
 			categories = result.Metadata.Categories
 		}
+		if scope == "" && result.Metadata.Scope != "" {
+			scope = result.Metadata.Scope
+		}
 		if result.Metadata.Served != nil && !cmd.Flags().Changed("served") {
 			served = *result.Metadata.Served
 		}
@@ -116,7 +248,18 @@ func run(cmd *cobra.Command, args []string) error {
 			}
 		}
 	}
-	
+
+	// Version settings from @xrd(...) apply unless set by flags
+	if selectedSchema.Version != "" && !cmd.Flags().Changed("version") {
+		version = selectedSchema.Version
+	}
+	if selectedSchema.Served != nil && !cmd.Flags().Changed("served") {
+		served = *selectedSchema.Served
+	}
+	if selectedSchema.Referenceable != nil && !cmd.Flags().Changed("referenceable") {
+		referenceable = *selectedSchema.Referenceable
+	}
+
 	// Validate that group is provided
 	if group == "" {
 		return fmt.Errorf("API group must be specified either via --group flag or '__xrd_group' variable in KCL file")
@@ -124,35 +267,346 @@ func run(cmd *cobra.Command, args []string) error {
 
 	// Prepare generator options
 	opts := generator.XRDOptions{
-		Group:          group,
-		Version:        version,
-		Kind:           "", // Will be set below if __xrd_kind is specified
-		WithClaims:     withClaims,
-		ClaimKind:      claimKind,
-		ClaimPlural:    claimPlural,
-		Served:         served,
-		Referenceable:  referenceable,
-		Categories:     categories,
-		PrinterColumns: parsePrinterColumns(printerColumns),
-	}
-	
+		Group:              group,
+		Version:            version,
+		Kind:               "", // Will be set below if __xrd_kind is specified
+		WithClaims:         withClaims,
+		ClaimKind:          claimKind,
+		ClaimPlural:        claimPlural,
+		Served:             served,
+		Referenceable:      referenceable,
+		Categories:         categories,
+		PrinterColumns:     parsePrinterColumns(printerColumns),
+		Deprecated:         selectedSchema.Deprecated,
+		DeprecationWarning: selectedSchema.DeprecationWarning,
+		CrossplaneVersion:  crossplaneVersion,
+		Scope:              scope,
+		Definitions:        definitions,
+	}
+	applySpecOptions(&opts, result.Metadata)
+
 	// If __xrd_kind is specified in metadata, use it as the XRD kind
 	if result.Metadata != nil && result.Metadata.XRKind != "" {
 		opts.Kind = result.Metadata.XRKind
 	}
-	
+
 	// If __xrd_status_preserve_unknown_fields is specified, use it
 	if result.Metadata != nil && result.Metadata.StatusPreserveUnknownFields != nil {
 		opts.StatusPreserveUnknownFields = *result.Metadata.StatusPreserveUnknownFields
 	}
 
 	// Generate XRD with schema resolution
-	xrd, err := generator.GenerateXRDWithSchemasAndOptions(selectedSchema, result.Schemas, opts)
+	var err error
+	out.xrd, err = generator.GenerateXRDWithSchemasAndOptions(selectedSchema, result.Schemas, opts)
 	if err != nil {
 		return fmt.Errorf("failed to generate XRD: %w", err)
 	}
+	costWarnings, err := generator.CheckValidationCosts([]generator.XRDVersion{
+		{
+			Name:                        version,
+			Schema:                      selectedSchema,
+			Schemas:                     result.Schemas,
+			StatusPreserveUnknownFields: opts.StatusPreserveUnknownFields,
+		},
+	}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to estimate CEL rule costs: %w", err)
+	}
+	if err := out.report(costWarnings); err != nil {
+		return fmt.Errorf("CEL rules exceed the cost budget: %w", err)
+	}
+	if compositionFile != "" {
+		var compositionWarnings parser.Diagnostics
+		out.composition, compositionWarnings, err = generator.GenerateCompositionWithSchemasAndOptions(selectedSchema, result.Schemas, opts)
+		if err != nil {
+			return fmt.Errorf("failed to generate Composition: %w", err)
+		}
+		if err := out.report(compositionWarnings); err != nil {
+			return fmt.Errorf("failed to generate Composition: %w", err)
+		}
+	}
+	if renderExample {
+		out.example, err = generator.GenerateExampleWithSchemasAndOptions(selectedSchema, result.Schemas, opts)
+		if err != nil {
+			return fmt.Errorf("failed to generate example: %w", err)
+		}
+	}
+	return nil
+}
+
+// versionSource is a schema selected for conversion and the file it is from
+type versionSource struct {
+	file   string
+	result *parser.ParseResult
+	schema *parser.Schema
+	shared bool // the file declares several versions
+}
+
+// selectSchemas returns the schemas of a file to convert: the --schema
+// schema, the schemas marked with @xrd, or else the primary schema
+func selectSchemas(result *parser.ParseResult) ([]*parser.Schema, error) {
+	if schemaName != "" {
+		// User specified a schema name via CLI
+		if result.Schemas[schemaName] == nil {
+			return nil, fmt.Errorf("schema '%s' not found in file. Available schemas: %v", schemaName, getSchemaNames(result.Schemas))
+		}
+		return []*parser.Schema{result.Schemas[schemaName]}, nil
+	}
+
+	// Check if any schema of the file is marked with @xrd annotation;
+	// schemas of imported packages are only used as field types
+	var xrdSchemas []*parser.Schema
+	for _, schema := range result.Schemas {
+		if schema.IsXRD && schema.File == result.Primary.File {
+			xrdSchemas = append(xrdSchemas, schema)
+		}
+	}
+	sort.Slice(xrdSchemas, func(i, j int) bool {
+		return xrdSchemas[i].Line < xrdSchemas[j].Line
+	})
+
+	// Several @xrd schemas are the versions of one XRD
+	if len(xrdSchemas) > 1 {
+		for _, schema := range xrdSchemas {
+			if schema.Version == "" {
+				return nil, fmt.Errorf("multiple schemas marked with @xrd annotation: '%s' and '%s'. Only one schema should be marked, or each should declare its version with @xrd(version=\"...\").", xrdSchemas[0].Name, xrdSchemas[1].Name)
+			}
+		}
+	}
+	if len(xrdSchemas) > 0 {
+		return xrdSchemas, nil
+	}
+
+	// Use the primary (last) schema
+	return []*parser.Schema{result.Primary}, nil
+}
+
+// generateMultiVersion generates one XRD with a version per selected schema.
+// Names, group and categories come from flags or the first file declaring
+// them; each version's name comes from @xrd(version=...) or the __xrd_version
+// of its file. Compositions and examples are generated as for a single version.
+func generateMultiVersion(cmd *cobra.Command, sources []versionSource, definitions *generator.Definitions, out *conversion) error {
+	opts := generator.XRDOptions{
+		Group:             group,
+		WithClaims:        withClaims,
+		ClaimKind:         claimKind,
+		ClaimPlural:       claimPlural,
+		Categories:        categories,
+		CrossplaneVersion: crossplaneVersion,
+		Scope:             scope,
+		Definitions:       definitions,
+	}
+
+	var versions []generator.XRDVersion
+	marked := false // whether any version sets referenceable itself
+	for _, src := range sources {
+		v := generator.XRDVersion{
+			Name:               src.schema.Version,
+			Schema:             src.schema,
+			Schemas:            src.result.Schemas,
+			Served:             served,
+			Deprecated:         src.schema.Deprecated,
+			DeprecationWarning: src.schema.DeprecationWarning,
+			PrinterColumns:     parsePrinterColumns(printerColumns),
+		}
+
+		if md := src.result.Metadata; md != nil {
+			if opts.Group == "" {
+				opts.Group = md.Group
+			}
+			if opts.Kind == "" {
+				opts.Kind = md.XRKind
+			}
+			if len(opts.Categories) == 0 {
+				opts.Categories = md.Categories
+			}
+			if opts.Scope == "" {
+				opts.Scope = md.Scope
+			}
+			applySpecOptions(&opts, md)
+			if len(printerColumns) == 0 {
+				for _, pc := range md.PrinterColumns {
+					v.PrinterColumns = append(v.PrinterColumns, generator.PrinterColumn{
+						Name:        pc.Name,
+						Type:        pc.Type,
+						JSONPath:    pc.JSONPath,
+						Description: pc.Description,
+					})
+				}
+			}
+			if md.StatusPreserveUnknownFields != nil {
+				v.StatusPreserveUnknownFields = *md.StatusPreserveUnknownFields
+			}
+			// File-level version settings only apply to files with one version
+			if !src.shared {
+				if v.Name == "" {
+					v.Name = md.XRVersion
+				}
+				if md.Served != nil && !cmd.Flags().Changed("served") {
+					v.Served = *md.Served
+				}
+				if md.Referenceable != nil {
+					v.Referenceable = *md.Referenceable
+					marked = true
+				}
+			}
+		}
+		if src.schema.Served != nil && !cmd.Flags().Changed("served") {
+			v.Served = *src.schema.Served
+		}
+		if src.schema.Referenceable != nil {
+			v.Referenceable = *src.schema.Referenceable
+			marked = true
+		}
+
+		if v.Name == "" {
+			return fmt.Errorf("no version for schema '%s' in %s: set __xrd_version or @xrd(version=\"...\")", src.schema.Name, src.file)
+		}
+		versions = append(versions, v)
+	}
+	// Without a marked version the last one is referenceable, as the only
+	// version of a single-version XRD is, unless --referenceable=false
+	if !marked && len(versions) > 0 {
+		versions[len(versions)-1].Referenceable = referenceable
+	}
+
+	// Validate that group is provided
+	if opts.Group == "" {
+		return fmt.Errorf("API group must be specified either via --group flag or '__xrd_group' variable in KCL file")
+	}
+
+	var err error
+	out.xrd, err = generator.GenerateXRDWithVersions(versions, opts)
+	if err != nil {
+		return fmt.Errorf("failed to generate XRD: %w", err)
+	}
+	costWarnings, err := generator.CheckValidationCosts(versions, opts)
+	if err != nil {
+		return fmt.Errorf("failed to estimate CEL rule costs: %w", err)
+	}
+	if err := out.report(costWarnings); err != nil {
+		return fmt.Errorf("CEL rules exceed the cost budget: %w", err)
+	}
+	if compositionFile != "" {
+		var compositionWarnings parser.Diagnostics
+		out.composition, compositionWarnings, err = generator.GenerateCompositionWithVersions(versions, opts)
+		if err != nil {
+			return fmt.Errorf("failed to generate Composition: %w", err)
+		}
+		if err := out.report(compositionWarnings); err != nil {
+			return fmt.Errorf("failed to generate Composition: %w", err)
+		}
+	}
+	if renderExample {
+		out.example, err = generator.GenerateExampleWithVersions(versions, opts)
+		if err != nil {
+			return fmt.Errorf("failed to generate example: %w", err)
+		}
+	}
+	return nil
+}
+
+// applySpecOptions sets the XRD spec settings and metadata from flags, or
+// else from the KCL file's metadata
+func applySpecOptions(opts *generator.XRDOptions, md *parser.XRDMetadata) {
+	opts.DefaultCompositionRef = firstNonEmpty(opts.DefaultCompositionRef, specOptions.DefaultCompositionRef)
+	opts.EnforcedCompositionRef = firstNonEmpty(opts.EnforcedCompositionRef, specOptions.EnforcedCompositionRef)
+	opts.DefaultCompositionUpdatePolicy = firstNonEmpty(opts.DefaultCompositionUpdatePolicy, specOptions.DefaultCompositionUpdatePolicy)
+	opts.DefaultCompositeDeletePolicy = firstNonEmpty(opts.DefaultCompositeDeletePolicy, specOptions.DefaultCompositeDeletePolicy)
+	if len(opts.ConnectionSecretKeys) == 0 {
+		opts.ConnectionSecretKeys = specOptions.ConnectionSecretKeys
+	}
+	if len(opts.Labels) == 0 {
+		opts.Labels = specOptions.Labels
+	}
+	if len(opts.Annotations) == 0 {
+		opts.Annotations = specOptions.Annotations
+	}
+	opts.Plural = firstNonEmpty(opts.Plural, specOptions.Plural)
+	opts.Singular = firstNonEmpty(opts.Singular, specOptions.Singular)
+	opts.ListKind = firstNonEmpty(opts.ListKind, specOptions.ListKind)
+	if len(opts.ShortNames) == 0 {
+		opts.ShortNames = specOptions.ShortNames
+	}
+	if opts.MaxRecursionDepth == 0 {
+		opts.MaxRecursionDepth = specOptions.MaxRecursionDepth
+	}
+	opts.ClaimSingular = firstNonEmpty(opts.ClaimSingular, specOptions.ClaimSingular)
+	opts.ClaimListKind = firstNonEmpty(opts.ClaimListKind, specOptions.ClaimListKind)
+	if len(opts.ClaimShortNames) == 0 {
+		opts.ClaimShortNames = specOptions.ClaimShortNames
+	}
+	if md == nil {
+		return
+	}
+
+	opts.DefaultCompositionRef = firstNonEmpty(opts.DefaultCompositionRef, md.DefaultCompositionRef)
+	opts.EnforcedCompositionRef = firstNonEmpty(opts.EnforcedCompositionRef, md.EnforcedCompositionRef)
+	opts.DefaultCompositionUpdatePolicy = firstNonEmpty(opts.DefaultCompositionUpdatePolicy, md.DefaultCompositionUpdatePolicy)
+	opts.DefaultCompositeDeletePolicy = firstNonEmpty(opts.DefaultCompositeDeletePolicy, md.DefaultCompositeDeletePolicy)
+	if len(opts.ConnectionSecretKeys) == 0 {
+		opts.ConnectionSecretKeys = md.ConnectionSecretKeys
+	}
+	if len(opts.Labels) == 0 {
+		opts.Labels = md.Labels
+	}
+	if len(opts.Annotations) == 0 {
+		opts.Annotations = md.Annotations
+	}
+	opts.Plural = firstNonEmpty(opts.Plural, md.Plural)
+	opts.Singular = firstNonEmpty(opts.Singular, md.Singular)
+	opts.ListKind = firstNonEmpty(opts.ListKind, md.ListKind)
+	if len(opts.ShortNames) == 0 {
+		opts.ShortNames = md.ShortNames
+	}
+	if opts.MaxRecursionDepth == 0 {
+		opts.MaxRecursionDepth = md.MaxRecursionDepth
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// report records diagnostics in the conversion, with warnings turned into
+// errors by --strict, and returns an error if any of them is an error
+func (out *conversion) report(diagnostics parser.Diagnostics) error {
+	if strict {
+		diagnostics = diagnostics.Strict()
+	}
+	out.diagnostics = append(out.diagnostics, diagnostics...)
+	switch n := len(diagnostics.Errors()); n {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("1 error")
+	default:
+		return fmt.Errorf("%d errors", n)
+	}
+}
+
+// printDiagnostics prints diagnostics to stderr like a compiler does,
+// `file:line:column: severity: message`
+func printDiagnostics(diagnostics parser.Diagnostics) {
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintln(os.Stderr, diagnostic)
+	}
+}
+
+// printWarnings prints warnings to stderr
+func printWarnings(warnings []string) {
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+}
 
-	// Output XRD
+// writeOutput writes the XRD to the output file or stdout
+func writeOutput(xrd string) error {
 	if outputFile == "" {
 		fmt.Println(xrd)
 	} else {
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeFiles writes KCL files into a new temporary directory and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// execute runs kcl2xrd with the given arguments; usage and errors are
+// returned, not printed
+func execute(t *testing.T, args ...string) error {
+	t.Helper()
+	cmd := newRootCmd()
+	cmd.SetArgs(args)
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	return cmd.Execute()
+}
+
+// xrdVersions reads the name and referenceable setting of each version of
+// an XRD file
+func xrdVersions(t *testing.T, path string) map[string]bool {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var xrd struct {
+		Spec struct {
+			Versions []struct {
+				Name          string `yaml:"name"`
+				Referenceable bool   `yaml:"referenceable"`
+			} `yaml:"versions"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(data, &xrd); err != nil {
+		t.Fatalf("failed to parse %s: %v", path, err)
+	}
+	versions := make(map[string]bool)
+	for _, v := range xrd.Spec.Versions {
+		versions[v.Name] = v.Referenceable
+	}
+	return versions
+}
+
+func TestMultiVersionWithoutReferenceableVersion(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"v1alpha1.k": `__xrd_kind = "XDatabase"
+__xrd_group = "database.example.org"
+__xrd_version = "v1alpha1"
+
+schema Database:
+    engine: str
+`,
+		"v1beta1.k": `__xrd_kind = "XDatabase"
+__xrd_group = "database.example.org"
+__xrd_version = "v1beta1"
+
+schema Database:
+    engine: str
+    storageGB?: int
+`,
+	})
+	alpha, beta := filepath.Join(dir, "v1alpha1.k"), filepath.Join(dir, "v1beta1.k")
+
+	output := filepath.Join(dir, "xrd.yaml")
+	if err := execute(t, "-i", alpha, "-i", beta, "-o", output); err != nil {
+		t.Fatalf("conversion failed: %v", err)
+	}
+	versions := xrdVersions(t, output)
+	if versions["v1alpha1"] || !versions["v1beta1"] {
+		t.Errorf("expected only the last version to be referenceable, got %v", versions)
+	}
+
+	// A file marking its version referenceable takes precedence
+	marked := writeFiles(t, map[string]string{
+		"v1alpha1.k": `__xrd_kind = "XDatabase"
+__xrd_group = "database.example.org"
+__xrd_version = "v1alpha1"
+__xrd_referenceable = True
+
+schema Database:
+    engine: str
+`,
+	})
+	if err := execute(t, "-i", filepath.Join(marked, "v1alpha1.k"), "-i", beta, "-o", output); err != nil {
+		t.Fatalf("conversion failed: %v", err)
+	}
+	versions = xrdVersions(t, output)
+	if !versions["v1alpha1"] || versions["v1beta1"] {
+		t.Errorf("expected only the marked version to be referenceable, got %v", versions)
+	}
+
+	err := execute(t, "-i", alpha, "-i", beta, "-o", output, "--referenceable=false")
+	if err == nil || !strings.Contains(err.Error(), "referenceable") {
+		t.Errorf("expected an error without a referenceable version, got %v", err)
+	}
+}
+
+func TestImportedStatusSchemas(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app/kcl.mod": `[package]
+name = "app"
+
+[dependencies]
+shared = { path = "../shared" }
+`,
+		"shared/kcl.mod": `[package]
+name = "shared"
+`,
+		"shared/common.k": `schema Tag:
+    key: str
+
+# @status
+schema SharedStatus:
+    healthy: bool
+
+# @spec.writeConnectionSecretToRef
+schema SecretRef:
+    name: str
+`,
+		"app/main.k": `import shared.common
+
+__xrd_kind = "XApp"
+__xrd_group = "example.org"
+
+schema App:
+    tags?: [common.Tag]
+`,
+	})
+	output := filepath.Join(dir, "xrd.yaml")
+	if err := execute(t, "-i", filepath.Join(dir, "app", "main.k"), "-o", output); err != nil {
+		t.Fatalf("conversion failed: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"healthy", "writeConnectionSecretToRef"} {
+		if strings.Contains(string(data), leaked) {
+			t.Errorf("Expected the schemas of the imported package to stay out of the XRD, found %s:\n%s", leaked, data)
+		}
+	}
+	if !strings.Contains(string(data), "tags:") {
+		t.Errorf("Expected the tags field in the XRD:\n%s", data)
+	}
+}
+
+func TestOpenAPIDefinitionsPerCommand(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app.k": `import k8s.api.core.v1
+
+__xrd_kind = "XApp"
+__xrd_group = "example.org"
+
+schema App:
+    tolerations?: [v1.Toleration]
+`,
+		"first.json":  `{"definitions": {"io.k8s.api.core.v1.Toleration": {"type": "object", "properties": {"key": {"type": "string"}}}}}`,
+		"second.json": `{"definitions": {"io.k8s.api.core.v1.Toleration": {"type": "object", "properties": {"effect": {"type": "string"}}}}}`,
+	})
+
+	// Each command loads the definitions given to it, not those loaded by
+	// an earlier command in the same process
+	for _, c := range []struct{ file, field string }{{"first.json", "key:"}, {"second.json", "effect:"}} {
+		output := filepath.Join(dir, "xrd.yaml")
+		if err := execute(t, "-i", filepath.Join(dir, "app.k"), "-o", output, "--openapi", filepath.Join(dir, c.file)); err != nil {
+			t.Fatalf("conversion with %s failed: %v", c.file, err)
+		}
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), c.field) {
+			t.Errorf("Expected the definition from %s in the XRD:\n%s", c.file, data)
+		}
+	}
+}
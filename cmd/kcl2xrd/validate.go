@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/validator"
+	"github.com/spf13/cobra"
+)
+
+// newValidateCmd returns the validate command, which checks composite
+// resources and claims against the schemas of an XRD
+func newValidateCmd() *cobra.Command {
+	var xrdFile string
+	cmd := &cobra.Command{
+		Use:   "validate --xrd XRD MANIFEST...",
+		Short: "Validate composite resources and claims against an XRD",
+		Long: `Validate composite resource and claim manifests against the openAPIV3Schema
+of an XRD or CRD without a cluster. Defaults are applied first; then types,
+required fields, enums, patterns, formats, bounds, oneOf/anyOf and
+x-kubernetes-validations CEL rules are checked as the API server would.
+Errors are reported with the path of the field. Transition rules using
+oldSelf are skipped, and rules using functions only the API server provides
+are reported as warnings.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Failures past this point are not usage errors
+			cmd.SilenceUsage = true
+
+			data, err := os.ReadFile(xrdFile)
+			if err != nil {
+				return fmt.Errorf("failed to read XRD: %w", err)
+			}
+			v, err := validator.New(data)
+			if err != nil {
+				return fmt.Errorf("failed to load %s: %w", xrdFile, err)
+			}
+
+			total, invalid := 0, 0
+			for _, file := range args {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read manifest: %w", err)
+				}
+				results, err := v.ValidateDocuments(data)
+				if err != nil {
+					return fmt.Errorf("failed to validate %s: %w", file, err)
+				}
+				for _, result := range results {
+					total++
+					for _, warning := range result.Warnings {
+						fmt.Fprintf(os.Stderr, "Warning: %s: %s/%s: %s\n", file, result.Kind, result.Name, warning)
+					}
+					if len(result.Errors) > 0 {
+						invalid++
+					}
+					for _, e := range result.Errors {
+						fmt.Printf("%s: %s/%s: %s\n", file, result.Kind, result.Name, e)
+					}
+				}
+			}
+
+			if invalid > 0 {
+				return fmt.Errorf("%d of %d manifests are invalid", invalid, total)
+			}
+			fmt.Fprintf(os.Stderr, "%d manifests are valid\n", total)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&xrdFile, "xrd", "", "XRD or CRD to validate against (required)")
+	if err := cmd.MarkFlagRequired("xrd"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return cmd
+}
@@ -0,0 +1,198 @@
+// Package differ compares two revisions of an XRD or CRD and classifies the
+// changes to its schemas as breaking or additive for the composite
+// resources and claims written against the old revision.
+package differ
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/generator"
+	"gopkg.in/yaml.v3"
+)
+
+// Severity classifies a change
+type Severity int
+
+const (
+	// Additive changes accept every manifest the old revision accepted,
+	// e.g. a new optional field or a relaxed constraint
+	Additive Severity = iota
+	// Breaking changes can reject or change the meaning of manifests the
+	// old revision accepted, e.g. a removed field or a tightened constraint
+	Breaking
+)
+
+func (s Severity) String() string {
+	if s == Breaking {
+		return "breaking"
+	}
+	return "additive"
+}
+
+// Change is a difference between two revisions of a definition
+type Change struct {
+	Severity Severity
+	Version  string // empty for changes to the definition itself
+	Path     string // field path, e.g. spec.parameters.size; empty for versions
+	Message  string
+}
+
+func (c Change) String() string {
+	location := c.Version
+	if c.Path != "" {
+		if location != "" {
+			location += " "
+		}
+		location += c.Path
+	}
+	if location == "" {
+		return c.Message
+	}
+	return location + ": " + c.Message
+}
+
+// definition is the part of an XRD or CRD that is compared
+type definition struct {
+	Kind string `yaml:"kind"`
+	Spec struct {
+		Group string `yaml:"group"`
+		Scope string `yaml:"scope"`
+		Names struct {
+			Kind string `yaml:"kind"`
+		} `yaml:"names"`
+		ClaimNames *struct {
+			Kind string `yaml:"kind"`
+		} `yaml:"claimNames"`
+		Versions []struct {
+			Name   string `yaml:"name"`
+			Served bool   `yaml:"served"`
+			Schema struct {
+				OpenAPIV3Schema generator.PropertySchema `yaml:"openAPIV3Schema"`
+			} `yaml:"schema"`
+		} `yaml:"versions"`
+	} `yaml:"spec"`
+}
+
+// Compare returns the changes from the old to the new revision of a
+// definition, breaking changes first
+func Compare(oldData, newData []byte) ([]Change, error) {
+	oldDef, err := decodeDefinition(oldData)
+	if err != nil {
+		return nil, fmt.Errorf("old definition: %w", err)
+	}
+	newDef, err := decodeDefinition(newData)
+	if err != nil {
+		return nil, fmt.Errorf("new definition: %w", err)
+	}
+
+	d := &differ{}
+	d.definition(oldDef, newDef)
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		return d.changes[i].Severity > d.changes[j].Severity
+	})
+	return d.changes, nil
+}
+
+// HasBreaking reports whether any of the changes is breaking
+func HasBreaking(changes []Change) bool {
+	for _, c := range changes {
+		if c.Severity == Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeDefinition decodes the first XRD or CRD of a YAML stream
+func decodeDefinition(data []byte) (*definition, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var d definition
+		err := decoder.Decode(&d)
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no CompositeResourceDefinition or CustomResourceDefinition found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode definition: %w", err)
+		}
+		if d.Kind == "CompositeResourceDefinition" || d.Kind == "CustomResourceDefinition" {
+			return &d, nil
+		}
+	}
+}
+
+// differ collects the changes between two definitions
+type differ struct {
+	version string
+	changes []Change
+}
+
+func (d *differ) breaking(path, format string, args ...interface{}) {
+	d.changes = append(d.changes, Change{Severity: Breaking, Version: d.version, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *differ) additive(path, format string, args ...interface{}) {
+	d.changes = append(d.changes, Change{Severity: Additive, Version: d.version, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// definition compares the names and versions of two definitions
+func (d *differ) definition(oldDef, newDef *definition) {
+	if oldDef.Spec.Group != newDef.Spec.Group {
+		d.breaking("", "group changed from %s to %s", oldDef.Spec.Group, newDef.Spec.Group)
+	}
+	if oldDef.Spec.Names.Kind != newDef.Spec.Names.Kind {
+		d.breaking("", "kind changed from %s to %s", oldDef.Spec.Names.Kind, newDef.Spec.Names.Kind)
+	}
+	if oldDef.Spec.Scope != newDef.Spec.Scope {
+		d.breaking("", "scope changed from %s to %s", orNone(oldDef.Spec.Scope), orNone(newDef.Spec.Scope))
+	}
+	switch {
+	case oldDef.Spec.ClaimNames != nil && newDef.Spec.ClaimNames == nil:
+		d.breaking("", "claims removed")
+	case oldDef.Spec.ClaimNames == nil && newDef.Spec.ClaimNames != nil:
+		d.additive("", "claims added with kind %s", newDef.Spec.ClaimNames.Kind)
+	case oldDef.Spec.ClaimNames != nil && oldDef.Spec.ClaimNames.Kind != newDef.Spec.ClaimNames.Kind:
+		d.breaking("", "claim kind changed from %s to %s", oldDef.Spec.ClaimNames.Kind, newDef.Spec.ClaimNames.Kind)
+	}
+
+	newVersions := make(map[string]int)
+	for i, v := range newDef.Spec.Versions {
+		newVersions[v.Name] = i
+	}
+	oldVersions := make(map[string]bool)
+	for _, oldVersion := range oldDef.Spec.Versions {
+		oldVersions[oldVersion.Name] = true
+		d.version = oldVersion.Name
+		i, ok := newVersions[oldVersion.Name]
+		if !ok {
+			d.breaking("", "version removed")
+			continue
+		}
+		newVersion := newDef.Spec.Versions[i]
+		switch {
+		case oldVersion.Served && !newVersion.Served:
+			d.breaking("", "version no longer served")
+		case !oldVersion.Served && newVersion.Served:
+			d.additive("", "version now served")
+		}
+		d.schema("", &oldVersion.Schema.OpenAPIV3Schema, &newVersion.Schema.OpenAPIV3Schema)
+	}
+	for _, newVersion := range newDef.Spec.Versions {
+		if !oldVersions[newVersion.Name] {
+			d.version = newVersion.Name
+			d.additive("", "version added")
+		}
+	}
+	d.version = ""
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,202 @@
+package differ
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// xrd returns an XRD with one version whose spec.parameters has the given
+// properties, indented by 20 spaces
+func xrd(version, properties string) []byte {
+	return []byte(`apiVersion: apiextensions.crossplane.io/v1
+kind: CompositeResourceDefinition
+metadata:
+  name: xbuckets.example.org
+spec:
+  group: example.org
+  names:
+    kind: XBucket
+    plural: xbuckets
+  versions:
+    - name: ` + version + `
+      served: true
+      referenceable: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              properties:
+                parameters:
+                  type: object
+` + properties)
+}
+
+const bucketProperties = `                  required:
+                    - name
+                  properties:
+                    name:
+                      type: string
+                      pattern: ^[a-z]+$
+                    region:
+                      type: string
+                      enum:
+                        - eu-west-1
+                        - us-east-1
+                    replicas:
+                      type: integer
+                      minimum: 1
+                      maximum: 5
+                    zones:
+                      type: array
+                      items:
+                        type: string
+`
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties string
+		want       []string
+	}{
+		{
+			name:       "unchanged",
+			properties: bucketProperties,
+		},
+		{
+			name: "field removed",
+			properties: strings.Replace(bucketProperties, `                    zones:
+                      type: array
+                      items:
+                        type: string
+`, "", 1),
+			want: []string{"breaking v1alpha1 spec.parameters.zones: field removed"},
+		},
+		{
+			name: "required field added",
+			properties: strings.Replace(bucketProperties, "                    - name\n", "                    - name\n                    - size\n", 1) +
+				"                    size:\n                      type: integer\n",
+			want: []string{"breaking v1alpha1 spec.parameters.size: required field added"},
+		},
+		{
+			name: "required field added with default",
+			properties: strings.Replace(bucketProperties, "                    - name\n", "                    - name\n                    - size\n", 1) +
+				"                    size:\n                      type: integer\n                      default: 10\n",
+			want: []string{"additive v1alpha1 spec.parameters.size: required field added with default 10"},
+		},
+		{
+			name:       "optional field added",
+			properties: bucketProperties + "                    size:\n                      type: integer\n",
+			want:       []string{"additive v1alpha1 spec.parameters.size: optional field added"},
+		},
+		{
+			name:       "type changed",
+			properties: strings.Replace(bucketProperties, "type: integer", "type: string", 1),
+			want:       []string{"breaking v1alpha1 spec.parameters.replicas: type changed from integer to string"},
+		},
+		{
+			name:       "item type changed",
+			properties: strings.Replace(bucketProperties, "                        type: string\n", "                        type: integer\n", 1),
+			want:       []string{"breaking v1alpha1 spec.parameters.zones[*]: type changed from string to integer"},
+		},
+		{
+			name:       "enum tightened",
+			properties: strings.Replace(bucketProperties, "                        - us-east-1\n", "", 1),
+			want:       []string{`breaking v1alpha1 spec.parameters.region: enum values removed: "us-east-1"`},
+		},
+		{
+			name:       "enum relaxed",
+			properties: strings.Replace(bucketProperties, "                        - us-east-1\n", "                        - us-east-1\n                        - ap-south-1\n", 1),
+			want:       []string{`additive v1alpha1 spec.parameters.region: enum values added: "ap-south-1"`},
+		},
+		{
+			name:       "pattern changed",
+			properties: strings.Replace(bucketProperties, "^[a-z]+$", "^[a-z]{3,}$", 1),
+			want:       []string{`breaking v1alpha1 spec.parameters.name: pattern changed from "^[a-z]+$" to "^[a-z]{3,}$"`},
+		},
+		{
+			name:       "bounds",
+			properties: strings.Replace(strings.Replace(bucketProperties, "minimum: 1", "minimum: 2", 1), "maximum: 5", "maximum: 10", 1),
+			want: []string{
+				"breaking v1alpha1 spec.parameters.replicas: minimum raised from 1 to 2",
+				"additive v1alpha1 spec.parameters.replicas: maximum raised from 5 to 10",
+			},
+		},
+		{
+			name:       "exclusive and float bounds",
+			properties: strings.Replace(strings.Replace(bucketProperties, "minimum: 1", "minimum: 1\n                      exclusiveMinimum: true", 1), "maximum: 5", "maximum: 5.5", 1),
+			want: []string{
+				"breaking v1alpha1 spec.parameters.replicas: minimum 1 became exclusive",
+				"additive v1alpha1 spec.parameters.replicas: maximum raised from 5 to 5.5",
+			},
+		},
+		{
+			name:       "multipleOf",
+			properties: strings.Replace(bucketProperties, "maximum: 5", "maximum: 5\n                      multipleOf: 2", 1),
+			want:       []string{"breaking v1alpha1 spec.parameters.replicas: multipleOf 2 added"},
+		},
+		{
+			name:       "field became nullable",
+			properties: strings.Replace(bucketProperties, "pattern: ^[a-z]+$", "pattern: ^[a-z]+$\n                      nullable: true", 1),
+			want:       []string{"additive v1alpha1 spec.parameters.name: field became nullable"},
+		},
+		{
+			name: "field became immutable",
+			properties: strings.Replace(bucketProperties, "                      pattern: ^[a-z]+$\n",
+				"                      pattern: ^[a-z]+$\n                      x-kubernetes-validations:\n                        - rule: self == oldSelf\n", 1),
+			want: []string{"breaking v1alpha1 spec.parameters.name: field became immutable"},
+		},
+		{
+			name:       "field no longer required",
+			properties: strings.Replace(bucketProperties, "                  required:\n                    - name\n", "", 1),
+			want:       []string{"additive v1alpha1 spec.parameters.name: field no longer required"},
+		},
+		{
+			name:       "list type changed",
+			properties: strings.Replace(bucketProperties, "                      type: array\n", "                      type: array\n                      x-kubernetes-list-type: set\n", 1),
+			want:       []string{"breaking v1alpha1 spec.parameters.zones: list type changed from atomic to set"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := Compare(xrd("v1alpha1", bucketProperties), xrd("v1alpha1", tt.properties))
+			if err != nil {
+				t.Fatalf("Compare() error = %v", err)
+			}
+			var got []string
+			for _, c := range changes {
+				got = append(got, c.Severity.String()+" "+c.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compare() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	changes, err := Compare(xrd("v1alpha1", bucketProperties), xrd("v1beta1", bucketProperties))
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+	want := []Change{
+		{Severity: Breaking, Version: "v1alpha1", Message: "version removed"},
+		{Severity: Additive, Version: "v1beta1", Message: "version added"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Compare() = %+v, want %+v", changes, want)
+	}
+	if !HasBreaking(changes) {
+		t.Error("HasBreaking() = false, want true")
+	}
+}
+
+func TestCompareNoDefinition(t *testing.T) {
+	_, err := Compare([]byte("kind: ConfigMap\n"), xrd("v1alpha1", bucketProperties))
+	if err == nil || !strings.Contains(err.Error(), "no CompositeResourceDefinition") {
+		t.Errorf("Compare() error = %v, want missing definition", err)
+	}
+}
@@ -0,0 +1,438 @@
+package differ
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/generator"
+	"gopkg.in/yaml.v3"
+)
+
+// immutableRule is the transition rule that makes a field immutable
+const immutableRule = "self == oldSelf"
+
+// schema compares the schemas of a field
+func (d *differ) schema(path string, oldSchema, newSchema *generator.PropertySchema) {
+	if oldType, newType := typeName(oldSchema), typeName(newSchema); oldType != newType {
+		d.breaking(path, "type changed from %s to %s", oldType, newType)
+		return
+	}
+
+	d.properties(path, oldSchema, newSchema)
+	d.values(path, oldSchema, newSchema)
+	d.constraints(path, oldSchema, newSchema)
+	d.validations(path, oldSchema, newSchema)
+	d.kubernetesExtensions(path, oldSchema, newSchema)
+
+	if oldSchema.Items != nil && newSchema.Items != nil {
+		d.schema(path+"[*]", oldSchema.Items, newSchema.Items)
+	}
+}
+
+// properties compares the fields of an object and which of them are required
+func (d *differ) properties(path string, oldSchema, newSchema *generator.PropertySchema) {
+	oldRequired := stringSet(oldSchema.Required)
+	newRequired := stringSet(newSchema.Required)
+
+	for _, name := range sortedNames(oldSchema.Properties, newSchema.Properties) {
+		fieldPath := join(path, name)
+		oldProp, inOld := oldSchema.Properties[name]
+		newProp, inNew := newSchema.Properties[name]
+		switch {
+		case !inNew:
+			d.breaking(fieldPath, "field removed")
+		case !inOld && newRequired[name] && newProp.Default == nil:
+			d.breaking(fieldPath, "required field added")
+		case !inOld && newRequired[name]:
+			d.additive(fieldPath, "required field added with default %s", literal(newProp.Default))
+		case !inOld:
+			d.additive(fieldPath, "optional field added")
+		default:
+			switch {
+			case !oldRequired[name] && newRequired[name] && newProp.Default == nil:
+				d.breaking(fieldPath, "field became required")
+			case !oldRequired[name] && newRequired[name]:
+				d.additive(fieldPath, "field became required with default %s", literal(newProp.Default))
+			case oldRequired[name] && !newRequired[name]:
+				d.additive(fieldPath, "field no longer required")
+			}
+			d.schema(fieldPath, &oldProp, &newProp)
+		}
+	}
+}
+
+// values compares the schemas of the values of a map
+func (d *differ) values(path string, oldSchema, newSchema *generator.PropertySchema) {
+	oldValues, newValues := additionalProperties(oldSchema), additionalProperties(newSchema)
+	switch {
+	case oldValues == nil && newValues != nil:
+		d.additive(path, "additional properties allowed")
+	case oldValues != nil && newValues == nil:
+		d.breaking(path, "additional properties no longer allowed")
+	case oldValues != nil:
+		d.schema(path+"[*]", oldValues, newValues)
+	}
+}
+
+// constraints compares the value constraints of a field
+func (d *differ) constraints(path string, oldSchema, newSchema *generator.PropertySchema) {
+	d.enum(path, oldSchema.Enum, newSchema.Enum)
+	d.stringConstraint(path, "pattern", oldSchema.Pattern, newSchema.Pattern)
+	d.stringConstraint(path, "format", oldSchema.Format, newSchema.Format)
+	d.lowerBound(path, "minLength", intBound(oldSchema.MinLength), intBound(newSchema.MinLength))
+	d.upperBound(path, "maxLength", intBound(oldSchema.MaxLength), intBound(newSchema.MaxLength))
+	d.lowerBound(path, "minimum", bound{oldSchema.Minimum, oldSchema.ExclusiveMinimum}, bound{newSchema.Minimum, newSchema.ExclusiveMinimum})
+	d.upperBound(path, "maximum", bound{oldSchema.Maximum, oldSchema.ExclusiveMaximum}, bound{newSchema.Maximum, newSchema.ExclusiveMaximum})
+	d.multipleOf(path, oldSchema.MultipleOf, newSchema.MultipleOf)
+	d.lowerBound(path, "minItems", intBound(oldSchema.MinItems), intBound(newSchema.MinItems))
+	d.upperBound(path, "maxItems", intBound(oldSchema.MaxItems), intBound(newSchema.MaxItems))
+	d.lowerBound(path, "minProperties", intBound(oldSchema.MinProperties), intBound(newSchema.MinProperties))
+	d.upperBound(path, "maxProperties", intBound(oldSchema.MaxProperties), intBound(newSchema.MaxProperties))
+	d.combination(path, "oneOf", oldSchema.OneOf, newSchema.OneOf)
+	d.combination(path, "anyOf", oldSchema.AnyOf, newSchema.AnyOf)
+
+	switch {
+	case !oldSchema.UniqueItems && newSchema.UniqueItems:
+		d.breaking(path, "items must be unique")
+	case oldSchema.UniqueItems && !newSchema.UniqueItems:
+		d.additive(path, "items no longer must be unique")
+	}
+
+	// Null values of fields that are not nullable are pruned
+	switch {
+	case oldSchema.Nullable && !newSchema.Nullable:
+		d.breaking(path, "field no longer nullable")
+	case !oldSchema.Nullable && newSchema.Nullable:
+		d.additive(path, "field became nullable")
+	}
+
+	switch {
+	case oldSchema.Default == nil && newSchema.Default != nil:
+		d.additive(path, "default %s added", literal(newSchema.Default))
+	case oldSchema.Default != nil && newSchema.Default == nil:
+		d.breaking(path, "default %s removed", literal(oldSchema.Default))
+	case !reflect.DeepEqual(oldSchema.Default, newSchema.Default):
+		d.breaking(path, "default changed from %s to %s", literal(oldSchema.Default), literal(newSchema.Default))
+	}
+}
+
+// enum compares the allowed values of a field
+func (d *differ) enum(path string, oldEnum, newEnum []interface{}) {
+	switch {
+	case len(oldEnum) == 0 && len(newEnum) > 0:
+		d.breaking(path, "enum added: %s", literals(newEnum))
+		return
+	case len(oldEnum) > 0 && len(newEnum) == 0:
+		d.additive(path, "enum removed")
+		return
+	}
+	if removed := missing(oldEnum, newEnum); len(removed) > 0 {
+		d.breaking(path, "enum values removed: %s", literals(removed))
+	}
+	if added := missing(newEnum, oldEnum); len(added) > 0 {
+		d.additive(path, "enum values added: %s", literals(added))
+	}
+}
+
+// stringConstraint compares a pattern or format. A changed one may reject
+// values the old one accepted.
+func (d *differ) stringConstraint(path, keyword, oldValue, newValue string) {
+	switch {
+	case oldValue == newValue:
+	case oldValue == "":
+		d.breaking(path, "%s %q added", keyword, newValue)
+	case newValue == "":
+		d.additive(path, "%s %q removed", keyword, oldValue)
+	default:
+		d.breaking(path, "%s changed from %q to %q", keyword, oldValue, newValue)
+	}
+}
+
+// bound is a minimum or maximum, which may be exclusive
+type bound struct {
+	value     *float64
+	exclusive bool
+}
+
+func intBound(v *int) bound {
+	if v == nil {
+		return bound{}
+	}
+	f := float64(*v)
+	return bound{value: &f}
+}
+
+func (b bound) String() string {
+	if b.exclusive {
+		return fmt.Sprintf("%v (exclusive)", *b.value)
+	}
+	return fmt.Sprint(*b.value)
+}
+
+// lowerBound compares a minimum, which tightens when raised or made exclusive
+func (d *differ) lowerBound(path, keyword string, oldBound, newBound bound) {
+	switch {
+	case oldBound.value == nil && newBound.value == nil:
+	case oldBound.value == nil:
+		d.breaking(path, "%s %s added", keyword, newBound)
+	case newBound.value == nil:
+		d.additive(path, "%s %s removed", keyword, oldBound)
+	case *newBound.value > *oldBound.value:
+		d.breaking(path, "%s raised from %s to %s", keyword, oldBound, newBound)
+	case *newBound.value < *oldBound.value:
+		d.additive(path, "%s lowered from %s to %s", keyword, oldBound, newBound)
+	default:
+		d.exclusivity(path, keyword, oldBound, newBound)
+	}
+}
+
+// upperBound compares a maximum, which tightens when lowered or made exclusive
+func (d *differ) upperBound(path, keyword string, oldBound, newBound bound) {
+	switch {
+	case oldBound.value == nil && newBound.value == nil:
+	case oldBound.value == nil:
+		d.breaking(path, "%s %s added", keyword, newBound)
+	case newBound.value == nil:
+		d.additive(path, "%s %s removed", keyword, oldBound)
+	case *newBound.value < *oldBound.value:
+		d.breaking(path, "%s lowered from %s to %s", keyword, oldBound, newBound)
+	case *newBound.value > *oldBound.value:
+		d.additive(path, "%s raised from %s to %s", keyword, oldBound, newBound)
+	default:
+		d.exclusivity(path, keyword, oldBound, newBound)
+	}
+}
+
+// exclusivity compares two bounds of the same value
+func (d *differ) exclusivity(path, keyword string, oldBound, newBound bound) {
+	switch {
+	case !oldBound.exclusive && newBound.exclusive:
+		d.breaking(path, "%s %v became exclusive", keyword, *newBound.value)
+	case oldBound.exclusive && !newBound.exclusive:
+		d.additive(path, "%s %v no longer exclusive", keyword, *newBound.value)
+	}
+}
+
+// multipleOf compares the divisor of a number. A new divisor accepts every
+// old value only if the old divisor is a multiple of it.
+func (d *differ) multipleOf(path string, oldValue, newValue *float64) {
+	switch {
+	case oldValue == nil && newValue == nil:
+	case oldValue == nil:
+		d.breaking(path, "multipleOf %v added", *newValue)
+	case newValue == nil:
+		d.additive(path, "multipleOf %v removed", *oldValue)
+	case *oldValue == *newValue:
+	case math.Mod(*oldValue, *newValue) == 0:
+		d.additive(path, "multipleOf changed from %v to %v", *oldValue, *newValue)
+	default:
+		d.breaking(path, "multipleOf changed from %v to %v", *oldValue, *newValue)
+	}
+}
+
+// combination compares oneOf or anyOf alternatives
+func (d *differ) combination(path, keyword string, oldAlternatives, newAlternatives []generator.PropertySchema) {
+	switch {
+	case reflect.DeepEqual(oldAlternatives, newAlternatives):
+	case len(oldAlternatives) == 0:
+		d.breaking(path, "%s added", keyword)
+	case len(newAlternatives) == 0:
+		d.additive(path, "%s removed", keyword)
+	default:
+		d.breaking(path, "%s changed", keyword)
+	}
+}
+
+// validations compares the CEL rules of a field. Rules are matched by their
+// expression; a new rule may reject objects the old revision accepted.
+func (d *differ) validations(path string, oldSchema, newSchema *generator.PropertySchema) {
+	oldRules := make(map[string]bool)
+	for _, v := range oldSchema.XKubernetesValidations {
+		oldRules[v.Rule] = true
+	}
+	newRules := make(map[string]bool)
+	for _, v := range newSchema.XKubernetesValidations {
+		newRules[v.Rule] = true
+		switch {
+		case oldRules[v.Rule]:
+		case v.Rule == immutableRule:
+			d.breaking(path, "field became immutable")
+		default:
+			d.breaking(path, "rule %q added", v.Rule)
+		}
+	}
+	for _, v := range oldSchema.XKubernetesValidations {
+		switch {
+		case newRules[v.Rule]:
+		case v.Rule == immutableRule:
+			d.additive(path, "field no longer immutable")
+		default:
+			d.additive(path, "rule %q removed", v.Rule)
+		}
+	}
+}
+
+// kubernetesExtensions compares the x-kubernetes- keywords of a field
+func (d *differ) kubernetesExtensions(path string, oldSchema, newSchema *generator.PropertySchema) {
+	oldImmutable := oldSchema.XKubernetesImmutable != nil && *oldSchema.XKubernetesImmutable
+	newImmutable := newSchema.XKubernetesImmutable != nil && *newSchema.XKubernetesImmutable
+	switch {
+	case !oldImmutable && newImmutable:
+		d.breaking(path, "field became immutable")
+	case oldImmutable && !newImmutable:
+		d.additive(path, "field no longer immutable")
+	}
+
+	oldEmbedded := oldSchema.XKubernetesEmbeddedResource != nil && *oldSchema.XKubernetesEmbeddedResource
+	newEmbedded := newSchema.XKubernetesEmbeddedResource != nil && *newSchema.XKubernetesEmbeddedResource
+	switch {
+	case !oldEmbedded && newEmbedded:
+		d.breaking(path, "field became an embedded resource")
+	case oldEmbedded && !newEmbedded:
+		d.additive(path, "field no longer an embedded resource")
+	}
+
+	oldPreserve := oldSchema.XKubernetesPreserveUnknownFields != nil && *oldSchema.XKubernetesPreserveUnknownFields
+	newPreserve := newSchema.XKubernetesPreserveUnknownFields != nil && *newSchema.XKubernetesPreserveUnknownFields
+	switch {
+	case oldPreserve && !newPreserve:
+		d.breaking(path, "unknown fields no longer preserved")
+	case !oldPreserve && newPreserve:
+		d.additive(path, "unknown fields preserved")
+	}
+
+	if oldType, newType := listType(oldSchema), listType(newSchema); oldType != newType {
+		d.breaking(path, "list type changed from %s to %s", oldType, newType)
+	} else if !reflect.DeepEqual(oldSchema.XKubernetesListMapKeys, newSchema.XKubernetesListMapKeys) {
+		d.breaking(path, "list map keys changed from %v to %v", oldSchema.XKubernetesListMapKeys, newSchema.XKubernetesListMapKeys)
+	}
+	if oldSchema.XKubernetesMapType != newSchema.XKubernetesMapType {
+		d.breaking(path, "map type changed from %s to %s", orNone(oldSchema.XKubernetesMapType), orNone(newSchema.XKubernetesMapType))
+	}
+}
+
+// typeName returns the type of a schema for comparison
+func typeName(s *generator.PropertySchema) string {
+	if s.XKubernetesIntOrString != nil && *s.XKubernetesIntOrString {
+		return "int-or-string"
+	}
+	if s.Type == "" {
+		return "any"
+	}
+	return s.Type
+}
+
+// listType returns the list type of a schema; lists are atomic by default
+func listType(s *generator.PropertySchema) string {
+	if s.Type == "array" && s.XKubernetesListType == "" {
+		return "atomic"
+	}
+	return s.XKubernetesListType
+}
+
+// additionalProperties returns the schema of the values of a map, which is
+// decoded as a generic map
+func additionalProperties(s *generator.PropertySchema) *generator.PropertySchema {
+	switch v := s.AdditionalProperties.(type) {
+	case bool:
+		if v {
+			return &generator.PropertySchema{}
+		}
+	case map[string]interface{}:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		var values generator.PropertySchema
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil
+		}
+		return &values
+	}
+	return nil
+}
+
+// missing returns the values of a not in b
+func missing(a, b []interface{}) []interface{} {
+	var result []interface{}
+	for _, v := range a {
+		found := false
+		for _, w := range b {
+			found = found || reflect.DeepEqual(v, w)
+		}
+		if !found {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func literal(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return fmt.Sprint(v)
+	}
+	setFlowStyle(&node)
+	data, err = yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func literals(values []interface{}) string {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = literal(v)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// setFlowStyle formats a YAML value on one line
+func setFlowStyle(node *yaml.Node) {
+	node.Style |= yaml.FlowStyle
+	for _, child := range node.Content {
+		setFlowStyle(child)
+	}
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// sortedNames returns the names of the properties of either schema
+func sortedNames(a, b map[string]generator.PropertySchema) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, properties := range []map[string]generator.PropertySchema{a, b} {
+		for name := range properties {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,109 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+)
+
+// AnnotationHandler applies a custom annotation to the OpenAPI schema
+// generated for the field it is written on, e.g. by adding an extension:
+//
+//	schema.Extensions["x-example-org-cost-center"] = center
+//
+// Extensions must start with x- and must not be keywords PropertySchema has
+// a field for, like x-kubernetes-validations. A returned error fails the
+// generation.
+type AnnotationHandler func(schema *PropertySchema, field parser.Field, annotation parser.Annotation) error
+
+var (
+	annotationHandlersMu sync.RWMutex
+	annotationHandlers   = make(map[string]AnnotationHandler)
+)
+
+// RegisterAnnotation registers the handler of a custom annotation. The
+// annotation is registered with the parser too, unless it already is, so
+// that it is accepted on schema attributes; to also handle it in the parser,
+// call parser.RegisterAnnotation first. RegisterAnnotation is meant to be
+// called from init functions.
+func RegisterAnnotation(name string, handler AnnotationHandler) error {
+	if handler == nil {
+		return errors.New("annotation handler is nil")
+	}
+	annotationHandlersMu.Lock()
+	defer annotationHandlersMu.Unlock()
+	if _, ok := annotationHandlers[name]; ok {
+		return fmt.Errorf("annotation @%s is already registered", name)
+	}
+	if !parser.IsRegisteredAnnotation(name) {
+		if err := parser.RegisterAnnotation(name, nil); err != nil {
+			return err
+		}
+	}
+	annotationHandlers[name] = handler
+	return nil
+}
+
+// applyAnnotationHandlers calls the handlers of the custom annotations of a
+// field on its schema
+func applyAnnotationHandlers(schema *PropertySchema, field parser.Field) error {
+	for _, annotation := range field.Annotations {
+		annotationHandlersMu.RLock()
+		handler := annotationHandlers[annotation.Name]
+		annotationHandlersMu.RUnlock()
+		if handler == nil {
+			continue
+		}
+		if schema.Extensions == nil {
+			schema.Extensions = make(map[string]interface{})
+		}
+		if err := handler(schema, field, annotation); err != nil {
+			return fmt.Errorf("@%s on %s: %w", annotation.Name, field.Name, err)
+		}
+		if err := checkExtensions(schema.Extensions); err != nil {
+			return fmt.Errorf("@%s on %s: %w", annotation.Name, field.Name, err)
+		}
+		if len(schema.Extensions) == 0 {
+			schema.Extensions = nil
+		}
+	}
+	return nil
+}
+
+// schemaKeywords are the keywords PropertySchema has fields for, which
+// extensions would be written next to
+var schemaKeywords = func() map[string]bool {
+	keywords := make(map[string]bool)
+	t := reflect.TypeOf(PropertySchema{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			keywords[name] = true
+		}
+	}
+	return keywords
+}()
+
+// checkExtensions reports an extension that is not an x- keyword, or that
+// is a keyword PropertySchema has a field for
+func checkExtensions(extensions map[string]interface{}) error {
+	keys := make([]string, 0, len(extensions))
+	for key := range extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "x-") {
+			return fmt.Errorf("extension %s must start with x-", key)
+		}
+		if schemaKeywords[key] {
+			return fmt.Errorf("extension %s is a schema keyword kcl2xrd sets itself", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,745 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// Cost limits the Kubernetes API server enforces on the estimated cost of
+// x-kubernetes-validations rules, and the request size it derives the size
+// of unbounded lists, maps and strings from
+const (
+	ruleCostLimit       = 10000000  // per rule
+	schemaCostLimit     = 100000000 // all rules of a schema, multiplied by how often they run
+	maxRequestSizeBytes = 3 * 1024 * 1024
+)
+
+// CheckValidationCosts estimates the cost of the x-kubernetes-validations
+// rules of the versions' schemas the way the Kubernetes API server does when
+// the XRD's CRD is created, and returns warnings for rules exceeding the
+// per-rule or per-schema budget. The warnings name the lists, maps and
+// strings without @maxItems or @maxLength that the estimate assumes to be as
+// large as a request allows.
+func CheckValidationCosts(versions []XRDVersion, opts XRDOptions) (parser.Diagnostics, error) {
+	var warnings parser.Diagnostics
+	for _, v := range versions {
+		r := newSchemaResolver(v.Schemas, opts)
+		openAPIV3Schema := r.buildOpenAPIV3Schema(v.Schema, v.StatusPreserveUnknownFields)
+		if r.err != nil {
+			return nil, r.err
+		}
+		c, err := newRuleChecker(v.Schema.File)
+		if err != nil {
+			return nil, err
+		}
+		c.estimateCosts = true
+		c.walk("", rootPropertySchema(openAPIV3Schema), 1, nil, "")
+		warnings = append(warnings, c.costWarnings(v.Name, len(versions) > 1)...)
+	}
+	return warnings, nil
+}
+
+// checkValidationRules type-checks the x-kubernetes-validations rules of a
+// version's schema against the types of the fields they are declared on
+func checkValidationRules(file string, openAPIV3Schema OpenAPIV3Schema) error {
+	c, err := newRuleChecker(file)
+	if err != nil {
+		return err
+	}
+	c.walk("", rootPropertySchema(openAPIV3Schema), 1, nil, "")
+	if len(c.errors) > 0 {
+		return fmt.Errorf("invalid CEL rules:\n  %s", strings.Join(c.errors, "\n  "))
+	}
+	return nil
+}
+
+// rootPropertySchema returns the root of an openAPIV3Schema as a property
+func rootPropertySchema(s OpenAPIV3Schema) *PropertySchema {
+	return &PropertySchema{
+		Type:       s.Type,
+		Properties: s.Properties,
+		Required:   s.Required,
+	}
+}
+
+// ruleChecker compiles the rules of a schema in a CEL environment like the
+// one the Kubernetes API server compiles x-kubernetes-validations in
+type ruleChecker struct {
+	file     string
+	env      *cel.Env
+	provider *schemaTypeProvider
+	errors   []string
+
+	estimateCosts bool
+	costs         []ruleCost
+}
+
+// ruleCost is the estimated cost of a rule
+type ruleCost struct {
+	line      int // source line of the rule
+	rule      string
+	path      string
+	cost      uint64 // cost of one evaluation
+	total     uint64 // cost times the number of times the rule can run
+	unbounded []string
+}
+
+func newRuleChecker(file string) (*ruleChecker, error) {
+	env, err := cel.NewEnv(celEnvOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+	return &ruleChecker{
+		file:     file,
+		env:      env,
+		provider: &schemaTypeProvider{Provider: env.CELTypeProvider(), objects: make(map[string]*PropertySchema)},
+	}, nil
+}
+
+// celEnvOptions returns the libraries of the Kubernetes CEL environment for
+// CRD validation rules
+func celEnvOptions() []cel.EnvOption {
+	opts := []cel.EnvOption{
+		cel.HomogeneousAggregateLiterals(),
+		cel.DefaultUTCTimeZone(true),
+		cel.CrossTypeNumericComparisons(true),
+		cel.OptionalTypes(),
+		ext.Strings(ext.StringsVersion(2)),
+		ext.Sets(),
+		ext.Lists(),
+		ext.Bindings(),
+		ext.TwoVarComprehensions(),
+	}
+	return append(opts, kubernetesLibraries()...)
+}
+
+// walk checks the rules of a schema and the schemas below it. cardinality
+// is how often the schema's rules can run for one object; unbounded are the
+// enclosing lists and maps without a size limit. uncorrelated is the
+// enclosing list, if any, whose items the API server cannot match with their
+// previous revision, which it only does for maps and lists of type map.
+func (c *ruleChecker) walk(path string, s *PropertySchema, cardinality uint64, unbounded []string, uncorrelated string) {
+	for _, validation := range s.XKubernetesValidations {
+		c.check(path, s, validation, cardinality, unbounded, uncorrelated)
+	}
+
+	for _, name := range sortedPropertyNames(s.Properties) {
+		prop := s.Properties[name]
+		c.walk(joinPath(path, name), &prop, cardinality, unbounded, uncorrelated)
+	}
+	if s.Items != nil {
+		itemsPath := path + "[*]"
+		n, bounded := maxElements(s)
+		if !bounded {
+			unbounded = append(append([]string(nil), unbounded...), displayFieldPath(path))
+		}
+		itemsUncorrelated := uncorrelated
+		if itemsUncorrelated == "" && s.XKubernetesListType != "map" {
+			itemsUncorrelated = displayFieldPath(path)
+		}
+		c.walk(itemsPath, s.Items, multiplySaturating(cardinality, n), unbounded, itemsUncorrelated)
+	}
+	if additional := AdditionalPropertiesSchema(s); additional != nil {
+		n, bounded := maxElements(s)
+		if !bounded {
+			unbounded = append(append([]string(nil), unbounded...), displayFieldPath(path))
+		}
+		c.walk(path+"[*]", additional, multiplySaturating(cardinality, n), unbounded, uncorrelated)
+	}
+}
+
+// check compiles a rule with self and oldSelf typed as the schema, and
+// checks its message expression, reason and field path. Transition rules,
+// like those of @immutable, are rejected under uncorrelated list items.
+func (c *ruleChecker) check(path string, s *PropertySchema, validation K8sValidation, cardinality uint64, unbounded []string, uncorrelated string) {
+	location := validationLocation(c.file, validation.Line)
+	fail := func(format string, args ...interface{}) {
+		c.errors = append(c.errors, fmt.Sprintf("%srule %q on %s: ", location, validation.Rule, displayFieldPath(path))+fmt.Sprintf(format, args...))
+	}
+
+	optionalOldSelf := validation.OptionalOldSelf != nil && *validation.OptionalOldSelf
+	selfType := c.provider.declType(path, s)
+	oldSelfType := selfType
+	if optionalOldSelf {
+		oldSelfType = cel.OptionalType(selfType)
+	}
+	env, err := c.env.Extend(
+		cel.CustomTypeProvider(c.provider),
+		cel.Variable("self", selfType),
+		cel.Variable("oldSelf", oldSelfType),
+	)
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+
+	if validation.Reason != "" && !validReasons[validation.Reason] {
+		fail("reason %q must be one of FieldValueInvalid, FieldValueForbidden, FieldValueRequired or FieldValueDuplicate", validation.Reason)
+	}
+	if validation.FieldPath != "" {
+		if err := checkFieldPath(s, validation.FieldPath); err != nil {
+			fail("fieldPath %q %v", validation.FieldPath, err)
+		}
+	}
+	if validation.MessageExpression != "" {
+		messageAST, iss := env.Compile(validation.MessageExpression)
+		switch {
+		case iss.Err() != nil:
+			fail("messageExpression %q: %s", validation.MessageExpression, compileErrors(iss))
+		case messageAST.OutputType().Kind() != types.StringKind && messageAST.OutputType().Kind() != types.DynKind:
+			fail("messageExpression %q must evaluate to a string, not %s", validation.MessageExpression, messageAST.OutputType())
+		}
+	}
+
+	ast, iss := env.Compile(validation.Rule)
+	if iss.Err() != nil {
+		fail("%s", compileErrors(iss))
+		return
+	}
+	if kind := ast.OutputType().Kind(); kind != types.BoolKind && kind != types.DynKind {
+		fail("must evaluate to a bool, not %s", ast.OutputType())
+		return
+	}
+	if optionalOldSelf && !usesOldSelf(ast) {
+		fail("optionalOldSelf may only be set on rules that use oldSelf")
+	}
+	if uncorrelated != "" && usesOldSelf(ast) {
+		fail("oldSelf cannot be used in the items of %s, which are not correlated with their previous revision; set @listType(\"map\") and @listMapKeys on the list", uncorrelated)
+	}
+	if !c.estimateCosts {
+		return
+	}
+
+	estimator := &sizeEstimator{root: s, path: path}
+	estimate, err := env.EstimateCost(ast, estimator)
+	if err != nil {
+		return
+	}
+	c.costs = append(c.costs, ruleCost{
+		line:      validation.Line,
+		rule:      validation.Rule,
+		path:      displayFieldPath(path),
+		cost:      estimate.Max,
+		total:     multiplySaturating(estimate.Max, cardinality),
+		unbounded: mergeUnbounded(unbounded, estimator.unbounded),
+	})
+}
+
+// validReasons are the reasons a rule can report a failure with
+var validReasons = map[string]bool{
+	"FieldValueInvalid":   true,
+	"FieldValueForbidden": true,
+	"FieldValueRequired":  true,
+	"FieldValueDuplicate": true,
+}
+
+// compileErrors formats the errors of a compilation with their columns
+func compileErrors(iss *cel.Issues) string {
+	var messages []string
+	for _, e := range iss.Errors() {
+		messages = append(messages, fmt.Sprintf("%s (column %d)", e.Message, e.Location.Column()+1))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// usesOldSelf reports whether a compiled rule refers to oldSelf, which makes
+// it a transition rule
+func usesOldSelf(ast *cel.Ast) bool {
+	for _, reference := range ast.NativeRep().ReferenceMap() {
+		if reference.Name == "oldSelf" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFieldPath checks that the field path of a rule, e.g. .spec.replicas
+// or .labels['app.kubernetes.io/name'], names a field of the schema the
+// rule is declared on. List items cannot be named.
+func checkFieldPath(s *PropertySchema, fieldPath string) error {
+	rest := fieldPath
+	for rest != "" {
+		parent := strings.TrimSuffix(fieldPath, rest)
+		if parent == "" {
+			parent = "the object"
+		}
+		var name string
+		switch {
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name, rest = rest[1:end+1], rest[end+1:]
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return fmt.Errorf("has an unterminated ['...'] selection")
+			}
+			name, rest = rest[2:end], rest[end+2:]
+		default:
+			return fmt.Errorf("must be a path of .field or ['key'] selections")
+		}
+		if name == "" {
+			return fmt.Errorf("selects an empty field name")
+		}
+
+		if prop, ok := s.Properties[name]; ok {
+			s = &prop
+		} else if additional := AdditionalPropertiesSchema(s); additional != nil {
+			s = additional
+		} else {
+			return fmt.Errorf("names %s, which is not a field of %s", name, parent)
+		}
+	}
+	return nil
+}
+
+// costWarnings returns warnings for the rules exceeding the per-rule budget,
+// and for the most expensive of the other rules if all of them exceed the
+// schema budget
+func (c *ruleChecker) costWarnings(version string, named bool) parser.Diagnostics {
+	prefix := ""
+	if named {
+		prefix = "version " + version + ": "
+	}
+	warn := func(line int, format string, args ...interface{}) parser.Diagnostic {
+		return parser.Diagnostic{Severity: parser.SeverityWarning, File: c.file, Line: line, Message: prefix + fmt.Sprintf(format, args...)}
+	}
+
+	var warnings parser.Diagnostics
+	var total uint64
+	for _, cost := range c.costs {
+		total = addSaturating(total, cost.total)
+		if cost.cost > ruleCostLimit {
+			warnings = append(warnings, warn(cost.line, "rule %q on %s has %s, exceeding the Kubernetes per-rule budget of %d%s",
+				cost.rule, cost.path, costPhrase(cost.cost), ruleCostLimit, unboundedHint(cost.unbounded)))
+		}
+	}
+	if total <= schemaCostLimit {
+		return warnings
+	}
+
+	costs := append([]ruleCost(nil), c.costs...)
+	sort.SliceStable(costs, func(i, j int) bool { return costs[i].total > costs[j].total })
+	warnings = append(warnings, warn(0, "the rules have %s in total, exceeding the Kubernetes budget of %d for a schema", costPhrase(total), schemaCostLimit))
+	for i, cost := range costs {
+		if i == 3 || cost.total == 0 {
+			break
+		}
+		// Rules over the per-rule budget have been reported
+		if cost.cost > ruleCostLimit {
+			continue
+		}
+		warnings = append(warnings, warn(cost.line, "rule %q on %s contributes %s%s",
+			cost.rule, cost.path, costPhrase(cost.total), unboundedHint(cost.unbounded)))
+	}
+	return warnings
+}
+
+// schemaTypeProvider declares the object types of schemas with properties,
+// so that rules can only select declared fields
+type schemaTypeProvider struct {
+	types.Provider
+	objects map[string]*PropertySchema
+}
+
+// declType returns the CEL type of values of a schema at a path
+func (p *schemaTypeProvider) declType(path string, s *PropertySchema) *types.Type {
+	if s.XKubernetesIntOrString != nil && *s.XKubernetesIntOrString {
+		return cel.DynType
+	}
+	switch s.Type {
+	case "string":
+		switch s.Format {
+		case "byte":
+			return cel.BytesType
+		case "duration":
+			return cel.DurationType
+		case "date", "date-time":
+			return cel.TimestampType
+		}
+		return cel.StringType
+	case "integer":
+		return cel.IntType
+	case "number":
+		return cel.DoubleType
+	case "boolean":
+		return cel.BoolType
+	case "array":
+		if s.Items == nil {
+			return cel.ListType(cel.DynType)
+		}
+		return cel.ListType(p.declType(path+".@items", s.Items))
+	case "object":
+		if additional := AdditionalPropertiesSchema(s); additional != nil {
+			return cel.MapType(cel.StringType, p.declType(path+".@values", additional))
+		}
+		if s.AdditionalProperties == true {
+			return cel.MapType(cel.StringType, cel.DynType)
+		}
+		if len(s.Properties) == 0 && s.XKubernetesPreserveUnknownFields != nil && *s.XKubernetesPreserveUnknownFields {
+			return cel.DynType
+		}
+		name := objectTypeName(path)
+		if path == "" {
+			s = withObjectMeta(s)
+		}
+		p.objects[name] = s
+		return types.NewObjectType(name)
+	}
+	return cel.DynType
+}
+
+func (p *schemaTypeProvider) FindStructType(structType string) (*types.Type, bool) {
+	if _, ok := p.objects[structType]; ok {
+		return types.NewTypeTypeWithParam(types.NewObjectType(structType)), true
+	}
+	return p.Provider.FindStructType(structType)
+}
+
+// FindStructFieldNames returns the fields of an object type as rules select
+// them, with property names escaped like the API server does
+func (p *schemaTypeProvider) FindStructFieldNames(structType string) ([]string, bool) {
+	s, ok := p.objects[structType]
+	if !ok {
+		return p.Provider.FindStructFieldNames(structType)
+	}
+	var names []string
+	for _, name := range sortedPropertyNames(s.Properties) {
+		if escaped, ok := EscapeCELName(name); ok {
+			names = append(names, escaped)
+		}
+	}
+	return names, true
+}
+
+func (p *schemaTypeProvider) FindStructFieldType(structType, fieldName string) (*types.FieldType, bool) {
+	s, ok := p.objects[structType]
+	if !ok {
+		return p.Provider.FindStructFieldType(structType, fieldName)
+	}
+	name, ok := unEscapeCELName(fieldName)
+	if !ok {
+		return nil, false
+	}
+	prop, ok := s.Properties[name]
+	if !ok {
+		return nil, false
+	}
+	path := strings.TrimPrefix(strings.TrimPrefix(structType, objectTypePrefix), ".")
+	return &types.FieldType{Type: p.declType(joinPath(path, fieldName), &prop)}, true
+}
+
+func (p *schemaTypeProvider) NewValue(structType string, fields map[string]ref.Val) ref.Val {
+	return p.Provider.NewValue(structType, fields)
+}
+
+// objectTypePrefix prefixes the names of object types; rules cannot refer
+// to them as identifiers, since every qualified name in a rule starts with
+// self or oldSelf
+const objectTypePrefix = "kcl2xrd.object"
+
+func objectTypeName(path string) string {
+	if path == "" {
+		return objectTypePrefix
+	}
+	return objectTypePrefix + "." + path
+}
+
+// celReservedNames are the CEL reserved words, which the API server escapes
+// as __{name}__ when they name a property
+var celReservedNames = map[string]bool{
+	"true": true, "false": true, "null": true, "in": true, "as": true,
+	"break": true, "const": true, "continue": true, "else": true, "for": true,
+	"function": true, "if": true, "import": true, "let": true, "loop": true,
+	"package": true, "namespace": true, "return": true, "var": true,
+	"void": true, "while": true,
+}
+
+// celNameRegex matches the property names rules can select
+var celNameRegex = regexp.MustCompile(`^[a-zA-Z_.\-/][a-zA-Z0-9_.\-/]*$`)
+
+// celNameEscapes are the escapes of characters CEL identifiers cannot have
+var celNameEscapes = strings.NewReplacer("__", "__underscores__", ".", "__dot__", "-", "__dash__", "/", "__slash__")
+
+// EscapeCELName returns the identifier a rule selects a property by, e.g.
+// my__dash__field for my-field, as the API server escapes it. Properties
+// with other characters cannot be selected.
+func EscapeCELName(name string) (string, bool) {
+	if celReservedNames[name] {
+		return "__" + name + "__", true
+	}
+	if !celNameRegex.MatchString(name) {
+		return "", false
+	}
+	return celNameEscapes.Replace(name), true
+}
+
+// celEscapeRegex matches the escapes EscapeCELName writes
+var celEscapeRegex = regexp.MustCompile(`__(underscores|dot|dash|slash)__`)
+
+// unEscapeCELName returns the property name of an identifier in a rule. A
+// reserved word not written escaped names no property.
+func unEscapeCELName(name string) (string, bool) {
+	if celReservedNames[name] {
+		return "", false
+	}
+	if strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__") && celReservedNames[name[2:len(name)-2]] {
+		return name[2 : len(name)-2], true
+	}
+	return celEscapeRegex.ReplaceAllStringFunc(name, func(escape string) string {
+		switch escape {
+		case "__underscores__":
+			return "__"
+		case "__dot__":
+			return "."
+		case "__dash__":
+			return "-"
+		}
+		return "/"
+	}), true
+}
+
+// withObjectMeta adds the fields of the root object that every schema has
+// implicitly, of which rules can read apiVersion, kind, metadata.name and
+// metadata.generateName
+func withObjectMeta(s *PropertySchema) *PropertySchema {
+	root := *s
+	root.Properties = make(map[string]PropertySchema, len(s.Properties)+3)
+	for name, prop := range s.Properties {
+		root.Properties[name] = prop
+	}
+	for _, name := range []string{"apiVersion", "kind"} {
+		if _, ok := root.Properties[name]; !ok {
+			root.Properties[name] = PropertySchema{Type: "string"}
+		}
+	}
+	if _, ok := root.Properties["metadata"]; !ok {
+		root.Properties["metadata"] = PropertySchema{
+			Type: "object",
+			Properties: map[string]PropertySchema{
+				"name":         {Type: "string"},
+				"generateName": {Type: "string"},
+			},
+		}
+	}
+	return &root
+}
+
+// sizeEstimator estimates the sizes of the lists, maps and strings a rule
+// reads from the maximums of their schemas
+type sizeEstimator struct {
+	root      *PropertySchema
+	path      string
+	unbounded []string
+}
+
+func (e *sizeEstimator) EstimateSize(element checker.AstNode) *checker.SizeEstimate {
+	elements := element.Path()
+	if len(elements) == 0 || (elements[0] != "self" && elements[0] != "oldSelf") {
+		return nil
+	}
+
+	s, path := e.root, e.path
+	for _, name := range elements[1:] {
+		switch name {
+		case "@items":
+			if s.Items == nil {
+				return nil
+			}
+			s, path = s.Items, path+"[*]"
+		case "@values":
+			additional := AdditionalPropertiesSchema(s)
+			if additional == nil {
+				return nil
+			}
+			s, path = additional, path+"[*]"
+		case "@keys":
+			return &checker.SizeEstimate{Min: 0, Max: maxRequestSizeBytes - 2}
+		default:
+			property, ok := unEscapeCELName(name)
+			if !ok {
+				return nil
+			}
+			prop, ok := s.Properties[property]
+			if !ok {
+				return nil
+			}
+			s, path = &prop, joinPath(path, property)
+		}
+	}
+
+	n, bounded := maxElements(s)
+	if !bounded {
+		e.unbounded = mergeUnbounded(e.unbounded, []string{displayFieldPath(path)})
+	}
+	return &checker.SizeEstimate{Min: 0, Max: n}
+}
+
+func (e *sizeEstimator) EstimateCallCost(function, overloadID string, target *checker.AstNode, args []checker.AstNode) *checker.CallEstimate {
+	return nil
+}
+
+// maxElements returns the maximum size of a list, map or string, and
+// whether its schema limits it. Without a limit the size is the most a
+// request can hold.
+func maxElements(s *PropertySchema) (uint64, bool) {
+	switch s.Type {
+	case "string":
+		if s.MaxLength != nil {
+			return uint64(*s.MaxLength), true
+		}
+		if len(s.Enum) > 0 {
+			longest := 0
+			for _, e := range s.Enum {
+				longest = max(longest, len(fmt.Sprint(e)))
+			}
+			return uint64(longest), true
+		}
+		return maxRequestSizeBytes - 2, false
+	case "array":
+		if s.MaxItems != nil {
+			return uint64(*s.MaxItems), true
+		}
+		// Items are at least as large as their serialization plus a comma
+		return (maxRequestSizeBytes - 2) / (minSerializedSize(s.Items) + 1), false
+	case "object":
+		if s.MaxProperties != nil {
+			return uint64(*s.MaxProperties), true
+		}
+		// Entries are at least as large as `"":` plus the value and a comma
+		return (maxRequestSizeBytes - 2) / (minSerializedSize(AdditionalPropertiesSchema(s)) + 4), false
+	}
+	return 1, true
+}
+
+// minSerializedSize returns the size of the smallest JSON value of a schema
+func minSerializedSize(s *PropertySchema) uint64 {
+	if s == nil {
+		return 1
+	}
+	switch s.Type {
+	case "string", "object", "array":
+		return 2 // "", {} or []
+	case "boolean":
+		return 4 // true
+	}
+	return 1
+}
+
+// AdditionalPropertiesSchema returns the schema of a map's values, or nil
+// if the schema is not a map. Schemas decoded from YAML hold
+// additionalProperties as a generic map.
+func AdditionalPropertiesSchema(s *PropertySchema) *PropertySchema {
+	switch additional := s.AdditionalProperties.(type) {
+	case *PropertySchema:
+		return additional
+	case PropertySchema:
+		return &additional
+	case map[string]interface{}:
+		data, err := yaml.Marshal(additional)
+		if err != nil {
+			return nil
+		}
+		var schema PropertySchema
+		if err := yaml.Unmarshal(data, &schema); err != nil {
+			return nil
+		}
+		return &schema
+	}
+	return nil
+}
+
+// validationLocation returns the `file:line: ` prefix of messages about a
+// rule, or nothing if its line is unknown
+func validationLocation(file string, line int) string {
+	switch {
+	case line == 0:
+		return ""
+	case file == "":
+		return fmt.Sprintf("line %d: ", line)
+	}
+	return fmt.Sprintf("%s:%d: ", file, line)
+}
+
+// unboundedHint names the lists, maps and strings an estimate assumed to be
+// unbounded
+func unboundedHint(unbounded []string) string {
+	if len(unbounded) == 0 {
+		return ""
+	}
+	return "; set @maxItems or @maxLength on " + strings.Join(unbounded, ", ")
+}
+
+// costPhrase describes a cost estimate, which may have saturated
+func costPhrase(cost uint64) string {
+	if cost == math.MaxUint64 {
+		return "an unbounded estimated cost"
+	}
+	return fmt.Sprintf("an estimated cost of %d", cost)
+}
+
+func mergeUnbounded(a, b []string) []string {
+	merged := append([]string(nil), a...)
+	for _, path := range b {
+		found := false
+		for _, existing := range merged {
+			found = found || existing == path
+		}
+		if !found {
+			merged = append(merged, path)
+		}
+	}
+	return merged
+}
+
+func multiplySaturating(a, b uint64) uint64 {
+	if a != 0 && b > math.MaxUint64/a {
+		return math.MaxUint64
+	}
+	return a * b
+}
+
+func addSaturating(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// displayFieldPath returns a schema path for messages
+func displayFieldPath(path string) string {
+	if path == "" {
+		return "the root object"
+	}
+	return path
+}
+
+func sortedPropertyNames(properties map[string]PropertySchema) []string {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
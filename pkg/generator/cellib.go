@@ -0,0 +1,136 @@
+package generator
+
+import (
+	"github.com/google/cel-go/cel"
+)
+
+// Types of the Kubernetes CEL libraries
+var (
+	urlType      = cel.OpaqueType("kubernetes.URL")
+	ipType       = cel.OpaqueType("net.IP")
+	cidrType     = cel.OpaqueType("net.CIDR")
+	quantityType = cel.OpaqueType("kubernetes.Quantity")
+	semverType   = cel.OpaqueType("kubernetes.Semver")
+	formatType   = cel.OpaqueType("kubernetes.NamedFormat")
+)
+
+// kubernetesLibraries declares the functions the Kubernetes API server adds
+// to CEL for validation rules: lists, regex, URLs, IPs and CIDRs, quantities,
+// semantic versions and named formats. They are declared for type checking
+// only; rules are never evaluated at generation time.
+func kubernetesLibraries() []cel.EnvOption {
+	a := cel.TypeParamType("A")
+	listA := cel.ListType(a)
+	stringList := cel.ListType(cel.StringType)
+	str := []*cel.Type{cel.StringType}
+
+	opts := []cel.EnvOption{
+		// Lists
+		member("isSorted", "list_is_sorted", []*cel.Type{listA}, cel.BoolType),
+		member("sum", "list_sum", []*cel.Type{listA}, a),
+		member("min", "list_min", []*cel.Type{listA}, a),
+		member("max", "list_max", []*cel.Type{listA}, a),
+		member("indexOf", "list_index_of", []*cel.Type{listA, a}, cel.IntType),
+		member("lastIndexOf", "list_last_index_of", []*cel.Type{listA, a}, cel.IntType),
+
+		// Regex
+		member("find", "string_find_string", []*cel.Type{cel.StringType, cel.StringType}, cel.StringType),
+		cel.Function("findAll",
+			cel.MemberOverload("string_find_all_string", []*cel.Type{cel.StringType, cel.StringType}, stringList),
+			cel.MemberOverload("string_find_all_string_int", []*cel.Type{cel.StringType, cel.StringType, cel.IntType}, stringList)),
+
+		// URLs
+		global("url", "string_to_url", str, urlType),
+		global("isURL", "is_url_string", str, cel.BoolType),
+		member("getScheme", "url_get_scheme", []*cel.Type{urlType}, cel.StringType),
+		member("getHost", "url_get_host", []*cel.Type{urlType}, cel.StringType),
+		member("getHostname", "url_get_hostname", []*cel.Type{urlType}, cel.StringType),
+		member("getPort", "url_get_port", []*cel.Type{urlType}, cel.StringType),
+		member("getEscapedPath", "url_get_escaped_path", []*cel.Type{urlType}, cel.StringType),
+		member("getQuery", "url_get_query", []*cel.Type{urlType}, cel.MapType(cel.StringType, stringList)),
+
+		// IPs and CIDRs
+		global("ip", "string_to_ip", str, ipType),
+		global("isIP", "is_ip", str, cel.BoolType),
+		global("ip.isCanonical", "ip_is_canonical", str, cel.BoolType),
+		member("family", "ip_family", []*cel.Type{ipType}, cel.IntType),
+		member("isUnspecified", "ip_is_unspecified", []*cel.Type{ipType}, cel.BoolType),
+		member("isLoopback", "ip_is_loopback", []*cel.Type{ipType}, cel.BoolType),
+		member("isLinkLocalMulticast", "ip_is_link_local_multicast", []*cel.Type{ipType}, cel.BoolType),
+		member("isLinkLocalUnicast", "ip_is_link_local_unicast", []*cel.Type{ipType}, cel.BoolType),
+		member("isGlobalUnicast", "ip_is_global_unicast", []*cel.Type{ipType}, cel.BoolType),
+		global("cidr", "string_to_cidr", str, cidrType),
+		global("isCIDR", "is_cidr", str, cel.BoolType),
+		cel.Function("containsIP",
+			cel.MemberOverload("cidr_contains_ip_string", []*cel.Type{cidrType, cel.StringType}, cel.BoolType),
+			cel.MemberOverload("cidr_contains_ip_ip", []*cel.Type{cidrType, ipType}, cel.BoolType)),
+		cel.Function("containsCIDR",
+			cel.MemberOverload("cidr_contains_cidr_string", []*cel.Type{cidrType, cel.StringType}, cel.BoolType),
+			cel.MemberOverload("cidr_contains_cidr", []*cel.Type{cidrType, cidrType}, cel.BoolType)),
+		member("ip", "cidr_ip", []*cel.Type{cidrType}, ipType),
+		member("masked", "cidr_masked", []*cel.Type{cidrType}, cidrType),
+		member("prefixLength", "cidr_prefix_length", []*cel.Type{cidrType}, cel.IntType),
+		cel.Function("string",
+			cel.Overload("ip_to_string", []*cel.Type{ipType}, cel.StringType),
+			cel.Overload("cidr_to_string", []*cel.Type{cidrType}, cel.StringType)),
+
+		// Quantities
+		global("quantity", "string_to_quantity", str, quantityType),
+		global("isQuantity", "is_quantity_string", str, cel.BoolType),
+		member("sign", "quantity_sign", []*cel.Type{quantityType}, cel.IntType),
+		member("isInteger", "quantity_is_integer", []*cel.Type{quantityType}, cel.BoolType),
+		member("asInteger", "quantity_as_integer", []*cel.Type{quantityType}, cel.IntType),
+		member("asApproximateFloat", "quantity_as_float", []*cel.Type{quantityType}, cel.DoubleType),
+		cel.Function("add",
+			cel.MemberOverload("quantity_add", []*cel.Type{quantityType, quantityType}, quantityType),
+			cel.MemberOverload("quantity_add_int", []*cel.Type{quantityType, cel.IntType}, quantityType)),
+		cel.Function("sub",
+			cel.MemberOverload("quantity_sub", []*cel.Type{quantityType, quantityType}, quantityType),
+			cel.MemberOverload("quantity_sub_int", []*cel.Type{quantityType, cel.IntType}, quantityType)),
+
+		// Semantic versions
+		cel.Function("semver",
+			cel.Overload("string_to_semver", str, semverType),
+			cel.Overload("string_bool_to_semver", []*cel.Type{cel.StringType, cel.BoolType}, semverType)),
+		cel.Function("isSemver",
+			cel.Overload("is_semver_string", str, cel.BoolType),
+			cel.Overload("is_semver_string_bool", []*cel.Type{cel.StringType, cel.BoolType}, cel.BoolType)),
+		member("major", "semver_major", []*cel.Type{semverType}, cel.IntType),
+		member("minor", "semver_minor", []*cel.Type{semverType}, cel.IntType),
+		member("patch", "semver_patch", []*cel.Type{semverType}, cel.IntType),
+
+		// Comparisons of quantities and semantic versions
+		cel.Function("isGreaterThan",
+			cel.MemberOverload("quantity_is_greater_than", []*cel.Type{quantityType, quantityType}, cel.BoolType),
+			cel.MemberOverload("semver_is_greater_than", []*cel.Type{semverType, semverType}, cel.BoolType)),
+		cel.Function("isLessThan",
+			cel.MemberOverload("quantity_is_less_than", []*cel.Type{quantityType, quantityType}, cel.BoolType),
+			cel.MemberOverload("semver_is_less_than", []*cel.Type{semverType, semverType}, cel.BoolType)),
+		cel.Function("compareTo",
+			cel.MemberOverload("quantity_compare_to", []*cel.Type{quantityType, quantityType}, cel.IntType),
+			cel.MemberOverload("semver_compare_to", []*cel.Type{semverType, semverType}, cel.IntType)),
+
+		// Named formats
+		global("format.named", "format_named", str, cel.OptionalType(formatType)),
+		member("validate", "format_validate", []*cel.Type{formatType, cel.StringType}, cel.OptionalType(stringList)),
+	}
+	for _, name := range []string{
+		"dns1123Label", "dns1123Subdomain", "dns1035Label", "qualifiedName",
+		"dns1123LabelPrefix", "dns1123SubdomainPrefix", "dns1035LabelPrefix",
+		"labelValue", "uri", "uuid", "byte", "date", "datetime",
+	} {
+		opts = append(opts, global("format."+name, "format_"+name, nil, formatType))
+	}
+	return opts
+}
+
+// global declares a function with one overload
+func global(name, id string, args []*cel.Type, result *cel.Type) cel.EnvOption {
+	return cel.Function(name, cel.Overload(id, args, result))
+}
+
+// member declares a receiver-style function with one overload; the first
+// argument is the receiver
+func member(name, id string, args []*cel.Type, result *cel.Type) cel.EnvOption {
+	return cel.Function(name, cel.MemberOverload(id, args, result))
+}
@@ -0,0 +1,244 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// Composition represents a Crossplane Composition
+type Composition struct {
+	APIVersion string          `yaml:"apiVersion"`
+	Kind       string          `yaml:"kind"`
+	Metadata   Metadata        `yaml:"metadata"`
+	Spec       CompositionSpec `yaml:"spec"`
+}
+
+// CompositionSpec represents the spec section of a Composition
+type CompositionSpec struct {
+	CompositeTypeRef TypeRef        `yaml:"compositeTypeRef"`
+	Mode             string         `yaml:"mode,omitempty"`
+	Pipeline         []PipelineStep `yaml:"pipeline"`
+}
+
+// TypeRef references the composite resource type of a Composition
+type TypeRef struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+}
+
+// PipelineStep is a step of a Composition function pipeline
+type PipelineStep struct {
+	Step        string      `yaml:"step"`
+	FunctionRef FunctionRef `yaml:"functionRef"`
+	Input       *KCLInput   `yaml:"input,omitempty"`
+}
+
+// FunctionRef references a composition function by name
+type FunctionRef struct {
+	Name string `yaml:"name"`
+}
+
+// KCLInput is the input of a function-kcl step
+type KCLInput struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   Metadata     `yaml:"metadata"`
+	Spec       KCLInputSpec `yaml:"spec"`
+}
+
+// KCLInputSpec holds the KCL source run by function-kcl
+type KCLInputSpec struct {
+	Source string `yaml:"source"`
+}
+
+// GenerateCompositionWithSchemasAndOptions generates a Composition skeleton
+// for the XRD GenerateXRDWithSchemasAndOptions generates from the same
+// arguments. It returns warnings for the defaults left out of the KCL source.
+func GenerateCompositionWithSchemasAndOptions(schema *parser.Schema, schemas map[string]*parser.Schema, opts XRDOptions) (string, parser.Diagnostics, error) {
+	return generateComposition([]XRDVersion{
+		{
+			Name:          opts.Version,
+			Schema:        schema,
+			Schemas:       schemas,
+			Referenceable: true,
+		},
+	}, opts)
+}
+
+// GenerateCompositionWithVersions generates a Composition skeleton for the
+// XRD GenerateXRDWithVersions generates from the same arguments. The
+// Composition targets the referenceable version.
+func GenerateCompositionWithVersions(versions []XRDVersion, opts XRDOptions) (string, parser.Diagnostics, error) {
+	if len(versions) == 0 {
+		return "", nil, fmt.Errorf("no versions to generate")
+	}
+	return generateComposition(versions, opts)
+}
+
+// generateComposition generates a Composition with a function-kcl pipeline
+// step whose source declares the parameters of the composite resource as
+// KCL schemas, so that composed resources start from typed parameters
+func generateComposition(versions []XRDVersion, opts XRDOptions) (string, parser.Diagnostics, error) {
+	xrdAPIVersion, _, err := resolveAPIVersion(opts)
+	if err != nil {
+		return "", nil, err
+	}
+	kind, plural := compositeNames(versions, opts)
+	v := referenceableVersion(versions)
+	xrdName := plural + "." + opts.Group
+
+	// The Composition is named so that the XRD's composition refs select it
+	name := firstNonEmptyString(opts.EnforcedCompositionRef, opts.DefaultCompositionRef, xrdName)
+	source, warnings := kclParametersSource(kind, v.Schema, v.Schemas)
+
+	composition := Composition{
+		APIVersion: "apiextensions.crossplane.io/v1",
+		Kind:       "Composition",
+		Metadata: Metadata{
+			Name: name,
+		},
+		Spec: CompositionSpec{
+			CompositeTypeRef: TypeRef{
+				APIVersion: opts.Group + "/" + v.Name,
+				Kind:       kind,
+			},
+			Pipeline: []PipelineStep{
+				{
+					Step:        "render",
+					FunctionRef: FunctionRef{Name: "function-kcl"},
+					Input: &KCLInput{
+						APIVersion: "krm.kcl.dev/v1alpha1",
+						Kind:       "KCLInput",
+						Metadata:   Metadata{Name: "render"},
+						Spec:       KCLInputSpec{Source: source},
+					},
+				},
+			},
+		},
+	}
+	// Crossplane v2 only runs pipelines and has no mode to set
+	if !strings.HasSuffix(xrdAPIVersion, "/v2") {
+		composition.Spec.Mode = "Pipeline"
+	}
+
+	var buf strings.Builder
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(composition)
+	encoder.Close()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal Composition to YAML: %w", err)
+	}
+
+	return buf.String(), warnings, nil
+}
+
+// kclParametersSource returns the KCL source of the function-kcl step: the
+// spec.parameters fields of the schema and the schemas they refer to, and
+// the observed composite resource's parameters read into them. It returns
+// warnings for the defaults left out.
+func kclParametersSource(kind string, schema *parser.Schema, schemas map[string]*parser.Schema) (string, parser.Diagnostics) {
+	var parameters []parser.Field
+	for _, field := range schema.Fields {
+		if !field.IsStatus && !field.IsSpec {
+			parameters = append(parameters, field)
+		}
+	}
+
+	// Schemas referenced by the parameters, in the order they are found
+	var nested []*parser.Schema
+	seen := map[string]bool{schema.Name: true}
+	var collect func(fields []parser.Field)
+	collect = func(fields []parser.Field) {
+		for _, field := range fields {
+			for _, name := range parser.TypeNames(field.Type) {
+				// Schemas of imported packages, named like pkg.Name, are not written
+				if s := schemas[name]; s != nil && !seen[name] && !strings.Contains(name, ".") {
+					seen[name] = true
+					nested = append(nested, s)
+					collect(s.Fields)
+				}
+			}
+		}
+	}
+	collect(parameters)
+
+	var buf strings.Builder
+	var warnings parser.Diagnostics
+	fmt.Fprintf(&buf, "# Parameters of %s, generated by kcl2xrd\n", kind)
+	for _, s := range nested {
+		warnings = append(warnings, writeKCLSchema(&buf, s, s.Fields, seen)...)
+		buf.WriteString("\n")
+	}
+	warnings = append(warnings, writeKCLSchema(&buf, schema, parameters, seen)...)
+	fmt.Fprintf(&buf, `
+oxr = option("params").oxr
+params = %s {**oxr.spec.parameters}
+
+# Compose resources from the typed parameters
+items = []
+`, schema.Name)
+	return buf.String(), warnings
+}
+
+// writeKCLSchema writes a KCL schema with the given fields of a schema.
+// Types naming schemas that are not written, e.g. from imported packages,
+// become any. Defaults are written as the literals of their values; those
+// that could not be evaluated are left out and returned as warnings.
+func writeKCLSchema(buf *strings.Builder, schema *parser.Schema, fields []parser.Field, written map[string]bool) parser.Diagnostics {
+	fmt.Fprintf(buf, "schema %s:\n", schema.Name)
+	if len(fields) == 0 {
+		buf.WriteString("    [...str]: any\n")
+		return nil
+	}
+	var warnings parser.Diagnostics
+	for _, field := range fields {
+		fieldName := field.Name
+		if parser.IsKeyword(fieldName) {
+			fieldName = "$" + fieldName
+		}
+		optional := "?"
+		if field.Required {
+			optional = ""
+		}
+		typ := field.Type
+		if typ == "" {
+			typ = "any"
+		}
+		for _, n := range parser.TypeNames(typ) {
+			if !written[n] {
+				typ = "any"
+				break
+			}
+		}
+		fmt.Fprintf(buf, "    %s%s: %s", fieldName, optional, typ)
+		switch {
+		case field.Default == "" || field.Default == "Undefined":
+		case field.DefaultValue != nil || field.Default == "None":
+			fmt.Fprintf(buf, " = %s", parser.Literal(field.DefaultValue))
+		default:
+			warnings = append(warnings, parser.Diagnostic{
+				Severity: parser.SeverityWarning,
+				File:     schema.File,
+				Line:     field.Line,
+				Column:   field.Column,
+				Message:  fmt.Sprintf("default %s of %s.%s could not be evaluated and is left out of the Composition", field.Default, schema.Name, field.Name),
+			})
+		}
+		buf.WriteString("\n")
+	}
+	return warnings
+}
+
+// firstNonEmptyString returns the first non-empty value
+func firstNonEmptyString(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+)
+
+// conformDefault converts the numbers of an evaluated default to the types
+// of its schema: integers of number fields become floats and whole floats of
+// integer fields become integers
+func conformDefault(v interface{}, s *PropertySchema) interface{} {
+	if s == nil {
+		return v
+	}
+	switch value := v.(type) {
+	case int:
+		if s.Type == "number" {
+			return float64(value)
+		}
+	case float64:
+		if (s.Type == "integer" || s.XKubernetesIntOrString != nil) && value == math.Trunc(value) {
+			return int(value)
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = conformDefault(value[i], s.Items)
+		}
+	case map[string]interface{}:
+		for k, item := range value {
+			if prop, ok := s.Properties[k]; ok {
+				value[k] = conformDefault(item, &prop)
+			} else {
+				value[k] = conformDefault(item, AdditionalPropertiesSchema(s))
+			}
+		}
+	}
+	return v
+}
+
+// checkDefaults validates the defaults of a version's schema against the
+// fields they are declared on, like the API server does when the XRD's CRD
+// is created. Fields a default leaves out take their own defaults.
+func checkDefaults(openAPIV3Schema OpenAPIV3Schema) error {
+	var errs []string
+	var walk func(path string, s *PropertySchema)
+	walk = func(path string, s *PropertySchema) {
+		if s.Default != nil {
+			c := &ValueChecker{}
+			c.Check(path, parser.CopyValue(s.Default), s)
+			for _, e := range c.Errors {
+				location := ""
+				if e.Field != path {
+					location = " at " + e.Field
+				}
+				errs = append(errs, fmt.Sprintf("%s: default %s%s: %s", displayFieldPath(path), defaultLiteral(s.Default), location, e.Message))
+			}
+		}
+		for _, name := range sortedPropertyNames(s.Properties) {
+			prop := s.Properties[name]
+			walk(joinPath(path, name), &prop)
+		}
+		if s.Items != nil {
+			walk(path+"[*]", s.Items)
+		}
+		if additional := AdditionalPropertiesSchema(s); additional != nil {
+			walk(path+"[*]", additional)
+		}
+	}
+	walk("", rootPropertySchema(openAPIV3Schema))
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid defaults:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// defaultLiteral formats a default value for messages
+func defaultLiteral(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
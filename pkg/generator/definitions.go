@@ -0,0 +1,342 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Definitions holds upstream OpenAPI schemas: the definitions of Kubernetes'
+// swagger.json or of other OpenAPI documents, and the versions of CRDs.
+// Fields typed with a KCL schema generated from such a type, like
+// k8s.api.core.v1.Toleration from the k8s KCL module, render the upstream
+// schema instead of the expansion of the KCL schema.
+//
+// A KCL type matches the definition sharing its longest dotted name suffix,
+// if only one does: k8s.api.core.v1.Toleration matches
+// io.k8s.api.core.v1.Toleration. CRD versions are named group.version.Kind,
+// so that crossplane.apiextensions.v1.Composition matches the v1 version of
+// the Composition CRD.
+type Definitions struct {
+	schemas  map[string]definitionSource // by definition name
+	suffixes map[string]string           // definition names by dotted name suffix; "" if ambiguous
+}
+
+// definitionSource is a definition and the document its references point into
+type definitionSource struct {
+	schema      map[string]interface{}
+	definitions map[string]interface{} // definitions of the document; nil for CRDs
+	refPrefix   string                 // prefix of the document's $refs, e.g. #/definitions/
+}
+
+// LoadDefinitions reads the definitions of OpenAPI documents (JSON or YAML,
+// Swagger 2.0 or OpenAPI 3) and CRDs from files, or from the .json, .yaml
+// and .yml files of directories
+func LoadDefinitions(paths []string) (*Definitions, error) {
+	d := &Definitions{
+		schemas:  make(map[string]definitionSource),
+		suffixes: make(map[string]string),
+	}
+	for _, path := range paths {
+		files := []string{path}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			files = nil
+			err := filepath.WalkDir(path, func(file string, entry os.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				switch filepath.Ext(file) {
+				case ".json", ".yaml", ".yml":
+					if !entry.IsDir() {
+						files = append(files, file)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			if err := d.loadFile(file); err != nil {
+				return nil, fmt.Errorf("failed to load OpenAPI definitions from %s: %w", file, err)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(d.schemas))
+	for name := range d.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts := strings.Split(name, ".")
+		for i := 0; i <= len(parts)-2; i++ {
+			suffix := strings.Join(parts[i:], ".")
+			if other, ok := d.suffixes[suffix]; ok && other != name {
+				d.suffixes[suffix] = ""
+				continue
+			}
+			d.suffixes[suffix] = name
+		}
+	}
+	return d, nil
+}
+
+// loadFile reads the documents of a file
+func (d *Definitions) loadFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc map[string]interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if doc == nil {
+			continue
+		}
+
+		switch kind, _ := doc["kind"].(string); {
+		case kind == "CustomResourceDefinition" || kind == "CompositeResourceDefinition":
+			spec, _ := doc["spec"].(map[string]interface{})
+			group, _ := spec["group"].(string)
+			names, _ := spec["names"].(map[string]interface{})
+			kindName, _ := names["kind"].(string)
+			versions, _ := spec["versions"].([]interface{})
+			for _, v := range versions {
+				version, _ := v.(map[string]interface{})
+				name, _ := version["name"].(string)
+				schema, _ := version["schema"].(map[string]interface{})
+				if openAPIV3Schema, ok := schema["openAPIV3Schema"].(map[string]interface{}); ok && name != "" {
+					d.schemas[group+"."+name+"."+kindName] = definitionSource{schema: openAPIV3Schema}
+				}
+			}
+		case doc["definitions"] != nil:
+			// Swagger 2.0, like Kubernetes' swagger.json
+			definitions, _ := doc["definitions"].(map[string]interface{})
+			d.addDocument(definitions, "#/definitions/")
+		case doc["components"] != nil:
+			// OpenAPI 3
+			components, _ := doc["components"].(map[string]interface{})
+			schemas, _ := components["schemas"].(map[string]interface{})
+			d.addDocument(schemas, "#/components/schemas/")
+		}
+	}
+}
+
+// addDocument adds the definitions of an OpenAPI document. The references
+// between them are inlined when a definition is used.
+func (d *Definitions) addDocument(definitions map[string]interface{}, refPrefix string) {
+	for name, schema := range definitions {
+		if schema, ok := schema.(map[string]interface{}); ok {
+			d.schemas[name] = definitionSource{schema: schema, definitions: definitions, refPrefix: refPrefix}
+		}
+	}
+}
+
+// refInliner replaces the $refs of a definition with the definitions they
+// refer to. Recursive references, like those of JSONSchemaProps, become
+// objects that keep unknown fields.
+type refInliner struct {
+	definitions map[string]interface{}
+	prefix      string
+	inlining    map[string]bool
+}
+
+func (r *refInliner) inline(schema map[string]interface{}) map[string]interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, r.prefix)
+		target, _ := r.definitions[name].(map[string]interface{})
+		if target == nil || r.inlining[name] {
+			return map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true}
+		}
+		r.inlining[name] = true
+		defer delete(r.inlining, name)
+		resolved := r.inline(structuralDefinition(name, target))
+		// Keywords next to the $ref, like a description, override the definition's
+		for k, v := range schema {
+			if k != "$ref" {
+				resolved[k] = v
+			}
+		}
+		return resolved
+	}
+
+	out := make(map[string]interface{}, len(schema))
+	for k, v := range schema {
+		switch k {
+		case "properties":
+			props, _ := v.(map[string]interface{})
+			inlined := make(map[string]interface{}, len(props))
+			for name, prop := range props {
+				if p, ok := prop.(map[string]interface{}); ok {
+					inlined[name] = r.inline(p)
+				}
+			}
+			out[k] = inlined
+		case "items", "additionalProperties":
+			if p, ok := v.(map[string]interface{}); ok {
+				out[k] = r.inline(p)
+			} else {
+				out[k] = v
+			}
+		case "allOf":
+			// A single allOf entry wraps a $ref in Kubernetes' OpenAPI 3 documents
+			entries, _ := v.([]interface{})
+			for _, entry := range entries {
+				if p, ok := entry.(map[string]interface{}); ok {
+					for ek, ev := range r.inline(p) {
+						if _, set := schema[ek]; !set {
+							out[ek] = ev
+						}
+					}
+				}
+			}
+		case "anyOf", "oneOf":
+			entries, _ := v.([]interface{})
+			inlined := make([]interface{}, 0, len(entries))
+			for _, entry := range entries {
+				if p, ok := entry.(map[string]interface{}); ok {
+					inlined = append(inlined, r.inline(p))
+				}
+			}
+			out[k] = inlined
+		default:
+			out[k] = v
+		}
+	}
+	return structuralSchema(out)
+}
+
+// structuralDefinition returns the schema a definition has in CRDs:
+// quantities are integers or strings
+func structuralDefinition(name string, schema map[string]interface{}) map[string]interface{} {
+	if strings.HasSuffix(name, ".apimachinery.pkg.api.resource.Quantity") {
+		out := map[string]interface{}{"x-kubernetes-int-or-string": true}
+		if description, ok := schema["description"]; ok {
+			out["description"] = description
+		}
+		return out
+	}
+	return schema
+}
+
+// structuralSchema adapts the keywords of an OpenAPI schema that CRDs do not
+// accept or that would prune values: int-or-string formats become
+// x-kubernetes-int-or-string, uniqueItems is dropped and objects without
+// properties, like RawExtension, keep unknown fields
+func structuralSchema(schema map[string]interface{}) map[string]interface{} {
+	if schema["format"] == "int-or-string" {
+		delete(schema, "type")
+		delete(schema, "format")
+		schema["x-kubernetes-int-or-string"] = true
+	}
+	delete(schema, "uniqueItems")
+	_, hasProperties := schema["properties"]
+	_, hasAdditional := schema["additionalProperties"]
+	_, isIntOrString := schema["x-kubernetes-int-or-string"]
+	typ, _ := schema["type"].(string)
+	switch {
+	case typ == "" && hasProperties:
+		schema["type"] = "object"
+	case typ == "object" && !hasProperties && !hasAdditional:
+		schema["x-kubernetes-preserve-unknown-fields"] = true
+	case typ == "" && !isIntOrString && schema["anyOf"] == nil && schema["oneOf"] == nil:
+		// Any value, like apiextensions JSON
+		schema["x-kubernetes-preserve-unknown-fields"] = true
+	}
+	return schema
+}
+
+// lookup returns the definition a KCL type was generated from
+func (d *Definitions) lookup(typ string) (PropertySchema, bool) {
+	if d == nil || !strings.Contains(typ, ".") {
+		return PropertySchema{}, false
+	}
+	parts := strings.Split(typ, ".")
+	for i := 0; i <= len(parts)-2; i++ {
+		name, ok := d.suffixes[strings.Join(parts[i:], ".")]
+		if !ok {
+			continue
+		}
+		if name == "" {
+			// Ambiguous: shorter suffixes would be too
+			return PropertySchema{}, false
+		}
+		source := d.schemas[name]
+		raw := source.schema
+		if source.definitions != nil {
+			r := &refInliner{definitions: source.definitions, prefix: source.refPrefix, inlining: map[string]bool{name: true}}
+			raw = r.inline(structuralDefinition(name, raw))
+		}
+		schema, err := propertySchemaFromMap(raw)
+		if err != nil {
+			return PropertySchema{}, false
+		}
+		return schema, true
+	}
+	return PropertySchema{}, false
+}
+
+// propertySchemaFromMap converts a decoded OpenAPI schema into a
+// PropertySchema. Every call returns a new copy.
+func propertySchemaFromMap(raw map[string]interface{}) (PropertySchema, error) {
+	var schema PropertySchema
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return schema, err
+	}
+	if err := yaml.Unmarshal(data, &schema); err != nil {
+		return schema, err
+	}
+	if err := decodeAdditionalProperties(&schema); err != nil {
+		return schema, err
+	}
+	return schema, nil
+}
+
+// decodeAdditionalProperties turns the additionalProperties schemas decoded
+// as maps into PropertySchemas. Keywords CRDs do not know, like the
+// x-kubernetes-patch-strategy of Kubernetes' definitions, are dropped.
+func decodeAdditionalProperties(schema *PropertySchema) error {
+	schema.Extensions = nil
+	if raw, ok := schema.AdditionalProperties.(map[string]interface{}); ok {
+		additional, err := propertySchemaFromMap(raw)
+		if err != nil {
+			return err
+		}
+		schema.AdditionalProperties = &additional
+	}
+	for name, prop := range schema.Properties {
+		if err := decodeAdditionalProperties(&prop); err != nil {
+			return err
+		}
+		schema.Properties[name] = prop
+	}
+	if schema.Items != nil {
+		if err := decodeAdditionalProperties(schema.Items); err != nil {
+			return err
+		}
+	}
+	for _, branches := range [][]PropertySchema{schema.AnyOf, schema.OneOf} {
+		for i := range branches {
+			if err := decodeAdditionalProperties(&branches[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,493 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// exampleField is a field of an example object. Optional fields are written
+// commented out.
+type exampleField struct {
+	name      string
+	value     interface{}
+	commented bool
+}
+
+// exampleObject is an object of an example manifest, with its fields in order
+type exampleObject []exampleField
+
+// formatExamples are example values for string formats
+var formatExamples = map[string]string{
+	"date":      "2024-01-01",
+	"date-time": "2024-01-01T00:00:00Z",
+	"duration":  "1h",
+	"email":     "user@example.com",
+	"hostname":  "example.com",
+	"ipv4":      "192.0.2.1",
+	"ipv6":      "2001:db8::1",
+	"uri":       "https://example.com",
+	"url":       "https://example.com",
+	"uuid":      "123e4567-e89b-12d3-a456-426614174000",
+	"byte":      "ZXhhbXBsZQ==",
+	"password":  "changeme",
+}
+
+// GenerateExampleWithSchemasAndOptions generates an example composite
+// resource for the XRD GenerateXRDWithSchemasAndOptions generates from the
+// same arguments, followed by an example claim if opts.WithClaims is set
+func GenerateExampleWithSchemasAndOptions(schema *parser.Schema, schemas map[string]*parser.Schema, opts XRDOptions) (string, error) {
+	return generateExample([]XRDVersion{
+		{
+			Name:          opts.Version,
+			Schema:        schema,
+			Schemas:       schemas,
+			Referenceable: true,
+		},
+	}, opts)
+}
+
+// GenerateExampleWithVersions generates example manifests for the XRD
+// GenerateXRDWithVersions generates from the same arguments. The examples
+// use the referenceable version.
+func GenerateExampleWithVersions(versions []XRDVersion, opts XRDOptions) (string, error) {
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no versions to generate")
+	}
+	return generateExample(versions, opts)
+}
+
+// generateExample renders the spec of the referenceable version with values
+// from defaults, enums, formats, patterns and bounds. Required fields get
+// valid placeholders and optional fields are commented out.
+func generateExample(versions []XRDVersion, opts XRDOptions) (string, error) {
+	_, scope, err := resolveAPIVersion(opts)
+	if err != nil {
+		return "", err
+	}
+	kind, _ := compositeNames(versions, opts)
+	v := referenceableVersion(versions)
+
+	r := newSchemaResolver(v.Schemas, opts)
+	openAPIV3Schema := r.buildOpenAPIV3Schema(v.Schema, false)
+	if r.err != nil {
+		return "", r.err
+	}
+	spec, err := exampleValue("spec", openAPIV3Schema.Properties["spec"])
+	if err != nil {
+		return "", err
+	}
+
+	apiVersion := opts.Group + "/" + v.Name
+	namespace := ""
+	if scope == "Namespaced" {
+		namespace = "default"
+	}
+	var buf strings.Builder
+	writeExampleManifest(&buf, apiVersion, kind, namespace, spec)
+	if opts.WithClaims {
+		claimKind, _ := claimNames(kind, opts)
+		buf.WriteString("---\n")
+		writeExampleManifest(&buf, apiVersion, claimKind, "default", spec)
+	}
+	return buf.String(), nil
+}
+
+// writeExampleManifest writes a manifest with an example spec
+func writeExampleManifest(buf *strings.Builder, apiVersion, kind, namespace string, spec interface{}) {
+	fmt.Fprintf(buf, "apiVersion: %s\nkind: %s\nmetadata:\n  name: %s-example\n", apiVersion, kind, strings.ToLower(kind))
+	if namespace != "" {
+		fmt.Fprintf(buf, "  namespace: %s\n", namespace)
+	}
+	for _, line := range exampleLines("spec", spec) {
+		buf.WriteString(line + "\n")
+	}
+}
+
+// exampleValue returns a valid value for a property schema
+func exampleValue(path string, schema PropertySchema) (interface{}, error) {
+	if schema.Default != nil {
+		return schema.Default, nil
+	}
+	if len(schema.Enum) > 0 {
+		return schema.Enum[0], nil
+	}
+	if schema.XKubernetesIntOrString != nil && *schema.XKubernetesIntOrString {
+		return exampleNumber(schema), nil
+	}
+	// Unions of types or literals, like `bool | "auto"`, take their first branch
+	if branch, ok := unionBranch(schema); ok {
+		return exampleValue(path, branch)
+	}
+
+	switch schema.Type {
+	case "string":
+		return exampleString(path, schema)
+	case "integer", "number":
+		return exampleNumber(schema), nil
+	case "boolean":
+		return true, nil
+	case "array":
+		if schema.Items == nil {
+			return []interface{}{}, nil
+		}
+		count := 1
+		if schema.MinItems != nil && *schema.MinItems > count {
+			count = *schema.MinItems
+		}
+		if schema.MaxItems != nil && *schema.MaxItems < count {
+			count = *schema.MaxItems
+		}
+		items := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := exampleValue(fmt.Sprintf("%s[%d]", path, i), *schema.Items)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case "object", "":
+		if schema.XKubernetesEmbeddedResource != nil && *schema.XKubernetesEmbeddedResource && len(schema.Properties) == 0 {
+			return exampleObject{{name: "apiVersion", value: "v1"}, {name: "kind", value: "ConfigMap"}}, nil
+		}
+		if len(schema.Properties) > 0 || len(requiredFields(schema)) > 0 {
+			return exampleObjectValue(path, schema)
+		}
+		if values, ok := schema.AdditionalProperties.(*PropertySchema); ok && values.Type != "" {
+			value, err := exampleValue(path+".key", *values)
+			if err != nil {
+				return nil, err
+			}
+			return exampleObject{{name: "key", value: value}}, nil
+		}
+		return exampleObject{}, nil
+	}
+	return nil, fmt.Errorf("%s: no example for type %s", path, schema.Type)
+}
+
+// unionBranch returns the first branch of a schema without a type whose
+// oneOf/anyOf branches are types or literals rather than sets of required
+// fields
+func unionBranch(schema PropertySchema) (PropertySchema, bool) {
+	if schema.Type != "" {
+		return PropertySchema{}, false
+	}
+	for _, branches := range [][]PropertySchema{schema.OneOf, schema.AnyOf} {
+		if len(branches) > 0 && (branches[0].Type != "" || len(branches[0].Enum) > 0) {
+			return branches[0], true
+		}
+	}
+	return PropertySchema{}, false
+}
+
+// requiredFields returns the required fields of an object schema in
+// declaration order. The fields of the first oneOf/anyOf combination count
+// as required.
+func requiredFields(schema PropertySchema) []string {
+	required := append([]string{}, schema.Required...)
+	for _, combinations := range [][]PropertySchema{schema.OneOf, schema.AnyOf} {
+		if len(combinations) > 0 {
+			required = append(required, combinations[0].Required...)
+		}
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range required {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// exampleObjectValue returns an object with all fields of a schema. Required
+// keys of a map get a value of its additionalProperties schema.
+func exampleObjectValue(path string, schema PropertySchema) (exampleObject, error) {
+	names := requiredFields(schema)
+	isRequired := make(map[string]bool)
+	for _, name := range names {
+		isRequired[name] = true
+	}
+
+	// Required fields first in declaration order, then optional fields
+	var optional []string
+	for name := range schema.Properties {
+		if !isRequired[name] {
+			optional = append(optional, name)
+		}
+	}
+	sort.Strings(optional)
+	names = append(names, optional...)
+
+	var obj exampleObject
+	for _, name := range names {
+		prop, ok := schema.Properties[name]
+		if !ok {
+			// A key of a map, or of an object keeping unknown fields
+			if values, isMap := schema.AdditionalProperties.(*PropertySchema); isMap {
+				prop = *values
+			} else if schema.AdditionalProperties == nil && schema.XKubernetesPreserveUnknownFields == nil {
+				continue
+			}
+		}
+		value, err := exampleValue(path+"."+name, prop)
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, exampleField{name: name, value: value, commented: !isRequired[name]})
+	}
+	return obj, nil
+}
+
+// exampleNumber returns a number within the bounds that is a multiple of
+// multipleOf, an integer unless the schema is of type number
+func exampleNumber(schema PropertySchema) interface{} {
+	step := 1.0
+	if schema.MultipleOf != nil {
+		step = *schema.MultipleOf
+	}
+	value := 1.0
+	switch {
+	case schema.Minimum != nil:
+		value = *schema.Minimum
+		if schema.ExclusiveMinimum {
+			value += step
+		}
+	case schema.Maximum != nil && *schema.Maximum < 1:
+		value = *schema.Maximum
+		if schema.ExclusiveMaximum {
+			value -= step
+		}
+	}
+	if schema.MultipleOf != nil {
+		value = math.Ceil(value/step) * step
+	}
+	// The step overshot the maximum: take the middle of the bounds
+	if schema.Minimum != nil && schema.Maximum != nil &&
+		(value > *schema.Maximum || value == *schema.Maximum && schema.ExclusiveMaximum) {
+		value = (*schema.Minimum + *schema.Maximum) / 2
+	}
+	if schema.Type == "number" {
+		return value
+	}
+	return int(math.Ceil(value))
+}
+
+// exampleString returns a string of the schema's format, or one matching its
+// pattern and length constraints
+func exampleString(path string, schema PropertySchema) (string, error) {
+	minLength, maxLength := 0, -1
+	if schema.MinLength != nil {
+		minLength = *schema.MinLength
+	}
+	if schema.MaxLength != nil {
+		maxLength = *schema.MaxLength
+	}
+	fits := func(s string) bool {
+		n := len([]rune(s))
+		return n >= minLength && (maxLength < 0 || n <= maxLength)
+	}
+
+	if s, ok := formatExamples[schema.Format]; ok && schema.Pattern == "" && fits(s) {
+		return s, nil
+	}
+	if schema.Pattern == "" {
+		s := "example"
+		for len(s) < minLength {
+			s += "x"
+		}
+		if maxLength >= 0 && len(s) > maxLength {
+			s = s[:maxLength]
+		}
+		return s, nil
+	}
+
+	re, err := regexp.Compile(schema.Pattern)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid pattern %q: %w", path, schema.Pattern, err)
+	}
+	parsed, err := syntax.Parse(schema.Pattern, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid pattern %q: %w", path, schema.Pattern, err)
+	}
+	candidates := []string{"example"}
+	if s, ok := formatExamples[schema.Format]; ok {
+		candidates = append([]string{s}, candidates...)
+	}
+	// Repetitions are tried with increasing counts until the length fits
+	for repeat := 1; repeat <= 64; repeat++ {
+		candidates = append(candidates, patternString(parsed, repeat))
+	}
+	for _, s := range candidates {
+		if re.MatchString(s) && fits(s) {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("%s: no example matches pattern %q with the length constraints", path, schema.Pattern)
+}
+
+// patternString returns a string matching a parsed regular expression, with
+// unbounded repetitions repeated the given number of times
+func patternString(re *syntax.Regexp, repeat int) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCharClass:
+		return string(classRune(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return "a"
+	case syntax.OpCapture:
+		return patternString(re.Sub[0], repeat)
+	case syntax.OpConcat:
+		var b strings.Builder
+		for _, sub := range re.Sub {
+			b.WriteString(patternString(sub, repeat))
+		}
+		return b.String()
+	case syntax.OpAlternate:
+		return patternString(re.Sub[0], repeat)
+	case syntax.OpStar, syntax.OpPlus:
+		return strings.Repeat(patternString(re.Sub[0], repeat), repeat)
+	case syntax.OpQuest:
+		return patternString(re.Sub[0], repeat)
+	case syntax.OpRepeat:
+		count := re.Min
+		if count < repeat && (re.Max < 0 || re.Max >= repeat) {
+			count = repeat
+		}
+		return strings.Repeat(patternString(re.Sub[0], repeat), count)
+	}
+	// Anchors, word boundaries and empty matches
+	return ""
+}
+
+// classRune returns a readable rune of a character class given as ranges:
+// a, 0 or A if the class has one of them, or else its first rune
+func classRune(ranges []rune) rune {
+	for _, preferred := range "a0A" {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			if ranges[i] <= preferred && preferred <= ranges[i+1] {
+				return preferred
+			}
+		}
+	}
+	if len(ranges) == 0 {
+		return 'a'
+	}
+	return ranges[0]
+}
+
+// exampleLines returns the YAML lines of a field, commented out if optional
+func exampleLines(name string, value interface{}) []string {
+	var lines []string
+	switch v := value.(type) {
+	case exampleObject:
+		if !v.hasActiveFields() {
+			lines = append(lines, name+": {}")
+		} else {
+			lines = append(lines, name+":")
+		}
+		for _, f := range v {
+			lines = append(lines, indentLines(f.lines(), "  ")...)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return []string{name + ": []"}
+		}
+		lines = append(lines, name+":")
+		for _, item := range v {
+			lines = append(lines, itemLines(item)...)
+		}
+	default:
+		lines = append(lines, name+": "+scalarYAML(v))
+	}
+	return lines
+}
+
+// lines returns the YAML lines of an object field
+func (f exampleField) lines() []string {
+	lines := exampleLines(f.name, f.value)
+	if f.commented {
+		for i, line := range lines {
+			lines[i] = "# " + line
+		}
+	}
+	return lines
+}
+
+// hasActiveFields reports whether any field of the object is not commented out
+func (o exampleObject) hasActiveFields() bool {
+	for _, f := range o {
+		if !f.commented {
+			return true
+		}
+	}
+	return false
+}
+
+// itemLines returns the YAML lines of a list item
+func itemLines(item interface{}) []string {
+	switch v := item.(type) {
+	case exampleObject:
+		if !v.hasActiveFields() {
+			lines := []string{"  - {}"}
+			for _, f := range v {
+				lines = append(lines, indentLines(f.lines(), "    ")...)
+			}
+			return lines
+		}
+		// The first field starts the item, so active fields go first
+		var fields []exampleField
+		for _, f := range v {
+			if !f.commented {
+				fields = append(fields, f)
+			}
+		}
+		for _, f := range v {
+			if f.commented {
+				fields = append(fields, f)
+			}
+		}
+		lines := indentLines(fields[0].lines(), "    ")
+		lines[0] = "  - " + strings.TrimPrefix(lines[0], "    ")
+		for _, f := range fields[1:] {
+			lines = append(lines, indentLines(f.lines(), "    ")...)
+		}
+		return lines
+	case []interface{}:
+		// Nested lists are written inline
+		return []string{"  - " + scalarYAML(v)}
+	default:
+		return []string{"  - " + scalarYAML(v)}
+	}
+}
+
+// indentLines indents lines
+func indentLines(lines []string, indent string) []string {
+	for i, line := range lines {
+		lines[i] = indent + line
+	}
+	return lines
+}
+
+// scalarYAML returns a value in YAML flow style, quoted where needed
+func scalarYAML(value interface{}) string {
+	var buf strings.Builder
+	encoder := yaml.NewEncoder(&buf)
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	node.Style = yaml.FlowStyle
+	encoder.Encode(node)
+	encoder.Close()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
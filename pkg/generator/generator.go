@@ -2,6 +2,7 @@ package generator
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -19,7 +20,9 @@ type XRD struct {
 
 // Metadata represents the metadata section of an XRD
 type Metadata struct {
-	Name string `yaml:"name"`
+	Name        string            `yaml:"name"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
 }
 
 // PrinterColumn represents an additional printer column
@@ -34,22 +37,40 @@ type PrinterColumn struct {
 // XRDSpec represents the spec section of an XRD
 type XRDSpec struct {
 	Group      string      `yaml:"group"`
+	Scope      string      `yaml:"scope,omitempty"`
 	Names      Names       `yaml:"names"`
 	ClaimNames *ClaimNames `yaml:"claimNames,omitempty"`
 	Categories []string    `yaml:"categories,omitempty"`
-	Versions   []Version   `yaml:"versions"`
+	// Composition selection, policies and connection details
+	ConnectionSecretKeys           []string        `yaml:"connectionSecretKeys,omitempty"`
+	DefaultCompositeDeletePolicy   string          `yaml:"defaultCompositeDeletePolicy,omitempty"`
+	DefaultCompositionRef          *CompositionRef `yaml:"defaultCompositionRef,omitempty"`
+	EnforcedCompositionRef         *CompositionRef `yaml:"enforcedCompositionRef,omitempty"`
+	DefaultCompositionUpdatePolicy string          `yaml:"defaultCompositionUpdatePolicy,omitempty"`
+	Versions                       []Version       `yaml:"versions"`
+}
+
+// CompositionRef references a Composition by name
+type CompositionRef struct {
+	Name string `yaml:"name"`
 }
 
 // Names represents the names section of an XRD spec
 type Names struct {
-	Kind   string `yaml:"kind"`
-	Plural string `yaml:"plural"`
+	Kind       string   `yaml:"kind"`
+	Plural     string   `yaml:"plural"`
+	Singular   string   `yaml:"singular,omitempty"`
+	ShortNames []string `yaml:"shortNames,omitempty"`
+	ListKind   string   `yaml:"listKind,omitempty"`
 }
 
 // ClaimNames represents optional claim names in an XRD spec
 type ClaimNames struct {
-	Kind   string `yaml:"kind"`
-	Plural string `yaml:"plural"`
+	Kind       string   `yaml:"kind"`
+	Plural     string   `yaml:"plural"`
+	Singular   string   `yaml:"singular,omitempty"`
+	ShortNames []string `yaml:"shortNames,omitempty"`
+	ListKind   string   `yaml:"listKind,omitempty"`
 }
 
 // XRDOptions contains options for generating an XRD
@@ -65,6 +86,63 @@ type XRDOptions struct {
 	Categories                  []string
 	PrinterColumns              []PrinterColumn
 	StatusPreserveUnknownFields bool
+	Deprecated                  bool
+	DeprecationWarning          string
+	// Crossplane v2 output: CrossplaneVersion "v2" (or a Scope) targets
+	// apiextensions.crossplane.io/v2 with spec.scope, which defaults to
+	// Namespaced. An empty CrossplaneVersion means v1 unless Scope is set.
+	CrossplaneVersion string
+	Scope             string
+	// XRD spec settings: composition refs by name, policies
+	// (Automatic/Manual, Background/Foreground) and connection secret keys
+	DefaultCompositionRef          string
+	EnforcedCompositionRef         string
+	DefaultCompositionUpdatePolicy string
+	DefaultCompositeDeletePolicy   string
+	ConnectionSecretKeys           []string
+	// Labels and annotations for the XRD's metadata
+	Labels      map[string]string
+	Annotations map[string]string
+	// Overrides for spec.names and claimNames; plurals default to
+	// Pluralize(kind)
+	Plural          string
+	Singular        string
+	ShortNames      []string
+	ListKind        string
+	ClaimSingular   string
+	ClaimShortNames []string
+	ClaimListKind   string
+	// MaxRecursionDepth cuts recursive schema references: a schema is
+	// expanded at most this many times within itself, deeper references
+	// become x-kubernetes-preserve-unknown-fields objects. With 0, recursive
+	// references are an error.
+	MaxRecursionDepth int
+	// Definitions are upstream OpenAPI schemas that fields typed with the
+	// KCL schemas generated from them render, e.g. Kubernetes API types
+	Definitions *Definitions
+}
+
+// XRD API versions and the scopes of Crossplane v2 XRDs
+const (
+	APIVersionV1 = "apiextensions.crossplane.io/v1"
+	APIVersionV2 = "apiextensions.crossplane.io/v2"
+
+	ScopeNamespaced    = "Namespaced"
+	ScopeCluster       = "Cluster"
+	ScopeLegacyCluster = "LegacyCluster"
+)
+
+// XRDVersion describes one version of a multi-version XRD
+type XRDVersion struct {
+	Name                        string
+	Schema                      *parser.Schema
+	Schemas                     map[string]*parser.Schema // schemas for nested type resolution
+	Served                      bool
+	Referenceable               bool
+	Deprecated                  bool
+	DeprecationWarning          string
+	PrinterColumns              []PrinterColumn
+	StatusPreserveUnknownFields bool
 }
 
 // Version represents a version in an XRD spec
@@ -72,6 +150,8 @@ type Version struct {
 	Name                     string          `yaml:"name"`
 	Served                   bool            `yaml:"served"`
 	Referenceable            bool            `yaml:"referenceable"`
+	Deprecated               bool            `yaml:"deprecated,omitempty"`
+	DeprecationWarning       string          `yaml:"deprecationWarning,omitempty"`
 	Schema                   VersionSchema   `yaml:"schema"`
 	AdditionalPrinterColumns []PrinterColumn `yaml:"additionalPrinterColumns,omitempty"`
 }
@@ -98,15 +178,22 @@ type PropertySchema struct {
 	AdditionalProperties interface{}               `yaml:"additionalProperties,omitempty"`
 	Format               string                    `yaml:"format,omitempty"`
 	Default              interface{}               `yaml:"default,omitempty"`
+	Nullable             bool                      `yaml:"nullable,omitempty"`
 	// Validation fields
 	Pattern                          string           `yaml:"pattern,omitempty"`
 	MinLength                        *int             `yaml:"minLength,omitempty"`
 	MaxLength                        *int             `yaml:"maxLength,omitempty"`
-	Minimum                          *int             `yaml:"minimum,omitempty"`
-	Maximum                          *int             `yaml:"maximum,omitempty"`
+	Minimum                          *float64         `yaml:"minimum,omitempty"`
+	ExclusiveMinimum                 bool             `yaml:"exclusiveMinimum,omitempty"`
+	Maximum                          *float64         `yaml:"maximum,omitempty"`
+	ExclusiveMaximum                 bool             `yaml:"exclusiveMaximum,omitempty"`
+	MultipleOf                       *float64         `yaml:"multipleOf,omitempty"`
 	MinItems                         *int             `yaml:"minItems,omitempty"`
 	MaxItems                         *int             `yaml:"maxItems,omitempty"`
-	Enum                             []string         `yaml:"enum,omitempty"`
+	UniqueItems                      bool             `yaml:"uniqueItems,omitempty"` // rejected in CRDs; @uniqueItems is a set list
+	MinProperties                    *int             `yaml:"minProperties,omitempty"`
+	MaxProperties                    *int             `yaml:"maxProperties,omitempty"`
+	Enum                             []interface{}    `yaml:"enum,omitempty"`
 	OneOf                            []PropertySchema `yaml:"oneOf,omitempty"`
 	AnyOf                            []PropertySchema `yaml:"anyOf,omitempty"`
 	XKubernetesValidations           []K8sValidation  `yaml:"x-kubernetes-validations,omitempty"`
@@ -115,12 +202,22 @@ type PropertySchema struct {
 	XKubernetesMapType               string           `yaml:"x-kubernetes-map-type,omitempty"`
 	XKubernetesListType              string           `yaml:"x-kubernetes-list-type,omitempty"`
 	XKubernetesListMapKeys           []string         `yaml:"x-kubernetes-list-map-keys,omitempty"`
+	XKubernetesIntOrString           *bool            `yaml:"x-kubernetes-int-or-string,omitempty"`
+	XKubernetesEmbeddedResource      *bool            `yaml:"x-kubernetes-embedded-resource,omitempty"`
+	// Extensions are keywords added by custom annotation handlers, e.g.
+	// x-example-org-cost-center
+	Extensions map[string]interface{} `yaml:",inline"`
 }
 
 // K8sValidation represents Kubernetes CEL validation rules
 type K8sValidation struct {
-	Rule    string `yaml:"rule"`
-	Message string `yaml:"message,omitempty"`
+	Rule              string `yaml:"rule"`
+	Message           string `yaml:"message,omitempty"`
+	MessageExpression string `yaml:"messageExpression,omitempty"`
+	Reason            string `yaml:"reason,omitempty"`
+	FieldPath         string `yaml:"fieldPath,omitempty"`
+	OptionalOldSelf   *bool  `yaml:"optionalOldSelf,omitempty"`
+	Line              int    `yaml:"-"` // source line of the rule in the KCL file, if known
 }
 
 // GenerateXRD generates a Crossplane XRD from a parsed KCL schema
@@ -139,97 +236,288 @@ func GenerateXRDWithOptions(schema *parser.Schema, opts XRDOptions) (string, err
 
 // GenerateXRDWithSchemasAndOptions generates a Crossplane XRD with schema resolution for nested types
 func GenerateXRDWithSchemasAndOptions(schema *parser.Schema, schemas map[string]*parser.Schema, opts XRDOptions) (string, error) {
-	// Determine the base name for the XRD
-	// If Kind is specified in options, use it; otherwise use schema name
-	baseName := schema.Name
-	if opts.Kind != "" {
-		baseName = opts.Kind
-	}
+	return generateXRD([]XRDVersion{
+		{
+			Name:                        opts.Version,
+			Schema:                      schema,
+			Schemas:                     schemas,
+			Served:                      opts.Served,
+			Referenceable:               opts.Referenceable,
+			PrinterColumns:              opts.PrinterColumns,
+			StatusPreserveUnknownFields: opts.StatusPreserveUnknownFields,
+			Deprecated:                  opts.Deprecated,
+			DeprecationWarning:          opts.DeprecationWarning,
+		},
+	}, opts)
+}
 
-	// Convert base name to lowercase plural for the resource name
-	var plural string
-	if baseName[len(baseName)-1] == 'y' {
-		plural = strings.ToLower(baseName[:len(baseName)-1]) + "ies"
-	} else {
-		plural = strings.ToLower(baseName) + "s"
+// GenerateXRDWithVersions generates a Crossplane XRD serving several versions,
+// each with its own schema. Exactly one version must be referenceable. The
+// version-specific fields of opts (Version, Served, Referenceable,
+// PrinterColumns, StatusPreserveUnknownFields and the deprecation) are ignored.
+func GenerateXRDWithVersions(versions []XRDVersion, opts XRDOptions) (string, error) {
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no versions to generate")
 	}
-	// Determine names based on claims mode
-	var xrdKind, xrdPlural string
-	var claimKind, claimPlural string
 
-	if opts.WithClaims {
-		// When using claims, __xrd_kind should be the unprefixed name
-		// XRD gets X prefix, claims use the original unprefixed name
-
-		// Always treat baseName as unprefixed when using claims
-		// Strip X prefix if it was provided for backward compatibility
-		unprefixedName := baseName
-		if strings.HasPrefix(baseName, "X") {
-			unprefixedName = strings.TrimPrefix(baseName, "X")
+	seen := make(map[string]bool)
+	var referenceable []string
+	for _, v := range versions {
+		if v.Name == "" {
+			return "", fmt.Errorf("version of schema '%s' has no name", v.Schema.Name)
+		}
+		if seen[v.Name] {
+			return "", fmt.Errorf("version '%s' is declared more than once", v.Name)
 		}
+		seen[v.Name] = true
+		if v.Referenceable {
+			referenceable = append(referenceable, v.Name)
+		}
+	}
+	if len(referenceable) != 1 {
+		return "", fmt.Errorf("exactly one version must be referenceable, got %d: %v", len(referenceable), referenceable)
+	}
 
-		// XRD kind gets X prefix
-		xrdKind = "X" + unprefixedName
+	return generateXRD(versions, opts)
+}
 
-		// Claim kind is the unprefixed name
-		if opts.ClaimKind == "" {
-			claimKind = unprefixedName
-		} else {
-			claimKind = opts.ClaimKind
-		}
+// generateXRD generates an XRD for the given versions. The XRD kind is
+// opts.Kind or else the name of the referenceable version's schema.
+func generateXRD(versions []XRDVersion, opts XRDOptions) (string, error) {
+	apiVersion, scope, err := resolveAPIVersion(opts)
+	if err != nil {
+		return "", err
+	}
+	if err := validateSpecOptions(opts, scope); err != nil {
+		return "", err
+	}
 
-		// Generate plurals
-		xrdPlural = strings.ToLower(xrdKind) + "s"
-		if opts.ClaimPlural == "" {
-			claimPlural = strings.ToLower(claimKind) + "s"
-		} else {
-			claimPlural = opts.ClaimPlural
+	// Determine names based on claims mode
+	xrdKind, xrdPlural := compositeNames(versions, opts)
+	claimKind, claimPlural := claimNames(xrdKind, opts)
+	if err := validateNames(xrdPlural, opts.Singular, opts.ShortNames); err != nil {
+		return "", err
+	}
+	if opts.WithClaims {
+		if err := validateNames(claimPlural, opts.ClaimSingular, opts.ClaimShortNames); err != nil {
+			return "", fmt.Errorf("claim names: %w", err)
 		}
-	} else {
-		// Without claims, use base name as-is for XRD
-		xrdKind = baseName
-		xrdPlural = plural
 	}
 
 	resourceName := xrdPlural + "." + opts.Group
 
 	xrd := XRD{
-		APIVersion: "apiextensions.crossplane.io/v1",
+		APIVersion: apiVersion,
 		Kind:       "CompositeResourceDefinition",
 		Metadata: Metadata{
-			Name: resourceName,
+			Name:        resourceName,
+			Labels:      opts.Labels,
+			Annotations: opts.Annotations,
 		},
 		Spec: XRDSpec{
 			Group: opts.Group,
+			Scope: scope,
 			Names: Names{
-				Kind:   xrdKind,
-				Plural: xrdPlural,
-			},
-			Versions: []Version{
-				{
-					Name:                     opts.Version,
-					Served:                   opts.Served,
-					Referenceable:            opts.Referenceable,
-					AdditionalPrinterColumns: opts.PrinterColumns,
-					Schema: VersionSchema{
-						OpenAPIV3Schema: OpenAPIV3Schema{
-							Type:       "object",
-							Properties: make(map[string]PropertySchema),
-						},
-					},
-				},
+				Kind:       xrdKind,
+				Plural:     xrdPlural,
+				Singular:   opts.Singular,
+				ShortNames: opts.ShortNames,
+				ListKind:   opts.ListKind,
 			},
+			Versions:   make([]Version, 0, len(versions)),
 			Categories: opts.Categories,
+
+			ConnectionSecretKeys:           opts.ConnectionSecretKeys,
+			DefaultCompositeDeletePolicy:   opts.DefaultCompositeDeletePolicy,
+			DefaultCompositionUpdatePolicy: opts.DefaultCompositionUpdatePolicy,
 		},
 	}
+	if opts.DefaultCompositionRef != "" {
+		xrd.Spec.DefaultCompositionRef = &CompositionRef{Name: opts.DefaultCompositionRef}
+	}
+	if opts.EnforcedCompositionRef != "" {
+		xrd.Spec.EnforcedCompositionRef = &CompositionRef{Name: opts.EnforcedCompositionRef}
+	}
 
 	// Add claim names if requested
 	if opts.WithClaims {
 		xrd.Spec.ClaimNames = &ClaimNames{
-			Kind:   claimKind,
-			Plural: claimPlural,
+			Kind:       claimKind,
+			Plural:     claimPlural,
+			Singular:   opts.ClaimSingular,
+			ShortNames: opts.ClaimShortNames,
+			ListKind:   opts.ClaimListKind,
+		}
+	}
+
+	for _, v := range versions {
+		r := newSchemaResolver(v.Schemas, opts)
+		openAPIV3Schema := r.buildOpenAPIV3Schema(v.Schema, v.StatusPreserveUnknownFields)
+		if r.err != nil {
+			return "", r.err
+		}
+		if err := checkValidationRules(v.Schema.File, openAPIV3Schema); err != nil {
+			return "", err
+		}
+		if err := checkDefaults(openAPIV3Schema); err != nil {
+			return "", err
+		}
+		xrd.Spec.Versions = append(xrd.Spec.Versions, Version{
+			Name:                     v.Name,
+			Served:                   v.Served,
+			Referenceable:            v.Referenceable,
+			Deprecated:               v.Deprecated,
+			DeprecationWarning:       v.DeprecationWarning,
+			AdditionalPrinterColumns: v.PrinterColumns,
+			Schema: VersionSchema{
+				OpenAPIV3Schema: openAPIV3Schema,
+			},
+		})
+	}
+
+	// Marshal to YAML with 2-space indentation
+	var buf strings.Builder
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(xrd)
+	encoder.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal XRD to YAML: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// compositeNames returns the kind and plural of the composite resource
+func compositeNames(versions []XRDVersion, opts XRDOptions) (string, string) {
+	// Determine the base name for the XRD
+	// If Kind is specified in options, use it; otherwise use schema name
+	baseName := referenceableVersion(versions).Schema.Name
+	if opts.Kind != "" {
+		baseName = opts.Kind
+	}
+
+	// When using claims, __xrd_kind should be the unprefixed name:
+	// the XRD gets the X prefix, claims use the unprefixed name.
+	// An X prefix is accepted for backward compatibility.
+	kind := baseName
+	if opts.WithClaims {
+		kind = "X" + strings.TrimPrefix(baseName, "X")
+	}
+
+	plural := Pluralize(kind)
+	if opts.Plural != "" {
+		plural = opts.Plural
+	}
+	return kind, plural
+}
+
+// claimNames returns the kind and plural of the claim for a composite kind:
+// the kind without its X prefix unless set in the options
+func claimNames(xrdKind string, opts XRDOptions) (string, string) {
+	kind := opts.ClaimKind
+	if kind == "" {
+		kind = strings.TrimPrefix(xrdKind, "X")
+	}
+	plural := opts.ClaimPlural
+	if plural == "" {
+		plural = Pluralize(kind)
+	}
+	return kind, plural
+}
+
+// referenceableVersion returns the referenceable version, or the first
+// version if none is referenceable
+func referenceableVersion(versions []XRDVersion) XRDVersion {
+	for _, v := range versions {
+		if v.Referenceable {
+			return v
 		}
 	}
+	return versions[0]
+}
+
+// resolveAPIVersion returns the XRD apiVersion and, for Crossplane v2, the
+// scope. Claims only exist for v2 XRDs with the LegacyCluster scope.
+func resolveAPIVersion(opts XRDOptions) (string, string, error) {
+	switch opts.CrossplaneVersion {
+	case "", "v1":
+		if opts.Scope == "" {
+			return APIVersionV1, "", nil
+		}
+		if opts.CrossplaneVersion == "v1" {
+			return "", "", fmt.Errorf("scope '%s' requires Crossplane v2 output", opts.Scope)
+		}
+	case "v2":
+	default:
+		return "", "", fmt.Errorf("unsupported Crossplane version '%s': must be v1 or v2", opts.CrossplaneVersion)
+	}
+
+	scope := opts.Scope
+	if scope == "" {
+		scope = ScopeNamespaced
+	}
+	switch scope {
+	case ScopeNamespaced, ScopeCluster, ScopeLegacyCluster:
+	default:
+		return "", "", fmt.Errorf("invalid scope '%s': must be %s, %s or %s", scope, ScopeNamespaced, ScopeCluster, ScopeLegacyCluster)
+	}
+
+	if scope != ScopeLegacyCluster && (opts.WithClaims || opts.ClaimKind != "" || opts.ClaimPlural != "") {
+		return "", "", fmt.Errorf("claims are not supported by Crossplane v2 XRDs with scope %s (only %s)", scope, ScopeLegacyCluster)
+	}
+
+	return APIVersionV2, scope, nil
+}
+
+// validateSpecOptions checks the policy values and that claim-related
+// settings are only used where claims exist
+func validateSpecOptions(opts XRDOptions, scope string) error {
+	switch opts.DefaultCompositionUpdatePolicy {
+	case "", "Automatic", "Manual":
+	default:
+		return fmt.Errorf("invalid defaultCompositionUpdatePolicy '%s': must be Automatic or Manual", opts.DefaultCompositionUpdatePolicy)
+	}
+	switch opts.DefaultCompositeDeletePolicy {
+	case "", "Background", "Foreground":
+	default:
+		return fmt.Errorf("invalid defaultCompositeDeletePolicy '%s': must be Background or Foreground", opts.DefaultCompositeDeletePolicy)
+	}
+
+	// Crossplane v2 XRs only have claims and connection secrets when legacy
+	if scope != "" && scope != ScopeLegacyCluster {
+		if opts.DefaultCompositeDeletePolicy != "" {
+			return fmt.Errorf("defaultCompositeDeletePolicy is not supported by Crossplane v2 XRDs with scope %s (only %s)", scope, ScopeLegacyCluster)
+		}
+		if len(opts.ConnectionSecretKeys) > 0 {
+			return fmt.Errorf("connectionSecretKeys is not supported by Crossplane v2 XRDs with scope %s (only %s)", scope, ScopeLegacyCluster)
+		}
+	}
+	return nil
+}
+
+// resourceNameRegex matches the lowercase names Kubernetes accepts for
+// plurals, singulars and short names
+var resourceNameRegex = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// validateNames checks that a plural, singular and short names are valid
+// lowercase resource names
+func validateNames(plural, singular string, shortNames []string) error {
+	names := append([]string{plural}, shortNames...)
+	if singular != "" {
+		names = append(names, singular)
+	}
+	for _, name := range names {
+		if !resourceNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid resource name '%s': must be lowercase alphanumeric characters or '-', starting with a letter", name)
+		}
+	}
+	return nil
+}
+
+// buildOpenAPIV3Schema builds the schema of one XRD version: spec.parameters,
+// spec-level fields, @spec.path schemas and status
+func (r *schemaResolver) buildOpenAPIV3Schema(schema *parser.Schema, statusPreserveUnknownFields bool) OpenAPIV3Schema {
 
 	// Build the spec.parameters structure, status structure, and spec-level fields
 	parametersSchema := PropertySchema{
@@ -244,9 +532,10 @@ func GenerateXRDWithSchemasAndOptions(schema *parser.Schema, schemas map[string]
 		Required:   []string{},
 	}
 
-	// Map to store spec-level fields (fields marked with @spec)
-	specLevelFields := make(map[string]PropertySchema)
-	specLevelRequired := []string{}
+	// Spec-level fields (fields marked with @spec), added to spec below
+	specLevelFields := PropertySchema{
+		Properties: make(map[string]PropertySchema),
+	}
 
 	// Map to store spec path schemas (schemas marked with @spec.path)
 	specPathSchemas := make(map[string]*parser.Schema)
@@ -255,10 +544,12 @@ func GenerateXRDWithSchemasAndOptions(schema *parser.Schema, schemas map[string]
 
 	// Check if there's a separate status schema
 	var statusSchemaObj *parser.Schema
-	for _, s := range schemas {
+	for _, s := range r.schemas {
 		if s.IsStatus {
-			statusSchemaObj = s
-			break
+			if statusSchemaObj == nil {
+				statusSchemaObj = s
+			}
+			continue
 		}
 		// Collect schemas with SpecPath
 		if s.SpecPath != "" {
@@ -268,41 +559,32 @@ func GenerateXRDWithSchemasAndOptions(schema *parser.Schema, schemas map[string]
 
 	// If there's a separate status schema, use its fields for status
 	if statusSchemaObj != nil {
+		r.enter(statusSchemaObj.Name)
 		for _, field := range statusSchemaObj.Fields {
-			propSchema := convertFieldToPropertySchemaWithSchemas(field, schemas)
-			statusSchema.Properties[field.Name] = propSchema
-			if field.Required {
-				statusSchema.Required = append(statusSchema.Required, field.Name)
-			}
+			r.addField(&statusSchema, field)
 			hasStatusFields = true
 		}
+		r.leave()
+		applySchemaValidations(statusSchemaObj, &statusSchema)
 	}
 
+	r.enter(schema.Name)
 	for _, field := range schema.Fields {
-		propSchema := convertFieldToPropertySchemaWithSchemas(field, schemas)
-
 		// Check if field is marked as status field
 		if field.IsStatus {
-			statusSchema.Properties[field.Name] = propSchema
-			if field.Required {
-				statusSchema.Required = append(statusSchema.Required, field.Name)
-			}
+			r.addField(&statusSchema, field)
 			hasStatusFields = true
 		} else if field.IsSpec {
 			// Spec-level field (goes directly under spec, not in parameters)
-			specLevelFields[field.Name] = propSchema
-			if field.Required {
-				specLevelRequired = append(specLevelRequired, field.Name)
-			}
+			r.addField(&specLevelFields, field)
 		} else {
 			// Regular spec.parameters field
-			parametersSchema.Properties[field.Name] = propSchema
-			if field.Required {
-				parametersSchema.Required = append(parametersSchema.Required, field.Name)
-			}
+			r.addField(&parametersSchema, field)
 		}
 	}
 
+	r.leave()
+
 	// Apply schema-level oneOf/anyOf to parameters
 	if len(schema.OneOf) > 0 {
 		for _, requiredFields := range schema.OneOf {
@@ -322,6 +604,9 @@ func GenerateXRDWithSchemasAndOptions(schema *parser.Schema, schemas map[string]
 		}
 	}
 
+	// Apply rules translated from the schema's check block to parameters
+	applySchemaValidations(schema, &parametersSchema)
+
 	// Add spec section with parameters
 	specSchema := PropertySchema{
 		Type: "object",
@@ -332,12 +617,13 @@ func GenerateXRDWithSchemasAndOptions(schema *parser.Schema, schemas map[string]
 	}
 
 	// Add spec-level fields directly to spec
-	for fieldName, fieldSchema := range specLevelFields {
+	for fieldName, fieldSchema := range specLevelFields.Properties {
 		specSchema.Properties[fieldName] = fieldSchema
 	}
 
-	// Add spec-level required fields to spec required list
-	specSchema.Required = append(specSchema.Required, specLevelRequired...)
+	// Add spec-level required fields and their rules to spec
+	specSchema.Required = append(specSchema.Required, specLevelFields.Required...)
+	specSchema.XKubernetesValidations = specLevelFields.XKubernetesValidations
 
 	// Process spec path schemas (schemas marked with @spec.path)
 	for path, specPathSchema := range specPathSchemas {
@@ -347,59 +633,56 @@ func GenerateXRDWithSchemasAndOptions(schema *parser.Schema, schemas map[string]
 			Required:   []string{},
 		}
 
+		r.enter(specPathSchema.Name)
 		for _, field := range specPathSchema.Fields {
-			propSchema := convertFieldToPropertySchemaWithSchemas(field, schemas)
-			pathSchema.Properties[field.Name] = propSchema
-			if field.Required {
-				pathSchema.Required = append(pathSchema.Required, field.Name)
-			}
+			r.addField(&pathSchema, field)
 		}
+		r.leave()
+		applySchemaValidations(specPathSchema, &pathSchema)
 
 		// Add the path schema to spec
 		specSchema.Properties[path] = pathSchema
 	}
 
-	xrd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"] = specSchema
-	xrd.Spec.Versions[0].Schema.OpenAPIV3Schema.Required = []string{"spec"}
+	openAPIV3Schema := OpenAPIV3Schema{
+		Type: "object",
+		Properties: map[string]PropertySchema{
+			"spec": specSchema,
+		},
+		Required: []string{"spec"},
+	}
 
 	// Add status section if there are status fields or if status preserve-unknown-fields is set
-	if hasStatusFields || opts.StatusPreserveUnknownFields {
+	if hasStatusFields || statusPreserveUnknownFields {
 		// If status preserve-unknown-fields is set but no fields, create minimal status schema
-		if opts.StatusPreserveUnknownFields && !hasStatusFields {
+		if statusPreserveUnknownFields && !hasStatusFields {
 			preserve := true
 			statusSchema = PropertySchema{
 				Type:                             "object",
 				XKubernetesPreserveUnknownFields: &preserve,
 			}
 		}
-		xrd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["status"] = statusSchema
-	}
-
-	// Marshal to YAML with 2-space indentation
-	var buf strings.Builder
-	encoder := yaml.NewEncoder(&buf)
-	encoder.SetIndent(2)
-	err := encoder.Encode(xrd)
-	encoder.Close()
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal XRD to YAML: %w", err)
+		openAPIV3Schema.Properties["status"] = statusSchema
 	}
 
-	return buf.String(), nil
+	return openAPIV3Schema
 }
 
 // convertFieldToPropertySchema converts a KCL field to an OpenAPI property schema
 func convertFieldToPropertySchema(field parser.Field) PropertySchema {
-	return convertFieldToPropertySchemaWithSchemas(field, nil)
+	return newSchemaResolver(nil, XRDOptions{}).convertField(field)
 }
 
-// convertFieldToPropertySchemaWithSchemas converts a KCL field to an OpenAPI property schema
-// with support for nested schema expansion
-func convertFieldToPropertySchemaWithSchemas(field parser.Field, schemas map[string]*parser.Schema) PropertySchema {
+// convertField converts a KCL field to an OpenAPI property schema with
+// support for nested schema expansion
+func (r *schemaResolver) convertField(field parser.Field) PropertySchema {
 	schema := PropertySchema{}
 
 	// Map KCL types to OpenAPI types
 	switch {
+	case isUnionType(field.Type):
+		// Union and literal types: enum, int-or-string or anyOf
+		schema = r.convertUnionType(field.Type)
 	case field.Type == "any":
 		// 'any' type should not have a type specified, only preserve unknown fields
 		// Don't set schema.Type
@@ -434,7 +717,7 @@ func convertFieldToPropertySchemaWithSchemas(field parser.Field, schemas map[str
 			}
 			schema.Items = &elementSchema
 		} else {
-			elementSchema := convertFieldToPropertySchemaWithSchemas(parser.Field{Type: elementType}, schemas)
+			elementSchema := r.convertField(parser.Field{Type: elementType})
 			// Apply itemsFormat if specified
 			if field.ItemsFormat != "" {
 				elementSchema.Format = field.ItemsFormat
@@ -458,7 +741,7 @@ func convertFieldToPropertySchemaWithSchemas(field parser.Field, schemas map[str
 			valueType := strings.TrimSpace(parts[1])
 
 			// Create the additionalProperties schema based on the value type
-			valueSchema := convertFieldToPropertySchemaWithSchemas(parser.Field{Type: valueType}, schemas)
+			valueSchema := r.convertField(parser.Field{Type: valueType})
 			schema.AdditionalProperties = &valueSchema
 
 			// Special handling for {any:any} - apply preserve unknown fields if annotation is present
@@ -469,11 +752,19 @@ func convertFieldToPropertySchemaWithSchemas(field parser.Field, schemas map[str
 		}
 	default:
 		// Check if it's a reference to another schema
-		if schemas != nil && schemas[field.Type] != nil {
+		if definition, ok := r.definitions.lookup(field.Type); ok {
+			// The upstream schema of a type from a generated KCL module
+			schema = definition
+		} else if nestedSchema := r.schemas[field.Type]; nestedSchema != nil {
+			if !r.enter(nestedSchema.Name) {
+				// A recursive reference cut at the maximum depth
+				return r.cutSchema(field)
+			}
+			defer r.leave()
+
 			// Expand the nested schema
 			schema.Type = "object"
 			schema.Properties = make(map[string]PropertySchema)
-			nestedSchema := schemas[field.Type]
 
 			// Add description from the field if present (for the object itself)
 			if field.Description != "" {
@@ -481,12 +772,10 @@ func convertFieldToPropertySchemaWithSchemas(field parser.Field, schemas map[str
 			}
 
 			for _, nestedField := range nestedSchema.Fields {
-				nestedProp := convertFieldToPropertySchemaWithSchemas(nestedField, schemas)
-				schema.Properties[nestedField.Name] = nestedProp
-				if nestedField.Required {
-					schema.Required = append(schema.Required, nestedField.Name)
-				}
+				r.addField(&schema, nestedField)
 			}
+			applySchemaValidations(nestedSchema, &schema)
+			applyKubernetesTypes(field, &schema)
 
 			// Apply validation fields and defaults to the nested schema object
 			applyFieldValidationsAndDefaults(field, &schema)
@@ -498,6 +787,7 @@ func convertFieldToPropertySchemaWithSchemas(field parser.Field, schemas map[str
 			schema.Type = "object"
 		}
 	}
+	applyKubernetesTypes(field, &schema)
 
 	if field.Description != "" {
 		schema.Description = field.Description
@@ -508,21 +798,48 @@ func convertFieldToPropertySchemaWithSchemas(field parser.Field, schemas map[str
 	return schema
 }
 
+// applySchemaValidations adds the CEL rules of a schema to the object it is
+// rendered as
+func applySchemaValidations(s *parser.Schema, schema *PropertySchema) {
+	for _, celVal := range s.CELValidations {
+		schema.XKubernetesValidations = append(schema.XKubernetesValidations, k8sValidation(celVal))
+	}
+}
+
+// k8sValidation converts a CEL validation rule into an x-kubernetes-validations entry
+func k8sValidation(v parser.CELValidation) K8sValidation {
+	validation := K8sValidation{
+		Rule:              v.Rule,
+		Message:           v.Message,
+		MessageExpression: v.MessageExpression,
+		Reason:            v.Reason,
+		FieldPath:         v.FieldPath,
+		Line:              v.Line,
+	}
+	if v.OptionalOldSelf {
+		optional := true
+		validation.OptionalOldSelf = &optional
+	}
+	return validation
+}
+
 // applyFieldValidationsAndDefaults applies validation and default values to a property schema
 func applyFieldValidationsAndDefaults(field parser.Field, schema *PropertySchema) {
 
-	if field.Default != "" && field.Default != "Undefined" {
+	if field.DefaultValue != nil {
+		// Evaluated by the parser, including lists, dicts and schema instances
+		schema.Default = conformDefault(parser.CopyValue(field.DefaultValue), schema)
+	} else if field.Default != "" && field.Default != "Undefined" && field.Default != "None" {
 		// Parse the default value to remove quotes if it's a string literal
 		defaultValue := strings.Trim(field.Default, `"`)
 
-		// Try to convert to appropriate type
+		// Try to convert to appropriate type; expressions the parser could
+		// not evaluate are left out
 		switch schema.Type {
 		case "integer":
 			// Try to parse as integer
 			if intVal, err := strconv.Atoi(defaultValue); err == nil {
 				schema.Default = intVal
-			} else {
-				schema.Default = defaultValue
 			}
 		case "boolean":
 			// Convert boolean strings to actual boolean values
@@ -535,13 +852,18 @@ func applyFieldValidationsAndDefaults(field parser.Field, schema *PropertySchema
 			// Try to parse as float
 			if floatVal, err := strconv.ParseFloat(defaultValue, 64); err == nil {
 				schema.Default = floatVal
-			} else {
-				schema.Default = defaultValue
 			}
+		case "array", "object":
+			// Only evaluated defaults are structured
 		case "string":
 			schema.Default = defaultValue
 		default:
-			schema.Default = defaultValue
+			// Union types take the type of the literal, e.g. 8080 for int | str
+			if value, _, ok := literalType(field.Default); ok {
+				schema.Default = value
+			} else {
+				schema.Default = defaultValue
+			}
 		}
 	}
 
@@ -560,10 +882,16 @@ func applyFieldValidationsAndDefaults(field parser.Field, schema *PropertySchema
 
 	if field.Minimum != nil {
 		schema.Minimum = field.Minimum
+		schema.ExclusiveMinimum = field.ExclusiveMinimum
 	}
 
 	if field.Maximum != nil {
 		schema.Maximum = field.Maximum
+		schema.ExclusiveMaximum = field.ExclusiveMaximum
+	}
+
+	if field.MultipleOf != nil {
+		schema.MultipleOf = field.MultipleOf
 	}
 
 	if field.MinItems != nil {
@@ -574,18 +902,29 @@ func applyFieldValidationsAndDefaults(field parser.Field, schema *PropertySchema
 		schema.MaxItems = field.MaxItems
 	}
 
+	if field.MinProperties != nil {
+		schema.MinProperties = field.MinProperties
+	}
+
+	if field.MaxProperties != nil {
+		schema.MaxProperties = field.MaxProperties
+	}
+
+	if field.Nullable {
+		schema.Nullable = true
+	}
+
 	if field.Format != "" {
 		schema.Format = field.Format
 	}
 
 	if len(field.Enum) > 0 {
-		schema.Enum = field.Enum
+		schema.Enum = enumValues(field.Enum, schema.Type)
 	}
 
-	if field.Immutable {
-		immutable := true
-		schema.XKubernetesImmutable = &immutable
-	}
+	// x-kubernetes-immutable is not enforced for CRDs; immutability is a
+	// transition rule
+	schema.XKubernetesValidations = append(schema.XKubernetesValidations, transitionRules(field)...)
 
 	// Apply preserveUnknownFields, but skip for array types with [{any:any}] pattern
 	// as those are handled in the type conversion logic
@@ -608,6 +947,9 @@ func applyFieldValidationsAndDefaults(field parser.Field, schema *PropertySchema
 
 	if field.ListType != "" {
 		schema.XKubernetesListType = field.ListType
+	} else if field.UniqueItems {
+		// The API server rejects uniqueItems; a set list has unique items
+		schema.XKubernetesListType = "set"
 	}
 
 	if len(field.ListMapKeys) > 0 {
@@ -617,11 +959,7 @@ func applyFieldValidationsAndDefaults(field parser.Field, schema *PropertySchema
 	// Apply CEL validations
 	if len(field.CELValidations) > 0 {
 		for _, celVal := range field.CELValidations {
-			k8sVal := K8sValidation{
-				Rule:    celVal.Rule,
-				Message: celVal.Message,
-			}
-			schema.XKubernetesValidations = append(schema.XKubernetesValidations, k8sVal)
+			schema.XKubernetesValidations = append(schema.XKubernetesValidations, k8sValidation(celVal))
 		}
 	}
 
@@ -1,6 +1,11 @@
 package generator
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -1269,9 +1274,9 @@ func TestGenerateXRDWithFormat(t *testing.T) {
 				Format:   "date-time",
 			},
 			{
-				Name:   "email",
-				Type:   "str",
-				Format: "email",
+				Name:    "email",
+				Type:    "str",
+				Format:  "email",
 				Pattern: "^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\\.[a-zA-Z]{2,}$",
 			},
 			{
@@ -1600,107 +1605,1503 @@ func TestGenerateXRDWithSpecPathSchemas(t *testing.T) {
 	}
 }
 
+func TestGenerateXRDWithItemsPreserveUnknownFields(t *testing.T) {
+	schema := &parser.Schema{
+		Name: "XMyApp",
+		Fields: []parser.Field{
+			{
+				Name:     "name",
+				Type:     "str",
+				Required: true,
+			},
+			{
+				Name:                  "filter",
+				Type:                  "[{any:any}]",
+				Required:              true,
+				PreserveUnknownFields: true,
+			},
+			{
+				Name:                       "configs",
+				Type:                       "[{str:str}]",
+				Required:                   true,
+				ItemsPreserveUnknownFields: true,
+			},
+			{
+				Name:                  "metadata",
+				Type:                  "{any:any}",
+				Required:              false,
+				PreserveUnknownFields: true,
+			},
+		},
+	}
 
+	xrdYAML, err := GenerateXRD(schema, "example.org", "v1alpha1")
+	if err != nil {
+		t.Fatalf("GenerateXRD failed: %v", err)
+	}
 
-func TestGenerateXRDWithItemsPreserveUnknownFields(t *testing.T) {
-schema := &parser.Schema{
-Name: "XMyApp",
-Fields: []parser.Field{
-{
-Name:     "name",
-Type:     "str",
-Required: true,
-},
-{
-Name:                       "filter",
-Type:                       "[{any:any}]",
-Required:                   true,
-PreserveUnknownFields:      true,
-},
-{
-Name:                       "configs",
-Type:                       "[{str:str}]",
-Required:                   true,
-ItemsPreserveUnknownFields: true,
-},
-{
-Name:                  "metadata",
-Type:                  "{any:any}",
-Required:              false,
-PreserveUnknownFields: true,
-},
-},
-}
+	// Check that it's valid YAML
+	var xrd map[string]interface{}
+	if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+		t.Fatalf("Generated XRD is not valid YAML: %v", err)
+	}
 
-xrdYAML, err := GenerateXRD(schema, "example.org", "v1alpha1")
-if err != nil {
-t.Fatalf("GenerateXRD failed: %v", err)
-}
+	// Navigate to the schema
+	spec := xrd["spec"].(map[string]interface{})
+	versions := spec["versions"].([]interface{})
+	version := versions[0].(map[string]interface{})
+	schema_obj := version["schema"].(map[string]interface{})
+	openAPIV3Schema := schema_obj["openAPIV3Schema"].(map[string]interface{})
+	properties := openAPIV3Schema["properties"].(map[string]interface{})
+	specProp := properties["spec"].(map[string]interface{})
+	specProps := specProp["properties"].(map[string]interface{})
+	parameters := specProps["parameters"].(map[string]interface{})
+	paramProps := parameters["properties"].(map[string]interface{})
 
-// Check that it's valid YAML
-var xrd map[string]interface{}
-if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
-t.Fatalf("Generated XRD is not valid YAML: %v", err)
-}
+	// Check filter field - should have x-kubernetes-preserve-unknown-fields only on items
+	filter := paramProps["filter"].(map[string]interface{})
+	if filter["type"] != "array" {
+		t.Errorf("Expected type 'array' for filter, got '%v'", filter["type"])
+	}
 
-// Navigate to the schema
-spec := xrd["spec"].(map[string]interface{})
-versions := spec["versions"].([]interface{})
-version := versions[0].(map[string]interface{})
-schema_obj := version["schema"].(map[string]interface{})
-openAPIV3Schema := schema_obj["openAPIV3Schema"].(map[string]interface{})
-properties := openAPIV3Schema["properties"].(map[string]interface{})
-specProp := properties["spec"].(map[string]interface{})
-specProps := specProp["properties"].(map[string]interface{})
-parameters := specProps["parameters"].(map[string]interface{})
-paramProps := parameters["properties"].(map[string]interface{})
-
-// Check filter field - should have x-kubernetes-preserve-unknown-fields only on items
-filter := paramProps["filter"].(map[string]interface{})
-if filter["type"] != "array" {
-t.Errorf("Expected type 'array' for filter, got '%v'", filter["type"])
-}
+	// Filter should NOT have x-kubernetes-preserve-unknown-fields at array level
+	if _, hasPreserve := filter["x-kubernetes-preserve-unknown-fields"]; hasPreserve {
+		t.Error("Array 'filter' should not have x-kubernetes-preserve-unknown-fields")
+	}
 
-// Filter should NOT have x-kubernetes-preserve-unknown-fields at array level
-if _, hasPreserve := filter["x-kubernetes-preserve-unknown-fields"]; hasPreserve {
-t.Error("Array 'filter' should not have x-kubernetes-preserve-unknown-fields")
-}
+	// Filter items SHOULD have x-kubernetes-preserve-unknown-fields
+	filterItems := filter["items"].(map[string]interface{})
+	if filterItems["type"] != "object" {
+		t.Errorf("Expected items type 'object' for filter, got '%v'", filterItems["type"])
+	}
+	if preserveVal, ok := filterItems["x-kubernetes-preserve-unknown-fields"]; !ok || preserveVal != true {
+		t.Error("Filter items should have x-kubernetes-preserve-unknown-fields: true")
+	}
+
+	// Check configs field - should have x-kubernetes-preserve-unknown-fields only on items
+	configs := paramProps["configs"].(map[string]interface{})
+	if configs["type"] != "array" {
+		t.Errorf("Expected type 'array' for configs, got '%v'", configs["type"])
+	}
+
+	// Configs should NOT have x-kubernetes-preserve-unknown-fields at array level
+	if _, hasPreserve := configs["x-kubernetes-preserve-unknown-fields"]; hasPreserve {
+		t.Error("Array 'configs' should not have x-kubernetes-preserve-unknown-fields")
+	}
+
+	// Configs items SHOULD have x-kubernetes-preserve-unknown-fields
+	configsItems := configs["items"].(map[string]interface{})
+	if configsItems["type"] != "object" {
+		t.Errorf("Expected items type 'object' for configs, got '%v'", configsItems["type"])
+	}
+	if preserveVal, ok := configsItems["x-kubernetes-preserve-unknown-fields"]; !ok || preserveVal != true {
+		t.Error("Configs items should have x-kubernetes-preserve-unknown-fields: true")
+	}
 
-// Filter items SHOULD have x-kubernetes-preserve-unknown-fields
-filterItems := filter["items"].(map[string]interface{})
-if filterItems["type"] != "object" {
-t.Errorf("Expected items type 'object' for filter, got '%v'", filterItems["type"])
+	// Check metadata field - should have x-kubernetes-preserve-unknown-fields on the object itself
+	metadata := paramProps["metadata"].(map[string]interface{})
+	if metadata["type"] != "object" {
+		t.Errorf("Expected type 'object' for metadata, got '%v'", metadata["type"])
+	}
+	if preserveVal, ok := metadata["x-kubernetes-preserve-unknown-fields"]; !ok || preserveVal != true {
+		t.Error("Metadata object should have x-kubernetes-preserve-unknown-fields: true")
+	}
 }
-if preserveVal, ok := filterItems["x-kubernetes-preserve-unknown-fields"]; !ok || preserveVal != true {
-t.Error("Filter items should have x-kubernetes-preserve-unknown-fields: true")
+
+func TestGenerateXRDWithSchemaValidations(t *testing.T) {
+	settingsSchema := &parser.Schema{
+		Name: "Settings",
+		Fields: []parser.Field{
+			{Name: "minSize", Type: "int", Required: true},
+			{Name: "maxSize", Type: "int", Required: true},
+		},
+		CELValidations: []parser.CELValidation{
+			{Rule: "self.minSize <= self.maxSize"},
+		},
+	}
+	mainSchema := &parser.Schema{
+		Name: "XMyApp",
+		Fields: []parser.Field{
+			{Name: "replicas", Type: "int", Required: true},
+			{Name: "enabled", Type: "bool", Required: true},
+			{Name: "settings", Type: "Settings", Required: false},
+		},
+		CELValidations: []parser.CELValidation{
+			{Rule: "!self.enabled || self.replicas >= 2", Message: "enabled apps need at least 2 replicas"},
+		},
+	}
+	schemas := map[string]*parser.Schema{
+		"Settings": settingsSchema,
+		"XMyApp":   mainSchema,
+	}
+
+	xrdYAML, err := GenerateXRDWithSchemasAndOptions(mainSchema, schemas, XRDOptions{
+		Group:   "example.org",
+		Version: "v1alpha1",
+	})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+
+	var xrd map[string]interface{}
+	if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+		t.Fatalf("Generated XRD is not valid YAML: %v", err)
+	}
+
+	spec := xrd["spec"].(map[string]interface{})
+	version := spec["versions"].([]interface{})[0].(map[string]interface{})
+	openAPIV3Schema := version["schema"].(map[string]interface{})["openAPIV3Schema"].(map[string]interface{})
+	specProp := openAPIV3Schema["properties"].(map[string]interface{})["spec"].(map[string]interface{})
+	parameters := specProp["properties"].(map[string]interface{})["parameters"].(map[string]interface{})
+
+	validations, ok := parameters["x-kubernetes-validations"].([]interface{})
+	if !ok || len(validations) != 1 {
+		t.Fatalf("Expected 1 x-kubernetes-validations entry on parameters, got %v", parameters["x-kubernetes-validations"])
+	}
+	rule := validations[0].(map[string]interface{})
+	if rule["rule"] != "!self.enabled || self.replicas >= 2" || rule["message"] != "enabled apps need at least 2 replicas" {
+		t.Errorf("Unexpected parameters validation: %v", rule)
+	}
+
+	// Rules of a nested schema apply to the object it is expanded into
+	settings := parameters["properties"].(map[string]interface{})["settings"].(map[string]interface{})
+	nested, ok := settings["x-kubernetes-validations"].([]interface{})
+	if !ok || len(nested) != 1 || nested[0].(map[string]interface{})["rule"] != "self.minSize <= self.maxSize" {
+		t.Errorf("Expected nested schema validation on settings, got %v", settings["x-kubernetes-validations"])
+	}
 }
 
-// Check configs field - should have x-kubernetes-preserve-unknown-fields only on items
-configs := paramProps["configs"].(map[string]interface{})
-if configs["type"] != "array" {
-t.Errorf("Expected type 'array' for configs, got '%v'", configs["type"])
+func TestConvertUnionTypes(t *testing.T) {
+	t.Run("string literals become a string enum", func(t *testing.T) {
+		schema := convertFieldToPropertySchema(parser.Field{Name: "size", Type: `"small" | "medium" | "large"`, Default: `"small"`})
+		if schema.Type != "string" || !reflect.DeepEqual(schema.Enum, []interface{}{"small", "medium", "large"}) {
+			t.Errorf("Expected string enum, got type %q enum %v", schema.Type, schema.Enum)
+		}
+		if schema.Default != "small" {
+			t.Errorf("Expected default 'small', got %v", schema.Default)
+		}
+	})
+
+	t.Run("integer literals become an integer enum", func(t *testing.T) {
+		schema := convertFieldToPropertySchema(parser.Field{Name: "replicas", Type: "1 | 3 | 5"})
+		if schema.Type != "integer" || !reflect.DeepEqual(schema.Enum, []interface{}{1, 3, 5}) {
+			t.Errorf("Expected integer enum, got type %q enum %v", schema.Type, schema.Enum)
+		}
+	})
+
+	t.Run("int | str becomes int-or-string", func(t *testing.T) {
+		schema := convertFieldToPropertySchema(parser.Field{Name: "port", Type: "int | str", Default: "8080"})
+		if schema.Type != "" || schema.XKubernetesIntOrString == nil || !*schema.XKubernetesIntOrString {
+			t.Errorf("Expected x-kubernetes-int-or-string without type, got %+v", schema)
+		}
+		if schema.Default != 8080 {
+			t.Errorf("Expected integer default 8080, got %#v", schema.Default)
+		}
+	})
+
+	t.Run("int | float becomes a number", func(t *testing.T) {
+		schema := convertFieldToPropertySchema(parser.Field{Name: "ratio", Type: "int | float", Default: "1"})
+		if schema.Type != "number" || schema.XKubernetesPreserveUnknownFields != nil || len(schema.AnyOf) != 0 {
+			t.Errorf("Expected type number, got %+v", schema)
+		}
+		if schema.Default != 1.0 {
+			t.Errorf("Expected number default 1, got %#v", schema.Default)
+		}
+	})
+
+	t.Run("mixed union becomes a structural anyOf", func(t *testing.T) {
+		schema := convertFieldToPropertySchema(parser.Field{Name: "tls", Type: `bool | "auto"`})
+		if schema.Type != "" || schema.XKubernetesPreserveUnknownFields == nil {
+			t.Errorf("Expected untyped property preserving unknown fields, got %+v", schema)
+		}
+		expected := []PropertySchema{
+			{Enum: []interface{}{true, false}},
+			{Enum: []interface{}{"auto"}},
+		}
+		if !reflect.DeepEqual(schema.AnyOf, expected) {
+			t.Errorf("Expected anyOf %+v, got %+v", expected, schema.AnyOf)
+		}
+	})
+
+	t.Run("union of one type keeps the type", func(t *testing.T) {
+		schema := convertFieldToPropertySchema(parser.Field{Name: "mode", Type: `str | "auto"`})
+		if schema.Type != "string" || len(schema.AnyOf) != 0 {
+			t.Errorf("Expected plain string, got %+v", schema)
+		}
+	})
+
+	t.Run("union inside a list", func(t *testing.T) {
+		schema := convertFieldToPropertySchema(parser.Field{Name: "ports", Type: "[int | str]"})
+		if schema.Type != "array" || schema.Items == nil || schema.Items.XKubernetesIntOrString == nil {
+			t.Errorf("Expected array of int-or-string, got %+v", schema)
+		}
+	})
+
+	t.Run("enum annotation values follow the type", func(t *testing.T) {
+		schema := convertFieldToPropertySchema(parser.Field{Name: "replicas", Type: "int", Enum: []string{"1", "3"}})
+		if !reflect.DeepEqual(schema.Enum, []interface{}{1, 3}) {
+			t.Errorf("Expected integer enum values, got %#v", schema.Enum)
+		}
+	})
 }
 
-// Configs should NOT have x-kubernetes-preserve-unknown-fields at array level
-if _, hasPreserve := configs["x-kubernetes-preserve-unknown-fields"]; hasPreserve {
-t.Error("Array 'configs' should not have x-kubernetes-preserve-unknown-fields")
+func TestGenerateXRDWithVersions(t *testing.T) {
+	v1alpha1 := &parser.Schema{
+		Name: "Database",
+		Fields: []parser.Field{
+			{Name: "size", Type: "str", Required: true},
+		},
+	}
+	v1beta1 := &parser.Schema{
+		Name: "DatabaseV1beta1",
+		Fields: []parser.Field{
+			{Name: "size", Type: "str", Required: true},
+			{Name: "storageGB", Type: "int", Required: false},
+		},
+	}
+	opts := XRDOptions{Group: "example.org", Kind: "Database"}
+
+	xrdYAML, err := GenerateXRDWithVersions([]XRDVersion{
+		{Name: "v1alpha1", Schema: v1alpha1, Served: true, Deprecated: true, DeprecationWarning: "use v1beta1"},
+		{Name: "v1beta1", Schema: v1beta1, Served: true, Referenceable: true},
+	}, opts)
+	if err != nil {
+		t.Fatalf("GenerateXRDWithVersions failed: %v", err)
+	}
+
+	var xrd map[string]interface{}
+	if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+		t.Fatalf("Generated XRD is not valid YAML: %v", err)
+	}
+	spec := xrd["spec"].(map[string]interface{})
+	if kind := spec["names"].(map[string]interface{})["kind"]; kind != "Database" {
+		t.Errorf("Expected kind 'Database', got %v", kind)
+	}
+
+	versions := spec["versions"].([]interface{})
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(versions))
+	}
+	alpha := versions[0].(map[string]interface{})
+	beta := versions[1].(map[string]interface{})
+	if alpha["name"] != "v1alpha1" || alpha["deprecated"] != true || alpha["deprecationWarning"] != "use v1beta1" || alpha["referenceable"] != false {
+		t.Errorf("Unexpected v1alpha1 version: %v", alpha)
+	}
+	if beta["name"] != "v1beta1" || beta["referenceable"] != true {
+		t.Errorf("Unexpected v1beta1 version: %v", beta)
+	}
+	if _, ok := beta["deprecated"]; ok {
+		t.Error("Expected v1beta1 not to be deprecated")
+	}
+
+	// Each version has its own schema
+	params := func(v map[string]interface{}) map[string]interface{} {
+		openAPIV3Schema := v["schema"].(map[string]interface{})["openAPIV3Schema"].(map[string]interface{})
+		specProp := openAPIV3Schema["properties"].(map[string]interface{})["spec"].(map[string]interface{})
+		parameters := specProp["properties"].(map[string]interface{})["parameters"].(map[string]interface{})
+		return parameters["properties"].(map[string]interface{})
+	}
+	if _, ok := params(alpha)["storageGB"]; ok {
+		t.Error("Expected storageGB only in v1beta1")
+	}
+	if _, ok := params(beta)["storageGB"]; !ok {
+		t.Error("Expected storageGB in v1beta1")
+	}
+
+	// Exactly one version must be referenceable
+	if _, err := GenerateXRDWithVersions([]XRDVersion{
+		{Name: "v1alpha1", Schema: v1alpha1, Referenceable: true},
+		{Name: "v1beta1", Schema: v1beta1, Referenceable: true},
+	}, opts); err == nil {
+		t.Error("Expected error for two referenceable versions")
+	}
+	if _, err := GenerateXRDWithVersions([]XRDVersion{
+		{Name: "v1alpha1", Schema: v1alpha1},
+		{Name: "v1beta1", Schema: v1beta1},
+	}, opts); err == nil {
+		t.Error("Expected error for no referenceable version")
+	}
+	if _, err := GenerateXRDWithVersions([]XRDVersion{
+		{Name: "v1", Schema: v1alpha1, Referenceable: true},
+		{Name: "v1", Schema: v1beta1},
+	}, opts); err == nil {
+		t.Error("Expected error for duplicate version names")
+	}
 }
 
-// Configs items SHOULD have x-kubernetes-preserve-unknown-fields
-configsItems := configs["items"].(map[string]interface{})
-if configsItems["type"] != "object" {
-t.Errorf("Expected items type 'object' for configs, got '%v'", configsItems["type"])
+func TestGenerateXRDCrossplaneV2(t *testing.T) {
+	schema := &parser.Schema{
+		Name: "App",
+		Fields: []parser.Field{
+			{Name: "name", Type: "str", Required: true},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		opts          XRDOptions
+		expectedScope string
+		expectError   bool
+	}{
+		{
+			name:          "v2 defaults to Namespaced",
+			opts:          XRDOptions{CrossplaneVersion: "v2"},
+			expectedScope: "Namespaced",
+		},
+		{
+			name:          "scope implies v2",
+			opts:          XRDOptions{Scope: "Cluster"},
+			expectedScope: "Cluster",
+		},
+		{
+			name:          "LegacyCluster allows claims",
+			opts:          XRDOptions{Scope: "LegacyCluster", WithClaims: true},
+			expectedScope: "LegacyCluster",
+		},
+		{
+			name:        "claims are rejected for Namespaced",
+			opts:        XRDOptions{CrossplaneVersion: "v2", WithClaims: true},
+			expectError: true,
+		},
+		{
+			name:        "invalid scope",
+			opts:        XRDOptions{Scope: "Global"},
+			expectError: true,
+		},
+		{
+			name:        "scope with v1",
+			opts:        XRDOptions{CrossplaneVersion: "v1", Scope: "Cluster"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Group = "example.org"
+			tt.opts.Version = "v1alpha1"
+			xrdYAML, err := GenerateXRDWithOptions(schema, tt.opts)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateXRDWithOptions failed: %v", err)
+			}
+
+			var xrd map[string]interface{}
+			if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+				t.Fatalf("Generated XRD is not valid YAML: %v", err)
+			}
+			if xrd["apiVersion"] != "apiextensions.crossplane.io/v2" {
+				t.Errorf("Expected apiVersion 'apiextensions.crossplane.io/v2', got '%v'", xrd["apiVersion"])
+			}
+			spec := xrd["spec"].(map[string]interface{})
+			if spec["scope"] != tt.expectedScope {
+				t.Errorf("Expected scope '%s', got '%v'", tt.expectedScope, spec["scope"])
+			}
+			if _, hasClaims := spec["claimNames"]; hasClaims != tt.opts.WithClaims {
+				t.Errorf("Expected claimNames present: %v", tt.opts.WithClaims)
+			}
+		})
+	}
+
+	// v1 output stays the default and has no scope
+	xrdYAML, err := GenerateXRD(schema, "example.org", "v1alpha1")
+	if err != nil {
+		t.Fatalf("GenerateXRD failed: %v", err)
+	}
+	if !strings.Contains(xrdYAML, "apiVersion: apiextensions.crossplane.io/v1\n") || strings.Contains(xrdYAML, "scope:") {
+		t.Errorf("Expected v1 XRD without scope, got:\n%s", xrdYAML)
+	}
 }
-if preserveVal, ok := configsItems["x-kubernetes-preserve-unknown-fields"]; !ok || preserveVal != true {
-t.Error("Configs items should have x-kubernetes-preserve-unknown-fields: true")
+
+func TestGenerateXRDWithSpecOptions(t *testing.T) {
+	schema := &parser.Schema{
+		Name: "XDatabase",
+		Fields: []parser.Field{
+			{Name: "name", Type: "str", Required: true},
+		},
+	}
+
+	xrdYAML, err := GenerateXRDWithOptions(schema, XRDOptions{
+		Group:                          "example.org",
+		Version:                        "v1alpha1",
+		DefaultCompositionRef:          "database-aws",
+		EnforcedCompositionRef:         "database-aws-v2",
+		DefaultCompositionUpdatePolicy: "Manual",
+		DefaultCompositeDeletePolicy:   "Foreground",
+		ConnectionSecretKeys:           []string{"username", "password"},
+		Labels:                         map[string]string{"team": "platform"},
+		Annotations:                    map[string]string{"docs.example.org/url": "https://docs.example.org"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithOptions failed: %v", err)
+	}
+
+	var xrd map[string]interface{}
+	if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+		t.Fatalf("Generated XRD is not valid YAML: %v", err)
+	}
+
+	metadata := xrd["metadata"].(map[string]interface{})
+	if labels := metadata["labels"].(map[string]interface{}); labels["team"] != "platform" {
+		t.Errorf("Unexpected labels: %v", labels)
+	}
+	if annotations := metadata["annotations"].(map[string]interface{}); annotations["docs.example.org/url"] != "https://docs.example.org" {
+		t.Errorf("Unexpected annotations: %v", annotations)
+	}
+
+	spec := xrd["spec"].(map[string]interface{})
+	if ref := spec["defaultCompositionRef"].(map[string]interface{}); ref["name"] != "database-aws" {
+		t.Errorf("Unexpected defaultCompositionRef: %v", ref)
+	}
+	if ref := spec["enforcedCompositionRef"].(map[string]interface{}); ref["name"] != "database-aws-v2" {
+		t.Errorf("Unexpected enforcedCompositionRef: %v", ref)
+	}
+	if spec["defaultCompositionUpdatePolicy"] != "Manual" || spec["defaultCompositeDeletePolicy"] != "Foreground" {
+		t.Errorf("Unexpected policies: %v, %v", spec["defaultCompositionUpdatePolicy"], spec["defaultCompositeDeletePolicy"])
+	}
+	if keys := spec["connectionSecretKeys"].([]interface{}); len(keys) != 2 || keys[0] != "username" {
+		t.Errorf("Unexpected connectionSecretKeys: %v", keys)
+	}
+
+	// Invalid policies and v2-incompatible settings are rejected
+	invalid := []XRDOptions{
+		{DefaultCompositionUpdatePolicy: "Sometimes"},
+		{DefaultCompositeDeletePolicy: "Orphan"},
+		{Scope: "Namespaced", DefaultCompositeDeletePolicy: "Foreground"},
+		{Scope: "Cluster", ConnectionSecretKeys: []string{"password"}},
+	}
+	for _, opts := range invalid {
+		opts.Group = "example.org"
+		opts.Version = "v1alpha1"
+		if _, err := GenerateXRDWithOptions(schema, opts); err == nil {
+			t.Errorf("Expected error for options %+v", opts)
+		}
+	}
 }
 
-// Check metadata field - should have x-kubernetes-preserve-unknown-fields on the object itself
-metadata := paramProps["metadata"].(map[string]interface{})
-if metadata["type"] != "object" {
-t.Errorf("Expected type 'object' for metadata, got '%v'", metadata["type"])
+func TestPluralize(t *testing.T) {
+	tests := map[string]string{
+		"XDatabase":     "xdatabases",
+		"Policy":        "policies",
+		"XPolicy":       "xpolicies",
+		"Address":       "addresses",
+		"Proxy":         "proxies",
+		"Key":           "keys",
+		"Gateway":       "gateways",
+		"Box":           "boxes",
+		"Patch":         "patches",
+		"Mesh":          "meshes",
+		"Status":        "statuses",
+		"Person":        "people",
+		"ClusterPerson": "clusterpeople",
+		"Human":         "humans",
+		"Metadata":      "metadata",
+		"DNSRecord":     "dnsrecords",
+		"S3Bucket":      "s3buckets",
+		"XRD":           "xrds",
+	}
+	for kind, expected := range tests {
+		if plural := Pluralize(kind); plural != expected {
+			t.Errorf("Pluralize(%q) = %q, expected %q", kind, plural, expected)
+		}
+	}
 }
-if preserveVal, ok := metadata["x-kubernetes-preserve-unknown-fields"]; !ok || preserveVal != true {
-t.Error("Metadata object should have x-kubernetes-preserve-unknown-fields: true")
+
+func TestGenerateXRDWithNames(t *testing.T) {
+	schema := &parser.Schema{
+		Name: "Policy",
+		Fields: []parser.Field{
+			{Name: "name", Type: "str", Required: true},
+		},
+	}
+
+	generate := func(opts XRDOptions) map[string]interface{} {
+		t.Helper()
+		opts.Group = "example.org"
+		opts.Version = "v1alpha1"
+		xrdYAML, err := GenerateXRDWithOptions(schema, opts)
+		if err != nil {
+			t.Fatalf("GenerateXRDWithOptions failed: %v", err)
+		}
+		var xrd map[string]interface{}
+		if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+			t.Fatalf("Generated XRD is not valid YAML: %v", err)
+		}
+		return xrd
+	}
+
+	// Claims pluralize both kinds
+	xrd := generate(XRDOptions{WithClaims: true})
+	spec := xrd["spec"].(map[string]interface{})
+	if plural := spec["names"].(map[string]interface{})["plural"]; plural != "xpolicies" {
+		t.Errorf("Expected XRD plural 'xpolicies', got %v", plural)
+	}
+	if plural := spec["claimNames"].(map[string]interface{})["plural"]; plural != "policies" {
+		t.Errorf("Expected claim plural 'policies', got %v", plural)
+	}
+	if name := xrd["metadata"].(map[string]interface{})["name"]; name != "xpolicies.example.org" {
+		t.Errorf("Expected name 'xpolicies.example.org', got %v", name)
+	}
+	if _, ok := spec["names"].(map[string]interface{})["singular"]; ok {
+		t.Error("Expected no singular without an override")
+	}
+
+	// Overrides
+	xrd = generate(XRDOptions{
+		WithClaims:      true,
+		Plural:          "xpolicys",
+		Singular:        "xpolicy",
+		ShortNames:      []string{"xpol"},
+		ListKind:        "XPolicyList",
+		ClaimShortNames: []string{"pol"},
+	})
+	spec = xrd["spec"].(map[string]interface{})
+	names := spec["names"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"kind":       "XPolicy",
+		"plural":     "xpolicys",
+		"singular":   "xpolicy",
+		"shortNames": []interface{}{"xpol"},
+		"listKind":   "XPolicyList",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected names %v, got %v", expected, names)
+	}
+	if name := xrd["metadata"].(map[string]interface{})["name"]; name != "xpolicys.example.org" {
+		t.Errorf("Expected name 'xpolicys.example.org', got %v", name)
+	}
+	claimNames := spec["claimNames"].(map[string]interface{})
+	if !reflect.DeepEqual(claimNames["shortNames"], []interface{}{"pol"}) {
+		t.Errorf("Expected claim short names [pol], got %v", claimNames["shortNames"])
+	}
+
+	// Names must be lowercase
+	for _, opts := range []XRDOptions{{Plural: "Policies"}, {ShortNames: []string{"Pol"}}, {Singular: "a policy"}} {
+		opts.Group = "example.org"
+		opts.Version = "v1alpha1"
+		if _, err := GenerateXRDWithOptions(schema, opts); err == nil {
+			t.Errorf("Expected error for names %+v", opts)
+		}
+	}
 }
+
+func TestGenerateXRDWithRecursiveSchemas(t *testing.T) {
+	schemas := map[string]*parser.Schema{
+		"Rule": {
+			Name: "Rule",
+			Fields: []parser.Field{
+				{Name: "path", Type: "str", Required: true},
+				{Name: "children", Type: "[Rule]", Description: "Nested rules"},
+			},
+		},
+		"Match": {
+			Name:   "Match",
+			Fields: []parser.Field{{Name: "next", Type: "Next"}},
+		},
+		"Next": {
+			Name:   "Next",
+			Fields: []parser.Field{{Name: "match", Type: "Match"}},
+		},
+	}
+	routing := &parser.Schema{
+		Name: "XRouting",
+		Fields: []parser.Field{
+			{Name: "rule", Type: "Rule", Required: true},
+		},
+	}
+	matching := &parser.Schema{
+		Name: "XMatching",
+		Fields: []parser.Field{
+			{Name: "match", Type: "Match"},
+		},
+	}
+
+	// Without a maximum depth recursion is an error that names the chain
+	_, err := GenerateXRDWithSchemasAndOptions(routing, schemas, XRDOptions{Group: "example.org", Version: "v1"})
+	if err == nil || !strings.Contains(err.Error(), "Rule -> Rule") {
+		t.Errorf("Expected recursion error with chain 'Rule -> Rule', got %v", err)
+	}
+	_, err = GenerateXRDWithSchemasAndOptions(matching, schemas, XRDOptions{Group: "example.org", Version: "v1"})
+	if err == nil || !strings.Contains(err.Error(), "Match -> Next -> Match") {
+		t.Errorf("Expected recursion error with chain 'Match -> Next -> Match', got %v", err)
+	}
+
+	// With a maximum depth the cycle is cut with preserve-unknown-fields
+	xrdYAML, err := GenerateXRDWithSchemasAndOptions(routing, schemas, XRDOptions{Group: "example.org", Version: "v1", MaxRecursionDepth: 2})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+	var xrd map[string]interface{}
+	if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+		t.Fatalf("Generated XRD is not valid YAML: %v", err)
+	}
+	versions := xrd["spec"].(map[string]interface{})["versions"].([]interface{})
+	params := versions[0].(map[string]interface{})["schema"].(map[string]interface{})["openAPIV3Schema"].(map[string]interface{})["properties"].(map[string]interface{})["spec"].(map[string]interface{})["properties"].(map[string]interface{})["parameters"].(map[string]interface{})
+
+	children := func(rule map[string]interface{}) map[string]interface{} {
+		return rule["properties"].(map[string]interface{})["children"].(map[string]interface{})["items"].(map[string]interface{})
+	}
+	rule := params["properties"].(map[string]interface{})["rule"].(map[string]interface{})
+	nested := children(rule)
+	if _, ok := nested["properties"]; !ok {
+		t.Fatalf("Expected the first nested rule to be expanded, got %v", nested)
+	}
+	cut := children(nested)
+	expected := map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true}
+	if !reflect.DeepEqual(cut, expected) {
+		t.Errorf("Expected cut rule %v, got %v", expected, cut)
+	}
+}
+
+func TestGenerateComposition(t *testing.T) {
+	schemas := map[string]*parser.Schema{
+		"Container": {
+			Name: "Container",
+			Fields: []parser.Field{
+				{Name: "image", Type: "str", Required: true},
+				{Name: "port", Type: "int", Default: "8080", DefaultValue: 8080},
+				{Name: "probe", Type: "k8s.api.core.v1.Probe"},
+			},
+		},
+	}
+	app := &parser.Schema{
+		Name: "App",
+		Fields: []parser.Field{
+			{Name: "name", Type: "str", Required: true},
+			{Name: "type", Type: `"web" | "worker"`, Default: `"web"`, DefaultValue: "web"},
+			{Name: "containers", Type: "[Container]"},
+			{Name: "labels", Type: "{str:str}", Default: `{team = "platform"}`, DefaultValue: map[string]interface{}{"team": "platform"}},
+			{Name: "region", Type: "str", Default: `option("region")`, Line: 9},
+			{Name: "deletionPolicy", Type: "str", IsSpec: true},
+			{Name: "ready", Type: "bool", IsStatus: true},
+		},
+	}
+	schemas["App"] = app
+
+	compositionYAML, warnings, err := GenerateCompositionWithSchemasAndOptions(app, schemas, XRDOptions{
+		Group:      "example.org",
+		Version:    "v1alpha1",
+		WithClaims: true,
+	})
+	if err != nil {
+		t.Fatalf("GenerateCompositionWithSchemasAndOptions failed: %v", err)
+	}
+	if len(warnings) != 1 || warnings[0].Line != 9 || !strings.Contains(warnings[0].Message, `default option("region") of App.region`) {
+		t.Errorf("Expected a warning for the default that was not evaluated, got %v", warnings)
+	}
+
+	var composition map[string]interface{}
+	if err := yaml.Unmarshal([]byte(compositionYAML), &composition); err != nil {
+		t.Fatalf("Generated Composition is not valid YAML: %v", err)
+	}
+	if name := composition["metadata"].(map[string]interface{})["name"]; name != "xapps.example.org" {
+		t.Errorf("Expected Composition name 'xapps.example.org', got %v", name)
+	}
+	spec := composition["spec"].(map[string]interface{})
+	expectedRef := map[string]interface{}{"apiVersion": "example.org/v1alpha1", "kind": "XApp"}
+	if !reflect.DeepEqual(spec["compositeTypeRef"], expectedRef) {
+		t.Errorf("Expected compositeTypeRef %v, got %v", expectedRef, spec["compositeTypeRef"])
+	}
+	if spec["mode"] != "Pipeline" {
+		t.Errorf("Expected mode 'Pipeline', got %v", spec["mode"])
+	}
+
+	step := spec["pipeline"].([]interface{})[0].(map[string]interface{})
+	if ref := step["functionRef"].(map[string]interface{})["name"]; ref != "function-kcl" {
+		t.Errorf("Expected function-kcl step, got %v", ref)
+	}
+	source := step["input"].(map[string]interface{})["spec"].(map[string]interface{})["source"].(string)
+
+	// Parameter fields are typed, spec-level and status fields are left out
+	// and types from other packages become any. Defaults are written as the
+	// literals of their values, those that were not evaluated are dropped.
+	for _, expected := range []string{
+		"schema Container:\n    image: str\n    port?: int = 8080\n    probe?: any\n",
+		"schema App:\n    name: str\n    $type?: \"web\" | \"worker\" = \"web\"\n    containers?: [Container]\n",
+		"    labels?: {str:str} = {\"team\": \"platform\"}\n    region?: str\n",
+		"params = App {**oxr.spec.parameters}",
+		"items = []",
+	} {
+		if !strings.Contains(source, expected) {
+			t.Errorf("Expected KCL source to contain %q, got:\n%s", expected, source)
+		}
+	}
+	if strings.Contains(source, "deletionPolicy") || strings.Contains(source, "ready") {
+		t.Errorf("Expected only parameter fields in KCL source, got:\n%s", source)
+	}
+
+	// Crossplane v2 has no mode and a default composition ref names the Composition
+	compositionYAML, _, err = GenerateCompositionWithSchemasAndOptions(app, schemas, XRDOptions{
+		Group:                 "example.org",
+		Version:               "v1",
+		CrossplaneVersion:     "v2",
+		DefaultCompositionRef: "app-default",
+	})
+	if err != nil {
+		t.Fatalf("GenerateCompositionWithSchemasAndOptions failed: %v", err)
+	}
+	composition = nil
+	if err := yaml.Unmarshal([]byte(compositionYAML), &composition); err != nil {
+		t.Fatalf("Generated Composition is not valid YAML: %v", err)
+	}
+	if name := composition["metadata"].(map[string]interface{})["name"]; name != "app-default" {
+		t.Errorf("Expected Composition name 'app-default', got %v", name)
+	}
+	if mode, ok := composition["spec"].(map[string]interface{})["mode"]; ok {
+		t.Errorf("Expected no mode for Crossplane v2, got %v", mode)
+	}
+}
+
+func TestGenerateExample(t *testing.T) {
+	minLength, maxLength, minimum := 3, 8, 2.0
+	schemas := map[string]*parser.Schema{
+		"Network": {
+			Name: "Network",
+			Fields: []parser.Field{
+				{Name: "cidr", Type: "str", Required: true, Pattern: `^\d+\.\d+\.\d+\.\d+/\d+$`},
+				{Name: "ipv6", Type: "bool"},
+			},
+		},
+	}
+	app := &parser.Schema{
+		Name: "App",
+		Fields: []parser.Field{
+			{Name: "name", Type: "str", Required: true, Pattern: "^[a-z][-a-z0-9]*$", MinLength: &minLength, MaxLength: &maxLength},
+			{Name: "size", Type: "str", Required: true, Enum: []string{"small", "large"}},
+			{Name: "replicas", Type: "int", Required: true, Minimum: &minimum},
+			{Name: "owner", Type: "str", Required: true, Format: "email"},
+			{Name: "region", Type: "str", Default: `"eu-west-1"`},
+			{Name: "network", Type: "Network", Required: true},
+			{Name: "tags", Type: "[str]"},
+		},
+	}
+	schemas["App"] = app
+
+	exampleYAML, err := GenerateExampleWithSchemasAndOptions(app, schemas, XRDOptions{
+		Group:      "example.org",
+		Version:    "v1alpha1",
+		WithClaims: true,
+	})
+	if err != nil {
+		t.Fatalf("GenerateExampleWithSchemasAndOptions failed: %v", err)
+	}
+
+	// Optional fields are commented out
+	for _, expected := range []string{
+		"kind: XApp\n",
+		"    # region: eu-west-1\n",
+		"    # tags:\n    #   - example\n",
+		"      # ipv6: true\n",
+		"---\napiVersion: example.org/v1alpha1\nkind: App\nmetadata:\n  name: app-example\n  namespace: default\n",
+	} {
+		if !strings.Contains(exampleYAML, expected) {
+			t.Errorf("Expected example to contain %q, got:\n%s", expected, exampleYAML)
+		}
+	}
+
+	// Required fields have values that satisfy their constraints
+	decoder := yaml.NewDecoder(strings.NewReader(exampleYAML))
+	var manifests []map[string]interface{}
+	for {
+		var manifest map[string]interface{}
+		if err := decoder.Decode(&manifest); err != nil {
+			break
+		}
+		manifests = append(manifests, manifest)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("Expected a composite resource and a claim, got %d manifests", len(manifests))
+	}
+	params := manifests[0]["spec"].(map[string]interface{})["parameters"].(map[string]interface{})
+	name, _ := params["name"].(string)
+	if !regexp.MustCompile("^[a-z][-a-z0-9]*$").MatchString(name) || len(name) < 3 || len(name) > 8 {
+		t.Errorf("Expected name matching the pattern with 3 to 8 characters, got %q", name)
+	}
+	if params["size"] != "small" {
+		t.Errorf("Expected the first enum value for size, got %v", params["size"])
+	}
+	if params["replicas"] != 2 {
+		t.Errorf("Expected the minimum for replicas, got %v", params["replicas"])
+	}
+	if params["owner"] != "user@example.com" {
+		t.Errorf("Expected an email for owner, got %v", params["owner"])
+	}
+	cidr, _ := params["network"].(map[string]interface{})["cidr"].(string)
+	if !regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+/\d+$`).MatchString(cidr) {
+		t.Errorf("Expected cidr matching its pattern, got %q", cidr)
+	}
+	for _, field := range []string{"region", "tags"} {
+		if _, ok := params[field]; ok {
+			t.Errorf("Expected optional field %s to be commented out", field)
+		}
+	}
+}
+
+func TestCELRuleTypeCheck(t *testing.T) {
+	schema := &parser.Schema{
+		Name: "XNetwork",
+		File: "network.k",
+		Fields: []parser.Field{
+			{
+				Name:     "name",
+				Type:     "str",
+				Required: true,
+				CELValidations: []parser.CELValidation{
+					{Rule: "size(self) <= 63", Line: 4},
+				},
+			},
+			{
+				Name: "subnets",
+				Type: "[Subnet]",
+				CELValidations: []parser.CELValidation{
+					{Rule: "self.all(s, s.cidr.startsWith('10.') && isCIDR(s.cidr))", Line: 7},
+					{Rule: "self.size() == oldSelf.size() || self.lenght > 0", Line: 8},
+				},
+			},
+			{
+				Name: "labels",
+				Type: "{str:str}",
+				CELValidations: []parser.CELValidation{
+					{Rule: "self.all(k, k.size() < 10)", Line: 11},
+					{Rule: "size(self)", Line: 12},
+				},
+			},
+		},
+	}
+	schemas := map[string]*parser.Schema{
+		"Subnet": {
+			Name: "Subnet",
+			Fields: []parser.Field{
+				{Name: "cidr", Type: "str", Required: true},
+			},
+		},
+	}
+
+	_, err := GenerateXRDWithSchemasAndOptions(schema, schemas, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err == nil {
+		t.Fatal("Expected an error for rules that do not type-check")
+	}
+	for _, expected := range []string{
+		`network.k:8: rule "self.size() == oldSelf.size() || self.lenght > 0" on spec.parameters.subnets:`,
+		`network.k:12: rule "size(self)" on spec.parameters.labels: must evaluate to a bool, not int`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain %q, got:\n%v", expected, err)
+		}
+	}
+	for _, valid := range []string{"network.k:4:", "network.k:7:", "network.k:11:"} {
+		if strings.Contains(err.Error(), valid) {
+			t.Errorf("Expected no error for the rule at %s, got:\n%v", valid, err)
+		}
+	}
+}
+
+func TestCheckValidationCosts(t *testing.T) {
+	newSchema := func(maxItems *int) *parser.Schema {
+		return &parser.Schema{
+			Name: "XCluster",
+			Fields: []parser.Field{
+				{
+					Name:     "ports",
+					Type:     "[int]",
+					MaxItems: maxItems,
+					CELValidations: []parser.CELValidation{
+						{Rule: "self.all(a, self.exists_one(b, a == b))", Line: 5},
+					},
+				},
+			},
+		}
+	}
+	opts := XRDOptions{Group: "example.org", Version: "v1alpha1"}
+
+	warnings, err := CheckValidationCosts([]XRDVersion{{Name: "v1alpha1", Schema: newSchema(nil)}}, opts)
+	if err != nil {
+		t.Fatalf("CheckValidationCosts failed: %v", err)
+	}
+	if len(warnings) == 0 || !strings.Contains(warnings[0].Message, "exceeding the Kubernetes per-rule budget") ||
+		!strings.Contains(warnings[0].Message, "set @maxItems or @maxLength on spec.parameters.ports") {
+		t.Errorf("Expected a cost warning naming the unbounded list, got %v", warnings)
+	}
+
+	maxItems := 10
+	warnings, err = CheckValidationCosts([]XRDVersion{{Name: "v1alpha1", Schema: newSchema(&maxItems)}}, opts)
+	if err != nil {
+		t.Fatalf("CheckValidationCosts failed: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Expected no warnings for a bounded list, got %v", warnings)
+	}
+}
+
+func TestGenerateXRDWithTransitionRules(t *testing.T) {
+	maxItems := 10
+	schema := &parser.Schema{
+		Name: "XDatabase",
+		Fields: []parser.Field{
+			{Name: "engine", Type: "str", Required: true, Immutable: true},
+			{Name: "region", Type: "str", Immutable: true},
+			{Name: "subnet", Type: "str", ImmutableOnceSet: true},
+			{Name: "users", Type: "[str]", MaxItems: &maxItems, AppendOnly: true},
+		},
+	}
+
+	xrdYAML, err := GenerateXRDWithSchemasAndOptions(schema, nil, XRDOptions{
+		Group:   "example.org",
+		Version: "v1alpha1",
+	})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+	if strings.Contains(xrdYAML, "x-kubernetes-immutable") {
+		t.Errorf("Expected immutability as CEL rules, not x-kubernetes-immutable:\n%s", xrdYAML)
+	}
+
+	var xrd map[string]interface{}
+	if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+		t.Fatalf("Generated XRD is not valid YAML: %v", err)
+	}
+	spec := xrd["spec"].(map[string]interface{})
+	version := spec["versions"].([]interface{})[0].(map[string]interface{})
+	openAPIV3Schema := version["schema"].(map[string]interface{})["openAPIV3Schema"].(map[string]interface{})
+	specProp := openAPIV3Schema["properties"].(map[string]interface{})["spec"].(map[string]interface{})
+	parameters := specProp["properties"].(map[string]interface{})["parameters"].(map[string]interface{})
+	properties := parameters["properties"].(map[string]interface{})
+
+	rules := func(schema map[string]interface{}) []string {
+		var result []string
+		validations, _ := schema["x-kubernetes-validations"].([]interface{})
+		for _, v := range validations {
+			rule := v.(map[string]interface{})
+			result = append(result, rule["rule"].(string)+": "+rule["message"].(string))
+		}
+		return result
+	}
+
+	fieldRules := map[string][]string{
+		"engine": {"self == oldSelf: engine is immutable"},
+		"region": {"self == oldSelf: region is immutable"},
+		"subnet": {"self == oldSelf: subnet cannot be changed once set"},
+		"users":  {"oldSelf.all(x, x in self): items cannot be removed from users"},
+	}
+	for name, expected := range fieldRules {
+		if got := rules(properties[name].(map[string]interface{})); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected rules %v on %s, got %v", expected, name, got)
+		}
+	}
+
+	// Optional fields need their parent to check whether they are added or
+	// removed, since the rules of a field only run while it is set
+	expected := []string{
+		"has(self.region) == has(oldSelf.region): region cannot be added or removed",
+		"!has(oldSelf.subnet) || has(self.subnet): subnet cannot be removed once set",
+		"!has(oldSelf.users) || has(self.users): users cannot be removed once set",
+	}
+	if got := rules(parameters); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected rules %v on parameters, got %v", expected, got)
+	}
+
+	schema.Fields = append(schema.Fields, parser.Field{Name: "owner", Type: "str", AppendOnly: true})
+	_, err = GenerateXRDWithSchemasAndOptions(schema, nil, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err == nil || !strings.Contains(err.Error(), "@appendOnly on owner requires a list") {
+		t.Errorf("Expected an error for @appendOnly on a string, got %v", err)
+	}
+}
+
+func TestGenerateXRDWithValidationArguments(t *testing.T) {
+	schema := &parser.Schema{
+		Name: "XStorage",
+		File: "storage.k",
+		Fields: []parser.Field{
+			{Name: "minSize", Type: "int", Required: true},
+			{Name: "maxSize", Type: "int", Required: true},
+			{Name: "labels", Type: "{str:str}"},
+		},
+		CELValidations: []parser.CELValidation{
+			{
+				Rule:              "self.minSize <= self.maxSize",
+				MessageExpression: "'minSize ' + string(self.minSize) + ' exceeds maxSize'",
+				Reason:            "FieldValueInvalid",
+				FieldPath:         ".minSize",
+				Line:              1,
+			},
+			{
+				Rule:            "!oldSelf.hasValue() || self.maxSize >= oldSelf.value().maxSize",
+				Message:         "maxSize cannot shrink",
+				OptionalOldSelf: true,
+				Line:            2,
+			},
+		},
+	}
+	opts := XRDOptions{Group: "example.org", Version: "v1alpha1"}
+
+	xrdYAML, err := GenerateXRDWithSchemasAndOptions(schema, nil, opts)
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+	for _, expected := range []string{
+		"- rule: self.minSize <= self.maxSize\n" +
+			"                      messageExpression: '''minSize '' + string(self.minSize) + '' exceeds maxSize'''\n" +
+			"                      reason: FieldValueInvalid\n" +
+			"                      fieldPath: .minSize\n",
+		"- rule: '!oldSelf.hasValue() || self.maxSize >= oldSelf.value().maxSize'\n" +
+			"                      message: maxSize cannot shrink\n" +
+			"                      optionalOldSelf: true\n",
+	} {
+		if !strings.Contains(xrdYAML, expected) {
+			t.Errorf("Expected XRD to contain:\n%s\ngot:\n%s", expected, xrdYAML)
+		}
+	}
+
+	schema.CELValidations = []parser.CELValidation{
+		{Rule: "self.minSize > 0", Reason: "FieldValueWrong", Line: 3},
+		{Rule: "self.minSize > 0", FieldPath: ".size", Line: 4},
+		{Rule: "self.minSize > 0", FieldPath: ".labels['app.kubernetes.io/name']", Line: 5},
+		{Rule: "self.minSize > 0", MessageExpression: "self.minSize", Line: 6},
+		{Rule: "self.minSize > 0", OptionalOldSelf: true, Line: 7},
+	}
+	_, err = GenerateXRDWithSchemasAndOptions(schema, nil, opts)
+	if err == nil {
+		t.Fatal("Expected an error for invalid rule arguments")
+	}
+	for _, expected := range []string{
+		`storage.k:3: rule "self.minSize > 0" on spec.parameters: reason "FieldValueWrong" must be one of`,
+		`storage.k:4: rule "self.minSize > 0" on spec.parameters: fieldPath ".size" names size, which is not a field of the object`,
+		`storage.k:6: rule "self.minSize > 0" on spec.parameters: messageExpression "self.minSize" must evaluate to a string, not int`,
+		`storage.k:7: rule "self.minSize > 0" on spec.parameters: optionalOldSelf may only be set on rules that use oldSelf`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain %q, got:\n%v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "storage.k:5:") {
+		t.Errorf("Expected no error for a field path into a map, got:\n%v", err)
+	}
+}
+
+func TestGenerateXRDWithOpenAPIKeywords(t *testing.T) {
+	minimum, maximum, multipleOf := -0.5, 10.0, 0.25
+	minProperties, maxProperties := 1, 4
+	enabled := true
+	tests := []struct {
+		field    parser.Field
+		expected PropertySchema
+	}{
+		{
+			field:    parser.Field{Name: "ratio", Type: "float", Minimum: &minimum, Maximum: &maximum, ExclusiveMaximum: true, MultipleOf: &multipleOf},
+			expected: PropertySchema{Type: "number", Minimum: &minimum, Maximum: &maximum, ExclusiveMaximum: true, MultipleOf: &multipleOf},
+		},
+		{
+			field:    parser.Field{Name: "labels", Type: "{str:str}", MinProperties: &minProperties, MaxProperties: &maxProperties},
+			expected: PropertySchema{Type: "object", AdditionalProperties: &PropertySchema{Type: "string"}, MinProperties: &minProperties, MaxProperties: &maxProperties},
+		},
+		{
+			field:    parser.Field{Name: "zones", Type: "[str]", UniqueItems: true},
+			expected: PropertySchema{Type: "array", Items: &PropertySchema{Type: "string"}, XKubernetesListType: "set"},
+		},
+		{
+			field:    parser.Field{Name: "note", Type: "str", Nullable: true},
+			expected: PropertySchema{Type: "string", Nullable: true},
+		},
+		{
+			field:    parser.Field{Name: "port", Type: "int", IntOrString: true, Default: "8080"},
+			expected: PropertySchema{XKubernetesIntOrString: &enabled, Default: 8080},
+		},
+		{
+			field:    parser.Field{Name: "template", Type: "{str:any}", EmbeddedResource: true},
+			expected: PropertySchema{Type: "object", XKubernetesPreserveUnknownFields: &enabled, XKubernetesEmbeddedResource: &enabled},
+		},
+	}
+	for _, tt := range tests {
+		if got := convertFieldToPropertySchema(tt.field); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Expected %s to convert to %+v, got %+v", tt.field.Name, tt.expected, got)
+		}
+	}
+
+	xrdYAML, err := GenerateXRDWithSchemasAndOptions(&parser.Schema{
+		Name:   "XQueue",
+		Fields: []parser.Field{tests[0].field},
+	}, nil, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+	expected := "minimum: -0.5\n                      maximum: 10\n                      exclusiveMaximum: true\n                      multipleOf: 0.25\n"
+	if !strings.Contains(xrdYAML, expected) {
+		t.Errorf("Expected XRD to contain:\n%s\ngot:\n%s", expected, xrdYAML)
+	}
+
+	for _, tt := range []struct {
+		field    parser.Field
+		expected string
+	}{
+		{parser.Field{Name: "name", Type: "str", MultipleOf: &multipleOf}, "@multipleOf on name requires a number, not str"},
+		{parser.Field{Name: "size", Type: "int", MaxProperties: &maxProperties}, "@maxProperties on size requires a map or schema, not int"},
+		{parser.Field{Name: "enabled", Type: "bool", IntOrString: true}, "@intOrString on enabled requires int, str or any, not bool"},
+		{parser.Field{Name: "env", Type: "{str:str}", EmbeddedResource: true}, "@embeddedResource on env requires a schema, {str:any} or any, not {str:str}"},
+		{parser.Field{Name: "owner", Type: "str", UniqueItems: true}, "@uniqueItems on owner requires a list, not str"},
+		{parser.Field{Name: "rules", Type: "[{any:any}]", UniqueItems: true}, "@uniqueItems on rules requires scalar items"},
+		{parser.Field{Name: "hosts", Type: "[str]", UniqueItems: true, ListType: "atomic"}, `@uniqueItems on hosts conflicts with @listType("atomic")`},
+	} {
+		_, err := GenerateXRDWithSchemasAndOptions(&parser.Schema{
+			Name:   "XQueue",
+			Fields: []parser.Field{tt.field},
+		}, nil, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected error %q, got %v", tt.expected, err)
+		}
+	}
+}
+
+func TestGenerateXRDWithStructuredDefaults(t *testing.T) {
+	schemas := map[string]*parser.Schema{
+		"Config": {
+			Name: "Config",
+			Fields: []parser.Field{
+				{Name: "replicas", Type: "int", Required: true, Default: "2", DefaultValue: 2},
+				{Name: "ratio", Type: "float", Default: "1", DefaultValue: 1},
+			},
+		},
+	}
+	schema := &parser.Schema{
+		Name: "XApp",
+		Fields: []parser.Field{
+			{Name: "tags", Type: "[str]", Default: `["a", "b"]`, DefaultValue: []interface{}{"a", "b"}},
+			{Name: "labels", Type: "{str:str}", Default: `{team = "x"}`, DefaultValue: map[string]interface{}{"team": "x"}},
+			{Name: "config", Type: "Config", Default: "Config {}", DefaultValue: map[string]interface{}{"replicas": 2, "ratio": 1}},
+		},
+	}
+
+	xrdYAML, err := GenerateXRDWithSchemasAndOptions(schema, schemas, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+	var xrd map[string]interface{}
+	if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+		t.Fatalf("Failed to parse XRD: %v", err)
+	}
+	spec := xrd["spec"].(map[string]interface{})
+	versions := spec["versions"].([]interface{})
+	version := versions[0].(map[string]interface{})
+	versionSchema := version["schema"].(map[string]interface{})
+	openAPISchema := versionSchema["openAPIV3Schema"].(map[string]interface{})
+	properties := openAPISchema["properties"].(map[string]interface{})
+	specProps := properties["spec"].(map[string]interface{})["properties"].(map[string]interface{})
+	paramProps := specProps["parameters"].(map[string]interface{})["properties"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"tags":   []interface{}{"a", "b"},
+		"labels": map[string]interface{}{"team": "x"},
+		"config": map[string]interface{}{"replicas": 2, "ratio": 1},
+	}
+	for name, value := range expected {
+		got := paramProps[name].(map[string]interface{})["default"]
+		if !reflect.DeepEqual(got, value) {
+			t.Errorf("Expected default of %s to be %#v, got %#v", name, value, got)
+		}
+	}
+
+	pattern := "^[a-z]+$"
+	minItems := 3
+	for _, tt := range []struct {
+		field    parser.Field
+		expected string
+	}{
+		{parser.Field{Name: "size", Type: "str", Default: `"medium"`, DefaultValue: "medium", Enum: []string{"small", "large"}}, `spec.parameters.size: default "medium": Unsupported value: "medium": supported values: "small", "large"`},
+		{parser.Field{Name: "owner", Type: "str", Default: `"Team"`, DefaultValue: "Team", Pattern: pattern}, `spec.parameters.owner: default "Team": Invalid value: "Team": should match '^[a-z]+$'`},
+		{parser.Field{Name: "contact", Type: "str", Default: `"ops"`, DefaultValue: "ops", Format: "email"}, `spec.parameters.contact: default "ops": Invalid value: "ops": must be a valid email`},
+		{parser.Field{Name: "zones", Type: "[str]", Default: `["a"]`, DefaultValue: []interface{}{"a"}, MinItems: &minItems}, `spec.parameters.zones: default ["a"]: Invalid value: 1: should have at least 3 items`},
+		{parser.Field{Name: "config", Type: "Config", Default: "Config {}", DefaultValue: map[string]interface{}{"replicas": 1, "ratio": "x"}}, `spec.parameters.config: default {"ratio":"x","replicas":1} at spec.parameters.config.ratio: Invalid value: "x": must be of type number`},
+		{parser.Field{Name: "config", Type: "Config", Default: "Config {}", DefaultValue: map[string]interface{}{"replicas": 1, "size": 1}}, `spec.parameters.config: default {"replicas":1,"size":1} at spec.parameters.config.size: Unknown field`},
+	} {
+		_, err := GenerateXRDWithSchemasAndOptions(&parser.Schema{
+			Name:   "XApp",
+			Fields: []parser.Field{tt.field},
+		}, schemas, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected error %q, got %v", tt.expected, err)
+		}
+	}
+}
+
+func TestGenerateXRDWithUpstreamDefinitions(t *testing.T) {
+	dir := t.TempDir()
+	swagger := `{
+  "definitions": {
+    "io.k8s.api.core.v1.Toleration": {
+      "description": "The pod this Toleration is attached to tolerates any taint that matches the triple.",
+      "type": "object",
+      "properties": {
+        "key": {"type": "string"},
+        "tolerationSeconds": {"type": "integer", "format": "int64"}
+      }
+    },
+    "io.k8s.api.core.v1.ResourceRequirements": {
+      "type": "object",
+      "properties": {
+        "limits": {"type": "object", "additionalProperties": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.api.resource.Quantity"}}
+      }
+    },
+    "io.k8s.apimachinery.pkg.api.resource.Quantity": {
+      "description": "Quantity is a fixed-point representation of a number.",
+      "type": "string"
+    }
+  }
+}`
+	crd := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+spec:
+  group: pkg.crossplane.io
+  names:
+    kind: Provider
+  versions:
+    - name: v1
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            package:
+              type: string
+`
+	if err := os.WriteFile(filepath.Join(dir, "swagger.json"), []byte(swagger), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "provider.yaml"), []byte(crd), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := LoadDefinitions([]string{dir})
+	if err != nil {
+		t.Fatalf("LoadDefinitions failed: %v", err)
+	}
+
+	schema := &parser.Schema{
+		Name: "XApp",
+		Fields: []parser.Field{
+			{Name: "tolerations", Type: "[k8s.api.core.v1.Toleration]", Description: "Tolerations of the pods"},
+			{Name: "resources", Type: "k8s.api.core.v1.ResourceRequirements"},
+			{Name: "provider", Type: "crossplane.pkg.v1.Provider"},
+		},
+	}
+	xrdYAML, err := GenerateXRDWithSchemasAndOptions(schema, nil, XRDOptions{Group: "example.org", Version: "v1alpha1", Definitions: defs})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+	var xrd map[string]interface{}
+	if err := yaml.Unmarshal([]byte(xrdYAML), &xrd); err != nil {
+		t.Fatalf("Failed to parse XRD: %v", err)
+	}
+	spec := xrd["spec"].(map[string]interface{})
+	versions := spec["versions"].([]interface{})
+	version := versions[0].(map[string]interface{})
+	versionSchema := version["schema"].(map[string]interface{})
+	openAPISchema := versionSchema["openAPIV3Schema"].(map[string]interface{})
+	properties := openAPISchema["properties"].(map[string]interface{})
+	specProps := properties["spec"].(map[string]interface{})["properties"].(map[string]interface{})
+	paramProps := specProps["parameters"].(map[string]interface{})["properties"].(map[string]interface{})
+
+	tolerations := paramProps["tolerations"].(map[string]interface{})
+	if tolerations["type"] != "array" || tolerations["description"] != "Tolerations of the pods" {
+		t.Errorf("Expected tolerations to be a described array, got %v", tolerations)
+	}
+	toleration := tolerations["items"].(map[string]interface{})
+	if _, ok := toleration["properties"].(map[string]interface{})["tolerationSeconds"]; !ok {
+		t.Errorf("Expected the Toleration definition as items, got %v", toleration)
+	}
+
+	limits := paramProps["resources"].(map[string]interface{})["properties"].(map[string]interface{})["limits"].(map[string]interface{})
+	quantity := limits["additionalProperties"].(map[string]interface{})
+	if quantity["x-kubernetes-int-or-string"] != true || quantity["type"] != nil {
+		t.Errorf("Expected quantities to be int-or-string, got %v", quantity)
+	}
+
+	provider := paramProps["provider"].(map[string]interface{})
+	if _, ok := provider["properties"].(map[string]interface{})["package"]; !ok {
+		t.Errorf("Expected the Provider CRD schema, got %v", provider)
+	}
+}
+
+func TestGenerateXRDWithCustomAnnotations(t *testing.T) {
+	err := RegisterAnnotation("costCenter", func(schema *PropertySchema, field parser.Field, annotation parser.Annotation) error {
+		center := strings.Trim(annotation.Args, `"`)
+		if center == "" {
+			return errors.New("requires a cost center")
+		}
+		schema.Extensions["x-example-org-cost-center"] = center
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterAnnotation failed: %v", err)
+	}
+	if !parser.IsRegisteredAnnotation("costCenter") {
+		t.Error("Expected @costCenter to be registered with the parser")
+	}
+	if err := RegisterAnnotation("costCenter", func(*PropertySchema, parser.Field, parser.Annotation) error { return nil }); err == nil {
+		t.Error("Expected registering @costCenter twice to fail")
+	}
+
+	schema := &parser.Schema{
+		Name: "XApp",
+		Fields: []parser.Field{
+			{Name: "name", Type: "str", Annotations: []parser.Annotation{{Name: "costCenter", Args: `"cc-1"`}}},
+		},
+	}
+	xrdYAML, err := GenerateXRDWithSchemasAndOptions(schema, nil, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+	if !strings.Contains(xrdYAML, "x-example-org-cost-center: cc-1") {
+		t.Errorf("Expected the cost center extension, got:\n%s", xrdYAML)
+	}
+
+	schema.Fields[0].Annotations[0].Args = ""
+	_, err = GenerateXRDWithSchemasAndOptions(schema, nil, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err == nil || err.Error() != "@costCenter on name: requires a cost center" {
+		t.Errorf("Expected the handler's error, got %v", err)
+	}
+}
+
+func TestGenerateXRDWithInvalidExtensions(t *testing.T) {
+	// @extension sets the extension named by its argument
+	err := RegisterAnnotation("extension", func(schema *PropertySchema, field parser.Field, annotation parser.Annotation) error {
+		schema.Extensions[strings.Trim(annotation.Args, `"`)] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterAnnotation failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		key      string
+		expected string
+	}{
+		{"x-example-org-audited", ""},
+		{"audited", "@extension on name: extension audited must start with x-"},
+		{"type", "@extension on name: extension type must start with x-"},
+		{"x-kubernetes-immutable", "@extension on name: extension x-kubernetes-immutable is a schema keyword kcl2xrd sets itself"},
+		{"x-kubernetes-validations", "@extension on name: extension x-kubernetes-validations is a schema keyword kcl2xrd sets itself"},
+	} {
+		schema := &parser.Schema{
+			Name: "XApp",
+			Fields: []parser.Field{
+				{Name: "name", Type: "str", Immutable: true, Annotations: []parser.Annotation{{Name: "extension", Args: `"` + tt.key + `"`}}},
+			},
+		}
+		_, err := GenerateXRDWithSchemasAndOptions(schema, nil, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+		switch {
+		case tt.expected == "" && err != nil:
+			t.Errorf("%s: expected the extension to be accepted, got %v", tt.key, err)
+		case tt.expected != "" && (err == nil || err.Error() != tt.expected):
+			t.Errorf("%s: expected %q, got %v", tt.key, tt.expected, err)
+		}
+	}
+}
+
+func TestGenerateExampleWithUnions(t *testing.T) {
+	schema := &parser.Schema{
+		Name: "App",
+		Fields: []parser.Field{
+			{Name: "mode", Type: `bool | "auto"`, Required: true},
+			{Name: "config", Type: "{str:str}", Required: true, OneOf: [][]string{{"groupName"}, {"groupRef"}}, AnyOf: [][]string{{"userEmail"}, {"userObjectId"}}},
+		},
+	}
+
+	exampleYAML, err := GenerateExampleWithSchemasAndOptions(schema, nil, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err != nil {
+		t.Fatalf("GenerateExampleWithSchemasAndOptions failed: %v", err)
+	}
+
+	// Unions take their first branch; maps get the keys of the first
+	// oneOf/anyOf combinations
+	for _, expected := range []string{
+		"    mode: true\n",
+		"    config:\n      groupName: example\n      userEmail: example\n",
+	} {
+		if !strings.Contains(exampleYAML, expected) {
+			t.Errorf("Expected example to contain %q, got:\n%s", expected, exampleYAML)
+		}
+	}
+}
+
+func TestCELRuleEscapedFieldNames(t *testing.T) {
+	schema := &parser.Schema{
+		Name: "XApp",
+		File: "app.k",
+		Fields: []parser.Field{
+			{Name: "my-field", Type: "str", Required: true},
+			{Name: "namespace", Type: "str", Required: true},
+			{Name: "a.b", Type: "[str]"},
+		},
+		CELValidations: []parser.CELValidation{
+			{Rule: "self.my__dash__field != ''", Line: 2},
+			{Rule: "self.__namespace__ != self.my__dash__field", Line: 3},
+			{Rule: "!has(self.a__dot__b) || self.a__dot__b.size() < 3", Line: 4},
+			{Rule: "self.namespace != ''", Line: 5},
+			{Rule: "self.my-field != ''", Line: 6},
+		},
+	}
+
+	_, err := GenerateXRDWithSchemasAndOptions(schema, nil, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err == nil {
+		t.Fatal("Expected an error for rules selecting unescaped names")
+	}
+	for _, expected := range []string{`app.k:5: rule "self.namespace != ''"`, `app.k:6: rule "self.my-field != ''"`} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error to contain %q, got:\n%v", expected, err)
+		}
+	}
+	for _, valid := range []string{"app.k:2:", "app.k:3:", "app.k:4:"} {
+		if strings.Contains(err.Error(), valid) {
+			t.Errorf("Expected no error for the rule at %s, got:\n%v", valid, err)
+		}
+	}
+}
+
+func TestTransitionRulesEscapeFieldNames(t *testing.T) {
+	schema := &parser.Schema{
+		Name: "XDatabase",
+		Fields: []parser.Field{
+			{Name: "db-name", Type: "str", Immutable: true},
+			{Name: "namespace", Type: "str", ImmutableOnceSet: true},
+		},
+	}
+
+	xrdYAML, err := GenerateXRDWithSchemasAndOptions(schema, nil, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+	for _, expected := range []string{
+		"rule: has(self.db__dash__name) == has(oldSelf.db__dash__name)",
+		"rule: '!has(oldSelf.__namespace__) || has(self.__namespace__)'",
+	} {
+		if !strings.Contains(xrdYAML, expected) {
+			t.Errorf("Expected XRD to contain %q, got:\n%s", expected, xrdYAML)
+		}
+	}
+}
+
+func TestTransitionRulesInListItems(t *testing.T) {
+	schemas := map[string]*parser.Schema{
+		"Member": {
+			Name: "Member",
+			Fields: []parser.Field{
+				{Name: "id", Type: "str", Required: true},
+				{Name: "role", Type: "str", Immutable: true},
+			},
+		},
+	}
+	members := parser.Field{Name: "members", Type: "[Member]"}
+	schema := &parser.Schema{Name: "XTeam", Fields: []parser.Field{members}}
+
+	// Items of other lists cannot be matched with their previous revision
+	_, err := GenerateXRDWithSchemasAndOptions(schema, schemas, XRDOptions{Group: "example.org", Version: "v1alpha1"})
+	if err == nil || !strings.Contains(err.Error(), "oldSelf cannot be used in the items of spec.parameters.members") {
+		t.Errorf("Expected an error for @immutable in the items of an atomic list, got %v", err)
+	}
+
+	members.ListType, members.ListMapKeys = "map", []string{"id"}
+	schema.Fields = []parser.Field{members}
+	if _, err := GenerateXRDWithSchemasAndOptions(schema, schemas, XRDOptions{Group: "example.org", Version: "v1alpha1"}); err != nil {
+		t.Errorf("Expected @immutable in the items of a map list to be allowed, got %v", err)
+	}
 }
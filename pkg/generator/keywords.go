@@ -0,0 +1,82 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+)
+
+// applyKubernetesTypes renders @intOrString and @embeddedResource, which
+// change the type of a field's schema
+func applyKubernetesTypes(field parser.Field, schema *PropertySchema) {
+	enabled := true
+	if field.IntOrString && schema.XKubernetesIntOrString == nil {
+		switch field.Type {
+		case "int", "str", "any":
+			*schema = PropertySchema{XKubernetesIntOrString: &enabled}
+		}
+	}
+
+	if !field.EmbeddedResource {
+		return
+	}
+	preserve := func() {
+		schema.XKubernetesPreserveUnknownFields = &enabled
+	}
+	switch {
+	case schema.Type == "" && schema.XKubernetesIntOrString == nil && len(schema.AnyOf) == 0:
+		// any: a resource of any kind
+		schema.Type = "object"
+		preserve()
+	case schema.Type == "object" && isAnyMap(schema):
+		// {str:any}: the fields of the resource are not known
+		schema.AdditionalProperties = nil
+		preserve()
+	}
+	if schema.Type == "object" && schema.AdditionalProperties == nil {
+		if len(schema.Properties) == 0 {
+			preserve()
+		}
+		schema.XKubernetesEmbeddedResource = &enabled
+	}
+}
+
+// isAnyMap reports whether a schema is a map of values of any type
+func isAnyMap(schema *PropertySchema) bool {
+	values, ok := schema.AdditionalProperties.(*PropertySchema)
+	return ok && values.Type == "" && values.XKubernetesIntOrString == nil && len(values.AnyOf) == 0
+}
+
+// checkKeywords returns an error if an annotation of a field does not apply
+// to the type of its schema
+func checkKeywords(field parser.Field, prop PropertySchema) error {
+	isNumber := prop.Type == "integer" || prop.Type == "number" || prop.XKubernetesIntOrString != nil
+	switch {
+	case field.ExclusiveMinimum && !isNumber:
+		return fmt.Errorf("@exclusiveMinimum on %s requires a number, not %s", field.Name, field.Type)
+	case field.ExclusiveMaximum && !isNumber:
+		return fmt.Errorf("@exclusiveMaximum on %s requires a number, not %s", field.Name, field.Type)
+	case field.MultipleOf != nil && !isNumber:
+		return fmt.Errorf("@multipleOf on %s requires a number, not %s", field.Name, field.Type)
+	case field.MinProperties != nil && prop.Type != "object":
+		return fmt.Errorf("@minProperties on %s requires a map or schema, not %s", field.Name, field.Type)
+	case field.MaxProperties != nil && prop.Type != "object":
+		return fmt.Errorf("@maxProperties on %s requires a map or schema, not %s", field.Name, field.Type)
+	case field.IntOrString && prop.XKubernetesIntOrString == nil:
+		return fmt.Errorf("@intOrString on %s requires int, str or any, not %s", field.Name, field.Type)
+	case field.EmbeddedResource && prop.XKubernetesEmbeddedResource == nil:
+		return fmt.Errorf("@embeddedResource on %s requires a schema, {str:any} or any, not %s", field.Name, field.Type)
+	}
+
+	if field.UniqueItems {
+		switch {
+		case prop.Type != "array":
+			return fmt.Errorf("@uniqueItems on %s requires a list, not %s", field.Name, field.Type)
+		case prop.XKubernetesListType != "set":
+			return fmt.Errorf("@uniqueItems on %s conflicts with @listType(%q)", field.Name, prop.XKubernetesListType)
+		case prop.Items != nil && prop.Items.Type == "object" && prop.Items.XKubernetesMapType != "atomic":
+			return fmt.Errorf("@uniqueItems on %s requires scalar items; use @listType(\"map\") with @listMapKeys for objects", field.Name)
+		}
+	}
+	return nil
+}
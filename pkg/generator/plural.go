@@ -0,0 +1,107 @@
+package generator
+
+import (
+	"strings"
+	"unicode"
+)
+
+// irregularPlurals maps lowercase singular words to their plural
+var irregularPlurals = map[string]string{
+	"child":     "children",
+	"person":    "people",
+	"man":       "men",
+	"woman":     "women",
+	"foot":      "feet",
+	"tooth":     "teeth",
+	"goose":     "geese",
+	"mouse":     "mice",
+	"ox":        "oxen",
+	"index":     "indices",
+	"matrix":    "matrices",
+	"vertex":    "vertices",
+	"analysis":  "analyses",
+	"basis":     "bases",
+	"crisis":    "crises",
+	"axis":      "axes",
+	"datum":     "data",
+	"medium":    "media",
+	"criterion": "criteria",
+	"leaf":      "leaves",
+	"knife":     "knives",
+	"life":      "lives",
+	"wife":      "wives",
+	"half":      "halves",
+	"shelf":     "shelves",
+	"wolf":      "wolves",
+	"potato":    "potatoes",
+	"tomato":    "tomatoes",
+	"hero":      "heroes",
+	"echo":      "echoes",
+	"veto":      "vetoes",
+	"quiz":      "quizzes",
+}
+
+// uncountableWords are lowercase words whose plural equals the singular
+var uncountableWords = map[string]bool{
+	"data":      true,
+	"metadata":  true,
+	"info":      true,
+	"series":    true,
+	"species":   true,
+	"news":      true,
+	"equipment": true,
+	"sheep":     true,
+	"fish":      true,
+	"deer":      true,
+	"moose":     true,
+	"aircraft":  true,
+}
+
+// Pluralize returns the lowercase plural of a Kubernetes kind, e.g.
+// Policy -> policies, Address -> addresses, Key -> keys. Only the last word
+// of a CamelCase kind is pluralized, so irregular words match whole words:
+// ClusterPerson -> clusterpeople but Human -> humans.
+func Pluralize(kind string) string {
+	if kind == "" {
+		return ""
+	}
+	runes := []rune(kind)
+	start := 0
+	for i := len(runes) - 1; i > 0; i-- {
+		// A word starts at an upper case letter after a lower case letter, or
+		// at the last upper case letter of an acronym followed by lower case
+		if unicode.IsUpper(runes[i]) && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			start = i
+			break
+		}
+	}
+	prefix := strings.ToLower(string(runes[:start]))
+	return prefix + pluralizeWord(strings.ToLower(string(runes[start:])))
+}
+
+// pluralizeWord pluralizes a single lowercase word
+func pluralizeWord(word string) string {
+	if uncountableWords[word] {
+		return word
+	}
+	if plural, ok := irregularPlurals[word]; ok {
+		return plural
+	}
+
+	switch {
+	case strings.HasSuffix(word, "s"), strings.HasSuffix(word, "x"), strings.HasSuffix(word, "z"),
+		strings.HasSuffix(word, "ch"), strings.HasSuffix(word, "sh"):
+		// Sibilant endings: address -> addresses, box -> boxes, patch -> patches
+		return word + "es"
+	case strings.HasSuffix(word, "y") && len(word) > 1 && !isVowel(word[len(word)-2]):
+		// Consonant + y: policy -> policies, but key -> keys
+		return word[:len(word)-1] + "ies"
+	}
+	return word + "s"
+}
+
+// isVowel reports whether a lowercase ASCII letter is a vowel
+func isVowel(c byte) bool {
+	return strings.IndexByte("aeiou", c) >= 0
+}
@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+)
+
+// schemaResolver expands references to other schemas while fields are
+// converted. It tracks the chain of schemas being expanded so that recursive
+// references are reported, or cut at maxDepth, instead of expanding forever.
+type schemaResolver struct {
+	schemas     map[string]*parser.Schema
+	definitions *Definitions // upstream schemas replacing the expansion of generated KCL schemas
+	maxDepth    int          // expansions of one schema within itself; 0 means recursion is an error
+	chain       []string     // names of the schemas being expanded, outermost first
+	err         error        // first recursive reference found
+}
+
+// newSchemaResolver returns a resolver for the given schemas
+func newSchemaResolver(schemas map[string]*parser.Schema, opts XRDOptions) *schemaResolver {
+	return &schemaResolver{schemas: schemas, definitions: opts.Definitions, maxDepth: opts.MaxRecursionDepth}
+}
+
+// enter starts expanding the named schema. It returns false if the schema is
+// already being expanded maxDepth times; without a maximum depth the
+// reference chain is recorded as an error.
+func (r *schemaResolver) enter(name string) bool {
+	first, depth := -1, 0
+	for i, n := range r.chain {
+		if n == name {
+			if first < 0 {
+				first = i
+			}
+			depth++
+		}
+	}
+	if depth > 0 && r.maxDepth <= 0 {
+		if r.err == nil {
+			cycle := append(append([]string{}, r.chain[first:]...), name)
+			r.err = fmt.Errorf("recursive schema reference: %s (set a maximum recursion depth to cut the cycle)", strings.Join(cycle, " -> "))
+		}
+		return false
+	}
+	if depth > 0 && depth >= r.maxDepth {
+		return false
+	}
+	r.chain = append(r.chain, name)
+	return true
+}
+
+// leave ends the expansion of the innermost schema
+func (r *schemaResolver) leave() {
+	r.chain = r.chain[:len(r.chain)-1]
+}
+
+// cutSchema returns the schema of a recursive reference that is not expanded:
+// an object that keeps whatever fields it is given
+func (r *schemaResolver) cutSchema(field parser.Field) PropertySchema {
+	preserve := true
+	schema := PropertySchema{
+		Type:                             "object",
+		Description:                      field.Description,
+		XKubernetesPreserveUnknownFields: &preserve,
+	}
+	applyFieldValidationsAndDefaults(field, &schema)
+	return schema
+}
@@ -0,0 +1,92 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+)
+
+// Transition rules compare an object with its previous revision on update.
+// The API server only evaluates a rule on a field while the field is set both
+// before and after the update, so whether an optional field may be added or
+// removed is checked by a rule on its parent object with has().
+
+// transitionRules returns the rules of the @immutable, @immutableOnceSet and
+// @appendOnly annotations of a field, which are added to its own schema
+func transitionRules(field parser.Field) []K8sValidation {
+	var rules []K8sValidation
+	if field.Immutable {
+		rules = append(rules, K8sValidation{
+			Rule:    "self == oldSelf",
+			Message: fmt.Sprintf("%s is immutable", field.Name),
+			Line:    field.Line,
+		})
+	}
+	if field.ImmutableOnceSet && !field.Immutable {
+		rules = append(rules, K8sValidation{
+			Rule:    "self == oldSelf",
+			Message: fmt.Sprintf("%s cannot be changed once set", field.Name),
+			Line:    field.Line,
+		})
+	}
+	if field.AppendOnly {
+		rules = append(rules, K8sValidation{
+			Rule:    "oldSelf.all(x, x in self)",
+			Message: fmt.Sprintf("items cannot be removed from %s", field.Name),
+			Line:    field.Line,
+		})
+	}
+	return rules
+}
+
+// presenceRules returns the rules a parent object needs for the transition
+// annotations of one of its fields. An @immutable field can neither be added
+// nor removed after creation; an @immutableOnceSet or @appendOnly field can
+// be added later, e.g. to objects created before the field existed, but not
+// removed. Required fields need no rule. The field is selected by its name
+// escaped like the API server does, e.g. my__dash__field for my-field.
+func presenceRules(field parser.Field) ([]K8sValidation, error) {
+	if field.Required || !(field.Immutable || field.ImmutableOnceSet || field.AppendOnly) {
+		return nil, nil
+	}
+	name, ok := EscapeCELName(field.Name)
+	if !ok {
+		return nil, fmt.Errorf("transition annotations on %s: the field name cannot be selected in a CEL rule", field.Name)
+	}
+	if field.Immutable {
+		return []K8sValidation{{
+			Rule:    fmt.Sprintf("has(self.%s) == has(oldSelf.%s)", name, name),
+			Message: fmt.Sprintf("%s cannot be added or removed", field.Name),
+			Line:    field.Line,
+		}}, nil
+	}
+	return []K8sValidation{{
+		Rule:    fmt.Sprintf("!has(oldSelf.%s) || has(self.%s)", name, name),
+		Message: fmt.Sprintf("%s cannot be removed once set", field.Name),
+		Line:    field.Line,
+	}}, nil
+}
+
+// addField converts a field and adds it to the properties of an object,
+// with the rules the object needs for the field's transition annotations
+func (r *schemaResolver) addField(parent *PropertySchema, field parser.Field) {
+	prop := r.convertField(field)
+	if err := applyAnnotationHandlers(&prop, field); err != nil && r.err == nil {
+		r.err = err
+	}
+	if field.AppendOnly && prop.Type != "array" && r.err == nil {
+		r.err = fmt.Errorf("@appendOnly on %s requires a list, not %s", field.Name, field.Type)
+	}
+	if err := checkKeywords(field, prop); err != nil && r.err == nil {
+		r.err = err
+	}
+	parent.Properties[field.Name] = prop
+	if field.Required {
+		parent.Required = append(parent.Required, field.Name)
+	}
+	rules, err := presenceRules(field)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	parent.XKubernetesValidations = append(parent.XKubernetesValidations, rules...)
+}
@@ -0,0 +1,284 @@
+package generator
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+)
+
+// splitUnionType splits a type expression at its top-level union bars, e.g.
+// `"a" | [int | str]` into `"a"` and `[int | str]`
+func splitUnionType(typ string) []string {
+	var members []string
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(typ); i++ {
+		c := typ[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{' || c == '(':
+			depth++
+		case c == ']' || c == '}' || c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			members = append(members, strings.TrimSpace(typ[start:i]))
+			start = i + 1
+		}
+	}
+	return append(members, strings.TrimSpace(typ[start:]))
+}
+
+// isUnionType reports whether a type is a union or a literal type
+func isUnionType(typ string) bool {
+	if len(splitUnionType(typ)) > 1 {
+		return true
+	}
+	_, _, ok := literalType(typ)
+	return ok
+}
+
+// literalType returns the value and OpenAPI type of a literal type such as
+// `"small"`, `1` or `True`
+func literalType(typ string) (interface{}, string, bool) {
+	if len(typ) >= 2 && (typ[0] == '"' || typ[0] == '\'') && typ[len(typ)-1] == typ[0] {
+		return typ[1 : len(typ)-1], "string", true
+	}
+	switch typ {
+	case "True":
+		return true, "boolean", true
+	case "False":
+		return false, "boolean", true
+	}
+	if v, err := strconv.Atoi(typ); err == nil {
+		return v, "integer", true
+	}
+	if v, err := strconv.ParseFloat(typ, 64); err == nil {
+		return v, "number", true
+	}
+	return nil, "", false
+}
+
+// convertUnionType converts a union or literal type:
+//   - literals of one kind become a typed enum: `"small" | "large"`
+//   - integers and strings become x-kubernetes-int-or-string: `int | str`
+//   - integers and floats become a number: `int | float`
+//   - other unions become a structural anyOf: the common type (or
+//     x-kubernetes-preserve-unknown-fields if the members differ in type) is
+//     declared on the property itself and each anyOf branch only holds the
+//     value validations of one member, e.g. `bool | "auto"`
+func (r *schemaResolver) convertUnionType(typ string) PropertySchema {
+	var members []string
+	for _, member := range splitUnionType(typ) {
+		// None only makes the field optional
+		if member != "None" {
+			members = append(members, member)
+		}
+	}
+	if len(members) == 1 {
+		if _, _, ok := literalType(members[0]); !ok {
+			return r.convertField(parser.Field{Type: members[0]})
+		}
+	}
+
+	// Literal unions of one kind
+	var values []interface{}
+	literalKind := ""
+	for _, member := range members {
+		value, kind, ok := literalType(member)
+		if !ok || (literalKind != "" && kind != literalKind) {
+			values = nil
+			break
+		}
+		values = append(values, value)
+		literalKind = kind
+	}
+	if values != nil {
+		return PropertySchema{Type: literalKind, Enum: values}
+	}
+
+	// Integers and strings, including literals of either
+	hasInt, hasString, intOrString := false, false, true
+	for _, member := range members {
+		kind := member
+		if _, literalKind, ok := literalType(member); ok {
+			kind = literalKind
+		}
+		switch kind {
+		case "int", "integer":
+			hasInt = true
+		case "str", "string":
+			hasString = true
+		default:
+			intOrString = false
+		}
+	}
+	if intOrString && hasInt && hasString {
+		enabled := true
+		return PropertySchema{XKubernetesIntOrString: &enabled}
+	}
+
+	// Integers and floats: a number accepts both
+	numeric := true
+	for _, member := range members {
+		if member != "int" && member != "float" {
+			numeric = false
+		}
+	}
+	if numeric {
+		return PropertySchema{Type: "number"}
+	}
+
+	// Structural anyOf: member literals of one kind share a branch
+	var branches []PropertySchema
+	literalBranches := make(map[string]int)
+	for _, member := range members {
+		if value, kind, ok := literalType(member); ok {
+			if i, seen := literalBranches[kind]; seen {
+				branches[i].Enum = append(branches[i].Enum, value)
+				continue
+			}
+			literalBranches[kind] = len(branches)
+			branches = append(branches, PropertySchema{Type: kind, Enum: []interface{}{value}})
+			continue
+		}
+		branches = append(branches, r.convertField(parser.Field{Type: member}))
+	}
+
+	schema := commonSchema(branches)
+	for _, branch := range branches {
+		validations := valueValidations(branch)
+		if schema.Type == "" {
+			// Fields and items of members are only allowed in a branch if the
+			// property declares them too
+			validations.Properties, validations.Items = nil, nil
+		}
+		if reflect.DeepEqual(validations, PropertySchema{}) {
+			// A branch without value validations accepts anything the
+			// property's own schema accepts, so the anyOf would be void
+			return schema
+		}
+		schema.AnyOf = append(schema.AnyOf, validations)
+	}
+	return schema
+}
+
+// commonSchema returns the structural part the union members share: their
+// type and, for objects and arrays, their properties or items. Members of
+// different types are only described by x-kubernetes-preserve-unknown-fields.
+func commonSchema(members []PropertySchema) PropertySchema {
+	preserve := true
+	untyped := PropertySchema{XKubernetesPreserveUnknownFields: &preserve}
+
+	typ := members[0].Type
+	for _, m := range members {
+		if m.Type == "" || m.Type != typ {
+			return untyped
+		}
+	}
+
+	schema := PropertySchema{Type: typ}
+	switch typ {
+	case "object":
+		for _, m := range members {
+			if m.AdditionalProperties != nil || m.XKubernetesPreserveUnknownFields != nil {
+				schema.XKubernetesPreserveUnknownFields = &preserve
+				schema.Properties = nil
+				return schema
+			}
+			for name, prop := range m.Properties {
+				if schema.Properties == nil {
+					schema.Properties = make(map[string]PropertySchema)
+				}
+				if _, ok := schema.Properties[name]; !ok {
+					schema.Properties[name] = prop
+				}
+			}
+		}
+	case "array":
+		for _, m := range members[1:] {
+			if !reflect.DeepEqual(m.Items, members[0].Items) {
+				schema.Items = &PropertySchema{XKubernetesPreserveUnknownFields: &preserve}
+				return schema
+			}
+		}
+		schema.Items = members[0].Items
+	}
+	return schema
+}
+
+// valueValidations strips a schema down to the value validations a
+// structural schema allows inside anyOf: no type, description, default or
+// additionalProperties
+func valueValidations(s PropertySchema) PropertySchema {
+	v := PropertySchema{
+		Required:         s.Required,
+		Format:           s.Format,
+		Pattern:          s.Pattern,
+		MinLength:        s.MinLength,
+		MaxLength:        s.MaxLength,
+		Minimum:          s.Minimum,
+		ExclusiveMinimum: s.ExclusiveMinimum,
+		Maximum:          s.Maximum,
+		ExclusiveMaximum: s.ExclusiveMaximum,
+		MultipleOf:       s.MultipleOf,
+		MinItems:         s.MinItems,
+		MaxItems:         s.MaxItems,
+		MinProperties:    s.MinProperties,
+		MaxProperties:    s.MaxProperties,
+		Enum:             s.Enum,
+	}
+	// Booleans have no value validation other than their values
+	if s.Type == "boolean" && len(s.Enum) == 0 {
+		v.Enum = []interface{}{true, false}
+	}
+	for name, prop := range s.Properties {
+		if p := valueValidations(prop); !reflect.DeepEqual(p, PropertySchema{}) {
+			if v.Properties == nil {
+				v.Properties = make(map[string]PropertySchema)
+			}
+			v.Properties[name] = p
+		}
+	}
+	if s.Items != nil {
+		if items := valueValidations(*s.Items); !reflect.DeepEqual(items, PropertySchema{}) {
+			v.Items = &items
+		}
+	}
+	return v
+}
+
+// enumValues converts the values of an @enum annotation to the property type
+func enumValues(values []string, typ string) []interface{} {
+	result := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		switch typ {
+		case "integer":
+			if v, err := strconv.Atoi(value); err == nil {
+				result = append(result, v)
+				continue
+			}
+		case "number":
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				result = append(result, v)
+				continue
+			}
+		case "boolean":
+			if v, err := strconv.ParseBool(strings.ToLower(value)); err == nil {
+				result = append(result, v)
+				continue
+			}
+		}
+		result = append(result, value)
+	}
+	return result
+}
@@ -0,0 +1,464 @@
+package generator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+)
+
+// This file validates values against property schemas the way the
+// Kubernetes API server does: defaults are applied and the structural schema
+// is enforced. It checks the defaults of generated schemas and, through the
+// validator package, composite resources and claims.
+
+// ValueError is an error of a field of a checked value
+type ValueError struct {
+	Field   string // path of the field, e.g. spec.parameters.size; empty for the root
+	Message string
+}
+
+// ValueChecker validates values against property schemas and collects the
+// errors. x-kubernetes-validations rules are evaluated by the Rules hook,
+// only on values that are otherwise valid.
+type ValueChecker struct {
+	Errors   []ValueError
+	Warnings []string // reported by the Rules hook
+	// ImplicitFields are the fields allowed without being declared in the
+	// schema, by the path of their object
+	ImplicitFields map[string]map[string]bool
+	// NoDefaults disables defaulting, e.g. for checking oneOf and anyOf
+	// alternatives
+	NoDefaults bool
+	// Rules evaluates the x-kubernetes-validations rules of a schema
+	Rules func(c *ValueChecker, path string, v interface{}, s *PropertySchema)
+}
+
+var (
+	emailRegex    = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	hostnameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	uuidRegex     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// Errorf records an error of the field at a path
+func (c *ValueChecker) Errorf(path, format string, args ...interface{}) {
+	c.Errors = append(c.Errors, ValueError{Field: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check validates a value against its schema and returns it with defaults
+// applied
+func (c *ValueChecker) Check(path string, v interface{}, s *PropertySchema) interface{} {
+	if v == nil && s.Nullable {
+		return nil
+	}
+	if !c.checkType(path, v, s) {
+		return v
+	}
+	errs := len(c.Errors)
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		v = c.object(path, value, s)
+	case []interface{}:
+		c.array(path, value, s)
+	case string:
+		c.string(path, value, s)
+	case int, float64:
+		c.number(path, toFloat(value), s)
+	}
+
+	if len(s.Enum) > 0 && !containsValue(s.Enum, v) {
+		supported := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			supported[i] = valueLiteral(e)
+		}
+		c.Errorf(path, "Unsupported value: %s: supported values: %s", valueLiteral(v), strings.Join(supported, ", "))
+	}
+	c.alternatives(path, v, s)
+	// Rules are only evaluated on values that are otherwise valid
+	if len(c.Errors) == errs && c.Rules != nil && len(s.XKubernetesValidations) > 0 {
+		c.Rules(c, path, v, s)
+	}
+	return v
+}
+
+// checkType reports a value that does not have the type of its schema
+func (c *ValueChecker) checkType(path string, v interface{}, s *PropertySchema) bool {
+	if s.XKubernetesIntOrString != nil && *s.XKubernetesIntOrString {
+		switch v.(type) {
+		case int, string:
+			return true
+		}
+		if f, ok := v.(float64); ok && f == math.Trunc(f) {
+			return true
+		}
+		c.Errorf(path, "Invalid value: %s: must be an integer or a string", valueLiteral(v))
+		return false
+	}
+
+	ok := true
+	switch s.Type {
+	case "":
+		return true
+	case "object":
+		_, ok = v.(map[string]interface{})
+	case "array":
+		_, ok = v.([]interface{})
+	case "string":
+		_, ok = v.(string)
+	case "boolean":
+		_, ok = v.(bool)
+	case "integer":
+		switch n := v.(type) {
+		case int:
+		case float64:
+			ok = n == math.Trunc(n)
+		default:
+			ok = false
+		}
+	case "number":
+		switch v.(type) {
+		case int, float64:
+		default:
+			ok = false
+		}
+	}
+	if !ok {
+		c.Errorf(path, "Invalid value: %s: must be of type %s", valueLiteral(v), s.Type)
+	}
+	return ok
+}
+
+// object validates the fields of an object, after applying the defaults of
+// missing fields
+func (c *ValueChecker) object(path string, obj map[string]interface{}, s *PropertySchema) map[string]interface{} {
+	// null is the same as an absent field, unless the field is nullable
+	additional := AdditionalPropertiesSchema(s)
+	for name, v := range obj {
+		prop, ok := s.Properties[name]
+		if v == nil && !(ok && prop.Nullable) && !(!ok && additional != nil && additional.Nullable) {
+			delete(obj, name)
+		}
+	}
+	if !c.NoDefaults {
+		for name, prop := range s.Properties {
+			if _, ok := obj[name]; !ok && prop.Default != nil {
+				obj[name] = parser.CopyValue(prop.Default)
+			}
+		}
+	}
+
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			c.Errorf(joinPath(path, name), "Required value")
+		}
+	}
+	if s.MinProperties != nil && len(obj) < *s.MinProperties {
+		c.Errorf(path, "Invalid value: %d: should have at least %d properties", len(obj), *s.MinProperties)
+	}
+	if s.MaxProperties != nil && len(obj) > *s.MaxProperties {
+		c.Errorf(path, "Too many: %d: must have at most %d properties", len(obj), *s.MaxProperties)
+	}
+
+	// An embedded resource has the fields of a Kubernetes object
+	embedded := s.XKubernetesEmbeddedResource != nil && *s.XKubernetesEmbeddedResource
+	if embedded {
+		for _, name := range []string{"apiVersion", "kind"} {
+			if value, _ := obj[name].(string); value == "" {
+				c.Errorf(joinPath(path, name), "Required value: must not be empty")
+			}
+		}
+	}
+
+	// Schemas without a type, like additionalProperties: {}, take any value
+	preserve := s.XKubernetesPreserveUnknownFields != nil && *s.XKubernetesPreserveUnknownFields
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fieldPath := joinPath(path, name)
+		if prop, ok := s.Properties[name]; ok {
+			obj[name] = c.Check(fieldPath, obj[name], &prop)
+			continue
+		}
+		switch {
+		case additional != nil:
+			obj[name] = c.Check(fieldPath, obj[name], additional)
+		case c.ImplicitFields[path][name]:
+		case embedded && (name == "apiVersion" || name == "kind" || name == "metadata"):
+		case s.Type == "object" && !preserve && s.AdditionalProperties != true:
+			c.Errorf(fieldPath, "Unknown field")
+		}
+	}
+	return obj
+}
+
+// array validates the items of a list
+func (c *ValueChecker) array(path string, list []interface{}, s *PropertySchema) {
+	if s.MinItems != nil && len(list) < *s.MinItems {
+		c.Errorf(path, "Invalid value: %d: should have at least %d items", len(list), *s.MinItems)
+	}
+	if s.MaxItems != nil && len(list) > *s.MaxItems {
+		c.Errorf(path, "Too many: %d: must have at most %d items", len(list), *s.MaxItems)
+	}
+	if s.Items != nil {
+		for i := range list {
+			list[i] = c.Check(fmt.Sprintf("%s[%d]", path, i), list[i], s.Items)
+		}
+	}
+
+	if s.XKubernetesListType == "set" || s.UniqueItems {
+		for i := range list {
+			for j := 0; j < i; j++ {
+				if equalValues(list[i], list[j]) {
+					c.Errorf(fmt.Sprintf("%s[%d]", path, i), "Duplicate value: %s", valueLiteral(list[i]))
+					break
+				}
+			}
+		}
+	}
+	if s.XKubernetesListType == "map" {
+		seen := make(map[string]bool)
+		for i, item := range list {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key := make([]string, len(s.XKubernetesListMapKeys))
+			for k, name := range s.XKubernetesListMapKeys {
+				key[k] = valueLiteral(obj[name])
+			}
+			id := strings.Join(key, ",")
+			if seen[id] {
+				c.Errorf(fmt.Sprintf("%s[%d]", path, i), "Duplicate value: map[%s]", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+// string validates the length, pattern and format of a string
+func (c *ValueChecker) string(path, s string, schema *PropertySchema) {
+	length := utf8.RuneCountInString(s)
+	if schema.MinLength != nil && length < *schema.MinLength {
+		c.Errorf(path, "Invalid value: %q: should be at least %d chars long", s, *schema.MinLength)
+	}
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		c.Errorf(path, "Too long: may not be longer than %d", *schema.MaxLength)
+	}
+	if schema.Pattern != "" {
+		re, err := regexp.Compile(schema.Pattern)
+		switch {
+		case err != nil:
+			c.Errorf(path, "Invalid pattern %q in schema: %v", schema.Pattern, err)
+		case !re.MatchString(s):
+			c.Errorf(path, "Invalid value: %q: should match '%s'", s, schema.Pattern)
+		}
+	}
+	if schema.Format != "" && !validFormat(schema.Format, s) {
+		c.Errorf(path, "Invalid value: %q: must be a valid %s", s, schema.Format)
+	}
+}
+
+// number validates the bounds of a number and that it is a multiple of
+// multipleOf
+func (c *ValueChecker) number(path string, n float64, s *PropertySchema) {
+	if s.Minimum != nil {
+		switch {
+		case s.ExclusiveMinimum && n <= *s.Minimum:
+			c.Errorf(path, "Invalid value: %v: should be greater than %v", n, *s.Minimum)
+		case n < *s.Minimum:
+			c.Errorf(path, "Invalid value: %v: should be greater than or equal to %v", n, *s.Minimum)
+		}
+	}
+	if s.Maximum != nil {
+		switch {
+		case s.ExclusiveMaximum && n >= *s.Maximum:
+			c.Errorf(path, "Invalid value: %v: should be less than %v", n, *s.Maximum)
+		case n > *s.Maximum:
+			c.Errorf(path, "Invalid value: %v: should be less than or equal to %v", n, *s.Maximum)
+		}
+	}
+	if s.MultipleOf != nil && *s.MultipleOf > 0 {
+		// Allow for the rounding of decimal multiples, e.g. 0.3 of 0.1
+		quotient := n / *s.MultipleOf
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			c.Errorf(path, "Invalid value: %v: should be a multiple of %v", n, *s.MultipleOf)
+		}
+	}
+}
+
+// alternatives validates the oneOf and anyOf alternatives of a schema. The
+// alternatives only constrain the value; they never apply defaults.
+func (c *ValueChecker) alternatives(path string, v interface{}, s *PropertySchema) {
+	matches := func(alternatives []PropertySchema) int {
+		n := 0
+		for i := range alternatives {
+			alt := &ValueChecker{Rules: c.Rules, NoDefaults: true}
+			alt.constraints(path, parser.CopyValue(v), &alternatives[i])
+			if len(alt.Errors) == 0 {
+				n++
+			}
+		}
+		return n
+	}
+	if len(s.OneOf) > 0 {
+		if n := matches(s.OneOf); n != 1 {
+			c.Errorf(path, "Invalid value: must validate one and only one schema (oneOf). Found %d valid alternatives", n)
+		}
+	}
+	if len(s.AnyOf) > 0 && matches(s.AnyOf) == 0 {
+		c.Errorf(path, "Invalid value: must validate at least one schema (anyOf)")
+	}
+}
+
+// constraints validates a value against a oneOf or anyOf alternative. Like
+// in Kubernetes, alternatives have no types of their own, and unknown fields
+// are left to the enclosing schema.
+func (c *ValueChecker) constraints(path string, v interface{}, s *PropertySchema) {
+	if obj, ok := v.(map[string]interface{}); ok {
+		for _, name := range s.Required {
+			if obj[name] == nil {
+				c.Errorf(joinPath(path, name), "Required value")
+			}
+		}
+		for name, prop := range s.Properties {
+			if field, ok := obj[name]; ok && field != nil {
+				c.constraints(joinPath(path, name), field, &prop)
+			}
+		}
+	}
+	if s.Type != "" || s.Items != nil || len(s.Enum) > 0 || s.Pattern != "" || s.Format != "" ||
+		s.MinLength != nil || s.MaxLength != nil || s.Minimum != nil || s.Maximum != nil || s.MultipleOf != nil ||
+		s.MinItems != nil || s.MaxItems != nil || s.UniqueItems || s.MinProperties != nil || s.MaxProperties != nil {
+		shallow := *s
+		shallow.Properties = nil
+		shallow.Required = nil
+		shallow.AdditionalProperties = nil
+		preserve := true
+		shallow.XKubernetesPreserveUnknownFields = &preserve
+		c.Check(path, v, &shallow)
+	}
+}
+
+// validFormat reports whether a string has a format Kubernetes checks;
+// unknown formats are not checked
+func validFormat(format, s string) bool {
+	switch format {
+	case "byte":
+		_, err := base64.StdEncoding.DecodeString(s)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	case "date-time", "datetime":
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	case "duration":
+		_, err := time.ParseDuration(s)
+		return err == nil
+	case "email":
+		return emailRegex.MatchString(s)
+	case "hostname":
+		return len(s) <= 253 && hostnameRegex.MatchString(s)
+	case "ipv4":
+		ip := net.ParseIP(s)
+		return ip != nil && ip.To4() != nil && !strings.Contains(s, ":")
+	case "ipv6":
+		ip := net.ParseIP(s)
+		return ip != nil && strings.Contains(s, ":")
+	case "cidr":
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	case "mac":
+		_, err := net.ParseMAC(s)
+		return err == nil
+	case "uri":
+		u, err := url.ParseRequestURI(s)
+		return err == nil && u.Scheme != ""
+	case "uuid":
+		return uuidRegex.MatchString(s)
+	}
+	return true
+}
+
+// valueLiteral formats a value for messages
+func valueLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// containsValue reports whether an enum contains a value
+func containsValue(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if equalValues(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValues compares two decoded YAML values; integers and floats of the
+// same value are equal
+func equalValues(a, b interface{}) bool {
+	switch a := a.(type) {
+	case int, float64:
+		switch b.(type) {
+		case int, float64:
+			return toFloat(a) == toFloat(b)
+		}
+		return false
+	case map[string]interface{}:
+		bm, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(bm) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := bm[k]; !ok || !equalValues(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bl, ok := b.([]interface{})
+		if !ok || len(a) != len(bl) {
+			return false
+		}
+		for i := range a {
+			if !equalValues(a[i], bl[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
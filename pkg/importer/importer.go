@@ -0,0 +1,470 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/generator"
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// Result is a KCL file imported from an XRD or CRD
+type Result struct {
+	KCL      string   // the annotated KCL source
+	Warnings []string // parts of the definition the KCL cannot represent
+}
+
+// manifest is the part of an XRD or CRD the importer reads
+type manifest struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name        string            `yaml:"name"`
+		Labels      map[string]string `yaml:"labels"`
+		Annotations map[string]string `yaml:"annotations"`
+	} `yaml:"metadata"`
+	Spec struct {
+		Group                          string          `yaml:"group"`
+		Scope                          string          `yaml:"scope"`
+		Names                          names           `yaml:"names"`
+		ClaimNames                     *names          `yaml:"claimNames"`
+		Categories                     []string        `yaml:"categories"`
+		ConnectionSecretKeys           []string        `yaml:"connectionSecretKeys"`
+		DefaultCompositeDeletePolicy   string          `yaml:"defaultCompositeDeletePolicy"`
+		DefaultCompositionRef          *compositionRef `yaml:"defaultCompositionRef"`
+		EnforcedCompositionRef         *compositionRef `yaml:"enforcedCompositionRef"`
+		DefaultCompositionUpdatePolicy string          `yaml:"defaultCompositionUpdatePolicy"`
+		Versions                       []version       `yaml:"versions"`
+	} `yaml:"spec"`
+}
+
+// names are the names of an XRD, its claims or a CRD
+type names struct {
+	Kind       string   `yaml:"kind"`
+	Plural     string   `yaml:"plural"`
+	Singular   string   `yaml:"singular"`
+	ShortNames []string `yaml:"shortNames"`
+	ListKind   string   `yaml:"listKind"`
+	Categories []string `yaml:"categories"`
+}
+
+// compositionRef references a Composition by name
+type compositionRef struct {
+	Name string `yaml:"name"`
+}
+
+// version is one version of an XRD or CRD. CRDs mark the version XRDs call
+// referenceable as storage.
+type version struct {
+	Name               string  `yaml:"name"`
+	Served             bool    `yaml:"served"`
+	Referenceable      bool    `yaml:"referenceable"`
+	Storage            bool    `yaml:"storage"`
+	Deprecated         bool    `yaml:"deprecated"`
+	DeprecationWarning *string `yaml:"deprecationWarning"`
+	Schema             struct {
+		OpenAPIV3Schema map[string]interface{} `yaml:"openAPIV3Schema"`
+	} `yaml:"schema"`
+	AdditionalPrinterColumns []generator.PrinterColumn `yaml:"additionalPrinterColumns"`
+}
+
+// Import converts an XRD or CRD manifest into a KCL file: the metadata as
+// __xrd_ variables, one schema per version and one per nested object, and
+// the validations as comment annotations. Generating an XRD from the KCL
+// reproduces the definition's schemas; what the KCL cannot express is
+// reported in the warnings.
+func Import(data []byte) (*Result, error) {
+	m, err := decodeManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Spec.Versions) == 0 {
+		return nil, fmt.Errorf("%s %s has no versions", m.Kind, m.Metadata.Name)
+	}
+
+	im := &importer{schemaNames: make(map[string]bool)}
+	isCRD := m.Kind == "CustomResourceDefinition"
+	multiVersion := len(m.Spec.Versions) > 1
+
+	// The version schemas are named first so nested schemas avoid their names
+	kind := m.Spec.Names.Kind
+	versionNames := make([]string, len(m.Spec.Versions))
+	for i, v := range m.Spec.Versions {
+		versionNames[i] = kind
+		if multiVersion {
+			versionNames[i] = kind + pascalCase(v.Name)
+		}
+		im.schemaNames[versionNames[i]] = true
+	}
+
+	var versionSchemas []*kclSchema
+	statusPreserve := make(map[bool]bool)
+	for i, v := range m.Spec.Versions {
+		im.prefix = ""
+		if multiVersion {
+			im.prefix = v.Name + ": "
+		}
+		schema, preserve := im.versionSchema(versionNames[i], v.Schema.OpenAPIV3Schema)
+		statusPreserve[preserve] = true
+
+		referenceable := v.Referenceable || (isCRD && v.Storage)
+		if multiVersion {
+			args := []string{fmt.Sprintf("version=%s", parser.QuoteString(v.Name))}
+			if !v.Served {
+				args = append(args, "served=False")
+			}
+			args = append(args, "referenceable="+kclBool(referenceable))
+			if v.DeprecationWarning != nil {
+				args = append(args, "deprecationWarning="+parser.QuoteString(*v.DeprecationWarning))
+			} else if v.Deprecated {
+				args = append(args, "deprecated=True")
+			}
+			schema.annotations = append([]string{"@xrd(" + strings.Join(args, ", ") + ")"}, schema.annotations...)
+		} else {
+			schema.annotations = append([]string{"@xrd"}, schema.annotations...)
+		}
+		versionSchemas = append(versionSchemas, schema)
+
+		if i > 0 && !reflect.DeepEqual(v.AdditionalPrinterColumns, m.Spec.Versions[0].AdditionalPrinterColumns) {
+			im.warnf("printer columns of version %s differ from version %s; only those of %s are imported", v.Name, m.Spec.Versions[0].Name, m.Spec.Versions[0].Name)
+		}
+	}
+	im.prefix = ""
+	if len(statusPreserve) > 1 {
+		im.warnf("only some versions have a status that preserves unknown fields; it is imported for all versions")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Imported from %s %s by kcl2xrd import\n", m.Kind, m.Metadata.Name)
+	im.writeClaimsNote(&buf, m)
+	buf.WriteString("\n")
+	im.writeMetadata(&buf, m, isCRD, statusPreserve[true])
+	for _, s := range im.schemas {
+		buf.WriteString("\n")
+		s.write(&buf)
+	}
+	for _, s := range versionSchemas {
+		buf.WriteString("\n")
+		s.write(&buf)
+	}
+
+	return &Result{KCL: buf.String(), Warnings: im.warnings}, nil
+}
+
+// decodeManifest returns the first XRD or CRD of a YAML stream
+func decodeManifest(data []byte) (*manifest, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var m manifest
+		err := decoder.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no CompositeResourceDefinition or CustomResourceDefinition found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode YAML: %w", err)
+		}
+		switch {
+		case m.Kind == "CompositeResourceDefinition" && strings.HasPrefix(m.APIVersion, "apiextensions.crossplane.io/"):
+			return &m, nil
+		case m.Kind == "CustomResourceDefinition" && strings.HasPrefix(m.APIVersion, "apiextensions.k8s.io/"):
+			return &m, nil
+		}
+	}
+}
+
+// writeClaimsNote explains how to regenerate claim names, which are set by
+// flags rather than in the KCL file
+func (im *importer) writeClaimsNote(buf *bytes.Buffer, m *manifest) {
+	claims := m.Spec.ClaimNames
+	if claims == nil {
+		return
+	}
+	if m.Spec.Names.Kind != "X"+claims.Kind {
+		im.warnf("claim kind %s is not the XRD kind %s without its X prefix; --with-claims derives the XRD kind from the claim kind", claims.Kind, m.Spec.Names.Kind)
+	}
+	flags := []string{"--with-claims"}
+	if claims.Plural != generator.Pluralize(claims.Kind) {
+		flags = append(flags, "--claim-plural "+claims.Plural)
+	}
+	if claims.Singular != "" {
+		flags = append(flags, "--claim-singular "+claims.Singular)
+	}
+	if len(claims.ShortNames) > 0 {
+		flags = append(flags, "--claim-short-names "+strings.Join(claims.ShortNames, ","))
+	}
+	if claims.ListKind != "" {
+		flags = append(flags, "--claim-list-kind "+claims.ListKind)
+	}
+	fmt.Fprintf(buf, "# Claims (%s): generate with %s\n", claims.Kind, strings.Join(flags, " "))
+}
+
+// writeMetadata writes the __xrd_ variables
+func (im *importer) writeMetadata(buf *bytes.Buffer, m *manifest, isCRD bool, statusPreserve bool) {
+	spec := m.Spec
+	variable := func(name, value string) {
+		fmt.Fprintf(buf, "__xrd_%s = %s\n", name, value)
+	}
+
+	variable("kind", parser.QuoteString(spec.Names.Kind))
+	variable("group", parser.QuoteString(spec.Group))
+	if len(spec.Versions) == 1 {
+		v := spec.Versions[0]
+		variable("version", parser.QuoteString(v.Name))
+		if !v.Served {
+			variable("served", "False")
+		}
+		if !v.Referenceable && !(isCRD && v.Storage) {
+			variable("referenceable", "False")
+		}
+		if v.Deprecated || v.DeprecationWarning != nil {
+			im.warnf("the deprecation of version %s is only imported for multi-version definitions", v.Name)
+		}
+	}
+	if spec.Scope != "" {
+		variable("scope", parser.QuoteString(spec.Scope))
+	}
+
+	categories := spec.Categories
+	if isCRD {
+		categories = spec.Names.Categories
+	}
+	if len(categories) > 0 {
+		variable("categories", kclStringList(categories))
+	}
+	if spec.Names.Plural != "" && spec.Names.Plural != generator.Pluralize(spec.Names.Kind) {
+		variable("plural", parser.QuoteString(spec.Names.Plural))
+	}
+	if spec.Names.Singular != "" {
+		variable("singular", parser.QuoteString(spec.Names.Singular))
+	}
+	if len(spec.Names.ShortNames) > 0 {
+		variable("short_names", kclStringList(spec.Names.ShortNames))
+	}
+	if spec.Names.ListKind != "" {
+		variable("list_kind", parser.QuoteString(spec.Names.ListKind))
+	}
+
+	if spec.DefaultCompositionRef != nil {
+		variable("default_composition_ref", parser.QuoteString(spec.DefaultCompositionRef.Name))
+	}
+	if spec.EnforcedCompositionRef != nil {
+		variable("enforced_composition_ref", parser.QuoteString(spec.EnforcedCompositionRef.Name))
+	}
+	if spec.DefaultCompositionUpdatePolicy != "" {
+		variable("default_composition_update_policy", parser.QuoteString(spec.DefaultCompositionUpdatePolicy))
+	}
+	if spec.DefaultCompositeDeletePolicy != "" {
+		variable("default_composite_delete_policy", parser.QuoteString(spec.DefaultCompositeDeletePolicy))
+	}
+	if len(spec.ConnectionSecretKeys) > 0 {
+		variable("connection_secret_keys", kclStringList(spec.ConnectionSecretKeys))
+	}
+
+	if len(m.Metadata.Labels) > 0 {
+		variable("labels", kclStringMap(m.Metadata.Labels))
+	}
+	annotations := make(map[string]string)
+	for k, v := range m.Metadata.Annotations {
+		// Set by kubectl, not by the author
+		if k != "kubectl.kubernetes.io/last-applied-configuration" {
+			annotations[k] = v
+		}
+	}
+	if len(annotations) > 0 {
+		variable("annotations", kclStringMap(annotations))
+	}
+
+	var columns []string
+	for _, pc := range spec.Versions[0].AdditionalPrinterColumns {
+		if strings.Contains(pc.JSONPath, ":") || strings.Contains(pc.Description, ":") {
+			im.warnf("printer column %s contains ':' and is not imported", pc.Name)
+			continue
+		}
+		if pc.Priority != 0 {
+			im.warnf("the priority of printer column %s is not imported", pc.Name)
+		}
+		column := pc.Name + ":" + pc.Type + ":" + pc.JSONPath
+		if pc.Description != "" {
+			column += ":" + pc.Description
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) > 0 {
+		variable("printer_columns", kclStringList(columns))
+	}
+
+	if statusPreserve {
+		variable("status_preserve_unknown_fields", "True")
+	}
+}
+
+// versionSchema converts the openAPIV3Schema of a version into a schema with
+// the spec.parameters fields, the other spec fields (@spec) and the status
+// fields (@status). It also reports whether the status only preserves
+// unknown fields.
+func (im *importer) versionSchema(name string, root map[string]interface{}) (*kclSchema, bool) {
+	schema := &kclSchema{name: name}
+	props := mapValue(root["properties"])
+	for key := range root {
+		switch key {
+		case "type", "properties", "required":
+		default:
+			im.warnf("%s of the root schema is not supported", key)
+		}
+	}
+	for prop := range props {
+		switch prop {
+		case "spec", "status":
+		case "apiVersion", "kind", "metadata":
+			// Defined by Kubernetes for every resource
+		default:
+			im.warnf("root property %s is not supported", prop)
+		}
+	}
+
+	spec := mapValue(props["spec"])
+	specProps := mapValue(spec["properties"])
+	for key := range spec {
+		switch key {
+		case "type", "properties", "required", "description":
+		default:
+			im.warnf("%s of spec is not supported", key)
+		}
+	}
+
+	if params, ok := specProps["parameters"].(map[string]interface{}); ok {
+		for key := range params {
+			switch key {
+			case "type", "properties", "required", "description":
+			case "oneOf", "anyOf":
+				if combinations, ok := requiredCombinations(params[key]); ok {
+					schema.annotations = append(schema.annotations, fmt.Sprintf("@%s(%s)", key, combinations))
+				} else {
+					im.warnf("spec.parameters: %s with schemas other than required fields is not supported", key)
+				}
+			case "x-kubernetes-validations":
+				// Rules on spec.parameters are schema-level @validate annotations
+				rules, _ := params[key].([]interface{})
+				for _, r := range rules {
+					rule := mapValue(r)
+					expr, _ := rule["rule"].(string)
+					message, _ := rule["message"].(string)
+					if presenceRule(params, expr, message) {
+						continue
+					}
+					if annotation, ok := im.validateAnnotation("spec.parameters", rule); ok {
+						schema.annotations = append(schema.annotations, annotation)
+					}
+				}
+			default:
+				im.warnf("%s of spec.parameters is not supported", key)
+			}
+		}
+		schema.fields = append(schema.fields, im.objectFields(name, "spec.parameters", params, "")...)
+	} else {
+		im.warnf("spec has no parameters; the generated XRD will have an empty spec.parameters object")
+	}
+
+	// Other spec properties are spec-level fields
+	delete(specProps, "parameters")
+	schema.fields = append(schema.fields, im.objectFields(name, "spec", map[string]interface{}{
+		"properties": specProps,
+		"required":   without(stringList(spec["required"]), "parameters"),
+	}, "@spec")...)
+
+	preserve := false
+	if status, ok := props["status"].(map[string]interface{}); ok {
+		if _, hasProps := status["properties"]; hasProps {
+			for key := range status {
+				switch key {
+				case "type", "properties", "required", "description":
+				default:
+					im.warnf("%s of status is not supported", key)
+				}
+			}
+			schema.fields = append(schema.fields, im.objectFields(name, "status", status, "@status")...)
+		} else if status["x-kubernetes-preserve-unknown-fields"] == true {
+			preserve = true
+		} else {
+			im.warnf("status without properties that does not preserve unknown fields is not supported")
+		}
+	}
+
+	// Fields of the three parts share the schema's namespace
+	seen := make(map[string]bool)
+	unique := schema.fields[:0]
+	for _, f := range schema.fields {
+		if seen[f.name] {
+			im.warnf("field %s is declared in several of spec.parameters, spec and status; only the first is imported", f.name)
+			continue
+		}
+		seen[f.name] = true
+		unique = append(unique, f)
+	}
+	schema.fields = unique
+
+	return schema, preserve
+}
+
+// requiredCombinations formats oneOf/anyOf branches that only list required
+// fields in the syntax of @oneOf and @anyOf
+func requiredCombinations(value interface{}) (string, bool) {
+	branches, ok := value.([]interface{})
+	if !ok || len(branches) == 0 {
+		return "", false
+	}
+	var combinations []string
+	for _, branch := range branches {
+		b := mapValue(branch)
+		if len(b) != 1 || b["required"] == nil {
+			return "", false
+		}
+		combinations = append(combinations, kclStringList(stringList(b["required"])))
+	}
+	return "[" + strings.Join(combinations, ", ") + "]", true
+}
+
+// without returns the values other than the given one
+func without(values []string, value string) []string {
+	var result []string
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// fieldOrder orders the properties of an object alphabetically, except that
+// required properties keep the order of the required list, which the
+// generator derives from the declaration order
+func fieldOrder(props map[string]interface{}, required []string) []string {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	isRequired := make(map[string]bool)
+	var ordered []string
+	for _, name := range required {
+		if _, ok := props[name]; ok && !isRequired[name] {
+			isRequired[name] = true
+			ordered = append(ordered, name)
+		}
+	}
+	next := 0
+	for i, name := range names {
+		if isRequired[name] {
+			names[i] = ordered[next]
+			next++
+		}
+	}
+	return names
+}
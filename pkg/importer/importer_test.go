@@ -0,0 +1,358 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/generator"
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+	"gopkg.in/yaml.v3"
+)
+
+const appXRD = `apiVersion: apiextensions.crossplane.io/v1
+kind: CompositeResourceDefinition
+metadata:
+  name: xapps.example.org
+spec:
+  group: example.org
+  names:
+    kind: XApp
+    plural: xapps
+  versions:
+    - name: v1alpha1
+      served: true
+      referenceable: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              properties:
+                deletionPolicy:
+                  type: string
+                  default: Delete
+                  enum:
+                    - Delete
+                    - Orphan
+                parameters:
+                  type: object
+                  properties:
+                    extra:
+                      type: object
+                      additionalProperties: {}
+                      x-kubernetes-preserve-unknown-fields: true
+                    hosts:
+                      type: array
+                      items:
+                        type: string
+                        format: hostname
+                    image:
+                      type: object
+                      properties:
+                        env:
+                          type: object
+                          additionalProperties:
+                            type: string
+                        image:
+                          type: string
+                          description: Container image
+                          pattern: ^[a-z0-9./:-]+$
+                        port:
+                          type: integer
+                          default: 8080
+                          minimum: 1
+                          maximum: 65535
+                      required:
+                        - image
+                    name:
+                      type: string
+                      description: Application name
+                      minLength: 3
+                      maxLength: 63
+                      x-kubernetes-validations:
+                        - rule: self == oldSelf
+                          message: name is immutable
+                    ports:
+                      type: array
+                      items:
+                        type: object
+                        properties:
+                          name:
+                            type: string
+                          port:
+                            type: integer
+                        required:
+                          - name
+                          - port
+                      x-kubernetes-list-type: map
+                      x-kubernetes-list-map-keys:
+                        - name
+                    prefix:
+                      type: string
+                      x-kubernetes-validations:
+                        - rule: self.startsWith("app-")
+                          message: must start with app-
+                    namespace:
+                      type: string
+                      x-kubernetes-validations:
+                        - rule: self == oldSelf
+                          message: namespace cannot be changed once set
+                    region:
+                      type: string
+                      x-kubernetes-validations:
+                        - rule: self == oldSelf
+                          message: region is immutable
+                    sidecar:
+                      type: object
+                      properties:
+                        extra:
+                          type: array
+                          items:
+                            type: object
+                            properties:
+                              env:
+                                type: object
+                                additionalProperties:
+                                  type: string
+                              image:
+                                type: string
+                                description: Container image
+                                pattern: ^[a-z0-9./:-]+$
+                              port:
+                                type: integer
+                                default: 8080
+                                minimum: 1
+                                maximum: 65535
+                            required:
+                              - image
+                          minItems: 1
+                        main:
+                          type: object
+                          properties:
+                            env:
+                              type: object
+                              additionalProperties:
+                                type: string
+                            image:
+                              type: string
+                              description: Container image
+                              pattern: ^[a-z0-9./:-]+$
+                            port:
+                              type: integer
+                              default: 8080
+                              minimum: 1
+                              maximum: 65535
+                          required:
+                            - image
+                      required:
+                        - main
+                    size:
+                      type: string
+                      default: small
+                      enum:
+                        - small
+                        - medium
+                        - large
+                    strategy:
+                      x-kubernetes-int-or-string: true
+                    type:
+                      type: string
+                      enum:
+                        - web
+                        - worker
+                  required:
+                    - name
+                  oneOf:
+                    - required:
+                        - image
+                    - required:
+                        - sidecar
+                  x-kubernetes-validations:
+                    - rule: "!has(oldSelf.__namespace__) || has(self.__namespace__)"
+                      message: namespace cannot be removed once set
+                    - rule: has(self.region) == has(oldSelf.region)
+                      message: region cannot be added or removed
+                    - rule: self.name != "default"
+                      messageExpression: "'name ' + self.name + ' is reserved'"
+                      reason: FieldValueForbidden
+                      fieldPath: .name
+              required:
+                - parameters
+            status:
+              type: object
+              properties:
+                ready:
+                  type: boolean
+          required:
+            - spec
+`
+
+// openAPISchemas returns the openAPIV3Schema of each version of an XRD
+func openAPISchemas(t *testing.T, xrd string) map[string]interface{} {
+	t.Helper()
+	var m manifest
+	if err := yaml.Unmarshal([]byte(xrd), &m); err != nil {
+		t.Fatalf("Failed to parse XRD: %v", err)
+	}
+	schemas := make(map[string]interface{})
+	for _, v := range m.Spec.Versions {
+		schemas[v.Name] = v.Schema.OpenAPIV3Schema
+	}
+	return schemas
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	result, err := Import([]byte(appXRD))
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if len(result.Warnings) > 0 {
+		t.Errorf("Expected no warnings, got %v", result.Warnings)
+	}
+
+	// Nested objects with the same properties share a schema, keywords are
+	// prefixed with $ and quotes in CEL rules are kept
+	for _, expected := range []string{
+		"schema Image:",
+		"extra?: [Image]",
+		"main: Image",
+		"ports?: [Port]",
+		"$type?: str",
+		"# @immutable\n    name: str",
+		`# @validate('self.startsWith("app-")', "must start with app-")`,
+		`# @oneOf([["image"], ["sidecar"]])`,
+		`# @validate('self.name != "default"', messageExpression="'name ' + self.name + ' is reserved'", reason="FieldValueForbidden", fieldPath=".name")`,
+		"# @immutable\n    region?: str",
+		"# @immutableOnceSet\n    namespace?: str",
+		"# @spec\n    # @enum([\"Delete\", \"Orphan\"])\n    deletionPolicy?: str = \"Delete\"",
+	} {
+		if !strings.Contains(result.KCL, expected) {
+			t.Errorf("Expected KCL to contain %q, got:\n%s", expected, result.KCL)
+		}
+	}
+
+	testFile := filepath.Join(t.TempDir(), "app.k")
+	if err := os.WriteFile(testFile, []byte(result.KCL), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	parsed, err := parser.ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	xrd, err := generator.GenerateXRDWithSchemasAndOptions(parsed.Primary, parsed.Schemas, generator.XRDOptions{
+		Group:         parsed.Metadata.Group,
+		Version:       parsed.Metadata.XRVersion,
+		Kind:          parsed.Metadata.XRKind,
+		Served:        true,
+		Referenceable: true,
+	})
+	if err != nil {
+		t.Fatalf("GenerateXRDWithSchemasAndOptions failed: %v", err)
+	}
+
+	if got, want := openAPISchemas(t, xrd), openAPISchemas(t, appXRD); !reflect.DeepEqual(got, want) {
+		t.Errorf("Regenerated schema differs from the imported one.\nKCL:\n%s\nXRD:\n%s", result.KCL, xrd)
+	}
+}
+
+func TestImportMultiVersionCRD(t *testing.T) {
+	crd := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.org
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: "{}"
+    team: platform
+spec:
+  group: example.org
+  scope: Namespaced
+  names:
+    kind: Widget
+    plural: widgets
+    shortNames: [wd]
+    categories: [tools]
+  versions:
+    - name: v1alpha1
+      served: true
+      storage: false
+      deprecated: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              properties:
+                size:
+                  type: integer
+                  minimum: -1
+                  maximum: 9.5
+                  exclusiveMaximum: true
+                  nullable: true
+    - name: v1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              properties:
+                parameters:
+                  type: object
+                  properties:
+                    size:
+                      type: integer
+                    check:
+                      type: string
+                      x-kubernetes-validations:
+                        - rule: self != ""
+                          messageExpression: '"invalid " + self'
+`
+	result, err := Import([]byte(crd))
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	for _, expected := range []string{
+		`__xrd_scope = "Namespaced"`,
+		`__xrd_categories = ["tools"]`,
+		`__xrd_short_names = ["wd"]`,
+		`__xrd_annotations = {"team": "platform"}`,
+		`# @xrd(version="v1alpha1", referenceable=False, deprecated=True)`,
+		"schema WidgetV1alpha1:",
+		`# @xrd(version="v1", referenceable=True)`,
+		"schema WidgetV1:",
+		"$check?: str",
+		"# @minimum(-1)\n    # @exclusiveMaximum(9.5)\n    # @nullable\n",
+		`# @validate('self != ""', messageExpression='"invalid " + self')`,
+	} {
+		if !strings.Contains(result.KCL, expected) {
+			t.Errorf("Expected KCL to contain %q, got:\n%s", expected, result.KCL)
+		}
+	}
+	if strings.Contains(result.KCL, "last-applied-configuration") {
+		t.Errorf("Expected the last applied configuration to be dropped, got:\n%s", result.KCL)
+	}
+
+	warnings := strings.Join(result.Warnings, "\n")
+	for _, expected := range []string{
+		"v1alpha1: spec has no parameters",
+	} {
+		if !strings.Contains(warnings, expected) {
+			t.Errorf("Expected warning %q, got:\n%s", expected, warnings)
+		}
+	}
+}
+
+func TestImportNoDefinition(t *testing.T) {
+	_, err := Import([]byte("apiVersion: v1\nkind: ConfigMap\n"))
+	if err == nil || !strings.Contains(err.Error(), "no CompositeResourceDefinition or CustomResourceDefinition found") {
+		t.Errorf("Expected an error for a file without a definition, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package importer
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+)
+
+// identifierRegex matches KCL identifiers
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// kclIdentifier returns the attribute name for a property name
+func kclIdentifier(name string) (string, bool) {
+	if !identifierRegex.MatchString(name) {
+		return "", false
+	}
+	if parser.IsKeyword(name) {
+		return "$" + name, true
+	}
+	return name, true
+}
+
+// pascalCase converts a name like forProvider or max-size to ForProvider or
+// MaxSize
+func pascalCase(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// kclBool returns a KCL boolean literal
+func kclBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+// kclStringList returns a KCL list literal of strings
+func kclStringList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = parser.QuoteString(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// kclStringMap returns a KCL dict literal of strings
+func kclStringMap(values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	entries := make([]string, len(keys))
+	for i, k := range keys {
+		entries[i] = parser.QuoteString(k) + ": " + parser.QuoteString(values[k])
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// annotationString quotes an annotation argument. Annotation arguments are
+// read as written, so the value must not contain the quote around it.
+func annotationString(s string) (string, bool) {
+	switch {
+	case !strings.Contains(s, `"`):
+		return `"` + s + `"`, true
+	case !strings.Contains(s, `'`):
+		return `'` + s + `'`, true
+	}
+	return "", false
+}
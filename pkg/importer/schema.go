@@ -0,0 +1,612 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/generator"
+	"github.com/ggkhrmv/kcl2xrd/pkg/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// importer converts OpenAPI schemas into KCL schemas
+type importer struct {
+	schemas     []*kclSchema      // nested schemas in order of creation
+	schemaNames map[string]bool   // names of all schemas, including the version schemas
+	structures  map[string]string // nested schema name by object structure, to share identical objects
+	prefix      string            // prefix of warnings, e.g. the version
+	warnings    []string
+}
+
+// kclSchema is a KCL schema to write
+type kclSchema struct {
+	name        string
+	annotations []string // schema-level annotations, e.g. @xrd
+	fields      []kclField
+}
+
+// kclField is an attribute of a KCL schema
+type kclField struct {
+	name        string
+	typ         string
+	optional    bool
+	def         string // KCL literal of the default value
+	description string
+	annotations []string
+}
+
+// warnf records a part of the definition that is not imported
+func (im *importer) warnf(format string, args ...interface{}) {
+	im.warnings = append(im.warnings, im.prefix+fmt.Sprintf(format, args...))
+}
+
+// objectFields converts the properties of an object schema into fields of
+// the named schema. A non-empty marker (@spec, @status) is added to every
+// field.
+func (im *importer) objectFields(parent, path string, obj map[string]interface{}, marker string) []kclField {
+	props := mapValue(obj["properties"])
+	required := stringList(obj["required"])
+	isRequired := make(map[string]bool)
+	for _, name := range required {
+		isRequired[name] = true
+	}
+
+	var fields []kclField
+	for _, name := range fieldOrder(props, required) {
+		f, ok := im.field(parent, path+"."+name, name, mapValue(props[name]))
+		if !ok {
+			continue
+		}
+		f.optional = !isRequired[name]
+		if marker != "" {
+			f.annotations = append([]string{marker}, f.annotations...)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// field converts a property schema into a field with its type, default,
+// description and validation annotations
+func (im *importer) field(parent, path, name string, prop map[string]interface{}) (kclField, bool) {
+	ident, ok := kclIdentifier(name)
+	if !ok {
+		im.warnf("%s: field name is not a KCL identifier", path)
+		return kclField{}, false
+	}
+	f := kclField{name: ident}
+	f.typ, f.annotations = im.kclType(parent, path, name, prop)
+
+	for _, key := range keywordOrder(prop) {
+		value := prop[key]
+		switch key {
+		case "type", "properties", "required", "items", "additionalProperties", "x-kubernetes-int-or-string":
+			// Part of the type
+		case "description":
+			f.description, _ = value.(string)
+		case "default":
+			f.def = parser.Literal(value)
+		case "pattern", "format":
+			im.stringAnnotation(&f, path, key, value)
+		case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			if n, ok := value.(int); ok && n >= 0 {
+				f.annotations = append(f.annotations, fmt.Sprintf("@%s(%d)", key, n))
+			} else {
+				im.warnf("%s: %s %v is not supported, only non-negative integers", path, key, value)
+			}
+		case "minimum", "maximum":
+			if _, ok := number(value); ok {
+				// exclusiveMinimum and exclusiveMaximum are booleans in OpenAPI v3.0
+				if exclusive := "exclusive" + strings.ToUpper(key[:1]) + key[1:]; prop[exclusive] == true {
+					key = exclusive
+				}
+				f.annotations = append(f.annotations, fmt.Sprintf("@%s(%s)", key, parser.Literal(value)))
+			} else {
+				im.warnf("%s: %s %v is not supported, only numbers", path, key, value)
+			}
+		case "exclusiveMinimum", "exclusiveMaximum":
+			// Part of the annotation of the bound
+			bound := strings.ToLower(strings.TrimPrefix(key, "exclusive"))
+			_, hasBound := prop[bound]
+			if _, ok := value.(bool); !ok || value == true && !hasBound {
+				im.warnf("%s: %s %v is not supported, only true with %s", path, key, value, bound)
+			}
+		case "multipleOf":
+			if n, ok := number(value); ok && n > 0 {
+				f.annotations = append(f.annotations, "@multipleOf("+parser.Literal(value)+")")
+			} else {
+				im.warnf("%s: multipleOf %v is not supported, only positive numbers", path, value)
+			}
+		case "uniqueItems", "nullable", "x-kubernetes-embedded-resource":
+			if value == true {
+				annotation := map[string]string{"uniqueItems": "@uniqueItems", "nullable": "@nullable", "x-kubernetes-embedded-resource": "@embeddedResource"}[key]
+				f.annotations = append(f.annotations, annotation)
+			}
+		case "enum":
+			im.enum(&f, path, value)
+		case "x-kubernetes-immutable":
+			if value == true {
+				f.addAnnotation("@immutable")
+			}
+		case "x-kubernetes-validations":
+			im.validations(&f, path, name, prop)
+		case "x-kubernetes-preserve-unknown-fields":
+			if value != true {
+				continue
+			}
+			if strings.HasPrefix(f.typ, "[{any:any}]") {
+				// Generated on the items of [{any:any}] instead
+				im.warnf("%s: x-kubernetes-preserve-unknown-fields on an array of objects is not supported", path)
+				continue
+			}
+			f.annotations = append(f.annotations, "@preserveUnknownFields")
+		case "x-kubernetes-map-type", "x-kubernetes-list-type":
+			annotation := map[string]string{"x-kubernetes-map-type": "mapType", "x-kubernetes-list-type": "listType"}[key]
+			im.stringAnnotation(&f, path, annotation, value)
+		case "x-kubernetes-list-map-keys":
+			f.annotations = append(f.annotations, "@listMapKeys("+kclStringList(stringList(value))+")")
+		case "oneOf", "anyOf":
+			if combinations, ok := requiredCombinations(value); ok {
+				f.annotations = append(f.annotations, fmt.Sprintf("@%s(%s)", key, combinations))
+			} else {
+				im.warnf("%s: %s with schemas other than required fields is not supported", path, key)
+			}
+		default:
+			im.warnf("%s: %s is not supported", path, key)
+		}
+	}
+	return f, true
+}
+
+// stringAnnotation adds an annotation with a quoted string argument
+func (im *importer) stringAnnotation(f *kclField, path, name string, value interface{}) {
+	s, ok := value.(string)
+	quoted, quotable := annotationString(s)
+	if !ok || !quotable {
+		im.warnf("%s: %s %v is not supported", path, name, value)
+		return
+	}
+	f.annotations = append(f.annotations, "@"+name+"("+quoted+")")
+}
+
+// enum adds an @enum annotation, or a literal union type for values the
+// annotation cannot hold
+func (im *importer) enum(f *kclField, path string, value interface{}) {
+	values, _ := value.([]interface{})
+	var literals []string
+	annotationSafe := true
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			if strings.ContainsAny(v, `,]"'`) {
+				annotationSafe = false
+			}
+		case int, float64, bool:
+		default:
+			im.warnf("%s: enum value %v is not supported", path, v)
+			return
+		}
+		literals = append(literals, parser.Literal(v))
+	}
+	if len(literals) == 0 {
+		return
+	}
+	if annotationSafe {
+		f.annotations = append(f.annotations, "@enum(["+strings.Join(literals, ", ")+"])")
+		return
+	}
+	switch f.typ {
+	case "str", "int", "float", "bool":
+		f.typ = strings.Join(literals, " | ")
+	default:
+		im.warnf("%s: enum on type %s is not supported", path, f.typ)
+	}
+}
+
+// validations adds an @validate annotation per CEL rule of a field, or the
+// transition annotation a rule was generated from. The rules the generator
+// adds to an object for the transition annotations of its own fields are
+// skipped.
+func (im *importer) validations(f *kclField, path, name string, prop map[string]interface{}) {
+	rules, _ := prop["x-kubernetes-validations"].([]interface{})
+	for _, r := range rules {
+		rule := mapValue(r)
+		expr, _ := rule["rule"].(string)
+		message, _ := rule["message"].(string)
+		if annotation := transitionAnnotation(name, expr, message); annotation != "" && len(rule) == 2 {
+			f.addAnnotation(annotation)
+			continue
+		}
+		if presenceRule(prop, expr, message) {
+			continue
+		}
+		if annotation, ok := im.validateAnnotation(path, rule); ok {
+			f.annotations = append(f.annotations, annotation)
+		}
+	}
+}
+
+// validateAnnotation returns the @validate annotation of a CEL rule
+func (im *importer) validateAnnotation(path string, rule map[string]interface{}) (string, bool) {
+	expr, _ := rule["rule"].(string)
+	quotedRule, ok := annotationString(expr)
+	if !ok {
+		im.warnf("%s: CEL rule %q contains both quote characters and is not supported", path, expr)
+		return "", false
+	}
+	args := []string{quotedRule}
+	for _, key := range []string{"message", "messageExpression", "reason", "fieldPath"} {
+		value, ok := rule[key]
+		if !ok {
+			continue
+		}
+		quoted, ok := annotationString(fmt.Sprint(value))
+		if !ok {
+			im.warnf("%s: %s of CEL rule %q contains both quote characters and is dropped", path, key, expr)
+			continue
+		}
+		// The message is the second argument; the others are named
+		if key == "message" {
+			args = append(args, quoted)
+		} else {
+			args = append(args, key+"="+quoted)
+		}
+	}
+	if rule["optionalOldSelf"] == true {
+		args = append(args, "optionalOldSelf=True")
+	}
+	for _, key := range sortedKeys(rule) {
+		switch key {
+		case "rule", "message", "messageExpression", "reason", "fieldPath", "optionalOldSelf":
+		default:
+			im.warnf("%s: %s of CEL rule %q is not supported", path, key, expr)
+		}
+	}
+	return "@validate(" + strings.Join(args, ", ") + ")", true
+}
+
+// presenceRule reports whether a rule of an object is one the generator adds
+// for an optional field with @immutable, @immutableOnceSet or @appendOnly,
+// which is generated again from the field's annotation
+func presenceRule(obj map[string]interface{}, rule, message string) bool {
+	required := make(map[string]bool)
+	for _, name := range stringList(obj["required"]) {
+		required[name] = true
+	}
+	for name, prop := range mapValue(obj["properties"]) {
+		// Rules select fields by their escaped names
+		escaped, ok := generator.EscapeCELName(name)
+		var annotations []string
+		switch {
+		case required[name] || !ok:
+			continue
+		case rule == fmt.Sprintf("has(self.%s) == has(oldSelf.%s)", escaped, escaped) && message == name+" cannot be added or removed":
+			annotations = []string{"@immutable"}
+		case rule == fmt.Sprintf("!has(oldSelf.%s) || has(self.%s)", escaped, escaped) && message == name+" cannot be removed once set":
+			annotations = []string{"@immutableOnceSet", "@appendOnly"}
+		default:
+			continue
+		}
+		rules, _ := mapValue(prop)["x-kubernetes-validations"].([]interface{})
+		for _, r := range rules {
+			fieldRule := mapValue(r)
+			expr, _ := fieldRule["rule"].(string)
+			fieldMessage, _ := fieldRule["message"].(string)
+			annotation := transitionAnnotation(name, expr, fieldMessage)
+			for _, a := range annotations {
+				if a == annotation {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// transitionAnnotation returns the annotation that generates a transition
+// rule with the given message for a field, if any. The rules the annotations
+// add to the parent object are generated again from the annotation.
+func transitionAnnotation(name, rule, message string) string {
+	switch {
+	case rule == "self == oldSelf" && message == name+" is immutable":
+		return "@immutable"
+	case rule == "self == oldSelf" && message == name+" cannot be changed once set":
+		return "@immutableOnceSet"
+	case rule == "oldSelf.all(x, x in self)" && message == "items cannot be removed from "+name:
+		return "@appendOnly"
+	}
+	return ""
+}
+
+// addAnnotation adds an annotation to a field unless it already has it
+func (f *kclField) addAnnotation(annotation string) {
+	for _, a := range f.annotations {
+		if a == annotation {
+			return
+		}
+	}
+	f.annotations = append(f.annotations, annotation)
+}
+
+// kclType returns the KCL type of a property schema and the annotations the
+// type needs, e.g. @itemsFormat for the items of an array
+func (im *importer) kclType(parent, path, name string, prop map[string]interface{}) (string, []string) {
+	if prop["x-kubernetes-int-or-string"] == true {
+		return "int | str", nil
+	}
+	switch prop["type"] {
+	case "string":
+		return "str", nil
+	case "integer":
+		return "int", nil
+	case "number":
+		return "float", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		items, ok := prop["items"].(map[string]interface{})
+		if !ok {
+			return "[any]", nil
+		}
+		// Items are named after the singular of the field, e.g. Port for ports
+		item := name
+		if strings.HasSuffix(item, "s") && !strings.HasSuffix(item, "ss") {
+			item = strings.TrimSuffix(item, "s")
+		}
+		return im.itemsType(parent, path+"[]", item, items)
+	case "object":
+		return im.objectType(parent, path, name, prop)
+	case nil:
+		if _, ok := prop["properties"]; ok {
+			return im.objectType(parent, path, name, prop)
+		}
+		return "any", nil
+	default:
+		im.warnf("%s: type %v is not supported", path, prop["type"])
+		return "any", nil
+	}
+}
+
+// objectType returns a nested schema for objects with properties, a dict
+// type for maps and {any:any} for objects with arbitrary fields
+func (im *importer) objectType(parent, path, name string, prop map[string]interface{}) (string, []string) {
+	if props := mapValue(prop["properties"]); len(props) > 0 {
+		if prop["additionalProperties"] != nil {
+			im.warnf("%s: additionalProperties next to properties is not supported", path)
+		}
+		return im.nestedSchema(parent, path, name, prop), nil
+	}
+
+	switch ap := prop["additionalProperties"].(type) {
+	case map[string]interface{}:
+		valueType := im.valueType(parent, path+"{}", name, ap)
+		if valueType == "any" {
+			return "{any:any}", nil
+		}
+		return "{str:" + valueType + "}", nil
+	case bool:
+		if ap {
+			return "{any:any}", []string{"@additionalProperties"}
+		}
+	}
+	if prop["x-kubernetes-preserve-unknown-fields"] != true {
+		im.warnf("%s: object without properties is imported as {any:any}, which allows any properties", path)
+	}
+	return "{any:any}", nil
+}
+
+// itemsType returns the list type for the items of an array
+func (im *importer) itemsType(parent, path, name string, items map[string]interface{}) (string, []string) {
+	var annotations []string
+	if items["x-kubernetes-preserve-unknown-fields"] == true {
+		annotations = append(annotations, "@itemsPreserveUnknownFields")
+	}
+	if format, ok := items["format"].(string); ok {
+		if quoted, ok := annotationString(format); ok {
+			annotations = append(annotations, "@itemsFormat("+quoted+")")
+		}
+	}
+
+	typ := ""
+	if items["type"] == "object" && len(mapValue(items["properties"])) == 0 && items["additionalProperties"] == nil {
+		// Arrays of arbitrary objects
+		typ = "{any:any}"
+	} else {
+		var itemAnnotations []string
+		typ, itemAnnotations = im.kclType(parent, path, name, items)
+		if len(itemAnnotations) > 0 {
+			im.warnf("%s: %s is not supported on array items", path, strings.Join(itemAnnotations, ", "))
+		}
+	}
+
+	for _, key := range sortedKeys(items) {
+		switch key {
+		case "type", "properties", "required", "items", "additionalProperties", "x-kubernetes-int-or-string",
+			"x-kubernetes-preserve-unknown-fields", "format", "description":
+		case "enum":
+			item := kclField{typ: typ}
+			im.enum(&item, path, items[key])
+			if item.typ == typ {
+				im.warnf("%s: enum on array items is only supported for values the type can list", path)
+			}
+			typ = item.typ
+		default:
+			im.warnf("%s: %s is not supported on array items", path, key)
+		}
+	}
+	return "[" + typ + "]", annotations
+}
+
+// valueType returns the value type of a map
+func (im *importer) valueType(parent, path, name string, value map[string]interface{}) string {
+	typ, annotations := im.kclType(parent, path, name, value)
+	if len(annotations) > 0 {
+		im.warnf("%s: %s is not supported on map values", path, strings.Join(annotations, ", "))
+	}
+	for _, key := range sortedKeys(value) {
+		switch key {
+		case "type", "properties", "required", "items", "additionalProperties", "x-kubernetes-int-or-string", "description":
+		case "enum":
+			v := kclField{typ: typ}
+			im.enum(&v, path, value[key])
+			if v.typ == typ {
+				im.warnf("%s: enum on map values is only supported for values the type can list", path)
+			}
+			typ = v.typ
+		default:
+			im.warnf("%s: %s is not supported on map values", path, key)
+		}
+	}
+	if strings.Contains(typ, " | ") {
+		return "(" + typ + ")"
+	}
+	return typ
+}
+
+// nestedSchema returns the name of the schema for an object with properties.
+// Objects with the same properties share a schema.
+func (im *importer) nestedSchema(parent, path, name string, obj map[string]interface{}) string {
+	structure, err := yaml.Marshal(map[string]interface{}{
+		"properties": obj["properties"],
+		"required":   obj["required"],
+	})
+	if err == nil {
+		if existing, ok := im.structures[string(structure)]; ok {
+			return existing
+		}
+	}
+
+	schema := &kclSchema{name: im.schemaName(parent, name)}
+	im.schemaNames[schema.name] = true
+	if im.structures == nil {
+		im.structures = make(map[string]string)
+	}
+	if err == nil {
+		im.structures[string(structure)] = schema.name
+	}
+	im.schemas = append(im.schemas, schema)
+	schema.fields = im.objectFields(schema.name, path, obj, "")
+	return schema.name
+}
+
+// schemaName returns an unused schema name for a field: the field name in
+// PascalCase, prefixed with the parent schema's name if that is taken
+func (im *importer) schemaName(parent, field string) string {
+	base := pascalCase(field)
+	if base == "" || base[0] >= '0' && base[0] <= '9' {
+		base = "Object" + base
+	}
+	candidates := []string{base, parent + base}
+	for _, candidate := range candidates {
+		if !im.schemaNames[candidate] && !parser.IsKeyword(candidate) {
+			return candidate
+		}
+	}
+	for i := 2; ; i++ {
+		if candidate := fmt.Sprintf("%s%s%d", parent, base, i); !im.schemaNames[candidate] {
+			return candidate
+		}
+	}
+}
+
+// write writes the schema as KCL
+func (s *kclSchema) write(buf *bytes.Buffer) {
+	for _, annotation := range s.annotations {
+		fmt.Fprintf(buf, "# %s\n", annotation)
+	}
+	fmt.Fprintf(buf, "schema %s:\n", s.name)
+	if len(s.fields) == 0 {
+		fmt.Fprintf(buf, "    \"\"\"%s has no fields\"\"\"\n", s.name)
+	}
+	for i, f := range s.fields {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		if f.description != "" {
+			for _, line := range strings.Split(f.description, "\n") {
+				fmt.Fprintf(buf, "    %s\n", strings.TrimRight("# "+line, " "))
+			}
+		}
+		for _, annotation := range f.annotations {
+			fmt.Fprintf(buf, "    # %s\n", annotation)
+		}
+		optional := ""
+		if f.optional {
+			optional = "?"
+		}
+		fmt.Fprintf(buf, "    %s%s: %s", f.name, optional, f.typ)
+		if f.def != "" {
+			fmt.Fprintf(buf, " = %s", f.def)
+		}
+		buf.WriteString("\n")
+	}
+}
+
+// keywords lists the schema keywords in the order their annotations are
+// written; other keywords follow alphabetically
+var keywords = []string{
+	"description", "default", "pattern", "minLength", "maxLength", "minimum", "exclusiveMinimum",
+	"maximum", "exclusiveMaximum", "multipleOf", "minItems", "maxItems", "uniqueItems",
+	"minProperties", "maxProperties", "format", "enum", "nullable", "x-kubernetes-immutable",
+	"x-kubernetes-validations", "x-kubernetes-preserve-unknown-fields", "x-kubernetes-embedded-resource",
+	"x-kubernetes-map-type", "x-kubernetes-list-type", "x-kubernetes-list-map-keys", "oneOf", "anyOf",
+}
+
+// keywordOrder returns the keywords of a property schema in annotation order
+func keywordOrder(prop map[string]interface{}) []string {
+	var keys []string
+	known := make(map[string]bool)
+	for _, k := range keywords {
+		known[k] = true
+		if _, ok := prop[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range sortedKeys(prop) {
+		if !known[k] {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// number returns the value of a YAML integer or float
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mapValue returns a YAML mapping, or nil
+func mapValue(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// stringList returns the strings of a YAML sequence
+func stringList(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var result []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,606 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// argKind is the form of the arguments an annotation takes
+type argKind int
+
+const (
+	argNone         argKind = iota // @immutable
+	argCount                       // @minLength(3): a non-negative integer
+	argNumber                      // @minimum(-0.5)
+	argPositive                    // @multipleOf(0.5): a positive number
+	argString                      // @pattern("^[a-z]+$")
+	argList                        // @enum(["a", "b"])
+	argCombinations                // @oneOf([["a"], ["b"]])
+	argValidate                    // @validate("rule", "message", reason="...")
+	argXRD                         // @xrd or @xrd(version="v1", served=True)
+	argCustom                      // registered with RegisterAnnotation; checked by its handler
+)
+
+// typeSet is a set of KCL attribute types an annotation applies to
+type typeSet int
+
+const (
+	typeStr typeSet = 1 << iota
+	typeInt
+	typeFloat
+	typeBool
+	typeList
+	typeDict
+	typeSchema
+
+	typeNumber = typeInt | typeFloat
+	typeObject = typeDict | typeSchema
+	typeAll    = typeStr | typeNumber | typeBool | typeList | typeObject
+)
+
+// typeSetNames name the types of a typeSet in messages
+var typeSetNames = []struct {
+	set  typeSet
+	name string
+}{
+	{typeStr, "str"},
+	{typeInt, "int"},
+	{typeFloat, "float"},
+	{typeBool, "bool"},
+	{typeList, "list"},
+	{typeDict, "dict"},
+	{typeSchema, "schema"},
+}
+
+// String lists the types, e.g. "int or float"
+func (s typeSet) String() string {
+	var names []string
+	for _, t := range typeSetNames {
+		if s&t.set != 0 {
+			names = append(names, t.name)
+		}
+	}
+	if len(names) <= 1 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// typeSetOf returns the type of an attribute's type expression. Unions,
+// literal types and any may hold values of every type.
+func typeSetOf(typ string) typeSet {
+	switch {
+	case typ == "str":
+		return typeStr
+	case typ == "int":
+		return typeInt
+	case typ == "float":
+		return typeFloat
+	case typ == "bool":
+		return typeBool
+	case strings.HasPrefix(typ, "["):
+		return typeList
+	case strings.HasPrefix(typ, "{"):
+		return typeDict
+	case typ == "any" || strings.Contains(typ, "|") || !typeNameRegex.MatchString(typ[:1]):
+		return typeAll
+	case typ == "True" || typ == "False" || typ == "None":
+		return typeAll
+	}
+	// A schema, of this file or imported
+	return typeSchema
+}
+
+// placement is where an annotation may be written
+type placement int
+
+const (
+	onAttribute placement = 1 << iota
+	onSchema
+)
+
+// annotationSpec describes an annotation: the arguments it takes, where it
+// may be written, the types of the attributes it applies to and how it
+// applies to a field or schema
+type annotationSpec struct {
+	args       argKind
+	values     []string // the values an argString annotation accepts, if fixed
+	on         placement
+	types      typeSet // for annotations on attributes
+	repeatable bool
+	field      func(f *Field, a parsedAnnotation)  // applies the annotation to an attribute's field
+	schema     func(s *Schema, a parsedAnnotation) // applies the annotation to a schema
+}
+
+// parsedAnnotation is an annotation with its arguments parsed as its
+// argKind describes
+type parsedAnnotation struct {
+	Annotation
+	count        int               // argCount
+	number       float64           // argNumber and argPositive
+	str          string            // argString, without the quotes
+	list         []string          // argList
+	combinations [][]string        // argCombinations
+	validation   CELValidation     // argValidate
+	keywords     map[string]string // argXRD
+}
+
+// annotations is the registry of the annotations kcl2xrd understands.
+// @spec.<path> is looked up as "spec.".
+var annotations = map[string]annotationSpec{
+	"xrd": {args: argXRD, on: onSchema,
+		schema: func(s *Schema, a parsedAnnotation) {
+			s.IsXRD = true
+			applyXRDArgs(s, a.keywords)
+		}},
+	"status": {args: argNone, on: onSchema | onAttribute, types: typeAll,
+		field:  func(f *Field, a parsedAnnotation) { f.IsStatus = true },
+		schema: func(s *Schema, a parsedAnnotation) { s.IsStatus = true }},
+	"spec": {args: argNone, on: onAttribute, types: typeAll,
+		field: func(f *Field, a parsedAnnotation) { f.IsSpec = true }},
+	"spec.": {args: argNone, on: onSchema,
+		schema: func(s *Schema, a parsedAnnotation) {
+			s.SpecPath, _, _ = strings.Cut(strings.TrimPrefix(a.Name, "spec."), ".")
+		}},
+	"pattern": {args: argString, on: onAttribute, types: typeStr,
+		field: func(f *Field, a parsedAnnotation) { f.Pattern = a.str }},
+	"minLength": {args: argCount, on: onAttribute, types: typeStr,
+		field: func(f *Field, a parsedAnnotation) { f.MinLength = &a.count }},
+	"maxLength": {args: argCount, on: onAttribute, types: typeStr,
+		field: func(f *Field, a parsedAnnotation) { f.MaxLength = &a.count }},
+	"minimum": {args: argNumber, on: onAttribute, types: typeNumber,
+		field: func(f *Field, a parsedAnnotation) { f.Minimum, f.ExclusiveMinimum = &a.number, false }},
+	"maximum": {args: argNumber, on: onAttribute, types: typeNumber,
+		field: func(f *Field, a parsedAnnotation) { f.Maximum, f.ExclusiveMaximum = &a.number, false }},
+	"exclusiveMinimum": {args: argNumber, on: onAttribute, types: typeNumber,
+		field: func(f *Field, a parsedAnnotation) { f.Minimum, f.ExclusiveMinimum = &a.number, true }},
+	"exclusiveMaximum": {args: argNumber, on: onAttribute, types: typeNumber,
+		field: func(f *Field, a parsedAnnotation) { f.Maximum, f.ExclusiveMaximum = &a.number, true }},
+	"multipleOf": {args: argPositive, on: onAttribute, types: typeNumber,
+		field: func(f *Field, a parsedAnnotation) { f.MultipleOf = &a.number }},
+	"minItems": {args: argCount, on: onAttribute, types: typeList,
+		field: func(f *Field, a parsedAnnotation) { f.MinItems = &a.count }},
+	"maxItems": {args: argCount, on: onAttribute, types: typeList,
+		field: func(f *Field, a parsedAnnotation) { f.MaxItems = &a.count }},
+	"uniqueItems": {args: argNone, on: onAttribute, types: typeList,
+		field: func(f *Field, a parsedAnnotation) { f.UniqueItems = true }},
+	"minProperties": {args: argCount, on: onAttribute, types: typeObject,
+		field: func(f *Field, a parsedAnnotation) { f.MinProperties = &a.count }},
+	"maxProperties": {args: argCount, on: onAttribute, types: typeObject,
+		field: func(f *Field, a parsedAnnotation) { f.MaxProperties = &a.count }},
+	"nullable": {args: argNone, on: onAttribute, types: typeAll,
+		field: func(f *Field, a parsedAnnotation) { f.Nullable = true }},
+	"format": {args: argString, on: onAttribute, types: typeStr | typeNumber,
+		field: func(f *Field, a parsedAnnotation) { f.Format = a.str }},
+	"itemsFormat": {args: argString, on: onAttribute, types: typeList,
+		field: func(f *Field, a parsedAnnotation) { f.ItemsFormat = a.str }},
+	"enum": {args: argList, on: onAttribute, types: typeStr | typeNumber,
+		field: func(f *Field, a parsedAnnotation) { f.Enum = a.list }},
+	"immutable": {args: argNone, on: onAttribute, types: typeAll,
+		field: func(f *Field, a parsedAnnotation) { f.Immutable = true }},
+	"immutableOnceSet": {args: argNone, on: onAttribute, types: typeAll,
+		field: func(f *Field, a parsedAnnotation) { f.ImmutableOnceSet = true }},
+	"appendOnly": {args: argNone, on: onAttribute, types: typeList,
+		field: func(f *Field, a parsedAnnotation) { f.AppendOnly = true }},
+	"validate": {args: argValidate, on: onSchema | onAttribute, types: typeAll, repeatable: true,
+		field:  func(f *Field, a parsedAnnotation) { f.CELValidations = append(f.CELValidations, a.validation) },
+		schema: func(s *Schema, a parsedAnnotation) { s.CELValidations = append(s.CELValidations, a.validation) }},
+	"preserveUnknownFields": {args: argNone, on: onAttribute, types: typeList | typeObject,
+		field: func(f *Field, a parsedAnnotation) { f.PreserveUnknownFields = true }},
+	"itemsPreserveUnknownFields": {args: argNone, on: onAttribute, types: typeList,
+		field: func(f *Field, a parsedAnnotation) { f.ItemsPreserveUnknownFields = true }},
+	"additionalProperties": {args: argNone, on: onAttribute, types: typeObject,
+		field: func(f *Field, a parsedAnnotation) { f.AdditionalPropertiesAnnotation = true }},
+	"intOrString": {args: argNone, on: onAttribute, types: typeStr | typeInt,
+		field: func(f *Field, a parsedAnnotation) { f.IntOrString = true }},
+	"embeddedResource": {args: argNone, on: onAttribute, types: typeObject,
+		field: func(f *Field, a parsedAnnotation) { f.EmbeddedResource = true }},
+	"mapType": {args: argString, values: []string{"granular", "atomic"}, on: onAttribute, types: typeObject,
+		field: func(f *Field, a parsedAnnotation) { f.MapType = a.str }},
+	"listType": {args: argString, values: []string{"atomic", "set", "map"}, on: onAttribute, types: typeList,
+		field: func(f *Field, a parsedAnnotation) { f.ListType = a.str }},
+	"listMapKeys": {args: argList, on: onAttribute, types: typeList,
+		field: func(f *Field, a parsedAnnotation) { f.ListMapKeys = a.list }},
+	"oneOf": {args: argCombinations, on: onSchema | onAttribute, types: typeObject,
+		field:  func(f *Field, a parsedAnnotation) { f.OneOf = a.combinations },
+		schema: func(s *Schema, a parsedAnnotation) { s.OneOf = a.combinations }},
+	"anyOf": {args: argCombinations, on: onSchema | onAttribute, types: typeObject,
+		field:  func(f *Field, a parsedAnnotation) { f.AnyOf = a.combinations },
+		schema: func(s *Schema, a parsedAnnotation) { s.AnyOf = a.combinations }},
+}
+
+// lookupAnnotation returns the registry entry of an annotation name without
+// the @, built in or registered with RegisterAnnotation
+func lookupAnnotation(name string) (annotationSpec, bool) {
+	if strings.HasPrefix(name, "spec.") {
+		name = "spec."
+	}
+	if spec, ok := annotations[name]; ok {
+		return spec, true
+	}
+	if IsRegisteredAnnotation(name) {
+		// Recorded for the handlers, which run once the schema is built
+		return annotationSpec{args: argCustom, on: onAttribute, types: typeAll,
+			field: func(f *Field, a parsedAnnotation) { f.Annotations = append(f.Annotations, a.Annotation) }}, true
+	}
+	return annotationSpec{}, false
+}
+
+// Annotation is a custom annotation written on a schema attribute, e.g.
+// `@team("payments")`
+type Annotation struct {
+	Name   string // without the @, e.g. team
+	Args   string // argument list as written, without the parentheses, e.g. "payments" with its quotes
+	Line   int
+	Column int
+}
+
+// AnnotationHandler applies a custom annotation to the field it is written
+// on. A returned error is reported at the annotation and fails the parse.
+type AnnotationHandler func(field *Field, annotation Annotation) error
+
+var (
+	customAnnotationsMu sync.RWMutex
+	// customAnnotations are the annotations registered with
+	// RegisterAnnotation; the handler is nil for annotations only recorded
+	// in Field.Annotations
+	customAnnotations = make(map[string]AnnotationHandler)
+)
+
+var customAnnotationNameRegex = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// RegisterAnnotation registers a custom annotation for schema attributes,
+// like @costCenter or @team("payments"). Registered annotations are accepted
+// wherever an attribute annotation is, written at most once per attribute,
+// and recorded in Field.Annotations for the generator. The handler, if not
+// nil, is called for every field the annotation is written on, before
+// defaults are evaluated; it checks the arguments itself.
+//
+// Built-in annotations cannot be replaced, and a name can be registered
+// once. RegisterAnnotation is meant to be called from init functions.
+func RegisterAnnotation(name string, handler AnnotationHandler) error {
+	if !customAnnotationNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid annotation name %q", name)
+	}
+	if _, ok := annotations[name]; ok || name == "spec" {
+		return fmt.Errorf("@%s is a built-in annotation", name)
+	}
+	customAnnotationsMu.Lock()
+	defer customAnnotationsMu.Unlock()
+	if _, ok := customAnnotations[name]; ok {
+		return fmt.Errorf("annotation @%s is already registered", name)
+	}
+	customAnnotations[name] = handler
+	return nil
+}
+
+// IsRegisteredAnnotation reports whether a custom annotation is registered
+func IsRegisteredAnnotation(name string) bool {
+	customAnnotationsMu.RLock()
+	defer customAnnotationsMu.RUnlock()
+	_, ok := customAnnotations[name]
+	return ok
+}
+
+// applyAnnotationHandlers calls the handlers of the custom annotations of a
+// schema's fields
+func applyAnnotationHandlers(filename string, schema *Schema) Diagnostics {
+	var diagnostics Diagnostics
+	for i := range schema.Fields {
+		field := &schema.Fields[i]
+		for _, annotation := range field.Annotations {
+			customAnnotationsMu.RLock()
+			handler := customAnnotations[annotation.Name]
+			customAnnotationsMu.RUnlock()
+			if handler == nil {
+				continue
+			}
+			if err := handler(field, annotation); err != nil {
+				diagnostics = append(diagnostics, errorf(filename, annotation.Line, annotation.Column, "@%s: %v", annotation.Name, err))
+			}
+		}
+	}
+	return diagnostics
+}
+
+var (
+	annotationNameRegex = regexp.MustCompile(`^@([A-Za-z_][\w.]*)`)
+	countArgRegex       = regexp.MustCompile(`^\d+$`)
+	numberArgRegex      = regexp.MustCompile(`^` + numberRegex + `$`)
+	stringArgRegex      = regexp.MustCompile(`^(?:` + quotedRegex + `)$`)
+)
+
+// xrdArgs are the arguments @xrd takes, and whether they are booleans
+var xrdArgs = map[string]bool{
+	"version":            false,
+	"served":             true,
+	"referenceable":      true,
+	"deprecated":         true,
+	"deprecationWarning": false,
+}
+
+// annotationName returns the name of an annotation comment with its @,
+// e.g. @minimum for `@minimum(0)`
+func annotationName(text string) string {
+	if m := annotationNameRegex.FindString(text); m != "" {
+		return m
+	}
+	return text
+}
+
+// splitAnnotation splits an annotation comment into its name, without the
+// @, and its argument list without the parentheses. hasArgs is false for an
+// annotation without parentheses; closed is false if they are not closed.
+func splitAnnotation(text string) (name, args string, hasArgs, closed bool) {
+	m := annotationNameRegex.FindStringSubmatch(text)
+	if m == nil {
+		return "", "", false, true
+	}
+	name = m[1]
+	rest := strings.TrimSpace(text[len(m[0]):])
+	if !strings.HasPrefix(rest, "(") {
+		return name, "", false, true
+	}
+	end := strings.LastIndex(rest, ")")
+	if end < 0 {
+		return name, rest[1:], true, false
+	}
+	return name, strings.TrimSpace(rest[1:end]), true, true
+}
+
+// checkAnnotations reports the annotations of a schema statement and its
+// attributes that are unknown, written twice, written where they have no
+// effect, or whose arguments do not have the form the annotation takes.
+// Such annotations would otherwise be ignored, and the schema be weaker than
+// declared.
+func checkAnnotations(filename string, stmt *schemaStmt, aliases map[string][]token) Diagnostics {
+	var diagnostics Diagnostics
+	check := func(comments []comment, on placement, typ string) {
+		seen := make(map[string]bool)
+		for _, c := range comments {
+			if !strings.HasPrefix(c.Text, "@") {
+				continue
+			}
+			if message := annotationError(c, on, typ, seen); message != "" {
+				diagnostics = append(diagnostics, errorf(filename, c.Line, c.Col, "%s", message))
+			}
+		}
+	}
+	check(stmt.comments, onSchema, "")
+	for _, attr := range stmt.attrs {
+		// Untyped assignments only override an inherited default
+		if len(attr.typ) > 0 {
+			check(attr.comments, onAttribute, typeExprString(expandAliases(attr.typ, aliases)))
+		}
+	}
+	return diagnostics
+}
+
+// annotationError describes what is wrong with an annotation written on a
+// schema or on an attribute of type typ, or returns "" if nothing is. seen
+// holds the annotations written before it on the same schema or attribute.
+func annotationError(c comment, on placement, typ string, seen map[string]bool) string {
+	name, _, _, _ := splitAnnotation(c.Text)
+	spec, ok := lookupAnnotation(name)
+	if !ok {
+		if suggestion := suggestAnnotation(name); suggestion != "" {
+			return "unknown annotation @" + name + "; did you mean @" + suggestion + "?"
+		}
+		return "unknown annotation @" + name
+	}
+	if message := annotationArgsError(c); message != "" {
+		return message
+	}
+
+	at := "@" + name
+	if spec.on&on == 0 {
+		if on == onSchema {
+			return at + " applies to attributes, not schemas"
+		}
+		return at + " applies to schemas, not attributes"
+	}
+	if seen[name] && !spec.repeatable {
+		return "duplicate annotation " + at
+	}
+	seen[name] = true
+	if on == onAttribute && spec.types&typeSetOf(typ) == 0 {
+		return at + " applies to " + spec.types.String() + " attributes, not " + typ
+	}
+	return ""
+}
+
+// suggestAnnotation returns the known annotation closest to a misspelled
+// name, or "" if none is close
+func suggestAnnotation(name string) string {
+	best, bestDistance := "", 3
+	if len(name) <= 4 {
+		bestDistance = 2
+	}
+	names := make([]string, 0, len(annotations))
+	for known := range annotations {
+		names = append(names, known)
+	}
+	customAnnotationsMu.RLock()
+	for known := range customAnnotations {
+		names = append(names, known)
+	}
+	customAnnotationsMu.RUnlock()
+	for _, known := range names {
+		if known == "spec." {
+			continue
+		}
+		// Case differences, like @maxlength, are the closest misspellings
+		distance := editDistance(strings.ToLower(name), strings.ToLower(known))
+		if name != known && strings.EqualFold(name, known) {
+			distance = 0
+		}
+		if distance < bestDistance || (distance == bestDistance && best != "" && known < best) {
+			best, bestDistance = known, distance
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// annotationArgsError describes what is wrong with the arguments of an
+// annotation, or returns "" if they are fine or the annotation is unknown
+func annotationArgsError(c comment) string {
+	name, _, _, _ := splitAnnotation(c.Text)
+	spec, known := lookupAnnotation(name)
+	if !known {
+		return ""
+	}
+	_, message := parseAnnotation(c, spec)
+	return message
+}
+
+// parseAnnotation parses the arguments of an annotation as the argKind of
+// its registry entry describes. It returns what is wrong with them, or "" if
+// they are fine.
+func parseAnnotation(c comment, spec annotationSpec) (parsedAnnotation, string) {
+	name, args, hasArgs, closed := splitAnnotation(c.Text)
+	a := parsedAnnotation{Annotation: Annotation{Name: name, Args: args, Line: c.Line, Column: c.Col}}
+	kind := spec.args
+	at := "@" + name
+	if !closed {
+		return a, at + " is missing the closing parenthesis"
+	}
+
+	switch kind {
+	case argNone:
+		if hasArgs {
+			return a, at + " takes no arguments"
+		}
+		return a, ""
+	case argXRD:
+		if !hasArgs {
+			return a, ""
+		}
+		values := parseAnnotationArgs(args)
+		if len(values) == 0 {
+			return a, `@xrd takes keyword arguments, e.g. version="v1", got ` + args
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := values[key]
+			isBool, ok := xrdArgs[key]
+			if !ok {
+				return a, "@xrd has no argument " + key + "; it takes version, served, referenceable, deprecated and deprecationWarning"
+			}
+			if _, err := strconv.ParseBool(strings.ToLower(value)); isBool && err != nil {
+				return a, "@xrd argument " + key + " must be True or False, got " + value
+			}
+		}
+		a.keywords = values
+		return a, ""
+	case argCustom:
+		return a, ""
+	case argValidate:
+		validation, ok := parseCELValidation(c)
+		if !ok {
+			return a, "@validate takes a quoted CEL rule, an optional message and the arguments messageExpression, reason, fieldPath and optionalOldSelf"
+		}
+		a.validation = validation
+		return a, ""
+	}
+
+	if !hasArgs || args == "" {
+		return a, at + " requires an argument"
+	}
+	switch kind {
+	case argCount:
+		v, err := strconv.Atoi(args)
+		if !countArgRegex.MatchString(args) || err != nil {
+			return a, at + " takes a non-negative integer, got " + args
+		}
+		a.count = v
+	case argNumber:
+		v, err := strconv.ParseFloat(args, 64)
+		if !numberArgRegex.MatchString(args) || err != nil {
+			return a, at + " takes a number, got " + args
+		}
+		a.number = v
+	case argPositive:
+		v, err := strconv.ParseFloat(args, 64)
+		if !numberArgRegex.MatchString(args) || err != nil || v <= 0 {
+			return a, at + " takes a positive number, got " + args
+		}
+		a.number = v
+	case argString:
+		if !stringArgRegex.MatchString(args) {
+			return a, at + " takes a quoted string, got " + args
+		}
+		a.str = args[1 : len(args)-1]
+		if values := spec.values; values != nil && !slices.Contains(values, a.str) {
+			return a, at + " takes one of " + strings.Join(values, ", ") + ", got " + a.str
+		}
+	case argList:
+		if !strings.HasPrefix(args, "[") || !strings.HasSuffix(args, "]") || strings.TrimSpace(args[1:len(args)-1]) == "" {
+			return a, at + ` takes a list of values, e.g. ["a", "b"], got ` + args
+		}
+		for _, value := range strings.Split(args[1:len(args)-1], ",") {
+			a.list = append(a.list, strings.Trim(strings.TrimSpace(value), `"'`))
+		}
+	case argCombinations:
+		if strings.HasPrefix(args, "[") && strings.HasSuffix(args, "]") {
+			a.combinations = parseRequiredCombinations(args[1 : len(args)-1])
+		}
+		if len(a.combinations) == 0 {
+			return a, at + ` takes a list of field lists, e.g. [["a"], ["b", "c"]], got ` + args
+		}
+	}
+	return a, ""
+}
+
+// applyAnnotations applies the annotations of a schema statement or of an
+// attribute to the schema or field, as their registry entries describe.
+// Annotations checkAnnotations reports are skipped.
+func applyAnnotations(schema *Schema, field *Field, comments []comment) {
+	for _, c := range comments {
+		if !strings.HasPrefix(c.Text, "@") {
+			continue
+		}
+		name, _, _, _ := splitAnnotation(c.Text)
+		spec, ok := lookupAnnotation(name)
+		if !ok {
+			continue
+		}
+		a, message := parseAnnotation(c, spec)
+		switch {
+		case message != "":
+		case field != nil && spec.field != nil:
+			spec.field(field, a)
+		case field == nil && spec.schema != nil:
+			spec.schema(schema, a)
+		}
+	}
+}
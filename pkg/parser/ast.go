@@ -0,0 +1,780 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"kcl-lang.io/kcl-go/pkg/ast"
+	kclparser "kcl-lang.io/kcl-go/pkg/parser"
+)
+
+// This file builds the statement tree kcl2xrd converts from the AST of the
+// KCL parser: imports, module-level assignments and type aliases, and
+// schema/mixin/protocol statements with their attributes, decorators,
+// docstrings and check blocks. Types and expressions are taken from the
+// source spans of their nodes and tokenized, so that they read as written.
+// Comments are kept with their positions because the annotation layer lives
+// in comments: the full-line comments above a schema or attribute are
+// attached to it.
+
+// tokenKind identifies the lexical class of a token
+type tokenKind int
+
+const (
+	tokName tokenKind = iota
+	tokString
+	tokNumber
+	tokOp
+)
+
+// token is a lexical token of a type or expression
+type token struct {
+	kind  tokenKind
+	text  string
+	start int // byte offset of the first character
+	end   int // byte offset after the last character
+}
+
+// comment is a full-line comment attached to the statement that follows it
+type comment struct {
+	Text string
+	Line int
+	Col  int // column of Text
+}
+
+// kclModule is the statement tree of a KCL file
+type kclModule struct {
+	filename    string
+	imports     []importStmt
+	assigns     []assignStmt
+	aliases     map[string][]token // type aliases declared with `type Name = ...`
+	schemas     []*schemaStmt
+	diagnostics Diagnostics // statements and annotations that were not understood
+}
+
+// importStmt is an `import a.b.c [as d]` statement
+type importStmt struct {
+	path  string
+	alias string
+	line  int
+	col   int
+}
+
+// assignStmt is a module-level `name = value` or `name: T = value` statement
+type assignStmt struct {
+	name  string
+	value []token
+	line  int
+	col   int
+}
+
+// schemaStmt is a schema, mixin or protocol statement
+type schemaStmt struct {
+	kind       string // "schema", "mixin" or "protocol"
+	name       string
+	base       string   // parent schema, e.g. `schema Child(Base):`
+	mixins     []string // names listed in a `mixin [...]` statement
+	doc        string
+	comments   []comment // comments immediately preceding the statement
+	decorators []string  // KCL decorators such as @deprecated(...)
+	attrs      []*attrStmt
+	checks     []checkStmt
+	line       int
+	col        int
+}
+
+// attrStmt is a schema attribute declaration or an untyped attribute
+// assignment (an override of an inherited attribute default)
+type attrStmt struct {
+	name       string
+	optional   bool
+	typ        []token // type expression tokens, empty for untyped assignments
+	def        string  // normalized default expression
+	doc        string
+	comments   []comment
+	decorators []string
+	line       int
+	col        int
+}
+
+// checkStmt is one expression of a schema `check:` block
+type checkStmt struct {
+	expr    string
+	message string
+	line    int
+	col     int
+}
+
+// parseKCLSource parses KCL source with the KCL parser into a statement tree
+func parseKCLSource(filename, src string) (*kclModule, error) {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	module, err := kclparser.ParseFile(filename, src)
+	if err != nil {
+		return nil, Diagnostics{syntaxError(filename, err)}
+	}
+	b := newModuleBuilder(filename, src, module.Comments)
+	b.build(module.Body)
+	return b.mod, nil
+}
+
+// syntaxPositionRegex finds the position in a KCL syntax error, e.g.
+// ` --> main.k:3:12`
+var syntaxPositionRegex = regexp.MustCompile(`(?m)(?:^|--> )(\S+?):(\d+):(\d+)`)
+
+// syntaxError turns an error of the KCL parser into a diagnostic at the
+// position it names
+func syntaxError(filename string, err error) Diagnostic {
+	message := strings.TrimSpace(err.Error())
+	line, col := 0, 0
+	if m := syntaxPositionRegex.FindStringSubmatch(message); m != nil {
+		line, _ = strconv.Atoi(m[2])
+		col, _ = strconv.Atoi(m[3])
+		message = strings.TrimSpace(strings.Replace(message, m[0], "", 1))
+		message = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(message, "-->"), ":"))
+	}
+	if i := strings.IndexByte(message, '\n'); i >= 0 {
+		message = message[:i]
+	}
+	return errorf(filename, line, col, "%s", message)
+}
+
+// moduleBuilder builds the statement tree of a file from its AST
+type moduleBuilder struct {
+	mod        *kclModule
+	src        string
+	lineStarts []int     // byte offsets of the lines
+	comments   []comment // full-line comments in source order
+	next       int       // first comment not yet attached or dropped
+}
+
+func newModuleBuilder(filename, src string, comments []*ast.Node[ast.Comment]) *moduleBuilder {
+	b := &moduleBuilder{
+		mod:        &kclModule{filename: filename, aliases: make(map[string][]token)},
+		src:        src,
+		lineStarts: []int{0},
+	}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			b.lineStarts = append(b.lineStarts, i+1)
+		}
+	}
+	for _, c := range comments {
+		start := b.offset(c.Line, c.Column)
+		lineStart := b.lineStarts[c.Line-1]
+		// Trailing comments follow code on their line
+		if strings.TrimSpace(src[lineStart:start]) != "" || !strings.HasPrefix(src[start:], "#") {
+			continue
+		}
+		end := strings.IndexByte(src[start:], '\n')
+		if end < 0 {
+			end = len(src) - start
+		}
+		text := src[start+1 : start+end]
+		b.comments = append(b.comments, comment{
+			Text: strings.TrimSpace(text),
+			Line: int(c.Line),
+			Col:  int(c.Column) + 2 + len(text) - len(strings.TrimLeft(text, " \t")),
+		})
+	}
+	sort.SliceStable(b.comments, func(i, j int) bool { return b.comments[i].Line < b.comments[j].Line })
+	return b
+}
+
+// offset returns the byte offset of a line and column of the KCL parser:
+// lines are 1-based, columns count characters from 0
+func (b *moduleBuilder) offset(line, col int64) int {
+	if line < 1 {
+		return 0
+	}
+	if int(line) > len(b.lineStarts) {
+		return len(b.src)
+	}
+	off := b.lineStarts[line-1]
+	for ; col > 0 && off < len(b.src) && b.src[off] != '\n'; col-- {
+		_, size := utf8.DecodeRuneInString(b.src[off:])
+		off += size
+	}
+	return off
+}
+
+// text returns the source text of a node
+func (b *moduleBuilder) text(pos ast.Pos) string {
+	return b.src[b.offset(pos.Line, pos.Column):b.offset(pos.EndLine, pos.EndColumn)]
+}
+
+// tokens returns the tokens of a node and the source text they index
+func (b *moduleBuilder) tokens(pos ast.Pos) ([]token, string) {
+	text := b.text(pos)
+	toks, err := lexExpr(text)
+	if err != nil {
+		return nil, text
+	}
+	return toks, text
+}
+
+// expr returns the source text of a node on one line
+func (b *moduleBuilder) expr(pos ast.Pos) string {
+	toks, text := b.tokens(pos)
+	return tokensText(text, toks)
+}
+
+// take returns the comments above a line that are not attached yet
+func (b *moduleBuilder) take(line int64) []comment {
+	start := b.next
+	for b.next < len(b.comments) && int64(b.comments[b.next].Line) < line {
+		b.next++
+	}
+	return b.comments[start:b.next]
+}
+
+// drop warns about the annotations among the comments up to a line, like
+// those inside a statement that is not converted
+func (b *moduleBuilder) drop(line int64) {
+	b.mod.dropComments(b.take(line + 1))
+}
+
+// startLine returns the first line of a statement, its decorators included
+func startLine(n *ast.Node[ast.Stmt]) int64 {
+	line := n.Line
+	var decorators []*ast.Node[ast.Decorator]
+	switch s := n.Node.(type) {
+	case *ast.SchemaStmt:
+		decorators = s.Decorators
+	case *ast.SchemaAttr:
+		decorators = s.Decorators
+	}
+	for _, d := range decorators {
+		if d.Line > 0 && d.Line < line {
+			line = d.Line
+		}
+	}
+	return line
+}
+
+// build adds the module-level statements kcl2xrd converts. Statements in
+// compound statements, like if blocks, are not interpreted.
+func (b *moduleBuilder) build(body []*ast.Node[ast.Stmt]) {
+	for _, n := range body {
+		comments := b.take(startLine(n))
+		switch s := n.Node.(type) {
+		case *ast.SchemaStmt:
+			schema := b.schema(s)
+			schema.comments = comments
+			b.mod.schemas = append(b.mod.schemas, schema)
+		case *ast.ImportStmt:
+			b.mod.dropComments(comments)
+			b.mod.imports = append(b.mod.imports, b.importStmt(n, s))
+		case *ast.TypeAliasStmt:
+			b.mod.dropComments(comments)
+			name := strings.TrimSpace(b.text(s.TypeName.Pos))
+			if s.Ty != nil {
+				b.mod.aliases[name], _ = b.tokens(s.Ty.Pos)
+			} else if s.TypeValue != nil {
+				b.mod.aliases[name], _ = lexExpr(s.TypeValue.Node)
+			}
+		case *ast.AssignStmt:
+			b.mod.dropComments(comments)
+			if len(s.Targets) == 1 {
+				assign := assignStmt{name: strings.TrimSpace(b.text(s.Targets[0].Pos)), line: int(n.Line), col: int(n.Column) + 1}
+				if s.Value != nil {
+					assign.value, _ = b.tokens(s.Value.Pos)
+				}
+				b.mod.assigns = append(b.mod.assigns, assign)
+			}
+		default:
+			b.mod.dropComments(comments)
+		}
+		b.drop(n.EndLine)
+	}
+	b.drop(int64(len(b.lineStarts)))
+}
+
+// importStmt converts `import a.b.c [as d]`
+func (b *moduleBuilder) importStmt(n *ast.Node[ast.Stmt], s *ast.ImportStmt) importStmt {
+	stmt := importStmt{line: int(n.Line), col: int(n.Column) + 1}
+	if s.Path != nil {
+		stmt.path = s.Path.Node
+	}
+	if s.Asname != nil && s.Asname.Node != "" {
+		stmt.alias = s.Asname.Node
+	} else {
+		parts := strings.Split(stmt.path, ".")
+		stmt.alias = parts[len(parts)-1]
+	}
+	return stmt
+}
+
+// decorator returns the source of a decorator, e.g. `@deprecated(...)`
+func (b *moduleBuilder) decorator(d *ast.Node[ast.Decorator]) string {
+	text := b.expr(d.Pos)
+	if !strings.HasPrefix(text, "@") {
+		text = "@" + text
+	}
+	return text
+}
+
+// schema converts a schema, mixin or protocol statement. Statements of its
+// body it does not convert, like conditional attributes, are reported as
+// warnings.
+func (b *moduleBuilder) schema(s *ast.SchemaStmt) *schemaStmt {
+	schema := &schemaStmt{kind: "schema", name: s.Name.Node, line: int(s.Name.Line)}
+	switch {
+	case s.IsMixin:
+		schema.kind = "mixin"
+	case s.IsProtocol:
+		schema.kind = "protocol"
+	}
+	// The statement starts the line of its name
+	header := b.src[b.lineStarts[s.Name.Line-1]:]
+	schema.col = len(header) - len(strings.TrimLeft(header, " \t")) + 1
+	if s.ParentName != nil {
+		toks, _ := b.tokens(s.ParentName.Pos)
+		schema.base = typeExprString(toks)
+	}
+	for _, mixin := range s.Mixins {
+		toks, _ := b.tokens(mixin.Pos)
+		schema.mixins = append(schema.mixins, typeExprString(toks))
+	}
+	for _, d := range s.Decorators {
+		schema.decorators = append(schema.decorators, b.decorator(d))
+	}
+	if s.Doc != nil && s.Doc.Line > 0 {
+		schema.doc = docstringText(b.text(s.Doc.Pos))
+	}
+
+	// Check blocks are not part of the body; their expressions are taken in
+	// line order with it so that comments are attached to the right statement
+	checks := s.Checks
+	takeChecks := func(before int64) {
+		for len(checks) > 0 && checks[0].Line < before {
+			b.mod.dropComments(b.take(checks[0].Line))
+			schema.checks = append(schema.checks, b.check(checks[0]))
+			b.drop(checks[0].EndLine)
+			checks = checks[1:]
+		}
+	}
+
+	var lastAttr *attrStmt
+	for i, n := range s.Body {
+		takeChecks(startLine(n))
+		comments := b.take(startLine(n))
+		switch stmt := n.Node.(type) {
+		case *ast.SchemaAttr:
+			attr := b.attr(stmt)
+			attr.comments = comments
+			schema.attrs = append(schema.attrs, attr)
+			lastAttr = attr
+			b.drop(n.EndLine)
+			continue
+		case *ast.AssignStmt:
+			// An untyped assignment overrides the default of an inherited attribute
+			if len(stmt.Targets) == 1 && stmt.Value != nil {
+				name := strings.TrimSpace(b.text(stmt.Targets[0].Pos))
+				if toks, _ := lexExpr(name); len(toks) == 1 && toks[0].kind == tokName {
+					attr := &attrStmt{
+						name:     strings.TrimPrefix(name, "$"),
+						def:      b.expr(stmt.Value.Pos),
+						comments: comments,
+						line:     int(n.Line),
+						col:      int(n.Column) + 1,
+					}
+					schema.attrs = append(schema.attrs, attr)
+					lastAttr = attr
+					b.drop(n.EndLine)
+					continue
+				}
+			}
+		case *ast.ExprStmt:
+			// Docstrings: of the schema if it comes first, else of the attribute above
+			if toks, text := b.tokens(n.Pos); len(toks) == 1 && toks[0].kind == tokString {
+				if i == 0 && schema.doc == "" {
+					schema.doc = docstringText(text)
+				} else if lastAttr != nil && lastAttr.doc == "" {
+					lastAttr.doc = docstringText(text)
+				}
+				b.mod.dropComments(comments)
+				continue
+			}
+		case *ast.IfStmt:
+			b.mod.warn(int(n.Line), int(n.Column)+1, "attributes in if blocks are not converted")
+			b.mod.dropComments(comments)
+			b.drop(n.EndLine)
+			continue
+		}
+		b.mod.warn(int(n.Line), int(n.Column)+1, "cannot parse `%s`; the statement is ignored", b.expr(n.Pos))
+		// The statement's annotations must not apply to the next attribute
+		b.mod.dropComments(comments)
+		b.drop(n.EndLine)
+	}
+	takeChecks(int64(len(b.lineStarts)) + 1)
+	return schema
+}
+
+// attr converts an attribute declaration `[$]name[?]: type [= default]`
+func (b *moduleBuilder) attr(s *ast.SchemaAttr) *attrStmt {
+	attr := &attrStmt{
+		name:     strings.TrimPrefix(s.Name.Node, "$"),
+		optional: s.IsOptional,
+		line:     int(s.Name.Line),
+		col:      int(s.Name.Column) + 1,
+	}
+	// The position of a $-prefixed name may start after the $
+	if attr.col > 1 && b.src[b.offset(s.Name.Line, s.Name.Column)-1] == '$' {
+		attr.col--
+	}
+	if s.Ty != nil {
+		attr.typ, _ = b.tokens(s.Ty.Pos)
+	}
+	if s.Value != nil {
+		attr.def = b.expr(s.Value.Pos)
+	}
+	for _, d := range s.Decorators {
+		attr.decorators = append(attr.decorators, b.decorator(d))
+	}
+	return attr
+}
+
+// check converts an expression of a check block with its optional condition
+// and message
+func (b *moduleBuilder) check(n *ast.Node[ast.CheckExpr]) checkStmt {
+	c := n.Node
+	test := n.Pos
+	if c.Test != nil {
+		test = c.Test.Pos
+	}
+	stmt := checkStmt{line: int(test.Line), col: int(test.Column) + 1}
+	if c.IfCond != nil {
+		// `expr if cond`: the condition is part of the expression translated
+		test.EndLine, test.EndColumn = c.IfCond.EndLine, c.IfCond.EndColumn
+	}
+	stmt.expr = b.expr(test)
+	if c.Msg != nil {
+		if toks, _ := b.tokens(c.Msg.Pos); len(toks) > 0 {
+			stmt.message = stringLiteralValue(toks[0].text)
+		}
+	}
+	return stmt
+}
+
+// lexExpr splits the source of a type or expression into tokens. Line
+// breaks, line continuations and comments are skipped.
+func lexExpr(src string) ([]token, error) {
+	var toks []token
+	pos := 0
+	for pos < len(src) {
+		c := src[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\n' || c == '\\':
+			pos++
+		case c == '#':
+			for pos < len(src) && src[pos] != '\n' {
+				pos++
+			}
+		case c == '"' || c == '\'':
+			tok, err := lexString(src, pos, pos)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, tok)
+			pos = tok.end
+		case isDigit(c) || (c == '.' && pos+1 < len(src) && isDigit(src[pos+1])):
+			start := pos
+			for pos < len(src) && (isIdentChar(src[pos]) || src[pos] == '.' ||
+				((src[pos] == '+' || src[pos] == '-') && (src[pos-1] == 'e' || src[pos-1] == 'E'))) {
+				pos++
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[start:pos], start: start, end: pos})
+		case isIdentStart(c):
+			start := pos
+			pos++
+			for pos < len(src) && isIdentChar(src[pos]) {
+				pos++
+			}
+			word := src[start:pos]
+			// String prefixes such as r"..." and b'...'
+			if pos < len(src) && (src[pos] == '"' || src[pos] == '\'') && isStringPrefix(word) {
+				tok, err := lexString(src, start, pos)
+				if err != nil {
+					return nil, err
+				}
+				toks = append(toks, tok)
+				pos = tok.end
+				continue
+			}
+			toks = append(toks, token{kind: tokName, text: word, start: start, end: pos})
+		default:
+			op := lexOperator(src[pos:])
+			toks = append(toks, token{kind: tokOp, text: op, start: pos, end: pos + len(op)})
+			pos += len(op)
+		}
+	}
+	return toks, nil
+}
+
+// warn records a warning about the statement or comment at a position
+func (m *kclModule) warn(line, col int, format string, args ...interface{}) {
+	m.diagnostics = append(m.diagnostics, warningf(m.filename, line, col, format, args...))
+}
+
+// dropComments warns about the annotations among comments that are not
+// attached to a schema or attribute, and so have no effect
+func (m *kclModule) dropComments(comments []comment) {
+	for _, c := range comments {
+		if strings.HasPrefix(c.Text, "@") {
+			m.warn(c.Line, c.Col, "annotation %s is not attached to a schema or attribute and has no effect", annotationName(c.Text))
+		}
+	}
+}
+
+// lexString scans a (possibly prefixed and/or triple-quoted) string literal.
+// start is the offset of the prefix, quotePos the offset of the opening quote.
+func lexString(src string, start, quotePos int) (token, error) {
+	q := src[quotePos]
+	triple := strings.HasPrefix(src[quotePos:], strings.Repeat(string(q), 3))
+	pos := quotePos + 1
+	if triple {
+		pos = quotePos + 3
+	}
+
+	for pos < len(src) {
+		c := src[pos]
+		switch {
+		case c == '\\':
+			pos += 2
+			continue
+		case c == '\n' && !triple:
+			return token{}, fmt.Errorf("unterminated string literal")
+		case c == q:
+			if !triple {
+				pos++
+				return token{kind: tokString, text: src[start:pos], start: start, end: pos}, nil
+			}
+			if strings.HasPrefix(src[pos:], strings.Repeat(string(q), 3)) {
+				pos += 3
+				return token{kind: tokString, text: src[start:pos], start: start, end: pos}, nil
+			}
+		}
+		pos++
+	}
+
+	return token{}, fmt.Errorf("unterminated string literal")
+}
+
+// lexOperator returns the longest operator at the start of s
+func lexOperator(s string) string {
+	for _, op := range []string{"**=", "//=", "<<=", ">>=", "...",
+		"==", "!=", "<=", ">=", "**", "//", "<<", ">>", "->",
+		"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^="} {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return s[:1]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isStringPrefix(word string) bool {
+	switch strings.ToLower(word) {
+	case "r", "b", "f", "rb", "br":
+		return true
+	}
+	return false
+}
+
+// keywords are the KCL keywords; attributes named after one are written with
+// a $ prefix, which the statement tree strips
+var keywords = map[string]bool{
+	"True": true, "False": true, "None": true, "Undefined": true,
+	"import": true, "as": true, "rule": true, "schema": true, "mixin": true,
+	"protocol": true, "check": true, "for": true, "assert": true, "if": true,
+	"elif": true, "else": true, "or": true, "and": true, "not": true,
+	"in": true, "is": true, "lambda": true, "all": true, "any": true,
+	"filter": true, "map": true, "type": true,
+}
+
+// IsKeyword reports whether a name is a KCL keyword, which attribute names
+// must prefix with $
+func IsKeyword(name string) bool {
+	return keywords[name]
+}
+
+// QuoteString returns the KCL string literal of a string
+func QuoteString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// Literal returns the KCL literal of an evaluated value: a string, int,
+// float64, bool, []interface{} or map[string]interface{}. Other values,
+// like nil, become None.
+func Literal(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return QuoteString(v)
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = Literal(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		entries := make([]string, len(keys))
+		for i, k := range keys {
+			entries[i] = QuoteString(k) + ": " + Literal(v[k])
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	default:
+		return "None"
+	}
+}
+
+// expandAliases replaces references to type aliases with their definitions
+func expandAliases(toks []token, aliases map[string][]token) []token {
+	return expandAliasesDepth(toks, aliases, 0)
+}
+
+func expandAliasesDepth(toks []token, aliases map[string][]token, depth int) []token {
+	if len(aliases) == 0 || depth > 8 {
+		return toks
+	}
+	var out []token
+	for i, tok := range toks {
+		def, ok := aliases[tok.text]
+		// Only bare names are aliases, not the members of qualified names
+		if !ok || tok.kind != tokName || (i > 0 && toks[i-1].text == ".") {
+			out = append(out, tok)
+			continue
+		}
+		out = append(out, expandAliasesDepth(def, aliases, depth+1)...)
+	}
+	return out
+}
+
+// typeExprString renders a type expression in the canonical form used by
+// parser.Field.Type: no whitespace except around union bars, e.g. `{str:[int]}`
+// or `"small" | "large"`
+func typeExprString(toks []token) string {
+	var b strings.Builder
+	for _, tok := range toks {
+		if tok.kind == tokOp && tok.text == "|" {
+			b.WriteString(" | ")
+			continue
+		}
+		b.WriteString(tok.text)
+	}
+	return b.String()
+}
+
+// tokensText returns the source text spanned by toks with line breaks and
+// indentation collapsed, so multi-line expressions read as one line. A line
+// break between two entries of a list or dict separates them like a comma,
+// so it is written as one.
+func tokensText(src string, toks []token) string {
+	if len(toks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	var brackets []string
+	for i, tok := range toks {
+		if i > 0 {
+			prev := toks[i-1]
+			gap := src[prev.end:tok.start]
+			switch {
+			case !strings.ContainsAny(gap, "\n\\#"):
+				b.WriteString(gap)
+			case len(brackets) > 0 && brackets[len(brackets)-1] != "(" && endsValue(prev) && startsValue(tok):
+				b.WriteString(", ")
+			case prev.text != "(" && prev.text != "[" && prev.text != "{" && tok.text != ")" && tok.text != "]" && tok.text != "}" && tok.text != ",":
+				b.WriteString(" ")
+			}
+		}
+		if tok.kind == tokOp {
+			switch tok.text {
+			case "(", "[", "{":
+				brackets = append(brackets, tok.text)
+			case ")", "]", "}":
+				if len(brackets) > 0 {
+					brackets = brackets[:len(brackets)-1]
+				}
+			}
+		}
+		b.WriteString(tok.text)
+	}
+	return b.String()
+}
+
+// endsValue reports whether a token can end a list item or dict entry
+func endsValue(tok token) bool {
+	if tok.kind == tokOp {
+		return tok.text == ")" || tok.text == "]" || tok.text == "}"
+	}
+	return isOperand(tok)
+}
+
+// startsValue reports whether a token can start a list item or dict entry
+func startsValue(tok token) bool {
+	if tok.kind == tokOp {
+		return tok.text == "(" || tok.text == "[" || tok.text == "{"
+	}
+	return isOperand(tok)
+}
+
+// isOperand reports whether a token is a literal, a name or a constant such
+// as True, rather than a keyword like `for` or `and`
+func isOperand(tok token) bool {
+	switch tok.text {
+	case "True", "False", "None", "Undefined":
+		return true
+	}
+	return tok.kind != tokName || !keywords[tok.text]
+}
+
+// stringLiteralValue strips the prefix and quotes from a string literal token
+func stringLiteralValue(lit string) string {
+	lit = strings.TrimLeft(lit, "rRbBfF")
+	for _, q := range []string{`"""`, `'''`, `"`, `'`} {
+		if len(lit) >= 2*len(q) && strings.HasPrefix(lit, q) && strings.HasSuffix(lit, q) {
+			return lit[len(q) : len(lit)-len(q)]
+		}
+	}
+	return lit
+}
+
+// docstringText joins the non-empty lines of a docstring with spaces
+func docstringText(lit string) string {
+	var lines []string
+	for _, line := range strings.Split(stringLiteralValue(lit), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, " ")
+}
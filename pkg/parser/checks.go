@@ -0,0 +1,1070 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file translates the expressions of KCL check blocks into CEL rules
+// for x-kubernetes-validations. Simple bounds on a single attribute, such as
+// `len(name) <= 63` or `replicas >= 1`, become native OpenAPI keywords on the
+// field instead. Rules are evaluated against the schema's object, so
+// attributes are referenced as `self.<name>`; rules that reference optional
+// attributes are guarded with `has()` because KCL skips checks on unset
+// attributes.
+
+// exprKind identifies the kind of a check expression node
+type exprKind int
+
+const (
+	exprName exprKind = iota
+	exprNumber
+	exprString
+	exprList
+	exprUnary
+	exprBinary
+	exprCond
+	exprCall
+	exprAttr
+	exprIndex
+	exprQuant
+)
+
+// exprNode is a node of a parsed check expression
+type exprNode struct {
+	kind exprKind
+	text string      // name, literal text, operator, attribute name or quantifier
+	args []*exprNode // operands, in source order
+	vars []string    // quantifier variables
+}
+
+// exprParser is a recursive descent parser for KCL expressions
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+// parseCheckExpr parses the expression of a check. A trailing `if` without
+// `else` is allowed at the top level, where it makes the check conditional.
+func parseCheckExpr(expr string) (*exprNode, error) {
+	toks, err := lexExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	p := &exprParser{toks: toks}
+	node, err := p.parseExpr(true)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected '%s'", p.toks[p.pos].text)
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) && p.toks[p.pos].kind != tokString {
+		return p.toks[p.pos].text
+	}
+	return ""
+}
+
+func (p *exprParser) accept(text string) bool {
+	if p.peek() == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		if p.pos < len(p.toks) {
+			return fmt.Errorf("expected '%s' but found '%s'", text, p.toks[p.pos].text)
+		}
+		return fmt.Errorf("expected '%s' at end of expression", text)
+	}
+	return nil
+}
+
+// parseExpr parses a conditional expression `body if cond else orElse`
+func (p *exprParser) parseExpr(topLevel bool) (*exprNode, error) {
+	body, err := p.parseOr()
+	if err != nil || !p.accept("if") {
+		return body, err
+	}
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("else") {
+		if !topLevel {
+			return nil, fmt.Errorf("conditional expression without 'else'")
+		}
+		return &exprNode{kind: exprCond, args: []*exprNode{body, cond}}, nil
+	}
+	orElse, err := p.parseExpr(false)
+	if err != nil {
+		return nil, err
+	}
+	return &exprNode{kind: exprCond, args: []*exprNode{body, cond, orElse}}, nil
+}
+
+func (p *exprParser) parseOr() (*exprNode, error) {
+	return p.parseBinary([]string{"or"}, p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (*exprNode, error) {
+	return p.parseBinary([]string{"and"}, p.parseNot)
+}
+
+func (p *exprParser) parseNot() (*exprNode, error) {
+	if p.accept("not") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{kind: exprUnary, text: "not", args: []*exprNode{x}}, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses a (possibly chained) comparison. `a < b < c` is
+// equivalent to `a < b and b < c`.
+func (p *exprParser) parseComparison() (*exprNode, error) {
+	left, err := p.parseBitwise()
+	if err != nil {
+		return nil, err
+	}
+	var result *exprNode
+	for {
+		op := p.peek()
+		switch op {
+		case "==", "!=", "<", "<=", ">", ">=", "in":
+			p.pos++
+		case "not":
+			if p.pos+1 >= len(p.toks) || p.toks[p.pos+1].text != "in" {
+				return orLeft(result, left), nil
+			}
+			p.pos += 2
+			op = "not in"
+		case "is":
+			p.pos++
+			op = "is"
+			if p.accept("not") {
+				op = "is not"
+			}
+		default:
+			return orLeft(result, left), nil
+		}
+		right, err := p.parseBitwise()
+		if err != nil {
+			return nil, err
+		}
+		cmp := &exprNode{kind: exprBinary, text: op, args: []*exprNode{left, right}}
+		if result == nil {
+			result = cmp
+		} else {
+			result = &exprNode{kind: exprBinary, text: "and", args: []*exprNode{result, cmp}}
+		}
+		left = right
+	}
+}
+
+// orLeft returns the comparison chain built so far, or the single operand
+func orLeft(result, left *exprNode) *exprNode {
+	if result != nil {
+		return result
+	}
+	return left
+}
+
+func (p *exprParser) parseBitwise() (*exprNode, error) {
+	return p.parseBinary([]string{"|", "^", "&", "<<", ">>"}, p.parseAdditive)
+}
+
+func (p *exprParser) parseAdditive() (*exprNode, error) {
+	return p.parseBinary([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *exprParser) parseMultiplicative() (*exprNode, error) {
+	return p.parseBinary([]string{"*", "/", "//", "%"}, p.parseUnary)
+}
+
+// parseBinary parses a left-associative chain of the given operators
+func (p *exprParser) parseBinary(ops []string, next func() (*exprNode, error)) (*exprNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, o := range ops {
+			if op == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return left, nil
+		}
+		p.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{kind: exprBinary, text: op, args: []*exprNode{left, right}}
+	}
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	switch op := p.peek(); op {
+	case "-", "+", "~":
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{kind: exprUnary, text: op, args: []*exprNode{x}}, nil
+	}
+	return p.parsePower()
+}
+
+func (p *exprParser) parsePower() (*exprNode, error) {
+	base, err := p.parsePostfix()
+	if err != nil || !p.accept("**") {
+		return base, err
+	}
+	exp, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &exprNode{kind: exprBinary, text: "**", args: []*exprNode{base, exp}}, nil
+}
+
+// parsePostfix parses an operand followed by calls, attribute accesses and
+// subscripts
+func (p *exprParser) parsePostfix() (*exprNode, error) {
+	x, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("("):
+			call := &exprNode{kind: exprCall, args: []*exprNode{x}}
+			for !p.accept(")") {
+				if p.pos+1 < len(p.toks) && p.toks[p.pos].kind == tokName && p.toks[p.pos+1].text == "=" {
+					return nil, fmt.Errorf("keyword arguments are not supported")
+				}
+				arg, err := p.parseExpr(false)
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+				if !p.accept(",") && p.peek() != ")" {
+					return nil, p.expect(")")
+				}
+			}
+			x = call
+		case p.accept("."):
+			if p.pos >= len(p.toks) || p.toks[p.pos].kind != tokName {
+				return nil, fmt.Errorf("expected attribute name after '.'")
+			}
+			x = &exprNode{kind: exprAttr, text: p.toks[p.pos].text, args: []*exprNode{x}}
+			p.pos++
+		case p.accept("["):
+			index, err := p.parseExpr(false)
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() == ":" {
+				return nil, fmt.Errorf("slices are not supported")
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &exprNode{kind: exprIndex, args: []*exprNode{x, index}}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) parseOperand() (*exprNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.toks[p.pos]
+	switch tok.kind {
+	case tokString:
+		p.pos++
+		return &exprNode{kind: exprString, text: tok.text}, nil
+	case tokNumber:
+		p.pos++
+		return &exprNode{kind: exprNumber, text: tok.text}, nil
+	case tokName:
+		if isQuantifier(p.toks[p.pos:]) {
+			return p.parseQuantifier()
+		}
+		p.pos++
+		return &exprNode{kind: exprName, text: tok.text}, nil
+	}
+
+	switch tok.text {
+	case "(":
+		p.pos++
+		x, err := p.parseExpr(false)
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case "[":
+		p.pos++
+		list := &exprNode{kind: exprList}
+		for !p.accept("]") {
+			elem, err := p.parseExpr(false)
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() == "for" {
+				return nil, fmt.Errorf("comprehensions are not supported")
+			}
+			list.args = append(list.args, elem)
+			if !p.accept(",") && p.peek() != "]" {
+				return nil, p.expect("]")
+			}
+		}
+		return list, nil
+	case "{":
+		return nil, fmt.Errorf("dict expressions are not supported")
+	}
+	return nil, fmt.Errorf("unexpected '%s'", tok.text)
+}
+
+// isQuantifier reports whether toks start a quantifier expression such as
+// `all x in items { ... }`
+func isQuantifier(toks []token) bool {
+	switch toks[0].text {
+	case "all", "any", "filter", "map":
+	default:
+		return false
+	}
+	for i := 1; i < len(toks); i++ {
+		switch {
+		case toks[i].kind == tokName && toks[i].text == "in":
+			return i > 1
+		case toks[i].kind != tokName && toks[i].text != ",":
+			return false
+		}
+	}
+	return false
+}
+
+func (p *exprParser) parseQuantifier() (*exprNode, error) {
+	q := &exprNode{kind: exprQuant, text: p.toks[p.pos].text}
+	p.pos++
+	for !p.accept("in") {
+		q.vars = append(q.vars, p.toks[p.pos].text)
+		p.pos++
+		p.accept(",")
+	}
+	iter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	cond, err := p.parseExpr(false)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	q.args = []*exprNode{iter, cond}
+	return q, nil
+}
+
+// Operator precedence of the generated CEL, lowest first
+const (
+	celPrecCond = iota + 1
+	celPrecOr
+	celPrecAnd
+	celPrecRelation
+	celPrecAdditive
+	celPrecMultiplicative
+	celPrecUnary
+	celPrecPrimary
+)
+
+// celTranslator converts check expressions of one schema into CEL
+type celTranslator struct {
+	fields map[string]*Field
+	vars   map[string]bool // quantifier variables in scope
+	guards []string        // optional attributes the rule reads, in order
+}
+
+func newCELTranslator(fields map[string]*Field) *celTranslator {
+	return &celTranslator{fields: fields, vars: make(map[string]bool)}
+}
+
+// rule translates a check expression into a CEL rule
+func (t *celTranslator) rule(n *exprNode) (string, error) {
+	t.guards = nil
+
+	var rule string
+	var prec int
+	var err error
+	if n.kind == exprCond && len(n.args) == 2 {
+		// `expr if cond` only checks expr when cond holds
+		rule, prec, err = t.conditional(n.args[0], n.args[1])
+	} else {
+		rule, prec, err = t.boolean(n)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(t.guards) == 0 {
+		return rule, nil
+	}
+
+	parts := make([]string, 0, len(t.guards)+1)
+	for _, name := range t.guards {
+		parts = append(parts, "!has(self."+name+")")
+	}
+	parts = append(parts, paren(rule, prec, celPrecOr))
+	return strings.Join(parts, " || "), nil
+}
+
+func (t *celTranslator) conditional(body, cond *exprNode) (string, int, error) {
+	c, cPrec, err := t.boolean(cond)
+	if err != nil {
+		return "", 0, err
+	}
+	b, bPrec, err := t.boolean(body)
+	if err != nil {
+		return "", 0, err
+	}
+	return "!" + paren(c, cPrec, celPrecPrimary) + " || " + paren(b, bPrec, celPrecOr), celPrecOr, nil
+}
+
+// boolean translates an expression used as a condition, applying KCL's
+// truthiness rules to bare attribute references
+func (t *celTranslator) boolean(n *exprNode) (string, int, error) {
+	if n.kind != exprName || t.vars[n.text] || t.fields[n.text] == nil {
+		return t.expr(n)
+	}
+
+	field := t.fields[n.text]
+	ref := "self." + n.text
+	var test string
+	prec := celPrecRelation
+	switch {
+	case field.Type == "bool":
+		test, prec = ref, celPrecPrimary
+	case field.Type == "str" || strings.HasPrefix(field.Type, "[") || strings.HasPrefix(field.Type, "{"):
+		test = "size(" + ref + ") > 0"
+	case field.Type == "int" || field.Type == "float":
+		test = ref + " != 0"
+	default:
+		return "has(" + ref + ")", celPrecPrimary, nil
+	}
+	if field.Required {
+		return test, prec, nil
+	}
+	return "has(" + ref + ") && " + paren(test, prec, celPrecAnd), celPrecAnd, nil
+}
+
+// expr translates an expression, returning the CEL text and its precedence
+func (t *celTranslator) expr(n *exprNode) (string, int, error) {
+	switch n.kind {
+	case exprName:
+		return t.name(n.text)
+
+	case exprNumber:
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(n.text, "_", ""), 64); err != nil {
+			return "", 0, fmt.Errorf("unsupported number literal '%s'", n.text)
+		}
+		return strings.ReplaceAll(n.text, "_", ""), celPrecPrimary, nil
+
+	case exprString:
+		switch stringPrefix(n.text) {
+		case "", "r":
+			return n.text, celPrecPrimary, nil
+		}
+		return "", 0, fmt.Errorf("unsupported string literal %s", n.text)
+
+	case exprList:
+		elems := make([]string, 0, len(n.args))
+		for _, arg := range n.args {
+			elem, _, err := t.expr(arg)
+			if err != nil {
+				return "", 0, err
+			}
+			elems = append(elems, elem)
+		}
+		return "[" + strings.Join(elems, ", ") + "]", celPrecPrimary, nil
+
+	case exprUnary:
+		switch n.text {
+		case "not":
+			x, prec, err := t.boolean(n.args[0])
+			if err != nil {
+				return "", 0, err
+			}
+			return "!" + paren(x, prec, celPrecPrimary), celPrecUnary, nil
+		case "-":
+			x, prec, err := t.expr(n.args[0])
+			if err != nil {
+				return "", 0, err
+			}
+			return "-" + paren(x, prec, celPrecUnary), celPrecUnary, nil
+		case "+":
+			return t.expr(n.args[0])
+		}
+		return "", 0, fmt.Errorf("unsupported operator '%s'", n.text)
+
+	case exprBinary:
+		return t.binary(n)
+
+	case exprCond:
+		if len(n.args) != 3 {
+			return "", 0, fmt.Errorf("conditional expression without 'else'")
+		}
+		c, cPrec, err := t.boolean(n.args[1])
+		if err != nil {
+			return "", 0, err
+		}
+		a, aPrec, err := t.expr(n.args[0])
+		if err != nil {
+			return "", 0, err
+		}
+		b, bPrec, err := t.expr(n.args[2])
+		if err != nil {
+			return "", 0, err
+		}
+		return paren(c, cPrec, celPrecOr) + " ? " + paren(a, aPrec, celPrecOr) + " : " + paren(b, bPrec, celPrecCond), celPrecCond, nil
+
+	case exprCall:
+		return t.call(n)
+
+	case exprAttr:
+		x, prec, err := t.expr(n.args[0])
+		if err != nil {
+			return "", 0, err
+		}
+		return paren(x, prec, celPrecPrimary) + "." + n.text, celPrecPrimary, nil
+
+	case exprIndex:
+		x, prec, err := t.expr(n.args[0])
+		if err != nil {
+			return "", 0, err
+		}
+		index, _, err := t.expr(n.args[1])
+		if err != nil {
+			return "", 0, err
+		}
+		return paren(x, prec, celPrecPrimary) + "[" + index + "]", celPrecPrimary, nil
+
+	case exprQuant:
+		return t.quantifier(n)
+	}
+	return "", 0, fmt.Errorf("unsupported expression")
+}
+
+// name translates a reference to an attribute, quantifier variable or constant
+func (t *celTranslator) name(name string) (string, int, error) {
+	if t.vars[name] {
+		return name, celPrecPrimary, nil
+	}
+	switch name {
+	case "True":
+		return "true", celPrecPrimary, nil
+	case "False":
+		return "false", celPrecPrimary, nil
+	case "None":
+		return "null", celPrecPrimary, nil
+	}
+
+	field := t.fields[name]
+	if field == nil {
+		return "", 0, fmt.Errorf("unknown name '%s'", name)
+	}
+	if field.IsStatus || field.IsSpec {
+		return "", 0, fmt.Errorf("'%s' is not a spec.parameters attribute", name)
+	}
+	if !field.Required && field.Default == "" {
+		t.guard(name)
+	}
+	return "self." + name, celPrecPrimary, nil
+}
+
+func (t *celTranslator) guard(name string) {
+	for _, g := range t.guards {
+		if g == name {
+			return
+		}
+	}
+	t.guards = append(t.guards, name)
+}
+
+func (t *celTranslator) binary(n *exprNode) (string, int, error) {
+	left, right := n.args[0], n.args[1]
+
+	switch n.text {
+	case "and", "or":
+		op, prec := "&&", celPrecAnd
+		if n.text == "or" {
+			op, prec = "||", celPrecOr
+		}
+		x, xPrec, err := t.boolean(left)
+		if err != nil {
+			return "", 0, err
+		}
+		y, yPrec, err := t.boolean(right)
+		if err != nil {
+			return "", 0, err
+		}
+		return paren(x, xPrec, prec) + " " + op + " " + paren(y, yPrec, prec), prec, nil
+
+	case "is", "is not":
+		// `x is None` tests whether an attribute is set
+		if right.kind == exprName && right.text == "None" && left.kind == exprName && t.fields[left.text] != nil && !t.vars[left.text] {
+			if n.text == "is" {
+				return "!has(self." + left.text + ")", celPrecUnary, nil
+			}
+			return "has(self." + left.text + ")", celPrecPrimary, nil
+		}
+		op := "=="
+		if n.text == "is not" {
+			op = "!="
+		}
+		return t.binary(&exprNode{kind: exprBinary, text: op, args: n.args})
+
+	case "in", "not in":
+		x, xPrec, err := t.expr(left)
+		if err != nil {
+			return "", 0, err
+		}
+		y, yPrec, err := t.expr(right)
+		if err != nil {
+			return "", 0, err
+		}
+		// Membership in a string is a substring test
+		var test string
+		if right.kind == exprName && !t.vars[right.text] && t.fields[right.text] != nil && t.fields[right.text].Type == "str" {
+			test = y + ".contains(" + x + ")"
+			if n.text == "not in" {
+				return "!" + test, celPrecUnary, nil
+			}
+			return test, celPrecPrimary, nil
+		}
+		test = paren(x, xPrec, celPrecRelation+1) + " in " + paren(y, yPrec, celPrecRelation+1)
+		if n.text == "not in" {
+			return "!(" + test + ")", celPrecUnary, nil
+		}
+		return test, celPrecRelation, nil
+	}
+
+	var prec int
+	switch n.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		prec = celPrecRelation
+	case "+", "-":
+		prec = celPrecAdditive
+	case "*", "/", "%":
+		prec = celPrecMultiplicative
+	default:
+		return "", 0, fmt.Errorf("unsupported operator '%s'", n.text)
+	}
+	x, xPrec, err := t.expr(left)
+	if err != nil {
+		return "", 0, err
+	}
+	y, yPrec, err := t.expr(right)
+	if err != nil {
+		return "", 0, err
+	}
+	leftMin := prec
+	if prec == celPrecRelation {
+		leftMin = prec + 1
+	}
+	return paren(x, xPrec, leftMin) + " " + n.text + " " + paren(y, yPrec, prec+1), prec, nil
+}
+
+// call translates calls of the builtins and string methods CEL has an
+// equivalent for
+func (t *celTranslator) call(n *exprNode) (string, int, error) {
+	fn, args := n.args[0], n.args[1:]
+
+	if fn.kind == exprName && !t.vars[fn.text] && t.fields[fn.text] == nil {
+		builtins := map[string]string{"len": "size", "int": "int", "float": "double", "str": "string"}
+		if celFn, ok := builtins[fn.text]; ok && len(args) == 1 {
+			x, _, err := t.expr(args[0])
+			if err != nil {
+				return "", 0, err
+			}
+			return celFn + "(" + x + ")", celPrecPrimary, nil
+		}
+		return "", 0, fmt.Errorf("unsupported function '%s'", fn.text)
+	}
+	if fn.kind != exprAttr {
+		return "", 0, fmt.Errorf("unsupported call")
+	}
+
+	// regex.match(s, pattern) and regex.search(s, pattern)
+	recv := fn.args[0]
+	if recv.kind == exprName && recv.text == "regex" && t.fields["regex"] == nil {
+		if len(args) != 2 || (fn.text != "match" && fn.text != "search") {
+			return "", 0, fmt.Errorf("unsupported function 'regex.%s'", fn.text)
+		}
+		x, prec, err := t.expr(args[0])
+		if err != nil {
+			return "", 0, err
+		}
+		pattern := args[1]
+		if fn.text == "match" {
+			// regex.match only matches at the start of the string
+			if pattern.kind != exprString {
+				return "", 0, fmt.Errorf("regex.match requires a literal pattern")
+			}
+			pattern = &exprNode{kind: exprString, text: anchorPatternLiteral(pattern.text)}
+		}
+		p, _, err := t.expr(pattern)
+		if err != nil {
+			return "", 0, err
+		}
+		return paren(x, prec, celPrecPrimary) + ".matches(" + p + ")", celPrecPrimary, nil
+	}
+
+	methods := map[string]string{
+		"startswith": "startsWith",
+		"endswith":   "endsWith",
+		"lower":      "lowerAscii",
+		"upper":      "upperAscii",
+		"strip":      "trim",
+	}
+	celMethod, ok := methods[fn.text]
+	if !ok {
+		return "", 0, fmt.Errorf("unsupported function '%s'", fn.text)
+	}
+	x, prec, err := t.expr(recv)
+	if err != nil {
+		return "", 0, err
+	}
+	celArgs := make([]string, 0, len(args))
+	for _, arg := range args {
+		a, _, err := t.expr(arg)
+		if err != nil {
+			return "", 0, err
+		}
+		celArgs = append(celArgs, a)
+	}
+	return paren(x, prec, celPrecPrimary) + "." + celMethod + "(" + strings.Join(celArgs, ", ") + ")", celPrecPrimary, nil
+}
+
+// quantifier translates `all`/`any`/`filter`/`map` expressions into CEL
+// macros
+func (t *celTranslator) quantifier(n *exprNode) (string, int, error) {
+	macros := map[string]string{"all": "all", "any": "exists", "filter": "filter", "map": "map"}
+	if len(n.vars) != 1 {
+		return "", 0, fmt.Errorf("'%s' with more than one variable is not supported", n.text)
+	}
+	v := n.vars[0]
+
+	iter, prec, err := t.expr(n.args[0])
+	if err != nil {
+		return "", 0, err
+	}
+
+	shadowed := t.vars[v]
+	t.vars[v] = true
+	var body string
+	if n.text == "map" {
+		body, _, err = t.expr(n.args[1])
+	} else {
+		body, _, err = t.boolean(n.args[1])
+	}
+	t.vars[v] = shadowed
+	if err != nil {
+		return "", 0, err
+	}
+
+	return paren(iter, prec, celPrecPrimary) + "." + macros[n.text] + "(" + v + ", " + body + ")", celPrecPrimary, nil
+}
+
+// paren wraps s in parentheses if its precedence is lower than min
+func paren(s string, prec, min int) string {
+	if prec < min {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// anchorPatternLiteral anchors the pattern in a string literal at the start
+func anchorPatternLiteral(lit string) string {
+	quote := strings.IndexAny(lit, `"'`)
+	if quote < 0 || strings.HasPrefix(stringLiteralValue(lit), "^") {
+		return lit
+	}
+	q := lit[quote : quote+1]
+	if strings.HasPrefix(lit[quote:], strings.Repeat(q, 3)) {
+		q = strings.Repeat(q, 3)
+	}
+	return lit[:quote] + q + "^" + lit[quote+len(q):]
+}
+
+// stringPrefix returns the lower-cased prefix of a string literal, e.g. "r"
+func stringPrefix(lit string) string {
+	return strings.ToLower(lit[:strings.IndexAny(lit, `"'`)])
+}
+
+// kclStringValue returns the value of a KCL string literal, or false if it
+// uses escapes that cannot be decoded
+func kclStringValue(lit string) (string, bool) {
+	prefix := stringPrefix(lit)
+	value := stringLiteralValue(lit)
+	switch {
+	case prefix == "r":
+		return value, true
+	case prefix != "":
+		return "", false
+	case !strings.Contains(value, `\`):
+		return value, true
+	}
+	unquoted, err := strconv.Unquote(`"` + value + `"`)
+	if err != nil {
+		return "", false
+	}
+	return unquoted, true
+}
+
+// translateChecks converts the checks of a schema into native keywords on its
+// fields and schema-level CEL rules. Checks that cannot be translated are
+// reported as warnings.
+func translateChecks(schema *Schema, filename string) Diagnostics {
+	fields := make(map[string]*Field)
+	for i := range schema.Fields {
+		fields[schema.Fields[i].Name] = &schema.Fields[i]
+	}
+	translator := newCELTranslator(fields)
+
+	var warnings Diagnostics
+	for _, check := range schema.Checks {
+		validations, native, err := translateCheck(translator, check)
+		if err != nil {
+			warnings = append(warnings, warningf(filename, check.Line, check.Column, "check `%s` was not translated to CEL: %v", check.Expr, err))
+			continue
+		}
+		for _, apply := range native {
+			apply()
+		}
+		schema.CELValidations = append(schema.CELValidations, validations...)
+	}
+	return warnings
+}
+
+// translateCheck translates one check. Without a custom message, each operand
+// of a top-level `and` is translated on its own and simple bounds become
+// native keywords; a message is only kept by a CEL rule.
+func translateCheck(t *celTranslator, check Check) ([]CELValidation, []func(), error) {
+	node, err := parseCheckExpr(check.Expr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if check.Message != "" {
+		rule, err := t.rule(node)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []CELValidation{{Rule: rule, Message: check.Message, Line: check.Line}}, nil, nil
+	}
+
+	var validations []CELValidation
+	var native []func()
+	for _, part := range conjuncts(node) {
+		if apply := nativeConstraint(t.fields, part); apply != nil {
+			native = append(native, apply)
+			continue
+		}
+		rule, err := t.rule(part)
+		if err != nil {
+			return nil, nil, err
+		}
+		validations = append(validations, CELValidation{Rule: rule, Line: check.Line})
+	}
+	return validations, native, nil
+}
+
+// conjuncts splits an expression at its top-level `and` operators
+func conjuncts(n *exprNode) []*exprNode {
+	if n.kind == exprBinary && n.text == "and" {
+		return append(conjuncts(n.args[0]), conjuncts(n.args[1])...)
+	}
+	return []*exprNode{n}
+}
+
+// nativeConstraint returns a function that applies the check as an OpenAPI
+// keyword on a field, or nil if the check has no native equivalent. Bounds
+// are only ever tightened, never relaxed.
+func nativeConstraint(fields map[string]*Field, n *exprNode) func() {
+	if n.kind == exprCall {
+		// regex.match(name, "pattern")
+		fn := n.args[0]
+		if fn.kind != exprAttr || fn.text != "match" || fn.args[0].kind != exprName || fn.args[0].text != "regex" || len(n.args) != 3 {
+			return nil
+		}
+		field := attributeField(fields, n.args[1])
+		if field == nil || field.Type != "str" || field.Pattern != "" || n.args[2].kind != exprString {
+			return nil
+		}
+		pattern, ok := kclStringValue(anchorPatternLiteral(n.args[2].text))
+		if !ok {
+			return nil
+		}
+		return func() { field.Pattern = pattern }
+	}
+
+	if n.kind != exprBinary {
+		return nil
+	}
+	left, right := n.args[0], n.args[1]
+
+	// name in ["a", "b"]
+	if n.text == "in" {
+		field := attributeField(fields, left)
+		if field == nil || field.Type != "str" || len(field.Enum) > 0 || right.kind != exprList || len(right.args) == 0 {
+			return nil
+		}
+		values := make([]string, 0, len(right.args))
+		for _, elem := range right.args {
+			if elem.kind != exprString {
+				return nil
+			}
+			value, ok := kclStringValue(elem.text)
+			if !ok {
+				return nil
+			}
+			values = append(values, value)
+		}
+		return func() { field.Enum = values }
+	}
+
+	op := n.text
+	switch op {
+	case "<", "<=", ">", ">=", "==":
+	default:
+		return nil
+	}
+	// Put the bounded operand on the left: `1 <= x` is `x >= 1`
+	if _, ok := intLiteral(left); ok {
+		left, right = right, left
+		op = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<=", "==": "=="}[op]
+	}
+	bound, ok := intLiteral(right)
+	if !ok {
+		return nil
+	}
+	var lower, upper *int
+	switch op {
+	case ">":
+		lower = intPtr(bound + 1)
+	case ">=":
+		lower = intPtr(bound)
+	case "<":
+		upper = intPtr(bound - 1)
+	case "<=":
+		upper = intPtr(bound)
+	case "==":
+		lower, upper = intPtr(bound), intPtr(bound)
+	}
+
+	// len(name) bounds the length of a string or the items of a list
+	if left.kind == exprCall && left.args[0].kind == exprName && left.args[0].text == "len" && len(left.args) == 2 {
+		field := attributeField(fields, left.args[1])
+		if field == nil || bound < 0 {
+			return nil
+		}
+		switch {
+		case field.Type == "str":
+			return func() { tightenBounds(&field.MinLength, &field.MaxLength, lower, upper) }
+		case strings.HasPrefix(field.Type, "["):
+			return func() { tightenBounds(&field.MinItems, &field.MaxItems, lower, upper) }
+		}
+		return nil
+	}
+
+	field := attributeField(fields, left)
+	if field == nil || field.Type != "int" {
+		return nil
+	}
+	return func() { tightenNumberBounds(field, lower, upper) }
+}
+
+// attributeField returns the field a bare name refers to, or nil
+func attributeField(fields map[string]*Field, n *exprNode) *Field {
+	if n.kind != exprName {
+		return nil
+	}
+	field := fields[n.text]
+	if field == nil || field.IsStatus || field.IsSpec {
+		return nil
+	}
+	return field
+}
+
+// intLiteral returns the value of an integer literal, possibly negated
+func intLiteral(n *exprNode) (int, bool) {
+	sign := 1
+	if n.kind == exprUnary && n.text == "-" {
+		sign, n = -1, n.args[0]
+	}
+	if n.kind != exprNumber {
+		return 0, false
+	}
+	v, err := strconv.Atoi(strings.ReplaceAll(n.text, "_", ""))
+	if err != nil {
+		return 0, false
+	}
+	return sign * v, true
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// tightenBounds narrows the min/max pair to the given bounds
+func tightenBounds(min, max **int, lower, upper *int) {
+	if lower != nil && (*min == nil || **min < *lower) {
+		*min = lower
+	}
+	if upper != nil && (*max == nil || **max > *upper) {
+		*max = upper
+	}
+}
+
+// tightenNumberBounds narrows the minimum and maximum of a field to the
+// given inclusive bounds. An equal exclusive bound is already tighter.
+func tightenNumberBounds(field *Field, lower, upper *int) {
+	if lower != nil && (field.Minimum == nil || *field.Minimum < float64(*lower)) {
+		field.Minimum = float64Ptr(float64(*lower))
+		field.ExclusiveMinimum = false
+	}
+	if upper != nil && (field.Maximum == nil || *field.Maximum > float64(*upper)) {
+		field.Maximum = float64Ptr(float64(*upper))
+		field.ExclusiveMaximum = false
+	}
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,435 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	kcl "kcl-lang.io/kcl-go"
+)
+
+// This file evaluates the default expressions of fields into values, so that
+// lists, dicts and schema instances become structured defaults. Defaults are
+// evaluated by the KCL runtime; literals, including instances of the file's
+// own schemas, are evaluated directly where the runtime is unavailable or
+// cannot run the file.
+
+// evaluateDefaults sets the DefaultValue of the fields of the schemas of a
+// file and returns warnings for the defaults that could not be evaluated.
+// Instances may be of the schemas in scope, by the names the file uses.
+func evaluateDefaults(filename string, schemas, scope map[string]*Schema) Diagnostics {
+	var fields []*Field
+	var owners []string
+	for _, name := range sortedSchemaNames(schemas) {
+		schema := schemas[name]
+		for i := range schema.Fields {
+			field := &schema.Fields[i]
+			if field.Default == "" || field.Default == "Undefined" {
+				continue
+			}
+			fields = append(fields, field)
+			owners = append(owners, name)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	exprs := make([]string, len(fields))
+	for i, field := range fields {
+		exprs[i] = field.Default
+	}
+	values, errs, warnings := evaluateWithKCL(filename, exprs)
+	e := &literalEvaluator{schemas: scope, instantiating: make(map[string]bool)}
+	for i, field := range fields {
+		if errs[i] == nil {
+			field.DefaultValue = values[i]
+			continue
+		}
+		value, err := e.evaluate(field.Default)
+		if err != nil {
+			warnings = append(warnings, warningf(filename, field.Line, field.Column, "default %s of %s.%s could not be evaluated: %v",
+				field.Default, owners[i], field.Name, errs[i]))
+			continue
+		}
+		field.DefaultValue = value
+	}
+	return warnings
+}
+
+// kclErrorLineRegex matches the `--> file:line:column` locations of KCL
+// error messages
+var kclErrorLineRegex = regexp.MustCompile(`-->[^\n]*?:(\d+)(?::\d+)?[ \t]*(?:\n|$)`)
+
+// evaluateWithKCL evaluates expressions in the scope of a file with the KCL
+// runtime. The expressions are appended to the file, one binding per line,
+// and run together; an expression the runtime's error points at fails on its
+// own and the others are run again without it. Errors pointing at none of
+// them are narrowed down by running halves of the expressions. If the file's
+// imports cannot be resolved, e.g. outside a KCL module, the expressions are
+// evaluated without the imports and a warning says so.
+func evaluateWithKCL(filename string, exprs []string) ([]interface{}, []error, Diagnostics) {
+	values := make([]interface{}, len(exprs))
+	errs := make([]error, len(exprs))
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return values, errs, nil
+	}
+	source := strings.TrimRight(string(content), "\n")
+	firstLine := strings.Count(source, "\n") + 2 // line of the first expression
+
+	path := filename
+	run := func(indices []int) (map[string]interface{}, error) {
+		var code strings.Builder
+		code.WriteString(source)
+		for _, i := range indices {
+			fmt.Fprintf(&code, "\n__kcl2xrd_default_%d = %s", i, exprs[i])
+		}
+		result, err := kcl.Run(path, kcl.WithCode(code.String()), kcl.WithShowHidden(true))
+		if err != nil {
+			return nil, err
+		}
+		first := result.First()
+		if first == nil {
+			return nil, fmt.Errorf("no result")
+		}
+		return first.ToMap()
+	}
+
+	var warnings Diagnostics
+	fileChecked := false
+	var evaluate func(indices []int)
+	evaluate = func(indices []int) {
+		if len(indices) == 0 {
+			return
+		}
+		result, err := run(indices)
+		if err == nil {
+			for _, i := range indices {
+				values[i] = normalizeKCLValue(result[fmt.Sprintf("__kcl2xrd_default_%d", i)])
+			}
+			return
+		}
+		if failed := failedExpressions(err, firstLine, exprs, indices); len(failed) > 0 {
+			var rest []int
+			for _, i := range indices {
+				if failed[i] {
+					errs[i] = err
+				} else {
+					rest = append(rest, i)
+				}
+			}
+			evaluate(rest)
+			return
+		}
+		if !fileChecked {
+			fileChecked = true
+			// Without the expressions the file may fail as well: imports may
+			// not resolve outside a KCL module, otherwise none can be evaluated
+			if _, fileErr := run(nil); fileErr != nil {
+				stripped := stripImports(source)
+				if stripped != source {
+					source, path = stripped, ""
+					_, fileErr = run(nil)
+				}
+				if fileErr != nil {
+					for _, i := range indices {
+						errs[i] = err
+					}
+					return
+				}
+				warnings = append(warnings, warningf(filename, 0, 0, "the imports could not be resolved; defaults are evaluated without them"))
+				evaluate(indices)
+				return
+			}
+		}
+		if len(indices) == 1 {
+			errs[indices[0]] = err
+			return
+		}
+		evaluate(indices[:len(indices)/2])
+		evaluate(indices[len(indices)/2:])
+	}
+
+	all := make([]int, len(exprs))
+	for i := range all {
+		all[i] = i
+	}
+	evaluate(all)
+	return values, errs, warnings
+}
+
+// failedExpressions returns the expressions of a run that a KCL error points
+// at: by the lines they were appended on, starting at firstLine, or by the
+// names of their bindings
+func failedExpressions(err error, firstLine int, exprs []string, indices []int) map[int]bool {
+	message := err.Error()
+	var lines []int
+	for _, match := range kclErrorLineRegex.FindAllStringSubmatch(message, -1) {
+		if line, err := strconv.Atoi(match[1]); err == nil {
+			lines = append(lines, line)
+		}
+	}
+	failed := make(map[int]bool)
+	start := firstLine
+	for _, i := range indices {
+		end := start + strings.Count(exprs[i], "\n")
+		if strings.Contains(message, fmt.Sprintf("__kcl2xrd_default_%d ", i)) {
+			failed[i] = true
+		}
+		for _, line := range lines {
+			if line >= start && line <= end {
+				failed[i] = true
+			}
+		}
+		start = end + 1
+	}
+	return failed
+}
+
+// normalizeKCLValue converts the numbers of an evaluated value to int or
+// float64 and drops the None attributes of dicts
+func normalizeKCLValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return int(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeKCLValue(v[i])
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			if item == nil {
+				delete(v, k)
+				continue
+			}
+			v[k] = normalizeKCLValue(item)
+		}
+	}
+	return v
+}
+
+// literalEvaluator evaluates literal expressions: strings, numbers, True,
+// False, None, lists, dicts and instances of the file's schemas, which hold
+// the defaults of the schema's attributes
+type literalEvaluator struct {
+	schemas       map[string]*Schema
+	instantiating map[string]bool // schemas being instantiated, to stop recursion
+}
+
+// evaluate returns the value of a literal expression
+func (e *literalEvaluator) evaluate(expr string) (interface{}, error) {
+	toks, err := lexExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	p := &exprParser{toks: toks}
+	value, err := e.value(p)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected '%s'", p.toks[p.pos].text)
+	}
+	return value, nil
+}
+
+func (e *literalEvaluator) value(p *exprParser) (interface{}, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.toks[p.pos]
+	switch {
+	case tok.kind == tokString:
+		p.pos++
+		value, ok := kclStringValue(tok.text)
+		if !ok {
+			return nil, fmt.Errorf("unsupported string %s", tok.text)
+		}
+		return value, nil
+	case tok.kind == tokNumber || tok.text == "-" && p.pos+1 < len(p.toks) && p.toks[p.pos+1].kind == tokNumber:
+		p.pos++
+		text := tok.text
+		if text == "-" {
+			text += p.toks[p.pos].text
+			p.pos++
+		}
+		return numberValue(text)
+	case tok.kind == tokName:
+		p.pos++
+		switch tok.text {
+		case "True":
+			return true, nil
+		case "False":
+			return false, nil
+		case "None":
+			return nil, nil
+		}
+		// Schemas of imported packages are named like pkg.Name
+		name := tok.text
+		for p.peek() == "." && p.pos+1 < len(p.toks) && p.toks[p.pos+1].kind == tokName {
+			name += "." + p.toks[p.pos+1].text
+			p.pos += 2
+		}
+		if p.peek() == "{" {
+			return e.instance(p, name)
+		}
+		return nil, fmt.Errorf("%s is not a literal", name)
+	case p.accept("["):
+		list := []interface{}{}
+		for !p.accept("]") {
+			item, err := e.value(p)
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() == "for" {
+				return nil, fmt.Errorf("comprehensions are not literals")
+			}
+			list = append(list, item)
+			if !p.accept(",") && p.peek() != "]" {
+				return nil, fmt.Errorf("expected ',' or ']' after a list item, got '%s'", p.peek())
+			}
+		}
+		return list, nil
+	case p.peek() == "{":
+		return e.dict(p)
+	}
+	return nil, fmt.Errorf("unexpected '%s'", tok.text)
+}
+
+// dict returns the entries of a dict or schema instance body. Keys are names
+// or strings; entries use `:` or `=` and are separated by commas, which
+// line breaks between entries are written as in Field.Default.
+func (e *literalEvaluator) dict(p *exprParser) (map[string]interface{}, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	dict := make(map[string]interface{})
+	for !p.accept("}") {
+		if p.pos >= len(p.toks) {
+			return nil, fmt.Errorf("unexpected end of expression")
+		}
+		tok := p.toks[p.pos]
+		var key string
+		switch tok.kind {
+		case tokName:
+			key = tok.text
+		case tokString:
+			value, ok := kclStringValue(tok.text)
+			if !ok {
+				return nil, fmt.Errorf("unsupported key %s", tok.text)
+			}
+			key = value
+		default:
+			return nil, fmt.Errorf("unsupported key '%s'", tok.text)
+		}
+		p.pos++
+		if !p.accept(":") && !p.accept("=") {
+			return nil, fmt.Errorf("expected ':' or '=' after %s", key)
+		}
+		value, err := e.value(p)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			dict[key] = value
+		}
+		if !p.accept(",") && p.peek() != "}" {
+			return nil, fmt.Errorf("expected ',' or '}' after %s, got '%s'", key, p.peek())
+		}
+	}
+	return dict, nil
+}
+
+// instance returns a schema instance: the defaults of the schema's
+// attributes with the given attributes set
+func (e *literalEvaluator) instance(p *exprParser, name string) (map[string]interface{}, error) {
+	schema := e.schemas[name]
+	if schema == nil {
+		return nil, fmt.Errorf("%s is not a schema of the file", name)
+	}
+	if e.instantiating[name] {
+		return nil, fmt.Errorf("%s has a recursive default", name)
+	}
+	e.instantiating[name] = true
+	defer delete(e.instantiating, name)
+
+	attrs, err := e.dict(p)
+	if err != nil {
+		return nil, err
+	}
+	instance := make(map[string]interface{})
+	for _, field := range schema.Fields {
+		if _, ok := attrs[field.Name]; ok || field.Default == "" || field.Default == "Undefined" {
+			continue
+		}
+		// Defaults evaluated already, like those of imported schemas, which
+		// are written in the scope of their own package
+		if field.DefaultValue != nil {
+			instance[field.Name] = CopyValue(field.DefaultValue)
+			continue
+		}
+		value, err := e.evaluate(field.Default)
+		if err != nil {
+			return nil, fmt.Errorf("default of %s.%s: %w", name, field.Name, err)
+		}
+		if value != nil {
+			instance[field.Name] = value
+		}
+	}
+	for k, v := range attrs {
+		instance[k] = v
+	}
+	return instance, nil
+}
+
+// CopyValue returns a deep copy of an evaluated or decoded value, so that
+// defaults sharing a value can be converted and applied independently
+func CopyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = CopyValue(item)
+		}
+		return list
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			dict[k] = CopyValue(item)
+		}
+		return dict
+	}
+	return v
+}
+
+// numberValue returns the value of an integer or float literal
+func numberValue(text string) (interface{}, error) {
+	text = strings.ReplaceAll(text, "_", "")
+	if v, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return int(v), nil
+	}
+	if v, err := strconv.ParseFloat(text, 64); err == nil {
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported number %s", text)
+}
+
+// sortedSchemaNames returns the names of the schemas in order
+func sortedSchemaNames(schemas map[string]*Schema) []string {
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Severity is the severity of a diagnostic
+type Severity int
+
+const (
+	// SeverityWarning marks a problem that did not stop the conversion, like
+	// a check that could not be translated to CEL
+	SeverityWarning Severity = iota
+	// SeverityError marks a problem that would make the generated schema
+	// weaker than the source declares, like an invalid annotation argument
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic is a problem found in a KCL file, with its source position.
+// Line and Column are 1-based; 0 means unknown.
+type Diagnostic struct {
+	Severity Severity
+	File     string
+	Line     int
+	Column   int
+	Message  string
+}
+
+// String formats the diagnostic like a compiler does:
+// `file:line:column: severity: message`
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if d.File != "" {
+		b.WriteString(d.File)
+		if d.Line > 0 {
+			fmt.Fprintf(&b, ":%d", d.Line)
+			if d.Column > 0 {
+				fmt.Fprintf(&b, ":%d", d.Column)
+			}
+		}
+		b.WriteString(": ")
+	}
+	fmt.Fprintf(&b, "%s: %s", d.Severity, d.Message)
+	return b.String()
+}
+
+// Diagnostics is a list of diagnostics. As an error it is returned by
+// ParseKCLFileWithSchemas when a file has errors, and holds its warnings too.
+type Diagnostics []Diagnostic
+
+// Error lists the diagnostics, one per line
+func (d Diagnostics) Error() string {
+	lines := make([]string, len(d))
+	for i, diagnostic := range d {
+		lines[i] = diagnostic.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasErrors reports whether any diagnostic is an error
+func (d Diagnostics) HasErrors() bool {
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns the diagnostics that are errors
+func (d Diagnostics) Errors() Diagnostics {
+	var errs Diagnostics
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SeverityError {
+			errs = append(errs, diagnostic)
+		}
+	}
+	return errs
+}
+
+// Strict returns the diagnostics with warnings turned into errors
+func (d Diagnostics) Strict() Diagnostics {
+	strict := make(Diagnostics, len(d))
+	for i, diagnostic := range d {
+		diagnostic.Severity = SeverityError
+		strict[i] = diagnostic
+	}
+	return strict
+}
+
+// sort orders the diagnostics by file and position
+func (d Diagnostics) sort() {
+	sort.SliceStable(d, func(i, j int) bool {
+		if d[i].File != d[j].File {
+			return d[i].File < d[j].File
+		}
+		if d[i].Line != d[j].Line {
+			return d[i].Line < d[j].Line
+		}
+		return d[i].Column < d[j].Column
+	})
+}
+
+// warningf returns a warning at a position
+func warningf(file string, line, col int, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{Severity: SeverityWarning, File: file, Line: line, Column: col, Message: fmt.Sprintf(format, args...)}
+}
+
+// errorf returns an error at a position
+func errorf(file string, line, col int, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{Severity: SeverityError, File: file, Line: line, Column: col, Message: fmt.Sprintf(format, args...)}
+}
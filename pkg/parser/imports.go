@@ -0,0 +1,397 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// This file resolves the packages a KCL file imports, so that fields typed
+// with schemas of other packages, like `common.Tags`, expand into those
+// schemas. Imports are resolved the way the KCL compiler does: relative to
+// the importing file for `import .x`, and otherwise relative to the root of
+// the module, the directory holding kcl.mod, or inside a dependency declared
+// in kcl.mod. Imported schemas are named by the path of their package,
+// e.g. common.Tags or shared.aws.NetworkConfig.
+
+// kclMod is a KCL module: a directory with a kcl.mod file, or the directory
+// of a file outside any module
+type kclMod struct {
+	dir  string
+	name string            // package name declared in kcl.mod
+	path string            // package path of the module's root: "" for the file's own module, the dependency name for dependencies
+	deps map[string]string // dependency directories by name
+}
+
+// kclPackage is an imported package
+type kclPackage struct {
+	path    string             // package path, e.g. common or k8s.api.core.v1
+	schemas map[string]*Schema // schemas by qualified name
+}
+
+// packageFile is a parsed KCL file of a package
+type packageFile struct {
+	filename string
+	module   *kclModule
+}
+
+// packageLoader loads imported packages once per parse
+type packageLoader struct {
+	modules     map[string]*kclMod
+	packages    map[string]*kclPackage // by package path; nil while loading or if the package failed to load
+	diagnostics Diagnostics
+}
+
+func newPackageLoader() *packageLoader {
+	return &packageLoader{
+		modules:  make(map[string]*kclMod),
+		packages: make(map[string]*kclPackage),
+	}
+}
+
+// mainModule returns the module of the file being converted: the nearest
+// directory above it with a kcl.mod, or the file's directory
+func (l *packageLoader) mainModule(filename string) *kclMod {
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		dir = filepath.Dir(filename)
+	}
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, "kcl.mod")); err == nil {
+			return l.module(d, "")
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			break
+		}
+		d = parent
+	}
+	return &kclMod{dir: dir, deps: map[string]string{}}
+}
+
+// module returns the module rooted at dir, reading its kcl.mod if it has one
+func (l *packageLoader) module(dir, path string) *kclMod {
+	if mod := l.modules[dir]; mod != nil {
+		return mod
+	}
+	mod := &kclMod{dir: dir, path: path, deps: make(map[string]string)}
+	l.modules[dir] = mod
+	filename := filepath.Join(dir, "kcl.mod")
+	var file kclModFile
+	if _, err := toml.DecodeFile(filename, &file); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			l.diagnostics = append(l.diagnostics, warningf(filename, 0, 0, "failed to read kcl.mod: %v", err))
+		}
+		return mod
+	}
+	mod.name = file.Package.Name
+	for name, value := range file.Dependencies {
+		if depDir := dependencyDir(dir, name, value); depDir != "" {
+			mod.deps[name] = depDir
+		}
+	}
+	return mod
+}
+
+// kclModFile is the part of a kcl.mod file the loader reads
+type kclModFile struct {
+	Package struct {
+		Name string `toml:"name"`
+	} `toml:"package"`
+	// Dependencies are versions, like "1.29", or tables with a path,
+	// version, git url or tag
+	Dependencies map[string]interface{} `toml:"dependencies"`
+}
+
+// dependencyDir returns the directory of a dependency declared in kcl.mod:
+// its path for local dependencies, otherwise where kpm stores it, in the
+// module's vendor directory, $KCL_PKG_PATH or ~/.kcl/kpm. It returns "" if
+// the dependency has not been downloaded.
+func dependencyDir(modDir, name string, value interface{}) string {
+	fields := make(map[string]string)
+	switch value := value.(type) {
+	case string:
+		fields["version"] = value
+	case map[string]interface{}:
+		for key, v := range value {
+			if s, ok := v.(string); ok {
+				fields[key] = s
+			}
+		}
+	}
+
+	if path := fields["path"]; path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(modDir, path)
+		}
+		return path
+	}
+	version := fields["version"]
+	if version == "" {
+		version = fields["tag"]
+	}
+	roots := []string{filepath.Join(modDir, "vendor")}
+	if pkgPath := os.Getenv("KCL_PKG_PATH"); pkgPath != "" {
+		roots = append(roots, pkgPath)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		roots = append(roots, filepath.Join(home, ".kcl", "kpm"))
+	}
+	for _, root := range roots {
+		candidates := []string{filepath.Join(root, name)}
+		if version != "" {
+			candidates = append([]string{filepath.Join(root, name+"_"+version)}, candidates...)
+		}
+		for _, dir := range candidates {
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				return dir
+			}
+		}
+	}
+	return ""
+}
+
+// resolveImports loads the packages a file imports and returns them by the
+// name the file uses for them. Imports that do not resolve to a directory
+// or file, like KCL's system modules, are left out.
+func (l *packageLoader) resolveImports(mod *kclMod, filename string, imports []importStmt) map[string]*kclPackage {
+	packages := make(map[string]*kclPackage)
+	for _, imp := range imports {
+		if pkg := l.resolveImport(mod, filename, imp.path); pkg != nil {
+			packages[imp.alias] = pkg
+		}
+	}
+	return packages
+}
+
+func (l *packageLoader) resolveImport(mod *kclMod, filename, path string) *kclPackage {
+	var dir, pkgPath string
+	if strings.HasPrefix(path, ".") {
+		// Relative to the importing file: each dot after the first goes up
+		rest := strings.TrimLeft(path, ".")
+		dir = filepath.Dir(filename)
+		for i := 1; i < len(path)-len(rest); i++ {
+			dir = filepath.Dir(dir)
+		}
+		dir = filepath.Join(dir, packageDir(rest))
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(mod.dir, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+		pkgPath = joinPackagePath(mod.path, strings.ReplaceAll(filepath.ToSlash(rel), "/", "."))
+	} else {
+		first, rest, _ := strings.Cut(path, ".")
+		switch {
+		case mod.deps[first] != "":
+			depDir := mod.deps[first]
+			mod = l.module(depDir, first)
+			dir = filepath.Join(depDir, packageDir(rest))
+			pkgPath = path
+		case first == mod.name && mod.name != "":
+			// The module's own name refers to its root
+			dir = filepath.Join(mod.dir, packageDir(rest))
+			pkgPath = joinPackagePath(mod.path, rest)
+		default:
+			dir = filepath.Join(mod.dir, packageDir(path))
+			pkgPath = joinPackagePath(mod.path, path)
+		}
+	}
+	if pkgPath == "" || pkgPath == "." {
+		return nil
+	}
+	return l.load(mod, dir, pkgPath)
+}
+
+// load parses the KCL files of a package: the .k files of a directory, or
+// a single file
+func (l *packageLoader) load(mod *kclMod, dir, pkgPath string) *kclPackage {
+	if pkg, ok := l.packages[pkgPath]; ok {
+		return pkg
+	}
+	filenames := packageFiles(dir)
+	if len(filenames) == 0 {
+		return nil
+	}
+	l.packages[pkgPath] = nil
+
+	var files []packageFile
+	for _, filename := range filenames {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			l.diagnostics = append(l.diagnostics, warningf(filename, 0, 0, "failed to load package %s: %v", pkgPath, err))
+			return nil
+		}
+		module, err := parseKCLSource(filename, string(content))
+		if err != nil {
+			var diagnostics Diagnostics
+			if errors.As(err, &diagnostics) {
+				l.diagnostics = append(l.diagnostics, diagnostics...)
+			} else {
+				l.diagnostics = append(l.diagnostics, warningf(filename, 0, 0, "failed to load package %s: %v", pkgPath, err))
+			}
+			return nil
+		}
+		files = append(files, packageFile{filename: filename, module: module})
+	}
+
+	// Warnings about the package's own defaults and checks are not reported:
+	// they are the package's concern, not the importing file's. Errors, like
+	// invalid annotation arguments, change the schemas the file uses.
+	local, diagnostics, err := l.buildSchemas(mod, files)
+	if err != nil {
+		l.diagnostics = append(l.diagnostics, warningf("", 0, 0, "failed to load package %s: %v", pkgPath, err))
+		return nil
+	}
+	l.diagnostics = append(l.diagnostics, diagnostics.Errors()...)
+
+	// Name the package's schemas, and the types referring to them, by their
+	// qualified names. The @xrd, @status and @spec.path markers only apply
+	// when the package's own file is converted.
+	pkg := &kclPackage{path: pkgPath, schemas: make(map[string]*Schema)}
+	qualify := func(name string) string {
+		if local[name] != nil {
+			return pkgPath + "." + name
+		}
+		return name
+	}
+	for name, schema := range local {
+		for i := range schema.Fields {
+			schema.Fields[i].Type = renameTypes(schema.Fields[i].Type, qualify)
+		}
+		schema.Name = qualify(name)
+		schema.IsXRD, schema.IsStatus, schema.SpecPath = false, false, ""
+		pkg.schemas[schema.Name] = schema
+	}
+	l.packages[pkgPath] = pkg
+	return pkg
+}
+
+// qualifyImports rewrites the types of a schema's fields, base and mixins
+// that name schemas of imported packages to the qualified names of those
+// schemas. Types of packages that could not be loaded are qualified with the
+// import path all the same, e.g. k8s.api.core.v1.Toleration, so that they
+// can still match upstream OpenAPI definitions.
+func (l *packageLoader) qualifyImports(schema *Schema, filename string, stmts []importStmt, imports map[string]*kclPackage) {
+	if len(stmts) == 0 {
+		return
+	}
+	paths := make(map[string]string)
+	for _, stmt := range stmts {
+		if !strings.HasPrefix(stmt.path, ".") {
+			paths[stmt.alias] = stmt.path
+		}
+	}
+	qualify := func(line, col int) func(string) string {
+		return func(name string) string {
+			alias, rest, ok := strings.Cut(name, ".")
+			if !ok {
+				return name
+			}
+			pkg := imports[alias]
+			if pkg == nil {
+				if path := paths[alias]; path != "" {
+					return path + "." + rest
+				}
+				return name
+			}
+			qualified := pkg.path + "." + rest
+			if pkg.schemas[qualified] == nil {
+				l.diagnostics = append(l.diagnostics, warningf(filename, line, col, "schema %s not found in package %s", rest, pkg.path))
+				return name
+			}
+			return qualified
+		}
+	}
+	for i := range schema.Fields {
+		schema.Fields[i].Type = renameTypes(schema.Fields[i].Type, qualify(schema.Fields[i].Line, schema.Fields[i].Column))
+	}
+	if schema.Base != "" {
+		schema.Base = qualify(schema.Line, schema.Column)(schema.Base)
+	}
+	for i, mixin := range schema.Mixins {
+		schema.Mixins[i] = qualify(schema.Line, schema.Column)(mixin)
+	}
+}
+
+// importedScope returns the schemas of imported packages by the names a
+// file uses for them, e.g. c.Tags for common.Tags imported as c
+func importedScope(imports map[string]*kclPackage) map[string]*Schema {
+	scope := make(map[string]*Schema)
+	for alias, pkg := range imports {
+		for name, schema := range pkg.schemas {
+			scope[alias+strings.TrimPrefix(name, pkg.path)] = schema
+		}
+	}
+	return scope
+}
+
+// importedDecl turns an imported schema, whose inheritance is already
+// resolved, into a declaration schemas of the file can inherit from
+func importedDecl(schema *Schema) *schemaDecl {
+	copied := *schema
+	copied.Base = ""
+	copied.Mixins = nil
+	return &schemaDecl{
+		schema:    &copied,
+		overrides: make(map[string]string),
+		commented: make(map[string]bool),
+	}
+}
+
+// typeRefRegex matches the names and string literals of a type expression
+var typeRefRegex = regexp.MustCompile(`"[^"]*"|'[^']*'|[A-Za-z_][\w.]*`)
+
+// renameTypes replaces the names in a type expression, leaving literal
+// types alone
+func renameTypes(typ string, rename func(string) string) string {
+	return typeRefRegex.ReplaceAllStringFunc(typ, func(s string) string {
+		if s[0] == '"' || s[0] == '\'' {
+			return s
+		}
+		return rename(s)
+	})
+}
+
+// packageFiles returns the KCL files of the package in dir, or the file
+// dir.k, in order
+func packageFiles(dir string) []string {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.k"))
+		var files []string
+		for _, match := range matches {
+			if !strings.HasSuffix(match, "_test.k") {
+				files = append(files, match)
+			}
+		}
+		sort.Strings(files)
+		return files
+	}
+	if info, err := os.Stat(dir + ".k"); err == nil && !info.IsDir() {
+		return []string{dir + ".k"}
+	}
+	return nil
+}
+
+// packageDir converts a dotted package path into a relative directory
+func packageDir(path string) string {
+	return filepath.FromSlash(strings.ReplaceAll(path, ".", "/"))
+}
+
+// joinPackagePath joins a module's package path and a path inside it
+func joinPackagePath(base, path string) string {
+	switch {
+	case base == "":
+		return path
+	case path == "":
+		return base
+	}
+	return base + "." + path
+}
@@ -0,0 +1,135 @@
+package parser
+
+import "fmt"
+
+// schemaDecl is a schema or mixin as declared, before inheritance is resolved
+type schemaDecl struct {
+	schema    *Schema
+	overrides map[string]string // untyped `name = value` assignments overriding inherited defaults
+	commented map[string]bool   // attributes declared with their own comments or docstring
+	kclTypes  *schemaTypeCache  // schema types of the declaring file resolved by the KCL compiler
+}
+
+// inheritanceResolver flattens base schemas and mixins into the schemas that
+// use them, so every Schema carries its complete list of fields
+type inheritanceResolver struct {
+	decls     map[string]*schemaDecl
+	resolved  map[string]*Schema
+	resolving map[string]bool
+}
+
+func newInheritanceResolver(decls map[string]*schemaDecl) *inheritanceResolver {
+	return &inheritanceResolver{
+		decls:     decls,
+		resolved:  make(map[string]*Schema),
+		resolving: make(map[string]bool),
+	}
+}
+
+// resolve returns the named schema with the fields and checks of its base
+// schema and mixins merged in. Inherited fields come first, in declaration
+// order; fields declared in the schema itself override inherited ones.
+func (r *inheritanceResolver) resolve(name string) (*Schema, error) {
+	if schema := r.resolved[name]; schema != nil {
+		return schema, nil
+	}
+	decl := r.decls[name]
+	if decl == nil {
+		return nil, fmt.Errorf("schema '%s' not found", name)
+	}
+	if r.resolving[name] {
+		return nil, fmt.Errorf("schema '%s' inherits from itself", name)
+	}
+	r.resolving[name] = true
+	defer delete(r.resolving, name)
+
+	own := decl.schema
+	merged := *own
+	merged.Checks = nil
+	fields := &fieldMerger{index: make(map[string]int)}
+
+	var parents []string
+	if own.Base != "" {
+		parents = append(parents, own.Base)
+	}
+	parents = append(parents, own.Mixins...)
+
+	external := false
+	for _, parent := range parents {
+		if r.decls[parent] == nil {
+			external = true
+			continue
+		}
+		parentSchema, err := r.resolve(parent)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range parentSchema.Fields {
+			fields.add(field)
+		}
+		merged.Checks = append(merged.Checks, parentSchema.Checks...)
+	}
+
+	// Parents declared in other modules are only known to the KCL compiler:
+	// take the attributes it resolved for this schema that are not declared here
+	if external {
+		declared := make(map[string]bool)
+		for _, field := range own.Fields {
+			declared[field.Name] = true
+		}
+		for _, field := range kclSchemaFields(decl.kclTypes.schema(name)) {
+			if !declared[field.Name] {
+				fields.add(field)
+			}
+		}
+	}
+
+	for _, field := range own.Fields {
+		fields.redeclare(field, decl.commented[field.Name])
+	}
+	for i := range fields.fields {
+		if def, ok := decl.overrides[fields.fields[i].Name]; ok {
+			fields.fields[i].Default = def
+		}
+	}
+
+	merged.Fields = fields.fields
+	merged.Checks = append(merged.Checks, own.Checks...)
+	r.resolved[name] = &merged
+	return &merged, nil
+}
+
+// fieldMerger collects fields by name, keeping the position of the first declaration
+type fieldMerger struct {
+	fields []Field
+	index  map[string]int
+}
+
+// add appends a field or replaces an earlier field with the same name
+func (m *fieldMerger) add(field Field) {
+	if i, ok := m.index[field.Name]; ok {
+		m.fields[i] = field
+		return
+	}
+	m.index[field.Name] = len(m.fields)
+	m.fields = append(m.fields, field)
+}
+
+// redeclare applies a field declared in the schema itself. A redeclaration
+// without comments changes the type, optionality and default of an inherited
+// field but keeps its description and annotations.
+func (m *fieldMerger) redeclare(field Field, commented bool) {
+	i, ok := m.index[field.Name]
+	if !ok || commented {
+		m.add(field)
+		return
+	}
+	inherited := m.fields[i]
+	inherited.Type = field.Type
+	inherited.Required = field.Required
+	inherited.Line = field.Line
+	if field.Default != "" {
+		inherited.Default = field.Default
+	}
+	m.fields[i] = inherited
+}
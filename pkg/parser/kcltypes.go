@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	kcl "kcl-lang.io/kcl-go"
+)
+
+// loadSchemaTypes resolves the schema types declared in a KCL file using the
+// KCL compiler's schema type API. If the file's imports cannot be resolved,
+// it retries with the imports removed, like evaluateMetadataWithKCL does.
+// It returns nil when the file cannot be compiled at all.
+func loadSchemaTypes(filename string) map[string]*kcl.KclType {
+	types, err := kcl.GetSchemaTypeMapping(filename, nil, "")
+	if err == nil {
+		return types
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	types, err = kcl.GetSchemaTypeMapping(filename, stripImports(string(content)), "")
+	if err != nil {
+		return nil
+	}
+	return types
+}
+
+// kclTypeString renders a resolved KCL type in the syntax used by Field.Type
+func kclTypeString(t *kcl.KclType) string {
+	switch t.GetType() {
+	case "list":
+		return "[" + kclTypeString(t.GetItem()) + "]"
+	case "dict":
+		return "{" + kclTypeString(t.GetKey()) + ":" + kclTypeString(t.GetItem()) + "}"
+	case "union":
+		parts := make([]string, 0, len(t.GetUnionTypes()))
+		for _, u := range t.GetUnionTypes() {
+			parts = append(parts, kclTypeString(u))
+		}
+		return strings.Join(parts, " | ")
+	case "schema":
+		// Schemas of other packages are qualified like imported ones
+		if pkg := t.GetPkgPath(); pkg != "" && pkg != "__main__" {
+			return pkg + "." + t.GetSchemaName()
+		}
+		return t.GetSchemaName()
+	case "":
+		return "any"
+	default:
+		return t.GetType()
+	}
+}
+
+// stripImports blanks the import statements of KCL source, keeping the
+// lines of the other statements where they are
+func stripImports(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "import ") {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// schemaTypeCache loads the KCL schema types of a file on first use
+type schemaTypeCache struct {
+	filename string
+	loaded   bool
+	types    map[string]*kcl.KclType
+}
+
+// schema returns the resolved type of the named schema, or nil
+func (c *schemaTypeCache) schema(name string) *kcl.KclType {
+	if !c.loaded {
+		c.types = loadSchemaTypes(c.filename)
+		c.loaded = true
+	}
+	return c.types[name]
+}
+
+// kclSchemaFields converts the attributes of a resolved schema type into
+// fields, in declaration order. The compiler's view carries types, defaults
+// and docstring descriptions but none of the comment annotations.
+func kclSchemaFields(t *kcl.KclType) []Field {
+	props := t.GetProperties()
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		li, lj := props[names[i]].GetLine(), props[names[j]].GetLine()
+		if li != lj {
+			return li < lj
+		}
+		return names[i] < names[j]
+	})
+
+	required := make(map[string]bool)
+	for _, name := range t.GetRequired() {
+		required[name] = true
+	}
+
+	fields := make([]Field, 0, len(names))
+	for _, name := range names {
+		prop := props[name]
+		fields = append(fields, Field{
+			Name:        strings.TrimPrefix(name, "$"),
+			Type:        kclTypeString(prop),
+			Description: prop.GetDescription(),
+			Required:    required[name],
+			Default:     prop.GetDefault(),
+		})
+	}
+	return fields
+}
@@ -1,7 +1,7 @@
 package parser
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
@@ -20,15 +20,38 @@ type Schema struct {
 	IsStatus    bool   // marked with @status annotation - used as status schema
 	SpecPath    string // marked with @spec.path annotation - used to place fields at spec.path
 	// Schema-level OneOf and AnyOf validations (apply to parameters object)
-	OneOf [][]string // oneOf validation - array of required field combinations
-	AnyOf [][]string // anyOf validation - array of required field combinations
+	OneOf  [][]string // oneOf validation - array of required field combinations
+	AnyOf  [][]string // anyOf validation - array of required field combinations
+	Checks []Check    // expressions from the schema's check block, including inherited ones
+	Base   string     // parent schema, e.g. BaseResource for `schema Database(BaseResource):`
+	Mixins []string   // mixins listed in the schema's `mixin [...]` statement
+	Line   int        // source line of the schema statement
+	Column int        // source column of the schema statement
+	File   string     // file the schema was parsed from
+	// CEL rules translated from the checks (apply to the schema's object)
+	CELValidations []CELValidation
+	// Version settings from @xrd(version="v1beta1", ...) for multi-version XRDs
+	Version            string
+	Served             *bool
+	Referenceable      *bool
+	Deprecated         bool
+	DeprecationWarning string
+}
+
+// Check represents an expression from a KCL schema check block
+type Check struct {
+	Expr    string // the KCL expression, e.g. `len(name) <= 63`
+	Message string // optional message given after the expression
+	Line    int    // source line of the expression
+	Column  int    // source column of the expression
 }
 
 // ParseResult contains all schemas parsed from a file
 type ParseResult struct {
-	Schemas  map[string]*Schema // map of schema name to schema
-	Primary  *Schema            // the last/main schema in the file
-	Metadata *XRDMetadata       // XRD metadata from KCL variables
+	Schemas     map[string]*Schema // map of schema name to schema
+	Primary     *Schema            // the last/main schema in the file
+	Metadata    *XRDMetadata       // XRD metadata from KCL variables
+	Diagnostics Diagnostics        // warnings about problems that did not stop parsing, e.g. untranslated checks
 }
 
 // XRDMetadata contains metadata for XRD generation parsed from KCL variables
@@ -41,6 +64,22 @@ type XRDMetadata struct {
 	Served                      *bool
 	Referenceable               *bool
 	StatusPreserveUnknownFields *bool
+	Scope                       string // Crossplane v2 XRD scope: Namespaced, Cluster or LegacyCluster
+	// XRD spec settings and metadata
+	DefaultCompositionRef          string
+	EnforcedCompositionRef         string
+	DefaultCompositionUpdatePolicy string
+	DefaultCompositeDeletePolicy   string
+	ConnectionSecretKeys           []string
+	Labels                         map[string]string
+	Annotations                    map[string]string
+	// Overrides for the generated spec.names
+	Plural     string
+	Singular   string
+	ShortNames []string
+	ListKind   string
+	// Expansions of a recursive schema within itself; 0 means recursion is an error
+	MaxRecursionDepth int
 }
 
 // PrinterColumn represents an additional printer column
@@ -58,19 +97,32 @@ type Field struct {
 	Description string
 	Required    bool
 	Default     string
+	// DefaultValue is the evaluated Default: a string, int, float64, bool,
+	// []interface{} or map[string]interface{}; nil if it is None or could not
+	// be evaluated
+	DefaultValue interface{}
 	// Validation fields
-	Pattern        string          // regex pattern for string validation
-	MinLength      *int            // minimum length for strings
-	MaxLength      *int            // maximum length for strings
-	Minimum        *int            // minimum value for numbers
-	Maximum        *int            // maximum value for numbers
-	MinItems       *int            // minimum number of items in arrays
-	MaxItems       *int            // maximum number of items in arrays
-	Format         string          // format for strings (e.g., "date-time")
-	ItemsFormat    string          // format for array items (e.g., "email" for [str] arrays)
-	Enum           []string        // enumeration of allowed values
-	Immutable      bool            // x-kubernetes-immutable marker
-	CELValidations []CELValidation // CEL validation rules
+	Pattern          string          // regex pattern for string validation
+	MinLength        *int            // minimum length for strings
+	MaxLength        *int            // maximum length for strings
+	Minimum          *float64        // minimum value for numbers
+	Maximum          *float64        // maximum value for numbers
+	ExclusiveMinimum bool            // the value must be greater than Minimum
+	ExclusiveMaximum bool            // the value must be less than Maximum
+	MultipleOf       *float64        // numbers must be a multiple of this
+	MinItems         *int            // minimum number of items in arrays
+	MaxItems         *int            // maximum number of items in arrays
+	UniqueItems      bool            // @uniqueItems: array items must be unique
+	MinProperties    *int            // minimum number of entries in maps
+	MaxProperties    *int            // maximum number of entries in maps
+	Nullable         bool            // @nullable: null is kept instead of pruned
+	Format           string          // format for strings (e.g., "date-time")
+	ItemsFormat      string          // format for array items (e.g., "email" for [str] arrays)
+	Enum             []string        // enumeration of allowed values
+	Immutable        bool            // @immutable: the value cannot change after creation
+	ImmutableOnceSet bool            // @immutableOnceSet: the value cannot change once set
+	AppendOnly       bool            // @appendOnly: list items can be added but not removed
+	CELValidations   []CELValidation // CEL validation rules
 	// Kubernetes-specific annotations
 	PreserveUnknownFields          bool     // x-kubernetes-preserve-unknown-fields
 	MapType                        string   // x-kubernetes-map-type
@@ -80,17 +132,48 @@ type Field struct {
 	IsSpec                         bool     // marks field as spec-level field (goes directly under spec, not in spec.parameters)
 	AdditionalPropertiesAnnotation bool     // @additionalProperties annotation
 	ItemsPreserveUnknownFields     bool     // @itemsPreserveUnknownFields - only applies to array items
+	IntOrString                    bool     // x-kubernetes-int-or-string
+	EmbeddedResource               bool     // x-kubernetes-embedded-resource
 	// OneOf and AnyOf validations
-	OneOf [][]string // oneOf validation - array of required field combinations
-	AnyOf [][]string // anyOf validation - array of required field combinations
+	OneOf  [][]string // oneOf validation - array of required field combinations
+	AnyOf  [][]string // anyOf validation - array of required field combinations
+	Line   int        // source line of the field declaration
+	Column int        // source column of the field declaration
+	// Annotations are the custom annotations of the field, registered with
+	// RegisterAnnotation
+	Annotations []Annotation
 }
 
 // CELValidation represents a CEL validation rule
 type CELValidation struct {
-	Rule    string
-	Message string
+	Rule              string
+	Message           string
+	MessageExpression string // CEL expression evaluating to the message
+	Reason            string // FieldValueInvalid, FieldValueForbidden, FieldValueRequired or FieldValueDuplicate
+	FieldPath         string // path of the field to report, relative to the object, e.g. .spec.replicas
+	OptionalOldSelf   bool   // also evaluate the rule on create, with oldSelf as an optional
+	Line              int    // source line of the @validate annotation or check
 }
 
+// quotedRegex matches a single or double quoted string, which may contain
+// the other quote and backslash escapes
+const quotedRegex = `'(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*"`
+
+// numberRegex matches an integer or float, which may be negative
+const numberRegex = `(-?\d+(?:\.\d+)?(?:[eE][-+]?\d+)?)`
+
+var (
+	// celValidationRegex matches the start of a @validate annotation,
+	// celValidationArgRegex one of its arguments
+	celValidationRegex    = regexp.MustCompile(`@validate\s*\(`)
+	celValidationArgRegex = regexp.MustCompile(`^\s*(?:(\w+)\s*=\s*)?(` + quotedRegex + `|\w+)\s*([,)])`)
+
+	// typeNameRegex matches the (possibly qualified) names in a type expression,
+	// typeLiteralRegex the string literals of literal types
+	typeNameRegex    = regexp.MustCompile(`[A-Za-z_][\w.]*`)
+	typeLiteralRegex = regexp.MustCompile(`"[^"]*"|'[^']*'`)
+)
+
 // ParseKCLFile parses a KCL schema file and returns a Schema structure
 // For backward compatibility, it returns the primary (last) schema
 func ParseKCLFile(filename string) (*Schema, error) {
@@ -101,304 +184,55 @@ func ParseKCLFile(filename string) (*Schema, error) {
 	return result.Primary, nil
 }
 
-// ParseKCLFileWithSchemas parses a KCL schema file and returns all schemas
+// ParseKCLFileWithSchemas parses a KCL schema file and returns all schemas.
+// Problems with positions in the file are returned as Diagnostics: warnings
+// in the result, or as the error if any of them is an error.
 func ParseKCLFileWithSchemas(filename string) (*ParseResult, error) {
 	// First, try to evaluate metadata using KCL runtime for more flexibility
-	kclMetadata, _ := evaluateMetadataWithKCL(filename)
+	kclMetadata, kclErr := evaluateMetadataWithKCL(filename)
 
-	file, err := os.Open(filename)
+	content, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	var currentSchema *Schema
-	var currentField *Field
-	var inSchema bool
-	var inDocstring bool
-	var docstringLines []string
-
-	schemas := make(map[string]*Schema)
-	var primarySchema *Schema
-	metadata := &XRDMetadata{}
-
-	schemaRegex := regexp.MustCompile(`^\s*schema\s+(\w+)\s*:?\s*$`)
-	fieldRegex := regexp.MustCompile(`^\s*\$?(\w+)\s*(\?)?:\s*(.+?)(?:\s*=\s*(.+))?\s*$`)
-
-	// Metadata variable patterns (using __xrd_ prefix for unique naming)
-	xrKindRegex := regexp.MustCompile(`^\s*__xrd_kind\s*=\s*['"](.*?)['"]\s*$`)
-	xrVersionRegex := regexp.MustCompile(`^\s*__xrd_version\s*=\s*['"](.*?)['"]\s*$`)
-	groupRegex := regexp.MustCompile(`^\s*__xrd_group\s*=\s*['"](.*?)['"]\s*$`)
-	// Also match __xrd_group with any expression (skip parsing, user must provide via CLI)
-	groupExprRegex := regexp.MustCompile(`^\s*__xrd_group\s*=\s*(.+)$`)
-	categoriesRegex := regexp.MustCompile(`^\s*__xrd_categories\s*=\s*\[(.*?)\]\s*$`)
-	servedRegex := regexp.MustCompile(`^\s*__xrd_served\s*=\s*(true|false|True|False)\s*$`)
-	referenceableRegex := regexp.MustCompile(`^\s*__xrd_referenceable\s*=\s*(true|false|True|False)\s*$`)
-	printerColumnsRegex := regexp.MustCompile(`^\s*__xrd_printer_columns\s*=\s*\[(.*?)\]\s*$`)
-
-	// Validation annotation patterns
-	patternRegex := regexp.MustCompile(`@pattern\s*\(\s*['"](.*?)['"]\s*\)`)
-	minLengthRegex := regexp.MustCompile(`@minLength\s*\(\s*(\d+)\s*\)`)
-	maxLengthRegex := regexp.MustCompile(`@maxLength\s*\(\s*(\d+)\s*\)`)
-	minimumRegex := regexp.MustCompile(`@minimum\s*\(\s*(\d+)\s*\)`)
-	maximumRegex := regexp.MustCompile(`@maximum\s*\(\s*(\d+)\s*\)`)
-	minItemsRegex := regexp.MustCompile(`@minItems\s*\(\s*(\d+)\s*\)`)
-	maxItemsRegex := regexp.MustCompile(`@maxItems\s*\(\s*(\d+)\s*\)`)
-	formatRegex := regexp.MustCompile(`@format\s*\(\s*['"](.*?)['"]\s*\)`)
-	itemsFormatRegex := regexp.MustCompile(`@itemsFormat\s*\(\s*['"](.*?)['"]\s*\)`)
-	enumRegex := regexp.MustCompile(`@enum\s*\(\s*\[(.*?)\]\s*\)`)
-	immutableRegex := regexp.MustCompile(`@immutable`)
-	celValidationRegex := regexp.MustCompile(`@validate\s*\(\s*['"](.*?)['"]\s*(?:,\s*['"](.*?)['"]\s*)?\)`)
-	preserveUnknownFieldsRegex := regexp.MustCompile(`@preserveUnknownFields`)
-	itemsPreserveUnknownFieldsRegex := regexp.MustCompile(`@itemsPreserveUnknownFields`)
-	additionalPropertiesRegex := regexp.MustCompile(`@additionalProperties`)
-	mapTypeRegex := regexp.MustCompile(`@mapType\s*\(\s*['"](.*?)['"]\s*\)`)
-	listTypeRegex := regexp.MustCompile(`@listType\s*\(\s*['"](.*?)['"]\s*\)`)
-	listMapKeysRegex := regexp.MustCompile(`@listMapKeys\s*\(\s*\[(.*?)\]\s*\)`)
-	statusAnnotationRegex := regexp.MustCompile(`@status`)
-	specAnnotationRegex := regexp.MustCompile(`@spec`)
-	specPathAnnotationRegex := regexp.MustCompile(`@spec\.(\w+)`)
-	xrdAnnotationRegex := regexp.MustCompile(`@xrd`)
-	oneOfRegex := regexp.MustCompile(`@oneOf\s*\(\s*\[(.*?)\]\s*\)`)
-	anyOfRegex := regexp.MustCompile(`@anyOf\s*\(\s*\[(.*?)\]\s*\)`)
-
-	var pendingAnnotations []string
-	var pendingComments []string
-
-	// Track variable assignments for resolving expressions
-	variables := make(map[string]string)
-	// Regex for simple variable assignments like: _xrSubgroup = "aws"
-	varAssignRegex := regexp.MustCompile(`^\s*(_\w+)\s*=\s*['"](.*?)['"]\s*$`)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		trimmedLine := strings.TrimSpace(line)
-
-		// Skip empty lines (except docstrings and when collecting comments)
-		if trimmedLine == "" && !inDocstring {
-			// Clear pending comments on empty line if not in schema
-			if !inSchema {
-				pendingComments = nil
-			}
-			continue
-		}
-
-		// Parse metadata variables (before schema definitions)
-		if !inSchema {
-			// Track variable assignments for later resolution
-			if matches := varAssignRegex.FindStringSubmatch(trimmedLine); len(matches) > 1 {
-				varName := matches[1]
-				varValue := matches[2]
-				variables[varName] = varValue
-			}
-
-			if matches := xrKindRegex.FindStringSubmatch(trimmedLine); len(matches) > 1 {
-				metadata.XRKind = matches[1]
-				continue
-			}
-			if matches := xrVersionRegex.FindStringSubmatch(trimmedLine); len(matches) > 1 {
-				metadata.XRVersion = matches[1]
-				continue
-			}
-			if matches := groupRegex.FindStringSubmatch(trimmedLine); len(matches) > 1 {
-				metadata.Group = matches[1]
-				continue
-			}
-			// If __xrd_group doesn't match the simple pattern, try to resolve format expressions
-			if groupExprRegex.MatchString(trimmedLine) && !groupRegex.MatchString(trimmedLine) {
-				// Try to resolve format expressions like: "{}.{}".format(var1, var2)
-				if resolvedGroup := resolveFormatExpression(trimmedLine, variables); resolvedGroup != "" {
-					metadata.Group = resolvedGroup
-				}
-				// If resolution failed, user will need to provide --group flag
-				continue
-			}
-			if matches := categoriesRegex.FindStringSubmatch(trimmedLine); len(matches) > 1 {
-				categoriesStr := matches[1]
-				categories := strings.Split(categoriesStr, ",")
-				for i, cat := range categories {
-					cat = strings.TrimSpace(cat)
-					cat = strings.Trim(cat, `"'`)
-					categories[i] = cat
-				}
-				metadata.Categories = categories
-				continue
-			}
-			if matches := servedRegex.FindStringSubmatch(trimmedLine); len(matches) > 1 {
-				served := strings.ToLower(matches[1]) == "true"
-				metadata.Served = &served
-				continue
-			}
-			if matches := referenceableRegex.FindStringSubmatch(trimmedLine); len(matches) > 1 {
-				referenceable := strings.ToLower(matches[1]) == "true"
-				metadata.Referenceable = &referenceable
-				continue
-			}
-			if matches := printerColumnsRegex.FindStringSubmatch(trimmedLine); len(matches) > 1 {
-				columnsStr := matches[1]
-				// Parse printer columns format: "Name:string:.metadata.name:Description", "Age:integer:.status.age:Age in days"
-				columnStrs := splitPrinterColumns(columnsStr)
-				for _, colStr := range columnStrs {
-					parts := strings.Split(colStr, ":")
-					if len(parts) >= 3 {
-						pc := PrinterColumn{
-							Name:     parts[0],
-							Type:     parts[1],
-							JSONPath: parts[2],
-						}
-						if len(parts) >= 4 {
-							pc.Description = parts[3]
-						}
-						metadata.PrinterColumns = append(metadata.PrinterColumns, pc)
-					}
-				}
-				continue
-			}
-		}
-
-		// Check for comments (annotations and descriptions)
-		if strings.HasPrefix(trimmedLine, "#") && !inDocstring {
-			commentText := strings.TrimPrefix(trimmedLine, "#")
-			commentText = strings.TrimSpace(commentText)
 
-			// Check if it's an annotation
-			if strings.HasPrefix(commentText, "@") {
-				pendingAnnotations = append(pendingAnnotations, trimmedLine)
-			} else if inSchema {
-				// It's a regular comment - store for next field description
-				pendingComments = append(pendingComments, commentText)
-			}
-			continue
+	module, err := parseKCLSource(filename, string(content))
+	if err != nil {
+		var diagnostics Diagnostics
+		if errors.As(err, &diagnostics) {
+			return nil, diagnostics
 		}
+		return nil, fmt.Errorf("failed to parse file: %w", err)
+	}
 
-		// Handle docstrings
-		if strings.Contains(trimmedLine, `"""`) || strings.Contains(trimmedLine, `r"""`) {
-			if inDocstring {
-				inDocstring = false
-				if currentField != nil {
-					currentField.Description = strings.Join(docstringLines, " ")
-				} else if currentSchema != nil && currentSchema.Description == "" {
-					currentSchema.Description = strings.Join(docstringLines, " ")
-				}
-				docstringLines = nil
-			} else {
-				inDocstring = true
-			}
-			continue
-		}
+	metadata, diagnostics := parseMetadata(module, kclErr)
 
-		if inDocstring {
-			docstringLines = append(docstringLines, trimmedLine)
-			continue
+	// Schemas of the file, with the schemas of the packages it imports
+	// available to nested types under their qualified names
+	loader := newPackageLoader()
+	schemas, schemaDiagnostics, err := loader.buildSchemas(loader.mainModule(filename), []packageFile{{filename: filename, module: module}})
+	if err != nil {
+		return nil, err
+	}
+	diagnostics = append(diagnostics, schemaDiagnostics...)
+	var primarySchema *Schema
+	for _, stmt := range module.schemas {
+		if stmt.kind == "schema" {
+			primarySchema = schemas[stmt.name]
 		}
-
-		// Check for schema definition
-		if matches := schemaRegex.FindStringSubmatch(line); matches != nil {
-			// Save previous schema if exists
-			if currentSchema != nil {
-				schemas[currentSchema.Name] = currentSchema
-				primarySchema = currentSchema
-			}
-
-			// Start new schema
-			currentSchema = &Schema{
-				Name:   matches[1],
-				Fields: []Field{},
-			}
-
-			// Check if this schema is marked with @xrd or @status annotation
-			for _, annotation := range pendingAnnotations {
-				if xrdAnnotationRegex.MatchString(annotation) {
-					currentSchema.IsXRD = true
-				}
-				if statusAnnotationRegex.MatchString(annotation) {
-					currentSchema.IsStatus = true
-				}
-				// Check for @spec.path annotation
-				if matches := specPathAnnotationRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-					currentSchema.SpecPath = matches[1]
-				}
-				// Check for schema-level oneOf
-				if matches := oneOfRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-					currentSchema.OneOf = parseRequiredCombinations(matches[1])
-				}
-				// Check for schema-level anyOf
-				if matches := anyOfRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-					currentSchema.AnyOf = parseRequiredCombinations(matches[1])
-				}
-			}
-			pendingAnnotations = nil
-			pendingComments = nil
-
-			inSchema = true
+	}
+	for _, pkg := range loader.packages {
+		if pkg == nil {
 			continue
 		}
-
-		// Check if we should exit schema (non-indented line that's not empty or comment)
-		if inSchema && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") && trimmedLine != "" {
-			// Non-indented line - schema has ended
-			inSchema = false
-			currentField = nil
-		}
-
-		// Parse field definitions
-		if inSchema && currentSchema != nil {
-			if matches := fieldRegex.FindStringSubmatch(line); matches != nil {
-				fieldName := matches[1]
-				optional := matches[2] == "?"
-				fieldType := strings.TrimSpace(matches[3])
-				defaultValue := ""
-				if len(matches) > 4 {
-					defaultValue = strings.TrimSpace(matches[4])
-				}
-
-				// Clean up the type (remove "default is" text if present)
-				if strings.Contains(fieldType, ",") {
-					parts := strings.Split(fieldType, ",")
-					fieldType = strings.TrimSpace(parts[0])
-				}
-
-				// Remove inline comments from default value if present
-				if defaultValue != "" && strings.Contains(defaultValue, "#") {
-					parts := strings.SplitN(defaultValue, "#", 2)
-					defaultValue = strings.TrimSpace(parts[0])
-				}
-
-				field := Field{
-					Name:     fieldName,
-					Type:     fieldType,
-					Required: !optional,
-					Default:  defaultValue,
-				}
-
-				// Set description from pending comments (above field)
-				if len(pendingComments) > 0 {
-					field.Description = strings.Join(pendingComments, "\n")
-					pendingComments = nil
-				}
-
-				// Apply validation annotations from pending comments
-				applyValidationAnnotations(&field, pendingAnnotations,
-					patternRegex, minLengthRegex, maxLengthRegex,
-					minimumRegex, maximumRegex, minItemsRegex, maxItemsRegex, formatRegex, itemsFormatRegex, enumRegex, immutableRegex, celValidationRegex,
-					preserveUnknownFieldsRegex, itemsPreserveUnknownFieldsRegex, additionalPropertiesRegex, mapTypeRegex, listTypeRegex, listMapKeysRegex, statusAnnotationRegex, specAnnotationRegex, oneOfRegex, anyOfRegex)
-				pendingAnnotations = nil
-
-				currentSchema.Fields = append(currentSchema.Fields, field)
-				currentField = &currentSchema.Fields[len(currentSchema.Fields)-1]
-			}
+		for name, schema := range pkg.schemas {
+			schemas[name] = schema
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
-	}
-
-	// Save the last schema
-	if currentSchema != nil {
-		schemas[currentSchema.Name] = currentSchema
-		primarySchema = currentSchema
+	diagnostics = append(diagnostics, loader.diagnostics...)
+	diagnostics.sort()
+	if diagnostics.HasErrors() {
+		return nil, diagnostics
 	}
 
 	if primarySchema == nil {
@@ -428,165 +262,546 @@ func ParseKCLFileWithSchemas(filename string) (*ParseResult, error) {
 	if kclMetadata.StatusPreserveUnknownFields != nil {
 		metadata.StatusPreserveUnknownFields = kclMetadata.StatusPreserveUnknownFields
 	}
+	if kclMetadata.Scope != "" {
+		metadata.Scope = kclMetadata.Scope
+	}
+	if kclMetadata.DefaultCompositionRef != "" {
+		metadata.DefaultCompositionRef = kclMetadata.DefaultCompositionRef
+	}
+	if kclMetadata.EnforcedCompositionRef != "" {
+		metadata.EnforcedCompositionRef = kclMetadata.EnforcedCompositionRef
+	}
+	if kclMetadata.DefaultCompositionUpdatePolicy != "" {
+		metadata.DefaultCompositionUpdatePolicy = kclMetadata.DefaultCompositionUpdatePolicy
+	}
+	if kclMetadata.DefaultCompositeDeletePolicy != "" {
+		metadata.DefaultCompositeDeletePolicy = kclMetadata.DefaultCompositeDeletePolicy
+	}
+	if len(kclMetadata.ConnectionSecretKeys) > 0 {
+		metadata.ConnectionSecretKeys = kclMetadata.ConnectionSecretKeys
+	}
+	if len(kclMetadata.Labels) > 0 {
+		metadata.Labels = kclMetadata.Labels
+	}
+	if len(kclMetadata.Annotations) > 0 {
+		metadata.Annotations = kclMetadata.Annotations
+	}
+	if kclMetadata.Plural != "" {
+		metadata.Plural = kclMetadata.Plural
+	}
+	if kclMetadata.Singular != "" {
+		metadata.Singular = kclMetadata.Singular
+	}
+	if len(kclMetadata.ShortNames) > 0 {
+		metadata.ShortNames = kclMetadata.ShortNames
+	}
+	if kclMetadata.ListKind != "" {
+		metadata.ListKind = kclMetadata.ListKind
+	}
+	if kclMetadata.MaxRecursionDepth != 0 {
+		metadata.MaxRecursionDepth = kclMetadata.MaxRecursionDepth
+	}
 
 	return &ParseResult{
-		Schemas:  schemas,
-		Primary:  primarySchema,
-		Metadata: metadata,
+		Schemas:     schemas,
+		Primary:     primarySchema,
+		Metadata:    metadata,
+		Diagnostics: diagnostics,
 	}, nil
 }
 
-// applyValidationAnnotations applies validation annotations from comments to a field
-func applyValidationAnnotations(field *Field, annotations []string,
-	patternRegex, minLengthRegex, maxLengthRegex, minimumRegex, maximumRegex, minItemsRegex, maxItemsRegex, formatRegex, itemsFormatRegex, enumRegex, immutableRegex, celValidationRegex,
-	preserveUnknownFieldsRegex, itemsPreserveUnknownFieldsRegex, additionalPropertiesRegex, mapTypeRegex, listTypeRegex, listMapKeysRegex, statusAnnotationRegex, specAnnotationRegex, oneOfRegex, anyOfRegex *regexp.Regexp) {
+// buildSchemas builds the schemas declared in the files of a package and
+// merges in the attributes they inherit. Types naming schemas of imported
+// packages are rewritten to the qualified names of those schemas.
+func (l *packageLoader) buildSchemas(mod *kclMod, files []packageFile) (map[string]*Schema, Diagnostics, error) {
+	// Names every field type may refer to without the KCL compiler's help
+	localTypes := make(map[string]bool)
+	for _, file := range files {
+		for _, stmt := range file.module.schemas {
+			localTypes[stmt.name] = true
+		}
+	}
 
-	for _, annotation := range annotations {
-		// Check for pattern
-		if matches := patternRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			field.Pattern = matches[1]
+	// Build schemas and mixins from their own statements first, then merge in
+	// the attributes they inherit
+	decls := make(map[string]*schemaDecl)
+	scopes := make([]map[string]*Schema, len(files))
+	var diagnostics Diagnostics
+	for i, file := range files {
+		diagnostics = append(diagnostics, file.module.diagnostics...)
+		imports := l.resolveImports(mod, file.filename, file.module.imports)
+		scopes[i] = importedScope(imports)
+		fileTypes := make(map[string]bool, len(localTypes)+len(scopes[i]))
+		for name := range localTypes {
+			fileTypes[name] = true
+		}
+		for name, schema := range scopes[i] {
+			fileTypes[name] = true
+			decls[schema.Name] = importedDecl(schema)
 		}
 
-		// Check for minLength
-		if matches := minLengthRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			if val, err := strconv.Atoi(matches[1]); err == nil {
-				field.MinLength = &val
+		// Schema types resolved by the KCL compiler, loaded only when a field
+		// refers to a type this file neither declares nor imports
+		kclTypes := &schemaTypeCache{filename: file.filename}
+		for _, stmt := range file.module.schemas {
+			if stmt.kind == "protocol" {
+				continue
 			}
+			decl := buildSchemaDecl(stmt, file.module.aliases, fileTypes, kclTypes)
+			l.qualifyImports(decl.schema, file.filename, file.module.imports, imports)
+			decls[stmt.name] = decl
+			diagnostics = append(diagnostics, checkAnnotations(file.filename, stmt, file.module.aliases)...)
+			diagnostics = append(diagnostics, applyAnnotationHandlers(file.filename, decl.schema)...)
 		}
+	}
+	inheritance := newInheritanceResolver(decls)
 
-		// Check for maxLength
-		if matches := maxLengthRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			if val, err := strconv.Atoi(matches[1]); err == nil {
-				field.MaxLength = &val
+	schemas := make(map[string]*Schema)
+	for _, file := range files {
+		for _, stmt := range file.module.schemas {
+			if stmt.kind != "schema" {
+				continue
 			}
+			schema, err := inheritance.resolve(stmt.name)
+			if err != nil {
+				return nil, nil, err
+			}
+			schema.File = file.filename
+			schemas[schema.Name] = schema
 		}
+	}
 
-		// Check for minimum
-		if matches := minimumRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			if val, err := strconv.Atoi(matches[1]); err == nil {
-				field.Minimum = &val
+	// Evaluate defaults once every schema has its inherited attributes
+	for i, file := range files {
+		own := make(map[string]*Schema)
+		scope := make(map[string]*Schema, len(schemas)+len(scopes[i]))
+		for name, schema := range schemas {
+			scope[name] = schema
+			if schema.File == file.filename {
+				own[name] = schema
 			}
 		}
+		for name, schema := range scopes[i] {
+			scope[name] = schema
+		}
+		diagnostics = append(diagnostics, evaluateDefaults(file.filename, own, scope)...)
+	}
 
-		// Check for maximum
-		if matches := maximumRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			if val, err := strconv.Atoi(matches[1]); err == nil {
-				field.Maximum = &val
+	// Translate check blocks once every schema has its inherited checks
+	for _, file := range files {
+		for _, stmt := range file.module.schemas {
+			if schema := schemas[stmt.name]; schema != nil && stmt.kind == "schema" && schema.File == file.filename {
+				diagnostics = append(diagnostics, translateChecks(schema, file.filename)...)
 			}
 		}
+	}
+	return schemas, diagnostics, nil
+}
 
-		// Check for minItems
-		if matches := minItemsRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			if val, err := strconv.Atoi(matches[1]); err == nil {
-				field.MinItems = &val
-			}
+// buildSchemaDecl converts a schema or mixin statement into a Schema holding
+// only the attributes declared in the statement itself
+func buildSchemaDecl(stmt *schemaStmt, aliases map[string][]token, localTypes map[string]bool, kclTypes *schemaTypeCache) *schemaDecl {
+	schema := &Schema{
+		Name:        stmt.name,
+		Description: stmt.doc,
+		Fields:      []Field{},
+		Base:        stmt.base,
+		Mixins:      stmt.mixins,
+		Line:        stmt.line,
+		Column:      stmt.col,
+	}
+	decl := &schemaDecl{
+		schema:    schema,
+		overrides: make(map[string]string),
+		commented: make(map[string]bool),
+		kclTypes:  kclTypes,
+	}
+
+	applyAnnotations(schema, nil, stmt.comments)
+
+	for _, attr := range stmt.attrs {
+		// Untyped assignments override the default of an inherited attribute
+		if len(attr.typ) == 0 {
+			decl.overrides[attr.name] = attr.def
+			continue
 		}
 
-		// Check for maxItems
-		if matches := maxItemsRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			if val, err := strconv.Atoi(matches[1]); err == nil {
-				field.MaxItems = &val
+		field := Field{
+			Name:     attr.name,
+			Type:     typeExprString(expandAliases(attr.typ, aliases)),
+			Required: !attr.optional,
+			Default:  attr.def,
+			Line:     attr.line,
+			Column:   attr.col,
+		}
+		if hasUnknownTypeNames(field.Type, localTypes) {
+			if prop := kclTypes.schema(stmt.name).GetProperties()[attr.name]; prop != nil {
+				field.Type = kclTypeString(prop)
 			}
 		}
 
-		// Check for format
-		if matches := formatRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			field.Format = matches[1]
+		// Comments above the field are its description, @-comments its annotations
+		var annotations []comment
+		var descriptions []string
+		for _, c := range attr.comments {
+			if strings.HasPrefix(c.Text, "@") {
+				annotations = append(annotations, c)
+			} else {
+				descriptions = append(descriptions, c.Text)
+			}
 		}
-
-		// Check for itemsFormat
-		if matches := itemsFormatRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			field.ItemsFormat = matches[1]
+		if len(descriptions) > 0 {
+			field.Description = strings.Join(descriptions, "\n")
+		}
+		if attr.doc != "" {
+			field.Description = attr.doc
 		}
+		decl.commented[attr.name] = len(attr.comments) > 0 || attr.doc != ""
 
-		// Check for enum
-		if matches := enumRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			enumStr := matches[1]
-			// Split by comma and clean up
-			enumValues := strings.Split(enumStr, ",")
-			for i, val := range enumValues {
-				val = strings.TrimSpace(val)
-				val = strings.Trim(val, `"'`)
-				enumValues[i] = val
+		applyAnnotations(schema, &field, annotations)
+
+		schema.Fields = append(schema.Fields, field)
+	}
+
+	for _, check := range stmt.checks {
+		schema.Checks = append(schema.Checks, Check{
+			Expr:    check.expr,
+			Message: check.message,
+			Line:    check.line,
+			Column:  check.col,
+		})
+	}
+
+	return decl
+}
+
+// parseCELValidation parses a @validate annotation:
+//
+//	@validate("self.min <= self.max", "min must not exceed max", reason="FieldValueInvalid", fieldPath=".min")
+//
+// The rule and message may be given by position or by name; the other
+// arguments are messageExpression, reason, fieldPath and optionalOldSelf.
+// Strings are kept as written, escapes included.
+func parseCELValidation(c comment) (CELValidation, bool) {
+	loc := celValidationRegex.FindStringIndex(c.Text)
+	if loc == nil {
+		return CELValidation{}, false
+	}
+	validation := CELValidation{Line: c.Line}
+	rest := c.Text[loc[1]:]
+	if strings.HasPrefix(strings.TrimSpace(rest), ")") {
+		return CELValidation{}, false
+	}
+	for position := 0; ; position++ {
+		matches := celValidationArgRegex.FindStringSubmatch(rest)
+		if matches == nil {
+			return CELValidation{}, false
+		}
+		rest = rest[len(matches[0]):]
+
+		name, value := matches[1], matches[2]
+		if name == "" {
+			switch position {
+			case 0:
+				name = "rule"
+			case 1:
+				name = "message"
 			}
-			field.Enum = enumValues
 		}
-
-		// Check for immutable
-		if immutableRegex.MatchString(annotation) {
-			field.Immutable = true
+		quoted := value[0] == '"' || value[0] == '\''
+		if quoted {
+			value = value[1 : len(value)-1]
 		}
-
-		// Check for CEL validation
-		if matches := celValidationRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			rule := matches[1]
-			message := ""
-			if len(matches) > 2 && matches[2] != "" {
-				message = matches[2]
+		switch {
+		case name == "optionalOldSelf" && !quoted:
+			v, err := strconv.ParseBool(strings.ToLower(value))
+			if err != nil {
+				return CELValidation{}, false
 			}
-			field.CELValidations = append(field.CELValidations, CELValidation{
-				Rule:    rule,
-				Message: message,
-			})
+			validation.OptionalOldSelf = v
+		case !quoted:
+			return CELValidation{}, false
+		case name == "rule":
+			validation.Rule = value
+		case name == "message":
+			validation.Message = value
+		case name == "messageExpression":
+			validation.MessageExpression = value
+		case name == "reason":
+			validation.Reason = value
+		case name == "fieldPath":
+			validation.FieldPath = value
+		default:
+			return CELValidation{}, false
 		}
 
-		// Check for preserveUnknownFields
-		if preserveUnknownFieldsRegex.MatchString(annotation) {
-			field.PreserveUnknownFields = true
+		if matches[3] == ")" {
+			break
 		}
+	}
+	return validation, validation.Rule != ""
+}
 
-		// Check for itemsPreserveUnknownFields
-		if itemsPreserveUnknownFieldsRegex.MatchString(annotation) {
-			field.ItemsPreserveUnknownFields = true
-		}
+// applyXRDArgs applies the version settings of an @xrd annotation
+func applyXRDArgs(schema *Schema, args map[string]string) {
+	schema.Version = args["version"]
+	if v, err := strconv.ParseBool(strings.ToLower(args["served"])); err == nil {
+		schema.Served = &v
+	}
+	if v, err := strconv.ParseBool(strings.ToLower(args["referenceable"])); err == nil {
+		schema.Referenceable = &v
+	}
+	if v, err := strconv.ParseBool(strings.ToLower(args["deprecated"])); err == nil {
+		schema.Deprecated = v
+	}
+	if warning, ok := args["deprecationWarning"]; ok {
+		schema.DeprecationWarning = warning
+		schema.Deprecated = true
+	}
+}
 
-		// Check for additionalProperties
-		if additionalPropertiesRegex.MatchString(annotation) {
-			field.AdditionalPropertiesAnnotation = true
+// parseAnnotationArgs parses the keyword arguments of an annotation, e.g.
+// `version="v1", served=True`. String values are unquoted.
+func parseAnnotationArgs(args string) map[string]string {
+	result := make(map[string]string)
+	var parts []string
+	var quote rune
+	start := 0
+	for i, c := range args {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			parts = append(parts, args[start:i])
+			start = i + 1
 		}
+	}
+	parts = append(parts, args[start:])
 
-		// Check for mapType
-		if matches := mapTypeRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			field.MapType = matches[1]
+	for _, part := range parts {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
 		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		result[strings.TrimSpace(key)] = value
+	}
+	return result
+}
+
+// parseMetadata extracts the __xrd_ metadata variables from module-level
+// assignments whose values are literals or simple format expressions. Other
+// values are left to the KCL runtime; if it failed with kclErr, they are
+// reported as warnings, like unknown __xrd_ variables and malformed printer
+// columns.
+func parseMetadata(module *kclModule, kclErr error) (*XRDMetadata, Diagnostics) {
+	metadata := &XRDMetadata{}
+	var diagnostics Diagnostics
 
-		// Check for listType
-		if matches := listTypeRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			field.ListType = matches[1]
+	// Track string variable assignments for resolving expressions
+	variables := make(map[string]string)
+	for _, assign := range module.assigns {
+		if value, ok := stringValue(assign.value); ok {
+			variables[assign.name] = value
 		}
+	}
 
-		// Check for listMapKeys
-		if matches := listMapKeysRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			keysStr := matches[1]
-			keys := strings.Split(keysStr, ",")
-			for i, key := range keys {
-				key = strings.TrimSpace(key)
-				key = strings.Trim(key, `"'`)
-				keys[i] = key
+	for _, assign := range module.assigns {
+		ok := true
+		switch assign.name {
+		case "__xrd_kind":
+			metadata.XRKind, ok = stringValue(assign.value)
+		case "__xrd_version":
+			metadata.XRVersion, ok = stringValue(assign.value)
+		case "__xrd_group":
+			if metadata.Group, ok = stringValue(assign.value); !ok {
+				// Format expressions like: "{}.{}".format(var1, var2)
+				// If resolution failed, user will need to provide --group flag
+				metadata.Group = resolveFormatExpression(typeExprString(assign.value), variables)
+				ok = metadata.Group != ""
+			}
+		case "__xrd_scope":
+			metadata.Scope, ok = stringValue(assign.value)
+		case "__xrd_categories":
+			metadata.Categories, ok = stringListValue(assign.value)
+		case "__xrd_plural":
+			metadata.Plural, ok = stringValue(assign.value)
+		case "__xrd_singular":
+			metadata.Singular, ok = stringValue(assign.value)
+		case "__xrd_short_names":
+			metadata.ShortNames, ok = stringListValue(assign.value)
+		case "__xrd_list_kind":
+			metadata.ListKind, ok = stringValue(assign.value)
+		case "__xrd_default_composition_ref":
+			metadata.DefaultCompositionRef, ok = stringValue(assign.value)
+		case "__xrd_enforced_composition_ref":
+			metadata.EnforcedCompositionRef, ok = stringValue(assign.value)
+		case "__xrd_default_composition_update_policy":
+			metadata.DefaultCompositionUpdatePolicy, ok = stringValue(assign.value)
+		case "__xrd_default_composite_delete_policy":
+			metadata.DefaultCompositeDeletePolicy, ok = stringValue(assign.value)
+		case "__xrd_connection_secret_keys":
+			metadata.ConnectionSecretKeys, ok = stringListValue(assign.value)
+		case "__xrd_labels":
+			metadata.Labels, ok = stringMapValue(assign.value)
+		case "__xrd_annotations":
+			metadata.Annotations, ok = stringMapValue(assign.value)
+		case "__xrd_served":
+			var value bool
+			if value, ok = boolValue(assign.value); ok {
+				metadata.Served = &value
+			}
+		case "__xrd_referenceable":
+			var value bool
+			if value, ok = boolValue(assign.value); ok {
+				metadata.Referenceable = &value
+			}
+		case "__xrd_status_preserve_unknown_fields":
+			var value bool
+			if value, ok = boolValue(assign.value); ok {
+				metadata.StatusPreserveUnknownFields = &value
+			}
+		case "__xrd_max_recursion_depth":
+			ok = false
+			if len(assign.value) == 1 && assign.value[0].kind == tokNumber {
+				if value, err := strconv.Atoi(assign.value[0].text); err == nil {
+					metadata.MaxRecursionDepth, ok = value, true
+				}
+			}
+		case "__xrd_printer_columns":
+			// Parse printer columns format: "Name:string:.metadata.name:Description", "Age:integer:.status.age:Age in days"
+			var columns []string
+			columns, ok = stringListValue(assign.value)
+			for _, colStr := range columns {
+				parts := strings.Split(colStr, ":")
+				if len(parts) < 3 {
+					diagnostics = append(diagnostics, warningf(module.filename, assign.line, assign.col, "printer column %q is not of the form name:type:jsonPath[:description]", colStr))
+					continue
+				}
+				pc := PrinterColumn{
+					Name:     parts[0],
+					Type:     parts[1],
+					JSONPath: parts[2],
+				}
+				if len(parts) >= 4 {
+					pc.Description = parts[3]
+				}
+				metadata.PrinterColumns = append(metadata.PrinterColumns, pc)
 			}
-			field.ListMapKeys = keys
+		default:
+			if strings.HasPrefix(assign.name, "__xrd_") {
+				diagnostics = append(diagnostics, warningf(module.filename, assign.line, assign.col, "unknown metadata variable %s", assign.name))
+			}
+		}
+		if !ok && kclErr != nil {
+			message, _, _ := strings.Cut(kclErr.Error(), "\n")
+			diagnostics = append(diagnostics, warningf(module.filename, assign.line, assign.col, "%s could not be evaluated: %s", assign.name, message))
 		}
+	}
+
+	return metadata, diagnostics
+}
+
+// stringValue returns the value of an expression consisting of a single string literal
+func stringValue(toks []token) (string, bool) {
+	if len(toks) != 1 || toks[0].kind != tokString {
+		return "", false
+	}
+	return stringLiteralValue(toks[0].text), true
+}
 
-		// Check for status annotation
-		if statusAnnotationRegex.MatchString(annotation) {
-			field.IsStatus = true
+// stringListValue returns the values of a list literal of strings
+func stringListValue(toks []token) ([]string, bool) {
+	if len(toks) < 2 || toks[0].text != "[" || toks[len(toks)-1].text != "]" {
+		return nil, false
+	}
+	var values []string
+	for _, tok := range toks[1 : len(toks)-1] {
+		switch {
+		case tok.kind == tokString:
+			values = append(values, stringLiteralValue(tok.text))
+		case tok.text == ",":
+		default:
+			return nil, false
 		}
+	}
+	return values, true
+}
 
-		// Check for spec annotation
-		if specAnnotationRegex.MatchString(annotation) {
-			field.IsSpec = true
+// stringMapValue returns the entries of a dict literal with string values,
+// e.g. {"team": "platform", tier = "gold"}
+func stringMapValue(toks []token) (map[string]string, bool) {
+	if len(toks) < 2 || toks[0].text != "{" || toks[len(toks)-1].text != "}" {
+		return nil, false
+	}
+	values := make(map[string]string)
+	entries := toks[1 : len(toks)-1]
+	for i := 0; i < len(entries); i++ {
+		if entries[i].text == "," {
+			continue
+		}
+		if i+2 >= len(entries) || (entries[i+1].text != ":" && entries[i+1].text != "=") || entries[i+2].kind != tokString {
+			return nil, false
+		}
+		key := entries[i].text
+		switch entries[i].kind {
+		case tokString:
+			key = stringLiteralValue(key)
+		case tokName:
+		default:
+			return nil, false
 		}
+		values[key] = stringLiteralValue(entries[i+2].text)
+		i += 2
+	}
+	return values, true
+}
+
+// boolValue returns the value of a True/False literal
+func boolValue(toks []token) (bool, bool) {
+	if len(toks) != 1 || toks[0].kind != tokName {
+		return false, false
+	}
+	switch toks[0].text {
+	case "True", "true":
+		return true, true
+	case "False", "false":
+		return false, true
+	}
+	return false, false
+}
 
-		// Check for oneOf
-		if matches := oneOfRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			field.OneOf = parseRequiredCombinations(matches[1])
+// TypeNames returns the names a type expression refers to, other than
+// builtin types: schemas and type aliases, qualified like pkg.Name when they
+// are imported
+func TypeNames(typ string) []string {
+	var names []string
+	for _, name := range typeNameRegex.FindAllString(typeLiteralRegex.ReplaceAllString(typ, ""), -1) {
+		switch name {
+		case "str", "int", "float", "bool", "any", "None", "Undefined", "True", "False":
+			continue
 		}
+		names = append(names, name)
+	}
+	return names
+}
 
-		// Check for anyOf
-		if matches := anyOfRegex.FindStringSubmatch(annotation); len(matches) > 1 {
-			field.AnyOf = parseRequiredCombinations(matches[1])
+// hasUnknownTypeNames reports whether a type expression refers to names other
+// than builtin types and the given locally declared schemas
+func hasUnknownTypeNames(typ string, known map[string]bool) bool {
+	for _, name := range TypeNames(typ) {
+		if !known[name] {
+			return true
 		}
 	}
+	return false
 }
 
 // parseRequiredCombinations parses oneOf/anyOf annotation content
@@ -652,57 +867,13 @@ func parseRequiredCombinations(content string) [][]string {
 	return result
 }
 
-// splitPrinterColumns splits printer columns string respecting quoted strings
-func splitPrinterColumns(s string) []string {
-	var result []string
-	var current strings.Builder
-	inQuote := false
-	quoteChar := rune(0)
-
-	for i, ch := range s {
-		if (ch == '"' || ch == '\'') && (i == 0 || s[i-1] != '\\') {
-			if inQuote {
-				if ch == quoteChar {
-					inQuote = false
-					quoteChar = 0
-				}
-			} else {
-				inQuote = true
-				quoteChar = ch
-			}
-			continue
-		}
-
-		if ch == ',' && !inQuote {
-			trimmed := strings.TrimSpace(current.String())
-			trimmed = strings.Trim(trimmed, `"'`)
-			if trimmed != "" {
-				result = append(result, trimmed)
-			}
-			current.Reset()
-			continue
-		}
-
-		current.WriteRune(ch)
-	}
-
-	// Add last item
-	trimmed := strings.TrimSpace(current.String())
-	trimmed = strings.Trim(trimmed, `"'`)
-	if trimmed != "" {
-		result = append(result, trimmed)
-	}
-
-	return result
-}
-
 // resolveFormatExpression attempts to resolve KCL format expressions like:
-// __xrd_group = "{}.{}".format(_xrSubgroup, _platformGroup)
+// "{}.{}".format(_xrSubgroup, _platformGroup)
 // Returns the resolved string if successful, empty string otherwise
-func resolveFormatExpression(line string, variables map[string]string) string {
-	// Pattern to match: __xrd_group = "format_string".format(var1, var2, ...)
-	formatRegex := regexp.MustCompile(`^\s*__xrd_group\s*=\s*["'](.*?)["']\.format\((.*?)\)\s*$`)
-	matches := formatRegex.FindStringSubmatch(line)
+func resolveFormatExpression(expr string, variables map[string]string) string {
+	// Pattern to match: "format_string".format(var1, var2, ...)
+	formatRegex := regexp.MustCompile(`^\s*["'](.*?)["']\.format\((.*?)\)\s*$`)
+	matches := formatRegex.FindStringSubmatch(expr)
 	if len(matches) < 3 {
 		return ""
 	}
@@ -745,7 +916,8 @@ func resolveFormatExpression(line string, variables map[string]string) string {
 }
 
 // evaluateMetadataWithKCL uses KCL runtime to evaluate metadata variables
-// This is more flexible than parsing format strings manually
+// This is more flexible than parsing format strings manually. The error is
+// the runtime's if the file could not be evaluated at all.
 func evaluateMetadataWithKCL(filename string) (*XRDMetadata, error) {
 	metadata := &XRDMetadata{}
 
@@ -760,24 +932,11 @@ func evaluateMetadataWithKCL(filename string) (*XRDMetadata, error) {
 			return metadata, nil
 		}
 
-		// Filter out import statements
-		lines := strings.Split(string(content), "\n")
-		var filteredLines []string
-		for _, line := range lines {
-			trimmed := strings.TrimSpace(line)
-			// Skip import statements
-			if strings.HasPrefix(trimmed, "import ") {
-				continue
-			}
-			filteredLines = append(filteredLines, line)
-		}
-		filteredContent := strings.Join(filteredLines, "\n")
-
 		// Try running without imports
-		result, err = kcl.Run("", kcl.WithCode(filteredContent), kcl.WithShowHidden(true))
+		result, err = kcl.Run("", kcl.WithCode(stripImports(string(content))), kcl.WithShowHidden(true))
 		if err != nil {
 			// If it still fails, return empty metadata (will fall back to manual parsing)
-			return metadata, nil
+			return metadata, err
 		}
 	}
 
@@ -808,6 +967,11 @@ func evaluateMetadataWithKCL(filename string) (*XRDMetadata, error) {
 		metadata.XRVersion = version
 	}
 
+	// Try to extract __xrd_scope
+	if scope, ok := resultMap["__xrd_scope"].(string); ok {
+		metadata.Scope = scope
+	}
+
 	// Try to extract __xrd_served
 	if served, ok := resultMap["__xrd_served"].(bool); ok {
 		metadata.Served = &served
@@ -823,6 +987,16 @@ func evaluateMetadataWithKCL(filename string) (*XRDMetadata, error) {
 		metadata.StatusPreserveUnknownFields = &statusPreserveUnknownFields
 	}
 
+	// Try to extract __xrd_max_recursion_depth
+	switch depth := resultMap["__xrd_max_recursion_depth"].(type) {
+	case int:
+		metadata.MaxRecursionDepth = depth
+	case int64:
+		metadata.MaxRecursionDepth = int(depth)
+	case float64:
+		metadata.MaxRecursionDepth = int(depth)
+	}
+
 	// Try to extract __xrd_categories
 	if categories, ok := resultMap["__xrd_categories"].([]interface{}); ok {
 		for _, cat := range categories {
@@ -832,5 +1006,65 @@ func evaluateMetadataWithKCL(filename string) (*XRDMetadata, error) {
 		}
 	}
 
+	// Try to extract the spec.names overrides
+	if plural, ok := resultMap["__xrd_plural"].(string); ok {
+		metadata.Plural = plural
+	}
+	if singular, ok := resultMap["__xrd_singular"].(string); ok {
+		metadata.Singular = singular
+	}
+	if names, ok := resultMap["__xrd_short_names"].([]interface{}); ok {
+		for _, name := range names {
+			if nameStr, ok := name.(string); ok {
+				metadata.ShortNames = append(metadata.ShortNames, nameStr)
+			}
+		}
+	}
+	if listKind, ok := resultMap["__xrd_list_kind"].(string); ok {
+		metadata.ListKind = listKind
+	}
+
+	// Try to extract the composition and policy settings
+	if ref, ok := resultMap["__xrd_default_composition_ref"].(string); ok {
+		metadata.DefaultCompositionRef = ref
+	}
+	if ref, ok := resultMap["__xrd_enforced_composition_ref"].(string); ok {
+		metadata.EnforcedCompositionRef = ref
+	}
+	if policy, ok := resultMap["__xrd_default_composition_update_policy"].(string); ok {
+		metadata.DefaultCompositionUpdatePolicy = policy
+	}
+	if policy, ok := resultMap["__xrd_default_composite_delete_policy"].(string); ok {
+		metadata.DefaultCompositeDeletePolicy = policy
+	}
+
+	// Try to extract __xrd_connection_secret_keys
+	if keys, ok := resultMap["__xrd_connection_secret_keys"].([]interface{}); ok {
+		for _, key := range keys {
+			if keyStr, ok := key.(string); ok {
+				metadata.ConnectionSecretKeys = append(metadata.ConnectionSecretKeys, keyStr)
+			}
+		}
+	}
+
+	// Try to extract __xrd_labels and __xrd_annotations
+	metadata.Labels = stringMapFromResult(resultMap["__xrd_labels"])
+	metadata.Annotations = stringMapFromResult(resultMap["__xrd_annotations"])
+
 	return metadata, nil
 }
+
+// stringMapFromResult converts an evaluated KCL dict with string values
+func stringMapFromResult(value interface{}) map[string]string {
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	result := make(map[string]string)
+	for k, v := range dict {
+		if s, ok := v.(string); ok {
+			result[k] = s
+		}
+	}
+	return result
+}
@@ -1,8 +1,11 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -131,6 +134,12 @@ func TestParseKCLFileWithValidations(t *testing.T) {
     
     # @validate("self > 0", "Must be positive")
     count?: int
+    
+    # @immutableOnceSet
+    zone?: str
+    
+    # @appendOnly
+    users?: [str]
 `
 
 	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
@@ -183,6 +192,62 @@ func TestParseKCLFileWithValidations(t *testing.T) {
 	if countField.CELValidations[0].Rule != "self > 0" {
 		t.Errorf("Expected CEL rule 'self > 0', got '%s'", countField.CELValidations[0].Rule)
 	}
+
+	// Check zone and users transition annotations
+	zoneField := schema.Fields[5]
+	if !zoneField.ImmutableOnceSet || zoneField.Immutable {
+		t.Error("Expected zone field to be immutable once set only")
+	}
+	usersField := schema.Fields[6]
+	if !usersField.AppendOnly {
+		t.Error("Expected users field to be append-only")
+	}
+}
+
+func TestParseCELValidationArguments(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `# @validate("self.minSize <= self.maxSize", "minSize must not exceed maxSize", reason="FieldValueInvalid", fieldPath=".minSize")
+schema Storage:
+    minSize: int
+    maxSize: int
+
+    # @validate(rule="!oldSelf.hasValue() || self >= oldSelf.value()", messageExpression='"tier cannot go down from " + string(oldSelf.value())', optionalOldSelf=True)
+    tier?: int
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	schema, err := ParseKCLFile(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFile failed: %v", err)
+	}
+
+	// A schema-level rule with positional and named arguments
+	expected := CELValidation{
+		Rule:      "self.minSize <= self.maxSize",
+		Message:   "minSize must not exceed maxSize",
+		Reason:    "FieldValueInvalid",
+		FieldPath: ".minSize",
+		Line:      1,
+	}
+	if len(schema.CELValidations) != 1 || schema.CELValidations[0] != expected {
+		t.Errorf("Expected schema validation %+v, got %+v", expected, schema.CELValidations)
+	}
+
+	// A field rule with named arguments only
+	expected = CELValidation{
+		Rule:              "!oldSelf.hasValue() || self >= oldSelf.value()",
+		MessageExpression: `"tier cannot go down from " + string(oldSelf.value())`,
+		OptionalOldSelf:   true,
+		Line:              6,
+	}
+	if tier := schema.Fields[2]; len(tier.CELValidations) != 1 || tier.CELValidations[0] != expected {
+		t.Errorf("Expected tier validation %+v, got %+v", expected, tier.CELValidations)
+	}
 }
 
 func TestParseKCLFileWithNestedSchemas(t *testing.T) {
@@ -1279,3 +1344,1166 @@ func TestParseKCLFileWithItemsPreserveUnknownFields(t *testing.T) {
 		t.Error("Expected PreserveUnknownFields to be true for 'filter'")
 	}
 }
+
+func TestParseKCLFileWithMultiLineConstructs(t *testing.T) {
+	// Test that multi-line types, union types, decorators and check blocks are parsed
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test_multiline.k")
+
+	content := `type Size = "small" | "medium" | "large"
+
+# @xrd
+schema Database:
+    """Database composite resource"""
+
+    # Instance size
+    size?: Size = "small"
+
+    # Settings keyed by name
+    settings?: {str: [
+        str
+    ]}
+
+    @deprecated(version="1.2", reason="use tier instead")
+    legacyTier?: str
+
+    # @minItems(1)
+    zones: [str] = [
+        "a",
+        "b",
+    ]
+
+    mode?: int | str
+
+    check:
+        len(zones) <= 3, "at most three zones"
+        size != "large" if mode
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+
+	schema := result.Schemas["Database"]
+	if schema == nil {
+		t.Fatal("Expected Database schema to be parsed")
+	}
+	if !schema.IsXRD {
+		t.Error("Expected Database schema to be marked as XRD")
+	}
+	if schema.Description != "Database composite resource" {
+		t.Errorf("Expected single-line docstring description, got '%s'", schema.Description)
+	}
+
+	expected := []struct {
+		name     string
+		typ      string
+		required bool
+		def      string
+	}{
+		{"size", `"small" | "medium" | "large"`, false, `"small"`},
+		{"settings", "{str:[str]}", false, ""},
+		{"legacyTier", "str", false, ""},
+		{"zones", "[str]", true, `["a", "b",]`},
+		{"mode", "int | str", false, ""},
+	}
+
+	if len(schema.Fields) != len(expected) {
+		t.Fatalf("Expected %d fields, got %d", len(expected), len(schema.Fields))
+	}
+
+	for i, exp := range expected {
+		field := schema.Fields[i]
+		if field.Name != exp.name {
+			t.Errorf("Field %d: expected name '%s', got '%s'", i, exp.name, field.Name)
+		}
+		if field.Type != exp.typ {
+			t.Errorf("Field %s: expected type '%s', got '%s'", exp.name, exp.typ, field.Type)
+		}
+		if field.Required != exp.required {
+			t.Errorf("Field %s: expected required=%v", exp.name, exp.required)
+		}
+		if field.Default != exp.def {
+			t.Errorf("Field %s: expected default '%s', got '%s'", exp.name, exp.def, field.Default)
+		}
+	}
+
+	if schema.Fields[0].Description != "Instance size" {
+		t.Errorf("Expected size description 'Instance size', got '%s'", schema.Fields[0].Description)
+	}
+	if schema.Fields[3].MinItems == nil || *schema.Fields[3].MinItems != 1 {
+		t.Error("Expected minItems of 1 for zones field")
+	}
+	if schema.Fields[0].Line != 8 {
+		t.Errorf("Expected size field on line 8, got %d", schema.Fields[0].Line)
+	}
+
+	if len(schema.Checks) != 2 {
+		t.Fatalf("Expected 2 checks, got %d", len(schema.Checks))
+	}
+	if schema.Checks[0].Expr != "len(zones) <= 3" || schema.Checks[0].Message != "at most three zones" {
+		t.Errorf("Unexpected first check: %+v", schema.Checks[0])
+	}
+	if schema.Checks[1].Expr != `size != "large" if mode` || schema.Checks[1].Line != 28 {
+		t.Errorf("Unexpected second check: %+v", schema.Checks[1])
+	}
+}
+
+func TestParseKCLFileUnterminatedString(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `schema TestSchema:
+    name?: str = "unterminated
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	_, err := ParseKCLFileWithSchemas(testFile)
+	if err == nil {
+		t.Fatal("Expected error for unterminated string, got nil")
+	}
+	if !strings.Contains(err.Error(), "test.k:2:18") {
+		t.Errorf("Expected error to carry the position, got: %v", err)
+	}
+}
+
+func TestParseKCLFileWithInheritance(t *testing.T) {
+	// Test that base schema and mixin fields are flattened into the child schema
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test_inheritance.k")
+
+	content := `schema BaseResource:
+    # AWS region
+    # @enum(["eu-central-1", "us-east-1"])
+    region: str = "eu-central-1"
+
+    # Resource tags
+    tags?: {str:str}
+
+    # @pattern("^[a-z]+$")
+    providerConfigRef?: str
+
+    check:
+        len(tags) <= 10 if tags
+
+mixin LabelMixin:
+    # @maxItems(5)
+    labels?: [str]
+
+# @xrd
+schema Database(BaseResource):
+    mixin [LabelMixin]
+
+    region = "us-east-1"
+
+    # Config ref required for databases
+    providerConfigRef: str
+
+    tags: {str:str}
+
+    # Storage size in GB
+    storageGB: int
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+
+	if _, ok := result.Schemas["LabelMixin"]; ok {
+		t.Error("Mixins should not be returned as schemas")
+	}
+
+	schema := result.Schemas["Database"]
+	if schema == nil {
+		t.Fatal("Expected Database schema to be parsed")
+	}
+	if schema.Base != "BaseResource" {
+		t.Errorf("Expected base 'BaseResource', got '%s'", schema.Base)
+	}
+
+	var names []string
+	fields := make(map[string]Field)
+	for _, field := range schema.Fields {
+		names = append(names, field.Name)
+		fields[field.Name] = field
+	}
+	expectedOrder := "region,tags,providerConfigRef,labels,storageGB"
+	if strings.Join(names, ",") != expectedOrder {
+		t.Errorf("Expected fields %s, got %s", expectedOrder, strings.Join(names, ","))
+	}
+
+	// Untyped assignment overrides the inherited default but keeps annotations
+	region := fields["region"]
+	if region.Default != `"us-east-1"` {
+		t.Errorf("Expected overridden region default, got '%s'", region.Default)
+	}
+	if len(region.Enum) != 2 || region.Description != "AWS region" {
+		t.Errorf("Expected region to keep inherited enum and description, got %+v", region)
+	}
+
+	// Commented redeclaration replaces the inherited field entirely
+	ref := fields["providerConfigRef"]
+	if !ref.Required || ref.Pattern != "" || ref.Description != "Config ref required for databases" {
+		t.Errorf("Expected providerConfigRef to be replaced by the child declaration, got %+v", ref)
+	}
+
+	// Uncommented redeclaration keeps the inherited description
+	tags := fields["tags"]
+	if !tags.Required || tags.Description != "Resource tags" {
+		t.Errorf("Expected tags to be required with inherited description, got %+v", tags)
+	}
+
+	if labels := fields["labels"]; labels.MaxItems == nil || *labels.MaxItems != 5 {
+		t.Error("Expected labels field from mixin with maxItems of 5")
+	}
+
+	if len(schema.Checks) != 1 || schema.Checks[0].Expr != "len(tags) <= 10 if tags" {
+		t.Errorf("Expected inherited check, got %+v", schema.Checks)
+	}
+
+	// The base schema itself is unaffected by the child
+	base := result.Schemas["BaseResource"]
+	if base == nil || len(base.Fields) != 3 || base.Fields[0].Default != `"eu-central-1"` {
+		t.Errorf("Expected base schema to keep its own fields, got %+v", base)
+	}
+}
+
+func TestParseKCLFileWithInheritanceCycle(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `schema A(B):
+    a: str
+
+schema B(A):
+    b: str
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if _, err := ParseKCLFileWithSchemas(testFile); err == nil {
+		t.Error("Expected error for inheritance cycle, got nil")
+	}
+}
+
+func TestParseKCLFileWithChecks(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `import regex
+
+schema App:
+    name: str
+    replicas: int = 1
+    enabled?: bool
+    tags?: [str]
+    tier: str
+    minReplicas?: int
+    maxReplicas?: int
+
+    check:
+        len(name) <= 63
+        regex.match(name, r"[a-z][a-z0-9-]*$")
+        1 <= replicas <= 10
+        tier in ["dev", "prod"]
+        replicas >= 2 if enabled
+        minReplicas <= maxReplicas, "minReplicas must not exceed maxReplicas"
+        all t in tags { t.startswith("team-") }
+        len(name) > 3, "name is too short"
+        replicas ** 2 < 100
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	schema := result.Schemas["App"]
+
+	fields := make(map[string]Field)
+	for _, f := range schema.Fields {
+		fields[f.Name] = f
+	}
+
+	// Simple bounds become native keywords
+	if name := fields["name"]; name.MaxLength == nil || *name.MaxLength != 63 || name.Pattern != "^[a-z][a-z0-9-]*$" {
+		t.Errorf("Expected name maxLength 63 and anchored pattern, got %+v", name)
+	}
+	if name := fields["name"]; name.MinLength != nil {
+		t.Errorf("Expected check with a message to stay a CEL rule, got minLength %d", *name.MinLength)
+	}
+	if replicas := fields["replicas"]; replicas.Minimum == nil || *replicas.Minimum != 1 || replicas.Maximum == nil || *replicas.Maximum != 10 {
+		t.Errorf("Expected replicas minimum 1 and maximum 10, got %+v", replicas)
+	}
+	if tier := fields["tier"]; len(tier.Enum) != 2 || tier.Enum[0] != "dev" || tier.Enum[1] != "prod" {
+		t.Errorf("Expected tier enum [dev prod], got %v", tier.Enum)
+	}
+
+	expected := []CELValidation{
+		{Rule: "!(has(self.enabled) && self.enabled) || self.replicas >= 2", Line: 17},
+		{Rule: "!has(self.minReplicas) || !has(self.maxReplicas) || self.minReplicas <= self.maxReplicas", Message: "minReplicas must not exceed maxReplicas", Line: 18},
+		{Rule: `!has(self.tags) || self.tags.all(t, t.startsWith("team-"))`, Line: 19},
+		{Rule: "size(self.name) > 3", Message: "name is too short", Line: 20},
+	}
+	if len(schema.CELValidations) != len(expected) {
+		t.Fatalf("Expected %d CEL validations, got %d: %+v", len(expected), len(schema.CELValidations), schema.CELValidations)
+	}
+	for i, want := range expected {
+		if schema.CELValidations[i] != want {
+			t.Errorf("CEL validation %d: expected %+v, got %+v", i, want, schema.CELValidations[i])
+		}
+	}
+
+	// Unsupported expressions are reported with file and line
+	if len(result.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 warning, got %v", result.Diagnostics)
+	}
+	if d := result.Diagnostics[0]; d.File != testFile || d.Line != 21 || d.Severity != SeverityWarning || !strings.Contains(d.Message, "'**'") {
+		t.Errorf("Expected warning for line 21 about '**', got %q", d)
+	}
+}
+
+func TestParseKCLFileWithXRDVersions(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `# @xrd(version="v1alpha1", served=True, deprecationWarning="v1alpha1 is deprecated, use v1beta1")
+schema DatabaseV1alpha1:
+    size: str
+
+# @xrd(version="v1beta1", referenceable=True)
+schema Database(DatabaseV1alpha1):
+    storageGB?: int
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+
+	alpha := result.Schemas["DatabaseV1alpha1"]
+	if !alpha.IsXRD || alpha.Version != "v1alpha1" || alpha.Served == nil || !*alpha.Served || alpha.Referenceable != nil {
+		t.Errorf("Unexpected v1alpha1 settings: %+v", alpha)
+	}
+	if !alpha.Deprecated || alpha.DeprecationWarning != "v1alpha1 is deprecated, use v1beta1" {
+		t.Errorf("Expected v1alpha1 to be deprecated with warning, got %v %q", alpha.Deprecated, alpha.DeprecationWarning)
+	}
+
+	beta := result.Schemas["Database"]
+	if !beta.IsXRD || beta.Version != "v1beta1" || beta.Referenceable == nil || !*beta.Referenceable || beta.Deprecated {
+		t.Errorf("Unexpected v1beta1 settings: %+v", beta)
+	}
+	if len(beta.Fields) != 2 {
+		t.Errorf("Expected v1beta1 to inherit size, got %+v", beta.Fields)
+	}
+}
+
+func TestParseKCLFileWithScope(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `__xrd_kind = "App"
+__xrd_scope = "Namespaced"
+
+schema App:
+    name: str
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	if result.Metadata.Scope != "Namespaced" {
+		t.Errorf("Expected Scope 'Namespaced', got '%s'", result.Metadata.Scope)
+	}
+}
+
+func TestParseKCLFileWithSpecMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `__xrd_kind = "XDatabase"
+__xrd_default_composition_ref = "database-aws"
+__xrd_enforced_composition_ref = "database-aws-v2"
+__xrd_default_composition_update_policy = "Manual"
+__xrd_default_composite_delete_policy = "Foreground"
+__xrd_connection_secret_keys = ["username", "password"]
+__xrd_labels = {"team": "platform", tier = "gold"}
+__xrd_annotations = {
+    "docs.example.org/url": "https://docs.example.org/database"
+}
+
+schema XDatabase:
+    name: str
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	md := result.Metadata
+
+	if md.DefaultCompositionRef != "database-aws" || md.EnforcedCompositionRef != "database-aws-v2" {
+		t.Errorf("Unexpected composition refs: %q, %q", md.DefaultCompositionRef, md.EnforcedCompositionRef)
+	}
+	if md.DefaultCompositionUpdatePolicy != "Manual" || md.DefaultCompositeDeletePolicy != "Foreground" {
+		t.Errorf("Unexpected policies: %q, %q", md.DefaultCompositionUpdatePolicy, md.DefaultCompositeDeletePolicy)
+	}
+	if strings.Join(md.ConnectionSecretKeys, ",") != "username,password" {
+		t.Errorf("Unexpected connection secret keys: %v", md.ConnectionSecretKeys)
+	}
+	if len(md.Labels) != 2 || md.Labels["team"] != "platform" || md.Labels["tier"] != "gold" {
+		t.Errorf("Unexpected labels: %v", md.Labels)
+	}
+	if md.Annotations["docs.example.org/url"] != "https://docs.example.org/database" {
+		t.Errorf("Unexpected annotations: %v", md.Annotations)
+	}
+}
+
+func TestParseKCLFileWithNameOverrides(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `__xrd_kind = "XProxy"
+__xrd_plural = "xproxies"
+__xrd_singular = "xproxy"
+__xrd_short_names = ["xpx", "xprx"]
+__xrd_list_kind = "XProxyList"
+
+schema XProxy:
+    host: str
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	md := result.Metadata
+
+	if md.Plural != "xproxies" || md.Singular != "xproxy" || md.ListKind != "XProxyList" {
+		t.Errorf("Unexpected names: plural %q, singular %q, listKind %q", md.Plural, md.Singular, md.ListKind)
+	}
+	if strings.Join(md.ShortNames, ",") != "xpx,xprx" {
+		t.Errorf("Unexpected short names: %v", md.ShortNames)
+	}
+}
+
+func TestParseKCLFileWithRecursiveSchema(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `__xrd_max_recursion_depth = 3
+
+schema Rule:
+    path: str
+    children?: [Rule]
+
+# @xrd
+schema XRouting:
+    rules: [Rule]
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	if result.Metadata.MaxRecursionDepth != 3 {
+		t.Errorf("Expected max recursion depth 3, got %d", result.Metadata.MaxRecursionDepth)
+	}
+
+	rule := result.Schemas["Rule"]
+	if rule == nil || len(rule.Fields) != 2 || rule.Fields[1].Type != "[Rule]" {
+		t.Errorf("Expected Rule with a children field of type [Rule], got %+v", rule)
+	}
+}
+
+func TestParseOpenAPIKeywords(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `schema XQueue:
+    # @minimum(-1.5)
+    # @exclusiveMaximum(1e3)
+    # @multipleOf(0.5)
+    ratio: float
+    # @exclusiveMinimum(0)
+    replicas: int
+    # @uniqueItems
+    zones?: [str]
+    # @minProperties(1)
+    # @maxProperties(8)
+    labels?: {str:str}
+    # @nullable
+    note?: str
+    # @intOrString
+    port?: int
+    # @embeddedResource
+    template?: {str:any}
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	fields := make(map[string]Field)
+	for _, f := range result.Schemas["XQueue"].Fields {
+		fields[f.Name] = f
+	}
+
+	ratio := fields["ratio"]
+	if ratio.Minimum == nil || *ratio.Minimum != -1.5 || ratio.ExclusiveMinimum {
+		t.Errorf("Expected inclusive minimum -1.5, got %v (exclusive %v)", ratio.Minimum, ratio.ExclusiveMinimum)
+	}
+	if ratio.Maximum == nil || *ratio.Maximum != 1000 || !ratio.ExclusiveMaximum {
+		t.Errorf("Expected exclusive maximum 1000, got %v (exclusive %v)", ratio.Maximum, ratio.ExclusiveMaximum)
+	}
+	if ratio.MultipleOf == nil || *ratio.MultipleOf != 0.5 {
+		t.Errorf("Expected multipleOf 0.5, got %v", ratio.MultipleOf)
+	}
+	if replicas := fields["replicas"]; replicas.Minimum == nil || *replicas.Minimum != 0 || !replicas.ExclusiveMinimum {
+		t.Errorf("Expected exclusive minimum 0, got %v (exclusive %v)", replicas.Minimum, replicas.ExclusiveMinimum)
+	}
+	if labels := fields["labels"]; labels.MinProperties == nil || *labels.MinProperties != 1 || labels.MaxProperties == nil || *labels.MaxProperties != 8 {
+		t.Errorf("Expected 1 to 8 properties, got %v to %v", labels.MinProperties, labels.MaxProperties)
+	}
+	if !fields["zones"].UniqueItems || !fields["note"].Nullable || !fields["port"].IntOrString || !fields["template"].EmbeddedResource {
+		t.Errorf("Expected uniqueItems, nullable, intOrString and embeddedResource, got %+v", fields)
+	}
+}
+
+func TestParseKCLFileWithStructuredDefaults(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `schema Config:
+    replicas: int = 2
+    mode?: str = "fast"
+    note?: str
+
+schema XApp:
+    tags: [str] = ["a", "b"]
+    labels: {str:str} = {team = "x", "app.kubernetes.io/name": "app"}
+    config: Config = Config {}
+    tuned: Config = Config {
+        replicas = 3
+    }
+    lines: {str:int} = {
+        a = 1
+        b = 2
+    }
+    ports: [int] = [
+        80
+        443
+    ]
+    ratio: float = -0.5
+    enabled: bool = True
+    name: str = "app"
+`
+
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	if len(result.Diagnostics) != 0 {
+		t.Errorf("Expected no warnings, got %v", result.Diagnostics)
+	}
+
+	expected := map[string]string{
+		"tags":    "[a b]",
+		"labels":  "map[app.kubernetes.io/name:app team:x]",
+		"config":  "map[mode:fast replicas:2]",
+		"tuned":   "map[mode:fast replicas:3]",
+		"lines":   "map[a:1 b:2]",
+		"ports":   "[80 443]",
+		"ratio":   "-0.5",
+		"enabled": "true",
+		"name":    "app",
+	}
+	for _, f := range result.Schemas["XApp"].Fields {
+		if got := fmt.Sprint(f.DefaultValue); got != expected[f.Name] {
+			t.Errorf("Expected default of %s to be %s, got %s", f.Name, expected[f.Name], got)
+		}
+	}
+}
+
+func TestLiteralDefaultsRequireSeparators(t *testing.T) {
+	e := &literalEvaluator{instantiating: make(map[string]bool)}
+	for expr, expected := range map[string]string{
+		`[1, 2,]`:              "[1 2]",
+		`{a = "x", "b": "y"}`:  "map[a:x b:y]",
+		`{a = [1, 2], b = {}}`: "map[a:[1 2] b:map[]]",
+	} {
+		value, err := e.evaluate(expr)
+		if err != nil {
+			t.Errorf("Expected %s to evaluate, got %v", expr, err)
+		} else if got := fmt.Sprint(value); got != expected {
+			t.Errorf("Expected %s to be %s, got %s", expr, expected, got)
+		}
+	}
+	for _, expr := range []string{`[1 2]`, `{a = "x" b = "y"}`, `{a = [1] b = 2}`} {
+		if value, err := e.evaluate(expr); err == nil {
+			t.Errorf("Expected an error for %s without separators, got %v", expr, value)
+		}
+	}
+
+	// Line breaks between entries are written as commas
+	src := "{\n    a = 1\n    b = [\n        80\n        443\n    ]\n}"
+	toks, err := lexExpr(src)
+	if err != nil {
+		t.Fatalf("lexExpr failed: %v", err)
+	}
+	if got := tokensText(src, toks); got != "{a = 1, b = [80, 443]}" {
+		t.Errorf("Expected line breaks written as commas, got %s", got)
+	}
+}
+
+func TestParseKCLFileWithImportedSchemas(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"app/kcl.mod": `[package]
+name = "app"
+
+[dependencies]
+shared = { path = "../shared" }
+`,
+		"shared/kcl.mod": `[package]
+name = "shared"
+`,
+		"shared/common.k": `schema Tag:
+    key: str
+
+schema Base:
+    # @maxLength(63)
+    owner: str = "platform"
+`,
+		"shared/aws/network.k": `import shared.common
+
+schema NetworkConfig:
+    cidr: str = "10.0.0.0/16"
+    tags?: [common.Tag]
+`,
+		"app/models/storage.k": `schema Storage:
+    size: int = 20
+`,
+		"app/main.k": `import shared.common
+import shared.aws as net
+import .models.storage
+
+schema XDatabase(common.Base):
+    network: net.NetworkConfig = net.NetworkConfig {}
+    storage?: storage.Storage
+    missing?: net.Missing
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	result, err := ParseKCLFileWithSchemas(filepath.Join(tempDir, "app", "main.k"))
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	if result.Primary.Name != "XDatabase" {
+		t.Errorf("Expected primary schema XDatabase, got %s", result.Primary.Name)
+	}
+
+	types := make(map[string]string)
+	for _, f := range result.Primary.Fields {
+		types[f.Name] = f.Type
+	}
+	expected := map[string]string{
+		"owner":   "str",
+		"network": "shared.aws.NetworkConfig",
+		"storage": "models.storage.Storage",
+		"missing": "net.Missing",
+	}
+	for name, typ := range expected {
+		if types[name] != typ {
+			t.Errorf("Expected %s to have type %s, got %q", name, typ, types[name])
+		}
+	}
+
+	for _, name := range []string{"shared.aws.NetworkConfig", "shared.common.Tag", "models.storage.Storage"} {
+		if result.Schemas[name] == nil {
+			t.Errorf("Expected imported schema %s, got %v", name, result.Schemas)
+		}
+	}
+	if network := result.Schemas["shared.aws.NetworkConfig"]; network != nil && network.Fields[1].Type != "[shared.common.Tag]" {
+		t.Errorf("Expected tags of type [shared.common.Tag], got %s", network.Fields[1].Type)
+	}
+	if got := fmt.Sprint(result.Primary.Fields[1].DefaultValue); got != "map[cidr:10.0.0.0/16]" {
+		t.Errorf("Expected the network default to be evaluated, got %s", got)
+	}
+
+	if len(result.Diagnostics) != 1 || !strings.Contains(result.Diagnostics[0].String(), "main.k:8:5: warning: schema Missing not found in package shared.aws") {
+		t.Errorf("Expected a warning for net.Missing, got %v", result.Diagnostics)
+	}
+}
+
+func TestParseKCLFileWithModDependencies(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"app/kcl.mod": `[package]
+name = 'app' # the application
+
+[dependencies]
+shared = { path = '../shared' } # local
+
+[dependencies.net]
+path = "../net"
+version = "0.1.0"
+`,
+		"shared/common.k": `schema Tag:
+    key: str
+`,
+		"net/vpc.k": `schema Vpc:
+    cidr: str
+`,
+		"app/main.k": `import shared.common
+import net.vpc
+
+schema XApp:
+    tags?: [common.Tag]
+    vpc?: vpc.Vpc
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	result, err := ParseKCLFileWithSchemas(filepath.Join(tempDir, "app", "main.k"))
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	for _, name := range []string{"shared.common.Tag", "net.vpc.Vpc"} {
+		if result.Schemas[name] == nil {
+			t.Errorf("Expected the dependency schema %s, got %v", name, result.Schemas)
+		}
+	}
+	if len(result.Diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics, got %v", result.Diagnostics)
+	}
+
+	// A kcl.mod that is not valid TOML is reported
+	mod := filepath.Join(tempDir, "app", "kcl.mod")
+	if err := os.WriteFile(mod, []byte("[dependencies]\nshared = { path = \"../shared\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write kcl.mod: %v", err)
+	}
+	result, err = ParseKCLFileWithSchemas(filepath.Join(tempDir, "app", "main.k"))
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	if len(result.Diagnostics) == 0 || !strings.Contains(result.Diagnostics[0].String(), "kcl.mod: warning: failed to read kcl.mod") {
+		t.Errorf("Expected a warning for the invalid kcl.mod, got %v", result.Diagnostics)
+	}
+}
+
+func TestParseKCLFileWithUpstreamModuleTypes(t *testing.T) {
+	tempDir := t.TempDir()
+	pkgPath := filepath.Join(tempDir, "kpm")
+	t.Setenv("KCL_PKG_PATH", pkgPath)
+	files := map[string]string{
+		"kpm/k8s_1.29/kcl.mod": `[package]
+name = "k8s"
+`,
+		"kpm/k8s_1.29/api/core/v1/toleration.k": `"""
+This file was generated by the KCL auto-gen tool. DO NOT EDIT.
+"""
+
+schema Toleration:
+    r"""
+    The pod this Toleration is attached to tolerates any taint that matches.
+
+    Attributes
+    ----------
+    key : str, default is Undefined, optional
+        Key is the taint key
+        that the toleration applies to.
+    tolerationSeconds : int, default is Undefined, optional
+        TolerationSeconds represents the period of time.
+
+    Examples
+    --------
+    toleration = Toleration {}
+    """
+
+    key?: str
+    tolerationSeconds?: int
+`,
+		"app/kcl.mod": `[package]
+name = "app"
+
+[dependencies]
+k8s = "1.29"
+crossplane = "1.17"
+`,
+		"app/main.k": `import k8s.api.core.v1 as corev1
+import crossplane.v1 as xpv1
+
+schema XApp:
+    tolerations?: [corev1.Toleration]
+    composition?: xpv1.Composition
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	result, err := ParseKCLFileWithSchemas(filepath.Join(tempDir, "app", "main.k"))
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+
+	// Types of modules that are not downloaded are qualified by their import path
+	fields := result.Primary.Fields
+	if fields[0].Type != "[k8s.api.core.v1.Toleration]" || fields[1].Type != "crossplane.v1.Composition" {
+		t.Errorf("Expected qualified types, got %s and %s", fields[0].Type, fields[1].Type)
+	}
+
+	toleration := result.Schemas["k8s.api.core.v1.Toleration"]
+	if toleration == nil {
+		t.Fatalf("Expected the Toleration schema of the k8s module, got %v", result.Schemas)
+	}
+	if len(toleration.Fields) != 2 || toleration.Fields[1].Type != "int" {
+		t.Errorf("Expected the fields of the Toleration schema, got %v", toleration.Fields)
+	}
+}
+
+func TestParseKCLFileDiagnostics(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.k")
+
+	content := `__xrd_kind_typo = "XApp"
+
+# @xrd
+schema XApp:
+    # @minimum(abc)
+    replicas: int
+    # @listType("sets")
+    tags: [str]
+    # @immutable
+    name: str
+    if name == "x":
+        extra: str
+
+# @maxLength(3)
+_unused = 1
+`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	_, err := ParseKCLFileWithSchemas(testFile)
+	diagnostics, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("Expected Diagnostics, got %v", err)
+	}
+	expected := []string{
+		testFile + ":1:1: warning: unknown metadata variable __xrd_kind_typo",
+		testFile + ":5:7: error: @minimum takes a number, got abc",
+		testFile + ":7:7: error: @listType takes one of atomic, set, map, got sets",
+		testFile + ":11:5: warning: attributes in if blocks are not converted",
+		testFile + ":14:3: warning: annotation @maxLength is not attached to a schema or attribute and has no effect",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got:\n%v", len(expected), diagnostics)
+	}
+	for i, want := range expected {
+		if got := diagnostics[i].String(); got != want {
+			t.Errorf("Diagnostic %d: expected %q, got %q", i, want, got)
+		}
+	}
+
+	// Without errors the warnings are part of the result
+	content = strings.NewReplacer("# @minimum(abc)", "# @minimum(1)", `# @listType("sets")`, `# @listType("set")`).Replace(content)
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	if len(result.Diagnostics) != 3 || result.Diagnostics.HasErrors() {
+		t.Errorf("Expected 3 warnings, got %v", result.Diagnostics)
+	}
+	if strict := result.Diagnostics.Strict(); !strict.HasErrors() || len(strict.Errors()) != 3 {
+		t.Errorf("Expected strict diagnostics to be errors, got %v", strict)
+	}
+
+	for _, tt := range []struct {
+		annotation string
+		expected   string
+	}{
+		{"@minLength(-1)", "@minLength takes a non-negative integer, got -1"},
+		{"@multipleOf(0)", "@multipleOf takes a positive number, got 0"},
+		{"@pattern(^[a-z]+$)", "@pattern takes a quoted string, got ^[a-z]+$"},
+		{"@enum([])", `@enum takes a list of values, e.g. ["a", "b"], got []`},
+		{`@oneOf(["a", "b"])`, `@oneOf takes a list of field lists, e.g. [["a"], ["b", "c"]], got ["a", "b"]`},
+		{"@maxItems", "@maxItems requires an argument"},
+		{"@format(\"date\"", "@format is missing the closing parenthesis"},
+		{`@validate("self > 0", severity="high")`, "@validate takes a quoted CEL rule"},
+		{`@xrd(version="v1", served=yes)`, "@xrd argument served must be True or False, got yes"},
+		{`@xrd(versions="v1")`, "@xrd has no argument versions"},
+	} {
+		if got := annotationArgsError(comment{Text: tt.annotation}); !strings.HasPrefix(got, tt.expected) {
+			t.Errorf("%s: expected %q, got %q", tt.annotation, tt.expected, got)
+		}
+	}
+}
+
+// TestAnnotationRegistry checks that every annotation applies wherever it
+// may be written, so that none is accepted and then ignored
+func TestAnnotationRegistry(t *testing.T) {
+	for name, spec := range annotations {
+		if spec.on&onAttribute != 0 && spec.field == nil {
+			t.Errorf("@%s may be written on attributes but does not apply to fields", name)
+		}
+		if spec.on&onSchema != 0 && spec.schema == nil {
+			t.Errorf("@%s may be written on schemas but does not apply to them", name)
+		}
+	}
+}
+
+func TestParseKCLFileAnnotationErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.k")
+	content := `# @xrd
+# @minimum(1)
+schema XApp:
+    # @maxlength(5)
+    name: str
+    # @enums(["a"])
+    kind: str
+    # @pattern("^a$")
+    replicas: int
+    # @maxItems(3)
+    # @maxItems(4)
+    tags: [str]
+    # @validate("self > 0")
+    # @validate("self < 10")
+    port: int
+    # @xrd
+    x?: {str:str}
+    # @foo
+    y?: int | str
+    # @minProperties(1)
+    # @preserveUnknownFields
+    z?: Config
+
+schema Config:
+    # @minItems(1)
+    any?: any
+`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	_, err := ParseKCLFileWithSchemas(testFile)
+	diagnostics, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("Expected Diagnostics, got %v", err)
+	}
+	expected := []string{
+		testFile + ":2:3: error: @minimum applies to attributes, not schemas",
+		testFile + ":4:7: error: unknown annotation @maxlength; did you mean @maxLength?",
+		testFile + ":6:7: error: unknown annotation @enums; did you mean @enum?",
+		testFile + ":8:7: error: @pattern applies to str attributes, not int",
+		testFile + ":11:7: error: duplicate annotation @maxItems",
+		testFile + ":16:7: error: @xrd applies to schemas, not attributes",
+		testFile + ":18:7: error: unknown annotation @foo",
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got:\n%v", len(expected), diagnostics)
+	}
+	for i, want := range expected {
+		if got := diagnostics[i].String(); got != want {
+			t.Errorf("Diagnostic %d: expected %q, got %q", i, want, got)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		expected string
+	}{
+		{"maxlength", "maxLength"},
+		{"pattren", "pattern"},
+		{"listMapKey", "listMapKeys"},
+		{"immutible", "immutable"},
+		{"description", ""},
+	} {
+		if got := suggestAnnotation(tt.name); got != tt.expected {
+			t.Errorf("suggestAnnotation(%q): expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestParseKCLFileWithCustomAnnotations(t *testing.T) {
+	err := RegisterAnnotation("team", func(field *Field, annotation Annotation) error {
+		if annotation.Args == "" {
+			return fmt.Errorf("requires a team name")
+		}
+		field.Description = strings.TrimSpace(field.Description + "\nOwned by " + strings.Trim(annotation.Args, `"`))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterAnnotation failed: %v", err)
+	}
+	if err := RegisterAnnotation("piiField", nil); err != nil {
+		t.Fatalf("RegisterAnnotation failed: %v", err)
+	}
+	for _, name := range []string{"team", "minimum", "spec", "spec.path", "cost-center"} {
+		if err := RegisterAnnotation(name, nil); err == nil {
+			t.Errorf("Expected registering %q to fail", name)
+		}
+	}
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.k")
+	content := `# @xrd
+schema XApp:
+    # The database
+    # @team("payments")
+    # @piiField
+    database: str
+`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	result, err := ParseKCLFileWithSchemas(testFile)
+	if err != nil {
+		t.Fatalf("ParseKCLFileWithSchemas failed: %v", err)
+	}
+	field := result.Schemas["XApp"].Fields[0]
+	if field.Description != "The database\nOwned by payments" {
+		t.Errorf("Expected the handler to extend the description, got %q", field.Description)
+	}
+	expected := []Annotation{
+		{Name: "team", Args: `"payments"`, Line: 4, Column: 7},
+		{Name: "piiField", Line: 5, Column: 7},
+	}
+	if !reflect.DeepEqual(field.Annotations, expected) {
+		t.Errorf("Expected annotations %v, got %v", expected, field.Annotations)
+	}
+
+	content = strings.NewReplacer(`# @team("payments")`, "# @team", "# @piiField", "# @piifield").Replace(content)
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	_, err = ParseKCLFileWithSchemas(testFile)
+	if err == nil || err.Error() != testFile+":4:7: error: @team: requires a team name\n"+
+		testFile+":5:7: error: unknown annotation @piifield; did you mean @piiField?" {
+		t.Errorf("Expected the handler's error and a suggestion, got %v", err)
+	}
+}
+
+func TestTypeNames(t *testing.T) {
+	tests := map[string][]string{
+		"str":                         nil,
+		`"web" | "worker"`:            nil,
+		"[Container]":                 {"Container"},
+		"{str:k8s.api.core.v1.Probe}": {"k8s.api.core.v1.Probe"},
+		`Base | "Name" | None`:        {"Base"},
+	}
+	for typ, expected := range tests {
+		if names := TypeNames(typ); !reflect.DeepEqual(names, expected) {
+			t.Errorf("TypeNames(%q) = %v, expected %v", typ, names, expected)
+		}
+	}
+}
+
+func TestFailedExpressions(t *testing.T) {
+	exprs := []string{`"a"`, "{\n    name = b\n}", "Config {}"}
+	// The file has 9 lines, the expressions are appended from line 10 on
+	err := errors.New(`error[E2L23]: CompileError
+ --> /tmp/app.k:12:12
+   |
+12 |     name = b
+   |            ^ name 'b' is not defined
+`)
+	if failed := failedExpressions(err, 10, exprs, []int{0, 1, 2}); !reflect.DeepEqual(failed, map[int]bool{1: true}) {
+		t.Errorf("Expected the multi-line expression to fail, got %v", failed)
+	}
+	// Without the first expression, the last one is on line 13
+	err = errors.New(`error[E3M38]: EvaluationError
+ --> /tmp/app.k:13:1
+   |
+13 | __kcl2xrd_default_2 = Config {}
+   | ^ Instance check failed
+`)
+	if failed := failedExpressions(err, 10, exprs, []int{1, 2}); !reflect.DeepEqual(failed, map[int]bool{2: true}) {
+		t.Errorf("Expected the last expression to fail, got %v", failed)
+	}
+	err = errors.New(`error[E2F04]: CannotFindModule
+ --> /tmp/app.k:1:1
+  |
+1 | import k8s.api.core.v1
+  | ^ Cannot find the module k8s.api.core.v1
+`)
+	if failed := failedExpressions(err, 10, exprs, []int{0, 1, 2}); len(failed) != 0 {
+		t.Errorf("Expected an error of the file to point at no expression, got %v", failed)
+	}
+}
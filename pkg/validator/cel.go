@@ -0,0 +1,228 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/generator"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+)
+
+// errNotEvaluated is returned for transition rules, which compare against
+// the previous object and cannot be evaluated for a single manifest
+var errNotEvaluated = errors.New("rule is not evaluated")
+
+// oldSelfRegex matches references to the previous value of a field
+var oldSelfRegex = regexp.MustCompile(`\boldSelf\b`)
+
+// unsupportedRuleError is returned for rules that use functions only the
+// Kubernetes API server provides, e.g. quantity() or isSorted()
+type unsupportedRuleError struct {
+	reason string
+}
+
+func (e unsupportedRuleError) Error() string {
+	return e.reason
+}
+
+// ruleCache compiles x-kubernetes-validations rules once per rule
+type ruleCache struct {
+	env      *cel.Env
+	err      error
+	programs map[string]cel.Program
+	errs     map[string]error
+}
+
+func newRuleCache() *ruleCache {
+	env, err := cel.NewEnv(
+		cel.Variable("self", cel.DynType),
+		cel.Variable("oldSelf", cel.DynType),
+		cel.OptionalTypes(),
+		cel.CrossTypeNumericComparisons(true),
+		ext.Strings(),
+		ext.Lists(),
+		ext.Sets(),
+		ext.Math(),
+		ext.Encoders(),
+		ext.Regex(),
+	)
+	return &ruleCache{
+		env:      env,
+		err:      err,
+		programs: make(map[string]cel.Program),
+		errs:     make(map[string]error),
+	}
+}
+
+// program returns the compiled program of a rule
+func (r *ruleCache) program(rule string) (cel.Program, error) {
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", r.err)
+	}
+	if prg, ok := r.programs[rule]; ok {
+		return prg, nil
+	}
+	if err, ok := r.errs[rule]; ok {
+		return nil, err
+	}
+
+	prg, err := r.compile(rule)
+	if err != nil {
+		r.errs[rule] = err
+		return nil, err
+	}
+	r.programs[rule] = prg
+	return prg, nil
+}
+
+func (r *ruleCache) compile(rule string) (cel.Program, error) {
+	ast, iss := r.env.Compile(rule)
+	if iss.Err() != nil {
+		if strings.Contains(iss.Err().Error(), "undeclared reference") {
+			return nil, unsupportedRuleError{reason: strings.TrimSpace(iss.Err().Error())}
+		}
+		return nil, fmt.Errorf("compilation failed: %w", iss.Err())
+	}
+	return r.env.Program(ast)
+}
+
+// evaluate evaluates a rule against a value. It returns whether the rule
+// holds and, if it does not, the message to report. Manifests are validated
+// as if they were created, so transition rules are only evaluated if they
+// set optionalOldSelf, with oldSelf empty.
+func (r *ruleCache) evaluate(validation generator.K8sValidation, v interface{}, s *generator.PropertySchema) (bool, string, error) {
+	optionalOldSelf := validation.OptionalOldSelf != nil && *validation.OptionalOldSelf
+	if oldSelfRegex.MatchString(validation.Rule) && !optionalOldSelf {
+		return true, "", errNotEvaluated
+	}
+	prg, err := r.program(validation.Rule)
+	if err != nil {
+		return false, "", err
+	}
+
+	vars := map[string]interface{}{"self": celValue(v, s), "oldSelf": types.OptionalNone}
+	out, _, err := prg.Eval(vars)
+	if err != nil {
+		return false, "", fmt.Errorf("evaluation failed: %w", err)
+	}
+	ok, isBool := out.Value().(bool)
+	if !isBool {
+		return false, "", fmt.Errorf("rule must evaluate to a bool, got %s", out.Type().TypeName())
+	}
+	if ok {
+		return true, "", nil
+	}
+	return false, r.message(validation, vars), nil
+}
+
+// message returns the message of a failed rule: the result of its message
+// expression, falling back to its message as the API server does
+func (r *ruleCache) message(validation generator.K8sValidation, vars map[string]interface{}) string {
+	if validation.MessageExpression != "" {
+		if prg, err := r.program(validation.MessageExpression); err == nil {
+			if out, _, err := prg.Eval(vars); err == nil {
+				if message, ok := out.Value().(string); ok && strings.TrimSpace(message) != "" {
+					return message
+				}
+			}
+		}
+	}
+	if validation.Message != "" {
+		return validation.Message
+	}
+	return "failed rule: " + validation.Rule
+}
+
+// validations evaluates the x-kubernetes-validations rules of a schema on a
+// value; it is the Rules hook of the value checker
+func (r *ruleCache) validations(c *generator.ValueChecker, path string, v interface{}, s *generator.PropertySchema) {
+	for _, validation := range s.XKubernetesValidations {
+		ok, message, err := r.evaluate(validation, v, s)
+		switch {
+		case err == errNotEvaluated:
+		case err != nil:
+			if unsupported, isUnsupported := err.(unsupportedRuleError); isUnsupported {
+				c.Warnings = append(c.Warnings, fmt.Sprintf("%s: rule %q is not evaluated locally: %s", displayPath(path), validation.Rule, unsupported.reason))
+				continue
+			}
+			c.Errorf(path, "Invalid value: rule %q: %v", validation.Rule, err)
+		case !ok:
+			c.Errorf(rulePath(path, validation.FieldPath), "%s: %s", reasonPhrase(validation.Reason), message)
+		}
+	}
+}
+
+// rulePath returns the path a failed rule is reported at: the field path of
+// the rule, e.g. .spec.replicas, below the object it is declared on
+func rulePath(path, fieldPath string) string {
+	if path == "" {
+		return strings.TrimPrefix(fieldPath, ".")
+	}
+	return path + fieldPath
+}
+
+// reasonPhrase returns how the API server describes the reason of a failed
+// rule; FieldValueInvalid is the default
+func reasonPhrase(reason string) string {
+	switch reason {
+	case "FieldValueForbidden":
+		return "Forbidden"
+	case "FieldValueRequired":
+		return "Required value"
+	case "FieldValueDuplicate":
+		return "Duplicate value"
+	}
+	return "Invalid value"
+}
+
+// displayPath returns the path of a field for messages; the root of the
+// manifest has an empty path
+func displayPath(path string) string {
+	if path == "" {
+		return "<root>"
+	}
+	return path
+}
+
+// celValue converts a value to the types CEL sees for its schema: numbers
+// are doubles even when written without a fraction, and properties are named
+// as the API server escapes them, e.g. my__dash__field for my-field
+func celValue(v interface{}, s *generator.PropertySchema) interface{} {
+	if s == nil {
+		return v
+	}
+	switch value := v.(type) {
+	case map[string]interface{}:
+		additional := generator.AdditionalPropertiesSchema(s)
+		m := make(map[string]interface{}, len(value))
+		for name, field := range value {
+			if prop, ok := s.Properties[name]; ok {
+				if escaped, ok := generator.EscapeCELName(name); ok {
+					m[escaped] = celValue(field, &prop)
+				}
+			} else {
+				m[name] = celValue(field, additional)
+			}
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(value))
+		for i, item := range value {
+			l[i] = celValue(item, s.Items)
+		}
+		return l
+	case int:
+		if s.Type == "number" {
+			return float64(value)
+		}
+	case float64:
+		if s.Type == "integer" {
+			return int64(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,210 @@
+// Package validator checks composite resources and claims against the
+// schemas of an XRD the way the Kubernetes API server does: defaults are
+// applied, the structural schema is enforced and x-kubernetes-validations
+// rules are evaluated with cel-go, all without a cluster.
+package validator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ggkhrmv/kcl2xrd/pkg/generator"
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a validation error of a manifest field
+type Error struct {
+	Field   string // path of the field, e.g. spec.parameters.size
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Result is the outcome of validating one manifest
+type Result struct {
+	Kind     string
+	Name     string
+	Errors   []Error
+	Warnings []string // e.g. rules that cannot be evaluated locally
+}
+
+// Validator validates manifests against the served versions of an XRD or CRD
+type Validator struct {
+	group     string
+	kind      string
+	claimKind string // empty without claims
+	isXRD     bool
+	versions  map[string]*generator.PropertySchema
+	rules     *ruleCache
+}
+
+// definition is the part of an XRD or CRD the validator reads
+type definition struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Spec       struct {
+		Group string `yaml:"group"`
+		Names struct {
+			Kind string `yaml:"kind"`
+		} `yaml:"names"`
+		ClaimNames *struct {
+			Kind string `yaml:"kind"`
+		} `yaml:"claimNames"`
+		Versions []struct {
+			Name   string `yaml:"name"`
+			Served bool   `yaml:"served"`
+			Schema struct {
+				OpenAPIV3Schema generator.PropertySchema `yaml:"openAPIV3Schema"`
+			} `yaml:"schema"`
+		} `yaml:"versions"`
+	} `yaml:"spec"`
+}
+
+// crossplaneSpecFields are the spec fields Crossplane adds to composite
+// resources and claims; the generated schemas do not declare them
+var crossplaneSpecFields = map[string]bool{
+	"claimRef":                    true,
+	"compositeDeletePolicy":       true,
+	"compositionRef":              true,
+	"compositionRevisionRef":      true,
+	"compositionRevisionSelector": true,
+	"compositionSelector":         true,
+	"compositionUpdatePolicy":     true,
+	"crossplane":                  true,
+	"environmentConfigRefs":       true,
+	"publishConnectionDetailsTo":  true,
+	"resourceRef":                 true,
+	"resourceRefs":                true,
+	"writeConnectionSecretToRef":  true,
+}
+
+// New returns a Validator for the first XRD or CRD of a YAML stream
+func New(data []byte) (*Validator, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var d definition
+		err := decoder.Decode(&d)
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no CompositeResourceDefinition or CustomResourceDefinition found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode definition: %w", err)
+		}
+		if d.Kind != "CompositeResourceDefinition" && d.Kind != "CustomResourceDefinition" {
+			continue
+		}
+
+		v := &Validator{
+			group:    d.Spec.Group,
+			kind:     d.Spec.Names.Kind,
+			isXRD:    d.Kind == "CompositeResourceDefinition",
+			versions: make(map[string]*generator.PropertySchema),
+			rules:    newRuleCache(),
+		}
+		if d.Spec.ClaimNames != nil {
+			v.claimKind = d.Spec.ClaimNames.Kind
+		}
+		for i := range d.Spec.Versions {
+			version := d.Spec.Versions[i]
+			if version.Served {
+				v.versions[version.Name] = &version.Schema.OpenAPIV3Schema
+			}
+		}
+		if len(v.versions) == 0 {
+			return nil, fmt.Errorf("%s %s serves no versions", d.Kind, v.kind)
+		}
+		return v, nil
+	}
+}
+
+// ValidateDocuments validates every manifest of a YAML stream
+func (v *Validator) ValidateDocuments(data []byte) ([]Result, error) {
+	var results []Result
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var manifest map[string]interface{}
+		err := decoder.Decode(&manifest)
+		if errors.Is(err, io.EOF) {
+			return results, nil
+		}
+		if err != nil {
+			return results, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if manifest == nil {
+			continue
+		}
+
+		result := Result{}
+		result.Kind, _ = manifest["kind"].(string)
+		if metadata, ok := manifest["metadata"].(map[string]interface{}); ok {
+			result.Name, _ = metadata["name"].(string)
+		}
+		result.Errors, result.Warnings = v.Validate(manifest)
+		results = append(results, result)
+	}
+}
+
+// Validate validates a composite resource or claim. Defaults are applied to
+// the manifest first, as the API server would.
+func (v *Validator) Validate(manifest map[string]interface{}) ([]Error, []string) {
+	c := &generator.ValueChecker{Rules: v.rules.validations}
+
+	apiVersion, _ := manifest["apiVersion"].(string)
+	group, version, _ := strings.Cut(apiVersion, "/")
+	schema := v.versions[version]
+	switch {
+	case group != v.group:
+		c.Errorf("apiVersion", "Invalid value: %q: must be in group %s", apiVersion, v.group)
+	case schema == nil:
+		c.Errorf("apiVersion", "Invalid value: %q: version %s is not served", apiVersion, version)
+	}
+	kind, _ := manifest["kind"].(string)
+	if kind != v.kind && (v.claimKind == "" || kind != v.claimKind) {
+		expected := v.kind
+		if v.claimKind != "" {
+			expected += " or " + v.claimKind
+		}
+		c.Errorf("kind", "Invalid value: %q: must be %s", kind, expected)
+	}
+	if metadata, ok := manifest["metadata"].(map[string]interface{}); !ok || metadata["name"] == nil {
+		c.Errorf("metadata.name", "Required value")
+	}
+	if schema == nil {
+		return errorsOf(c), c.Warnings
+	}
+
+	// apiVersion, kind and metadata are implicit in every schema
+	root := *schema
+	root.Properties = make(map[string]generator.PropertySchema, len(schema.Properties)+3)
+	for name, prop := range schema.Properties {
+		root.Properties[name] = prop
+	}
+	preserve := true
+	for _, name := range []string{"apiVersion", "kind", "metadata"} {
+		if _, ok := root.Properties[name]; !ok {
+			root.Properties[name] = generator.PropertySchema{XKubernetesPreserveUnknownFields: &preserve}
+		}
+	}
+	if spec, ok := root.Properties["spec"]; ok && v.isXRD {
+		spec.XKubernetesPreserveUnknownFields = nil
+		c.ImplicitFields = map[string]map[string]bool{"spec": crossplaneSpecFields}
+		root.Properties["spec"] = spec
+	}
+
+	c.Check("", manifest, &root)
+	return errorsOf(c), c.Warnings
+}
+
+// errorsOf returns the errors of a value checker
+func errorsOf(c *generator.ValueChecker) []Error {
+	var errs []Error
+	for _, e := range c.Errors {
+		errs = append(errs, Error{Field: displayPath(e.Field), Message: e.Message})
+	}
+	return errs
+}
@@ -0,0 +1,346 @@
+package validator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const bucketXRD = `apiVersion: apiextensions.crossplane.io/v1
+kind: CompositeResourceDefinition
+metadata:
+  name: xbuckets.example.org
+spec:
+  group: example.org
+  names:
+    kind: XBucket
+    plural: xbuckets
+  claimNames:
+    kind: Bucket
+    plural: buckets
+  versions:
+    - name: v1alpha1
+      served: true
+      referenceable: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              properties:
+                parameters:
+                  type: object
+                  properties:
+                    name:
+                      type: string
+                      pattern: ^[a-z0-9-]+$
+                      maxLength: 12
+                    region:
+                      type: string
+                      default: eu-west-1
+                      enum:
+                        - eu-west-1
+                        - us-east-1
+                    replicas:
+                      type: integer
+                      minimum: 1
+                      maximum: 5
+                    ratio:
+                      type: number
+                      minimum: -0.5
+                      exclusiveMinimum: true
+                      multipleOf: 0.25
+                      x-kubernetes-validations:
+                        - rule: self <= 1
+                          message: ratio must not exceed 1
+                    port:
+                      x-kubernetes-int-or-string: true
+                    tags:
+                      type: object
+                      additionalProperties:
+                        type: string
+                      maxProperties: 3
+                      x-kubernetes-validations:
+                        - rule: size(self) <= 2
+                    owner:
+                      type: object
+                      properties:
+                        email:
+                          type: string
+                          format: email
+                        team:
+                          type: string
+                      oneOf:
+                        - required:
+                            - email
+                        - required:
+                            - team
+                    zones:
+                      type: array
+                      x-kubernetes-list-type: set
+                      items:
+                        type: string
+                    note:
+                      type: string
+                      nullable: true
+                    template:
+                      type: object
+                      x-kubernetes-embedded-resource: true
+                      x-kubernetes-preserve-unknown-fields: true
+                    storage-class:
+                      type: string
+                  required:
+                    - name
+                  x-kubernetes-validations:
+                    - rule: "!has(self.replicas) || self.replicas == 1 || has(self.owner)"
+                      message: replicated buckets need an owner
+                    - rule: self.name == oldSelf.name
+                    - rule: self.name.isSorted()
+                    - rule: self.name != "default"
+                      messageExpression: "'name ' + self.name + ' is reserved'"
+                      reason: FieldValueForbidden
+                      fieldPath: .name
+                    - rule: "!oldSelf.hasValue() || self.name == oldSelf.value().name"
+                      message: name cannot be changed
+                      optionalOldSelf: true
+                    - rule: "!has(self.storage__dash__class) || self.storage__dash__class != 'glacier'"
+                      message: glacier is not supported
+              required:
+                - parameters
+`
+
+func decode(t *testing.T, manifest string) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := yaml.Unmarshal([]byte(manifest), &m); err != nil {
+		t.Fatalf("failed to decode manifest: %v", err)
+	}
+	return m
+}
+
+func TestValidate(t *testing.T) {
+	v, err := New([]byte(bucketXRD))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	manifest := decode(t, `apiVersion: example.org/v1alpha1
+kind: Bucket
+metadata:
+  name: logs
+spec:
+  compositionRef:
+    name: buckets
+  parameters:
+    name: logs
+    replicas: 3
+    ratio: 1
+    port: http
+    tags:
+      env: prod
+    owner:
+      email: ops@example.org
+    zones: [a, b]
+`)
+	errs, warnings := v.Validate(manifest)
+	if len(errs) != 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "isSorted") {
+		t.Errorf("Expected a warning for the rule using isSorted, got %v", warnings)
+	}
+
+	parameters := manifest["spec"].(map[string]interface{})["parameters"].(map[string]interface{})
+	if parameters["region"] != "eu-west-1" {
+		t.Errorf("Expected default region to be applied, got %v", parameters["region"])
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	v, err := New([]byte(bucketXRD))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		parameters string
+		expected   []Error
+	}{
+		{
+			name:       "required",
+			parameters: `{}`,
+			expected:   []Error{{"spec.parameters.name", "Required value"}},
+		},
+		{
+			name:       "type",
+			parameters: `{name: logs, replicas: two}`,
+			expected:   []Error{{"spec.parameters.replicas", `Invalid value: "two": must be of type integer`}},
+		},
+		{
+			name:       "pattern and length",
+			parameters: `{name: Logs_And_More}`,
+			expected: []Error{
+				{"spec.parameters.name", "Too long: may not be longer than 12"},
+				{"spec.parameters.name", `Invalid value: "Logs_And_More": should match '^[a-z0-9-]+$'`},
+			},
+		},
+		{
+			name:       "enum",
+			parameters: `{name: logs, region: mars}`,
+			expected:   []Error{{"spec.parameters.region", `Unsupported value: "mars": supported values: "eu-west-1", "us-east-1"`}},
+		},
+		{
+			name:       "bounds",
+			parameters: `{name: logs, replicas: 0}`,
+			expected:   []Error{{"spec.parameters.replicas", "Invalid value: 0: should be greater than or equal to 1"}},
+		},
+		{
+			name:       "exclusive bound",
+			parameters: `{name: logs, ratio: -0.5}`,
+			expected:   []Error{{"spec.parameters.ratio", "Invalid value: -0.5: should be greater than -0.5"}},
+		},
+		{
+			name:       "multipleOf",
+			parameters: `{name: logs, ratio: 0.3}`,
+			expected:   []Error{{"spec.parameters.ratio", "Invalid value: 0.3: should be a multiple of 0.25"}},
+		},
+		{
+			name:       "maxProperties",
+			parameters: `{name: logs, tags: {a: w, b: x, c: y, d: z}}`,
+			expected:   []Error{{"spec.parameters.tags", "Too many: 4: must have at most 3 properties"}},
+		},
+		{
+			name:       "nullable",
+			parameters: `{name: logs, note: null}`,
+		},
+		{
+			name:       "embedded resource",
+			parameters: `{name: logs, template: {apiVersion: v1, metadata: {name: config}}}`,
+			expected:   []Error{{"spec.parameters.template.kind", "Required value: must not be empty"}},
+		},
+		{
+			name:       "int or string",
+			parameters: `{name: logs, port: true}`,
+			expected:   []Error{{"spec.parameters.port", "Invalid value: true: must be an integer or a string"}},
+		},
+		{
+			name:       "unknown field",
+			parameters: `{name: logs, size: 3}`,
+			expected:   []Error{{"spec.parameters.size", "Unknown field"}},
+		},
+		{
+			name:       "additional properties",
+			parameters: `{name: logs, tags: {env: 1}}`,
+			expected:   []Error{{"spec.parameters.tags.env", "Invalid value: 1: must be of type string"}},
+		},
+		{
+			name:       "format",
+			parameters: `{name: logs, owner: {email: ops}}`,
+			expected:   []Error{{"spec.parameters.owner.email", `Invalid value: "ops": must be a valid email`}},
+		},
+		{
+			name:       "oneOf",
+			parameters: `{name: logs, owner: {email: ops@example.org, team: ops}}`,
+			expected:   []Error{{"spec.parameters.owner", "Invalid value: must validate one and only one schema (oneOf). Found 2 valid alternatives"}},
+		},
+		{
+			name:       "list set",
+			parameters: `{name: logs, zones: [a, a]}`,
+			expected:   []Error{{"spec.parameters.zones[1]", `Duplicate value: "a"`}},
+		},
+		{
+			name:       "rule message",
+			parameters: `{name: logs, ratio: 1.5}`,
+			expected:   []Error{{"spec.parameters.ratio", "Invalid value: ratio must not exceed 1"}},
+		},
+		{
+			name:       "rule without message",
+			parameters: `{name: logs, tags: {a: x, b: y, c: z}}`,
+			expected:   []Error{{"spec.parameters.tags", "Invalid value: failed rule: size(self) <= 2"}},
+		},
+		{
+			name:       "rule across fields",
+			parameters: `{name: logs, replicas: 2}`,
+			expected:   []Error{{"spec.parameters", "Invalid value: replicated buckets need an owner"}},
+		},
+		{
+			name:       "rule with an escaped field name",
+			parameters: `{name: logs, storage-class: glacier}`,
+			expected:   []Error{{"spec.parameters", "Invalid value: glacier is not supported"}},
+		},
+		{
+			name:       "rule with message expression, reason and field path",
+			parameters: `{name: default}`,
+			expected:   []Error{{"spec.parameters.name", "Forbidden: name default is reserved"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := decode(t, `apiVersion: example.org/v1alpha1
+kind: XBucket
+metadata:
+  name: logs
+spec:
+  parameters: `+tt.parameters)
+			errs, _ := v.Validate(manifest)
+			if !reflect.DeepEqual(errs, tt.expected) {
+				t.Errorf("Expected errors %v, got %v", tt.expected, errs)
+			}
+		})
+	}
+}
+
+func TestValidateDocuments(t *testing.T) {
+	v, err := New([]byte(bucketXRD))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	results, err := v.ValidateDocuments([]byte(`apiVersion: example.org/v1
+kind: Bucket
+metadata:
+  name: wrong-version
+spec:
+  parameters:
+    name: logs
+---
+apiVersion: example.org/v1alpha1
+kind: XQueue
+metadata: {}
+spec: {}
+`))
+	if err != nil {
+		t.Fatalf("ValidateDocuments failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	if results[0].Kind != "Bucket" || results[0].Name != "wrong-version" {
+		t.Errorf("Expected Bucket/wrong-version, got %s/%s", results[0].Kind, results[0].Name)
+	}
+	expected := []Error{{"apiVersion", `Invalid value: "example.org/v1": version v1 is not served`}}
+	if !reflect.DeepEqual(results[0].Errors, expected) {
+		t.Errorf("Expected errors %v, got %v", expected, results[0].Errors)
+	}
+
+	expected = []Error{
+		{"kind", `Invalid value: "XQueue": must be XBucket or Bucket`},
+		{"metadata.name", "Required value"},
+		{"spec.parameters", "Required value"},
+	}
+	if !reflect.DeepEqual(results[1].Errors, expected) {
+		t.Errorf("Expected errors %v, got %v", expected, results[1].Errors)
+	}
+}
+
+func TestNewNoDefinition(t *testing.T) {
+	if _, err := New([]byte("apiVersion: v1\nkind: ConfigMap\n")); err == nil {
+		t.Error("Expected an error for a file without a definition")
+	}
+}